@@ -0,0 +1,219 @@
+// Package account manages the accounts of users. Users log in with their password
+// or a magic link that is sent to them by email and authenticate with the session
+// token they get in return. Only hashes of the tokens are stored.
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	UserCollectionName      = "users"
+	SessionCollectionName   = "sessions"
+	MagicLinkCollectionName = "magicLinks"
+
+	// SessionDuration is the time a session token is valid.
+	SessionDuration = 30 * 24 * time.Hour
+	// MagicLinkDuration is the time a magic link is valid.
+	MagicLinkDuration = 15 * time.Minute
+
+	// the key of the authenticated user in the locals of a request
+	userKey = "user"
+)
+
+// ErrInvalidToken is returned if a token doesn't exist or is expired.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// NormalizeEmail returns the email address the way it is stored.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// HashPassword returns the bcrypt hash of the password.
+func HashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// CheckPassword checks whether the password matches the password of the user. Users
+// that only log in with magic links have no password.
+func CheckPassword(u models.User, password string) bool {
+	if len(u.PasswordHash) == 0 {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password)) == nil
+}
+
+// newToken returns a random token and the hash that is stored instead of the token.
+func newToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// CreateSession creates a new session for the user and returns its token.
+func CreateSession(ctx context.Context, userID primitive.ObjectID) (string, time.Time, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	s := models.Session{TokenHash: hash, UserID: userID, ExpiresAt: time.Now().UTC().Add(SessionDuration)}
+	if err := storage.S.Sessions.Add(ctx, s); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, s.ExpiresAt, nil
+}
+
+// DeleteSession deletes the session with the given token.
+func DeleteSession(ctx context.Context, token string) error {
+	return storage.S.Sessions.Delete(ctx, hashToken(token))
+}
+
+// DeleteUser deletes the user with all of their sessions and webhooks.
+func DeleteUser(ctx context.Context, userID primitive.ObjectID) error {
+	if err := storage.S.Sessions.DeleteByUser(ctx, userID); err != nil {
+		return err
+	}
+	hooks, err := storage.S.Webhooks.Find(ctx, &userID)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if err := storage.S.Webhooks.Delete(ctx, hook.ID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+	return storage.S.Users.Delete(ctx, userID)
+}
+
+// VerifyEmail marks the email address of the user as verified after the user has logged in
+// with a magic link. Since anybody could have registered the address with a password
+// before, the password and all sessions of unverified users are removed.
+func VerifyEmail(ctx context.Context, u models.User) (models.User, error) {
+	if u.EmailVerified {
+		return u, nil
+	}
+	// without the password no new sessions can be created in the meantime
+	u, err := storage.S.Users.VerifyEmail(ctx, u.ID)
+	if err != nil {
+		return u, err
+	}
+	return u, storage.S.Sessions.DeleteByUser(ctx, u.ID)
+}
+
+// Authenticate returns the user of the session with the given token.
+func Authenticate(ctx context.Context, token string) (models.User, error) {
+	s, err := storage.S.Sessions.Get(ctx, hashToken(token), time.Now().UTC())
+	if err != nil {
+		return models.User{}, invalidToken(err)
+	}
+	u, err := storage.S.Users.Get(ctx, s.UserID)
+	if err != nil {
+		return models.User{}, invalidToken(err)
+	}
+	return u, nil
+}
+
+// CreateMagicLink stores a new magic link for the email address and returns its token.
+func CreateMagicLink(ctx context.Context, email string) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	l := models.MagicLink{TokenHash: hash, Email: NormalizeEmail(email), ExpiresAt: time.Now().UTC().Add(MagicLinkDuration)}
+	if err := storage.S.Sessions.AddMagicLink(ctx, l); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RedeemMagicLink deletes the magic link with the given token and returns its email
+// address. Magic links can only be used once.
+func RedeemMagicLink(ctx context.Context, token string) (string, error) {
+	l, err := storage.S.Sessions.RedeemMagicLink(ctx, hashToken(token), time.Now().UTC())
+	if err != nil {
+		return "", invalidToken(err)
+	}
+	return l.Email, nil
+}
+
+// invalidToken translates ErrNotFound of the store to ErrInvalidToken.
+func invalidToken(err error) error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrInvalidToken
+	}
+	return err
+}
+
+// BearerToken returns the token of the Authorization header of the request.
+func BearerToken(c *fiber.Ctx) string {
+	token, found := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !found {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// RequireUser is a middleware that only lets requests with a valid session token pass.
+func RequireUser(c *fiber.Ctx) error {
+	token := BearerToken(c)
+	if token == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.GenericResponse{
+			Success: false,
+			Message: "authentication required",
+			Error:   "a session token has to be provided as bearer token",
+		})
+	}
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+	u, err := Authenticate(ctx, token)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if err == ErrInvalidToken {
+			status = fiber.StatusUnauthorized
+		}
+		return c.Status(status).JSON(models.GenericResponse{
+			Success: false,
+			Message: "authentication failed",
+			Error:   err.Error(),
+		})
+	}
+	c.Locals(userKey, u)
+	return c.Next()
+}
+
+// User returns the user that was authenticated by RequireUser.
+func User(c *fiber.Ctx) models.User {
+	u, _ := c.Locals(userKey).(models.User)
+	return u
+}
+
+// Follows returns the follows of the user that select the events of their feed.
+func Follows(u models.User) *storage.Follows {
+	f := &storage.Follows{Venues: u.FollowedVenues, Cities: u.FollowedCities}
+	for _, a := range u.FollowedArtists {
+		f.Artists = append(f.Artists, shared.RemoveDiacritics(a))
+	}
+	return f
+}
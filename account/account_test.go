@@ -0,0 +1,77 @@
+package account_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/account"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/shared"
+)
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := account.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	u := models.User{PasswordHash: hash}
+	if !account.CheckPassword(u, "correct horse battery staple") {
+		t.Error("expected the password to match")
+	}
+	if account.CheckPassword(u, "wrong password") {
+		t.Error("expected a wrong password not to match")
+	}
+	if account.CheckPassword(models.User{}, "") {
+		t.Error("users without a password must not be able to log in with a password")
+	}
+}
+
+func TestRequireUserWithoutToken(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", account.RequireUser, func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	for _, header := range []string{"", "Basic dXNlcjpwYXNzd29yZA==", "Bearer "} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			req.Header.Set(fiber.HeaderAuthorization, header)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusUnauthorized {
+			t.Errorf("expected status 401 for authorization header %q, got %d", header, resp.StatusCode)
+		}
+	}
+}
+
+func TestFollows(t *testing.T) {
+	event := func(title, location, city string) models.Event {
+		return models.Event{Title: title, NormalizedTitle: shared.RemoveDiacritics(title), Location: location, City: city}
+	}
+	if account.Follows(models.User{}).Match(event("Ásgeir", "Moods", "Zurich")) {
+		t.Errorf("expected users that don't follow anything to have an empty feed")
+	}
+
+	f := account.Follows(models.User{
+		FollowedArtists: []string{"Ásgeir"},
+		FollowedVenues:  []string{"Moods"},
+		FollowedCities:  []string{"St. Gallen"},
+	})
+	tests := []struct {
+		e    models.Event
+		want bool
+	}{
+		{event("ASGEIR live", "Kaufleuten", "Zurich"), true},
+		{event("Jazz Night", "moods", "Zurich"), true},
+		{event("Jazz Night", "Moods im Schiffbau", "Zurich"), false},
+		{event("Jazz Night", "Palace", "st. gallen"), true},
+		{event("Jazz Night", "Palace", "St Gallen"), false},
+	}
+	for _, tc := range tests {
+		if got := f.Match(tc.e); got != tc.want {
+			t.Errorf("got %v for %+v, want %v", got, tc.e, tc.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Package autocomplete suggests artists, venues, cities and genres of upcoming events
+// while a user is typing. Suggestions are matched by prefix, ignoring case and diacritics.
+package autocomplete
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/similar"
+	"github.com/jakopako/event-api/storage"
+)
+
+// The types of suggestions.
+const (
+	TypeArtist = "artist"
+	TypeVenue  = "venue"
+	TypeCity   = "city"
+	TypeGenre  = "genre"
+)
+
+// Types contains all types of suggestions.
+var Types = []string{TypeArtist, TypeVenue, TypeCity, TypeGenre}
+
+// maxTitles limits the number of event titles artists are extracted from.
+const maxTitles = 1000
+
+// how well a value matches the query, the higher the better
+const (
+	noMatch = iota
+	wordPrefixMatch
+	prefixMatch
+	exactMatch
+)
+
+// normalize returns the lowercase value without diacritics.
+func normalize(s string) string {
+	return strings.ToLower(strings.TrimSpace(shared.RemoveDiacritics(s)))
+}
+
+// match returns how well the value matches the query. The query has to be normalized.
+func match(value, q string) int {
+	v := normalize(value)
+	switch {
+	case q == "":
+		return noMatch
+	case v == q:
+		return exactMatch
+	case strings.HasPrefix(v, q):
+		return prefixMatch
+	}
+	for i, r := range v {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && strings.HasPrefix(v[i+utf8.RuneLen(r):], q) {
+			return wordPrefixMatch
+		}
+	}
+	return noMatch
+}
+
+// Rank returns at most limit of the candidates that match the query. Exact matches come
+// first, then values that start with the query and then values with a word that starts
+// with the query. Values that are equally good matches are ordered by the number of
+// upcoming events.
+func Rank(q string, candidates []models.Suggestion, limit int) []models.Suggestion {
+	q = normalize(q)
+	type ranked struct {
+		suggestion models.Suggestion
+		quality    int
+	}
+	var matches []ranked
+	for _, c := range candidates {
+		if quality := match(c.Value, q); quality != noMatch {
+			matches = append(matches, ranked{c, quality})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.quality != b.quality {
+			return a.quality > b.quality
+		}
+		if a.suggestion.Count != b.suggestion.Count {
+			return a.suggestion.Count > b.suggestion.Count
+		}
+		return a.suggestion.Value < b.suggestion.Value
+	})
+
+	suggestions := []models.Suggestion{}
+	for _, m := range matches[:min(limit, len(matches))] {
+		suggestions = append(suggestions, m.suggestion)
+	}
+	return suggestions
+}
+
+// Suggest returns suggestions of the given types for the query, at most limit per type.
+// Suggestions are ranked with Rank across all types.
+func Suggest(ctx context.Context, q string, types []string, limit int) ([]models.Suggestion, error) {
+	candidates, err := fetchCandidates(ctx, q, types)
+	if err != nil {
+		return nil, err
+	}
+	var suggestions []models.Suggestion
+	for _, t := range types {
+		suggestions = append(suggestions, Rank(q, candidates[t], limit)...)
+	}
+	return Rank(q, suggestions, len(suggestions)), nil
+}
+
+// fetchCandidates returns the artists, venues, cities and genres of upcoming events with
+// the number of events they occur in, grouped by type.
+func fetchCandidates(ctx context.Context, q string, types []string) (map[string][]models.Suggestion, error) {
+	d := time.Now()
+	f := storage.AutocompleteFilter{
+		Since:  time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, d.Location()),
+		Venues: slices.Contains(types, TypeVenue),
+		Cities: slices.Contains(types, TypeCity),
+		Genres: slices.Contains(types, TypeGenre),
+	}
+	if slices.Contains(types, TypeArtist) {
+		// the normalized title doesn't contain diacritics, so the query mustn't either
+		f.TitlePattern = `(^|\W)` + regexp.QuoteMeta(shared.RemoveDiacritics(strings.TrimSpace(q)))
+		f.MaxTitles = maxTitles
+	}
+	values, err := storage.S.Events.AutocompleteValues(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := map[string][]models.Suggestion{}
+	for t, suggestions := range map[string][]models.Suggestion{TypeVenue: values.Venues, TypeCity: values.Cities, TypeGenre: values.Genres} {
+		for _, s := range suggestions {
+			if s.Value != "" {
+				s.Type = t
+				candidates[t] = append(candidates[t], s)
+			}
+		}
+	}
+	if f.TitlePattern != "" {
+		candidates[TypeArtist] = countArtists(values.Titles)
+	}
+	return candidates, nil
+}
+
+// countArtists returns the artists in the given titles with the number of titles they
+// occur in. Artists are told apart by their normalized names and suggested the way they
+// are written first.
+func countArtists(titles []string) []models.Suggestion {
+	var suggestions []models.Suggestion
+	index := map[string]int{}
+	for _, title := range titles {
+		seen := map[string]bool{}
+		for _, a := range similar.ArtistNames(title) {
+			key := normalize(a)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if i, found := index[key]; found {
+				suggestions[i].Count++
+				continue
+			}
+			index[key] = len(suggestions)
+			suggestions = append(suggestions, models.Suggestion{Type: TypeArtist, Value: a, Count: 1})
+		}
+	}
+	return suggestions
+}
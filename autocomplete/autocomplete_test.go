@@ -0,0 +1,48 @@
+package autocomplete_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/jakopako/event-api/autocomplete"
+	"github.com/jakopako/event-api/models"
+)
+
+func TestRank(t *testing.T) {
+	candidates := []models.Suggestion{
+		{Type: autocomplete.TypeVenue, Value: "Bierhübeli", City: "Bern", Count: 3},
+		{Type: autocomplete.TypeArtist, Value: "Big Thief", Count: 1},
+		{Type: autocomplete.TypeVenue, Value: "Le Bikini", City: "Toulouse", Count: 10},
+		{Type: autocomplete.TypeCity, Value: "Biel/Bienne", Count: 8},
+		{Type: autocomplete.TypeGenre, Value: "bi", Count: 1},
+		{Type: autocomplete.TypeVenue, Value: "Kabinett", City: "Zurich", Count: 20},
+		{Type: autocomplete.TypeCity, Value: "Berlin", Count: 50},
+	}
+
+	tests := []struct {
+		q        string
+		limit    int
+		expected []string
+	}{
+		// exact matches first, then prefix matches and word matches, each by count
+		{"bi", 10, []string{"bi", "Biel/Bienne", "Bierhübeli", "Big Thief", "Le Bikini"}},
+		{"BIE", 10, []string{"Biel/Bienne", "Bierhübeli"}},
+		// diacritics are ignored in the query and in the values
+		{"bierhu", 10, []string{"Bierhübeli"}},
+		{"Bierhü", 10, []string{"Bierhübeli"}},
+		{"bienne", 10, []string{"Biel/Bienne"}},
+		{"bi", 2, []string{"bi", "Biel/Bienne"}},
+		{"abinett", 10, []string{}},
+		{" ", 10, []string{}},
+	}
+	for _, tt := range tests {
+		got := autocomplete.Rank(tt.q, candidates, tt.limit)
+		values := []string{}
+		for _, s := range got {
+			values = append(values, s.Value)
+		}
+		if !slices.Equal(values, tt.expected) {
+			t.Errorf("Rank(%q) = %v, expected %v", tt.q, values, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// Package backup dumps the events, venues, cities, genres, notifications and scraper
+// statuses of a store to a portable archive and restores them to any store.
+//
+// An archive is a file of newline-delimited JSON. The first line is a header with the
+// format and its version, each following line holds one item with its kind and its
+// document in canonical MongoDB Extended JSON, so that no type information is lost:
+//
+//	{"format":"event-api-backup","version":1,"createdAt":"2026-10-18T12:00:00Z"}
+//	{"kind":"cities","doc":{"name":"zurich","state":"","country":"switzerland",...}}
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// Format identifies archives in their header.
+	Format = "event-api-backup"
+	// Version is the version of the format that is written.
+	Version = 1
+)
+
+// The modes of restoring an archive. Merge keeps the stored items that aren't in the
+// archive, replace deletes all items of the restored kinds first.
+const (
+	ModeMerge   = "merge"
+	ModeReplace = "replace"
+)
+
+// batchSize is the number of items that are restored at once.
+const batchSize = 500
+
+type header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type line struct {
+	Kind string          `json:"kind"`
+	Doc  json.RawMessage `json:"doc"`
+}
+
+// Counts are the numbers of items per kind.
+type Counts map[string]int64
+
+// String returns the counts in the order of storage.Kinds, e.g. "events=12 cities=3".
+func (c Counts) String() string {
+	var parts []string
+	for _, kind := range storage.Kinds {
+		if n, ok := c[kind]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%d", kind, n))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseKinds parses a comma-separated list of kinds. An empty list stands for all kinds.
+func ParseKinds(list string) ([]string, error) {
+	if list == "" {
+		return storage.Kinds, nil
+	}
+	var kinds []string
+	for _, kind := range strings.Split(list, ",") {
+		kind = strings.TrimSpace(kind)
+		if !slices.Contains(storage.Kinds, kind) {
+			return nil, fmt.Errorf("unknown kind %q, the kinds are %s", kind, strings.Join(storage.Kinds, ", "))
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+// Export writes the archive of the items of the given kinds to w.
+func Export(ctx context.Context, r storage.BackupRepository, w io.Writer, kinds []string) (Counts, error) {
+	counts := Counts{}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(header{Format: Format, Version: Version, CreatedAt: time.Now().UTC()}); err != nil {
+		return counts, err
+	}
+	for _, kind := range kinds {
+		counts[kind] = 0
+		err := r.Dump(ctx, kind, func(doc bson.Raw) error {
+			ext, err := bson.MarshalExtJSON(doc, true, false)
+			if err != nil {
+				return err
+			}
+			if err := enc.Encode(line{Kind: kind, Doc: ext}); err != nil {
+				return err
+			}
+			counts[kind]++
+			return nil
+		})
+		if err != nil {
+			return counts, fmt.Errorf("failed to export %s: %w", kind, err)
+		}
+	}
+	return counts, bw.Flush()
+}
+
+// Options configure how an archive is restored.
+type Options struct {
+	// Mode is ModeMerge or ModeReplace.
+	Mode string
+	// Kinds are the kinds that are restored, the other items of the archive are skipped.
+	Kinds []string
+	// DryRun only checks the archive and counts its items without changing the store.
+	DryRun bool
+}
+
+// Import restores the items of the archive read from r to the store and returns their
+// numbers. Every item is checked before it is written. In replace mode the whole archive
+// is checked before the stored items are deleted, but in merge mode the items before an
+// invalid item have been restored.
+func Import(ctx context.Context, r storage.BackupRepository, rd io.Reader, opts Options) (Counts, error) {
+	if opts.Mode != ModeMerge && opts.Mode != ModeReplace {
+		return Counts{}, fmt.Errorf("mode has to be %s or %s, got %q", ModeMerge, ModeReplace, opts.Mode)
+	}
+	restore := func(kind string, docs []bson.Raw) error {
+		if err := r.Restore(ctx, kind, docs); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", kind, err)
+		}
+		return nil
+	}
+	if opts.DryRun {
+		return read(rd, opts.Kinds, nil)
+	}
+	if opts.Mode == ModeMerge {
+		return read(rd, opts.Kinds, restore)
+	}
+
+	// the archive is read twice, first to check it and then to restore it
+	rs, cleanup, err := rewindable(rd)
+	if err != nil {
+		return Counts{}, err
+	}
+	defer cleanup()
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Counts{}, err
+	}
+	if _, err := read(rs, opts.Kinds, nil); err != nil {
+		return Counts{}, err
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return Counts{}, err
+	}
+	// kinds without items in the archive are cleared as well
+	for _, kind := range opts.Kinds {
+		if err := r.Clear(ctx, kind); err != nil {
+			return Counts{}, fmt.Errorf("failed to clear %s: %w", kind, err)
+		}
+	}
+	return read(rs, opts.Kinds, restore)
+}
+
+// read reads the archive and calls restore with batches of the checked items of the kinds
+// unless restore is nil. It returns the numbers of the items.
+func read(rd io.Reader, kinds []string, restore func(kind string, docs []bson.Raw) error) (Counts, error) {
+	counts := Counts{}
+	br := bufio.NewReader(rd)
+	first, err := readLine(br)
+	if err != nil {
+		return counts, fmt.Errorf("failed to read the header: %w", err)
+	}
+	var h header
+	if err := json.Unmarshal(first, &h); err != nil || h.Format != Format {
+		return counts, errors.New("not a backup of the event api")
+	}
+	if h.Version != Version {
+		return counts, fmt.Errorf("unsupported version %d of the backup, expected %d", h.Version, Version)
+	}
+
+	var batch []bson.Raw
+	var batchKind string
+	flush := func() error {
+		if len(batch) == 0 || restore == nil {
+			batch = nil
+			return nil
+		}
+		err := restore(batchKind, batch)
+		batch = nil
+		return err
+	}
+	for n := 2; ; n++ {
+		data, err := readLine(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return counts, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		kind, doc, err := decode(data)
+		if err != nil {
+			return counts, fmt.Errorf("line %d: %w", n, err)
+		}
+		if !slices.Contains(kinds, kind) {
+			continue
+		}
+		if kind != batchKind || len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return counts, err
+			}
+			batchKind = kind
+		}
+		batch = append(batch, doc)
+		counts[kind]++
+	}
+	return counts, flush()
+}
+
+// rewindable returns rd if it can be read again from its current position, e.g. if it is
+// a file, and otherwise a temporary copy of it, e.g. of stdin. The returned function
+// removes the copy.
+func rewindable(rd io.Reader) (io.ReadSeeker, func(), error) {
+	if rs, ok := rd.(io.ReadSeeker); ok {
+		// pipes implement io.Seeker but fail to seek
+		if _, err := rs.Seek(0, io.SeekCurrent); err == nil {
+			return rs, func() {}, nil
+		}
+	}
+	f, err := os.CreateTemp("", "event-api-restore-*.ndjson")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to copy the archive: %w", err)
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if _, err := io.Copy(f, rd); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to copy the archive: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return f, cleanup, nil
+}
+
+// readLine returns the next line without its line break or io.EOF after the last line.
+func readLine(br *bufio.Reader) ([]byte, error) {
+	data, err := br.ReadBytes('\n')
+	if err == io.EOF && len(data) > 0 {
+		err = nil
+	}
+	return []byte(strings.TrimSpace(string(data))), err
+}
+
+// decode returns the kind and the BSON document of the item on the line. It fails if the
+// document isn't a valid item of its kind.
+func decode(data []byte) (string, bson.Raw, error) {
+	var l line
+	if err := json.Unmarshal(data, &l); err != nil {
+		return "", nil, err
+	}
+	var item any
+	switch l.Kind {
+	case storage.KindEvents, storage.KindArchive:
+		item = &models.Event{}
+	case storage.KindVenues:
+		item = &models.Venue{}
+	case storage.KindCities:
+		item = &models.City{}
+	case storage.KindGenres:
+		item = &models.TitleGenre{}
+	case storage.KindNotifications:
+		item = &models.Notification{}
+	case storage.KindStatuses:
+		item = &models.ScraperStatus{}
+	default:
+		return "", nil, fmt.Errorf("unknown kind %q", l.Kind)
+	}
+	var d bson.D
+	if err := bson.UnmarshalExtJSON(l.Doc, true, &d); err != nil {
+		return "", nil, fmt.Errorf("invalid %s document: %w", l.Kind, err)
+	}
+	doc, err := bson.Marshal(d)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := bson.Unmarshal(doc, item); err != nil {
+		return "", nil, fmt.Errorf("invalid %s document: %w", l.Kind, err)
+	}
+	return l.Kind, doc, nil
+}
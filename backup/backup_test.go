@@ -0,0 +1,220 @@
+package backup_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jakopako/event-api/backup"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"github.com/jakopako/event-api/storage/memory"
+	"github.com/jakopako/event-api/storage/sqlite"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var day = time.Date(2026, 11, 2, 20, 0, 0, 0, time.UTC)
+
+// seed returns a store with an item of every kind.
+func seed(t *testing.T) storage.Store {
+	t.Helper()
+	ctx := context.Background()
+	s := memory.New()
+	e := models.Event{Title: "Jazz Night", Location: "Moods", City: "Zurich", Date: day, Genres: []string{"jazz"}}
+	e.Address.Geolocacation.GeoJSONType = "Point"
+	e.Address.Geolocacation.Coordinates = []float64{8.54, 47.37}
+	key := models.EventKey{Title: e.Title, Date: e.Date, Location: e.Location}
+	e.SourceKey = &key
+	if _, err := s.Events.Upsert(ctx, []models.Event{e}); err != nil {
+		t.Fatal(err)
+	}
+	zurich := models.City{Name: "zurich", Country: "switzerland"}
+	zurich.Geolocation.Coordinates = []float64{8.5417, 47.3769}
+	if err := s.Cities.Insert(ctx, zurich); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Venues.Insert(ctx, models.Venue{Name: "Moods", Address: models.Address{Locality: "Zurich"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ArtistGenres.Insert(ctx, "miles davis", []string{"jazz"}); err != nil {
+		t.Fatal(err)
+	}
+	n := models.Notification{Email: "someone@example.com", Token: "token", SetupDate: day, Query: models.Query{City: "Zurich"}}
+	if _, err := s.Notifications.Add(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+	items := 3
+	if err := s.Statuses.Upsert(ctx, models.ScraperStatus{ScraperName: "moods", NrItems: &items, ScraperLogs: "done"}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func openSQLite(t *testing.T) storage.Store {
+	t.Helper()
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return sqlite.New(db)
+}
+
+func export(t *testing.T, s storage.Store) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	counts, err := backup.Export(context.Background(), s.Backup, &buf, storage.Kinds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := counts.String(), "events=1 archive=0 venues=1 cities=1 genres=1 notifications=1 statuses=1"; got != want {
+		t.Fatalf("exported %s, want %s", got, want)
+	}
+	return buf.Bytes()
+}
+
+func TestExportAndImport(t *testing.T) {
+	ctx := context.Background()
+	source := seed(t)
+	archive := export(t, source)
+
+	// the archive is restored to another backend
+	target := openSQLite(t)
+	counts, err := backup.Import(ctx, target.Backup, bytes.NewReader(archive), backup.Options{Mode: backup.ModeMerge, Kinds: storage.Kinds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := counts.String(), "events=1 venues=1 cities=1 genres=1 notifications=1 statuses=1"; got != want {
+		t.Errorf("imported %s, want %s", got, want)
+	}
+	restored, _, err := target.Events.Find(ctx, storage.EventFilter{}, 0, 10)
+	if err != nil || len(restored) != 1 {
+		t.Fatalf("got %d events with error %v, want 1", len(restored), err)
+	}
+	original, _, _ := source.Events.Find(ctx, storage.EventFilter{}, 0, 10)
+	if restored[0].ID != original[0].ID || restored[0].SourceKey == nil || !restored[0].Date.Equal(day) {
+		t.Errorf("got %+v, want %+v", restored[0], original[0])
+	}
+	if genres, err := target.ArtistGenres.Get(ctx, "miles davis"); err != nil || len(genres) != 1 {
+		t.Errorf("got genres %v with error %v", genres, err)
+	}
+	if _, err := target.Notifications.Get(ctx, "someone@example.com", "token"); err != nil {
+		t.Errorf("got error %v for the restored notification", err)
+	}
+	statuses, _, err := target.Statuses.Find(ctx, "moods", 0, 0, true)
+	if err != nil || len(statuses) != 1 || statuses[0].ScraperLogs != "done" || *statuses[0].NrItems != 3 {
+		t.Errorf("got statuses %+v with error %v", statuses, err)
+	}
+
+	// restoring again replaces the items instead of adding them twice
+	if _, err := backup.Import(ctx, target.Backup, bytes.NewReader(archive), backup.Options{Mode: backup.ModeMerge, Kinds: storage.Kinds}); err != nil {
+		t.Fatal(err)
+	}
+	if cities, err := target.Cities.FindByName(ctx, "zurich", ""); err != nil || len(cities) != 1 {
+		t.Errorf("got %d cities with error %v, want 1", len(cities), err)
+	}
+}
+
+func TestImportModes(t *testing.T) {
+	ctx := context.Background()
+	archive := export(t, seed(t))
+	target := memory.New()
+	if err := target.Cities.Insert(ctx, models.City{Name: "bern", Country: "switzerland"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a dry run changes nothing
+	counts, err := backup.Import(ctx, target.Backup, bytes.NewReader(archive), backup.Options{Mode: backup.ModeReplace, Kinds: storage.Kinds, DryRun: true})
+	if err != nil || counts[storage.KindCities] != 1 {
+		t.Fatalf("got counts %v with error %v", counts, err)
+	}
+	if _, err := target.Cities.Get(ctx, "zurich", "", "switzerland"); err != storage.ErrNotFound {
+		t.Errorf("got error %v after a dry run, want %v", err, storage.ErrNotFound)
+	}
+
+	// merging keeps the other cities, only the selected kinds are restored
+	if _, err := backup.Import(ctx, target.Backup, bytes.NewReader(archive), backup.Options{Mode: backup.ModeMerge, Kinds: []string{storage.KindCities}}); err != nil {
+		t.Fatal(err)
+	}
+	if cities, _ := target.Cities.Find(ctx, "", 10); len(cities) != 2 {
+		t.Errorf("got %d cities after merging, want 2", len(cities))
+	}
+	if events, _, _ := target.Events.Find(ctx, storage.EventFilter{}, 0, 10); len(events) != 0 {
+		t.Errorf("got %d events, want none since they weren't selected", len(events))
+	}
+
+	// replacing deletes them
+	if _, err := backup.Import(ctx, target.Backup, bytes.NewReader(archive), backup.Options{Mode: backup.ModeReplace, Kinds: storage.Kinds}); err != nil {
+		t.Fatal(err)
+	}
+	if cities, _ := target.Cities.Find(ctx, "", 10); len(cities) != 1 || cities[0].Name != "zurich" {
+		t.Errorf("got cities %+v after replacing, want zurich", cities)
+	}
+}
+
+func TestImportReplace(t *testing.T) {
+	ctx := context.Background()
+	archive := export(t, seed(t))
+	target := memory.New()
+	if err := target.Cities.Insert(ctx, models.City{Name: "bern", Country: "switzerland"}); err != nil {
+		t.Fatal(err)
+	}
+	past := models.Event{ID: primitive.NewObjectID(), Title: "Old Gig", Date: day.AddDate(-1, 0, 0)}
+	if _, err := target.Archive.Upsert(ctx, []models.Event{past}); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is deleted if the archive is invalid at its end
+	invalid := string(archive) + `{"kind":"cities","doc":{"name":1}}` + "\n"
+	if _, err := backup.Import(ctx, target.Backup, strings.NewReader(invalid), backup.Options{Mode: backup.ModeReplace, Kinds: storage.Kinds}); err == nil {
+		t.Fatal("expected an error for the invalid archive")
+	}
+	if _, err := target.Cities.Get(ctx, "bern", "", "switzerland"); err != nil {
+		t.Errorf("got error %v for a city after an invalid archive, want it to be kept", err)
+	}
+	if archived, _, _ := target.Archive.Find(ctx, storage.EventFilter{}, 0, 10); len(archived) != 1 {
+		t.Errorf("got %d archived events after an invalid archive, want 1", len(archived))
+	}
+
+	// the archive is read from a pipe that can't be rewound, the kinds without items in
+	// the archive are cleared as well
+	pipe := io.MultiReader(bytes.NewReader(archive))
+	if _, err := backup.Import(ctx, target.Backup, pipe, backup.Options{Mode: backup.ModeReplace, Kinds: storage.Kinds}); err != nil {
+		t.Fatal(err)
+	}
+	if cities, _ := target.Cities.Find(ctx, "", 10); len(cities) != 1 || cities[0].Name != "zurich" {
+		t.Errorf("got cities %+v after replacing, want zurich", cities)
+	}
+	if archived, _, _ := target.Archive.Find(ctx, storage.EventFilter{}, 0, 10); len(archived) != 0 {
+		t.Errorf("got %d archived events after replacing, want none", len(archived))
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	header := `{"format":"event-api-backup","version":1,"createdAt":"2026-10-18T12:00:00Z"}` + "\n"
+	tests := []struct {
+		name    string
+		archive string
+		err     string
+	}{
+		{"no header", `{"kind":"cities","doc":{}}`, "not a backup of the event api"},
+		{"version", `{"format":"event-api-backup","version":2}`, "unsupported version 2"},
+		{"kind", header + `{"kind":"users","doc":{}}`, `line 2: unknown kind "users"`},
+		{"document", header + "\n" + `{"kind":"cities","doc":{"name":1}}`, "line 3: invalid cities document"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := backup.Import(context.Background(), memory.New().Backup, strings.NewReader(tc.archive), backup.Options{Mode: backup.ModeMerge, Kinds: storage.Kinds})
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+	if _, err := backup.ParseKinds("events,users"); err == nil {
+		t.Error("expected an error for an unknown kind")
+	}
+}
@@ -0,0 +1,385 @@
+// Package client is a typed Go client for the event api. It is meant to be used by
+// scrapers and other services so that they don't have to deal with the http plumbing.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+)
+
+const (
+	defaultMaxRetries = 5
+	defaultRetryWait  = time.Second
+	maxRetryWait      = time.Minute
+)
+
+// Client talks to an event api instance. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	user       string
+	password   string
+	httpClient *http.Client
+	maxRetries int
+	retryWait  time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithBasicAuth sets the credentials that are needed for the protected endpoints.
+func WithBasicAuth(user, password string) Option {
+	return func(c *Client) {
+		c.user = user
+		c.password = password
+	}
+}
+
+// WithHTTPClient replaces the default http client.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithRetries sets how often a request is retried when the api responds with
+// 429 Too Many Requests and how long to wait before the first retry if the
+// response doesn't contain a Retry-After header. The wait time doubles with
+// every retry.
+func WithRetries(maxRetries int, wait time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryWait = wait
+	}
+}
+
+// New creates a client for the api running at baseURL, e.g. http://localhost:8080.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 90 * time.Second},
+		maxRetries: defaultMaxRetries,
+		retryWait:  defaultRetryWait,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is returned if the api responds with an unexpected status code.
+type Error struct {
+	StatusCode int
+	Message    string
+	Err        string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("event api returned status %d", e.StatusCode)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != "" {
+		msg += ": " + e.Err
+	}
+	return msg
+}
+
+// ValidationError is returned if some of the events sent to the api didn't pass the
+// validation. Events that passed the validation have still been added.
+type ValidationError struct {
+	StatusCode       int
+	Message          string
+	ValidationErrors []models.ValidateEventError
+	ValidatedEvents  []models.Event
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("event api returned status %d: %s (%d validation errors)", e.StatusCode, e.Message, len(e.ValidationErrors))
+}
+
+// Events returns a single page of events matching the query. The date of the query
+// is taken from q.StartDate; if it is set, only events of the 24 hours following the
+// start date are returned, otherwise all upcoming events. EndDate is ignored.
+func (c *Client) Events(ctx context.Context, q models.Query) (*models.GetEventsResponseSuccess, error) {
+	params := url.Values{}
+	setParam(params, "title", q.Title)
+	setParam(params, "location", q.Location)
+	setParam(params, "type", q.Type)
+	setParam(params, "city", q.City)
+	setParam(params, "country", q.Country)
+	setParam(params, "genres", strings.Join(q.Genres, ","))
+	setParam(params, "genreMode", q.GenreMode)
+	setParam(params, "excludeGenres", strings.Join(q.ExcludeGenres, ","))
+	setParam(params, "excludeTypes", strings.Join(q.ExcludeTypes, ","))
+	if q.Subgenres {
+		params.Set("subgenres", "true")
+	}
+	setParam(params, "filter", q.Filter)
+	if q.Radius > 0 {
+		params.Set("radius", strconv.Itoa(q.Radius))
+	}
+	if q.StartDate != nil {
+		params.Set("date", q.StartDate.UTC().Format(time.RFC3339))
+	}
+	if q.Page > 0 {
+		params.Set("page", strconv.Itoa(q.Page))
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.FormatInt(q.Limit, 10))
+	}
+
+	var resp models.GetEventsResponseSuccess
+	if err := c.do(ctx, http.MethodGet, "/api/events", params, nil, false, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AllEvents iterates over all events matching the query, fetching one page after the
+// other. Iteration starts at q.Page (or the first page) and stops at the first error.
+func (c *Client) AllEvents(ctx context.Context, q models.Query) iter.Seq2[models.Event, error] {
+	return func(yield func(models.Event, error) bool) {
+		if q.Page < 1 {
+			q.Page = 1
+		}
+		for {
+			page, err := c.Events(ctx, q)
+			if err != nil {
+				yield(models.Event{}, err)
+				return
+			}
+			for _, e := range page.Data {
+				if !yield(e, nil) {
+					return
+				}
+			}
+			if int64(q.Page) >= page.LastPage || len(page.Data) == 0 {
+				return
+			}
+			q.Page++
+		}
+	}
+}
+
+// ValidateEvents validates the events without adding them. The sanitized events are
+// returned. If some events are invalid a *ValidationError is returned.
+func (c *Client) ValidateEvents(ctx context.Context, events []models.Event) ([]models.Event, error) {
+	var resp models.ValidateAndAddEventsResponse
+	if err := c.doEvents(ctx, "/api/events/validate", events, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+	return resp.ValidatedEvents, nil
+}
+
+// AddEvents adds or updates the events. If some events are invalid a *ValidationError
+// is returned; the valid events have been added nevertheless.
+func (c *Client) AddEvents(ctx context.Context, events []models.Event) error {
+	return c.doEvents(ctx, "/api/events", events, http.StatusCreated, nil)
+}
+
+// DeleteEvents deletes all events of the given source. If from is not nil, only the
+// events starting at or after from are deleted. If sourceURL is empty, all events
+// starting at or after from are deleted.
+func (c *Client) DeleteEvents(ctx context.Context, sourceURL string, from *time.Time) error {
+	params := url.Values{}
+	params.Set("sourceUrl", sourceURL)
+	if from != nil {
+		params.Set("datetime", from.UTC().Format("2006-01-02 15:04"))
+	}
+	return c.do(ctx, http.MethodDelete, "/api/events", params, nil, true, http.StatusOK, nil)
+}
+
+// ScraperStatuses returns a single page of scraper statuses. If name is not empty only
+// the status of the scraper with that name is returned.
+func (c *Client) ScraperStatuses(ctx context.Context, name string, page int, limit int64, withLogs bool) (*models.GetScraperStatusResponse, error) {
+	params := url.Values{}
+	setParam(params, "name", name)
+	if page > 0 {
+		params.Set("page", strconv.Itoa(page))
+	}
+	if limit > 0 {
+		params.Set("limit", strconv.FormatInt(limit, 10))
+	}
+	if withLogs {
+		params.Set("returnScraperLogs", "true")
+	}
+	var resp models.GetScraperStatusResponse
+	if err := c.do(ctx, http.MethodGet, "/api/status", params, nil, false, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpsertScraperStatus inserts or updates the status of a scraper.
+func (c *Client) UpsertScraperStatus(ctx context.Context, status models.ScraperStatus) error {
+	body, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, "/api/status", nil, body, true, http.StatusOK, nil)
+}
+
+// DeleteScraperStatus deletes the status of a scraper.
+func (c *Client) DeleteScraperStatus(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/api/status/"+url.PathEscape(name), nil, nil, true, http.StatusOK, nil)
+}
+
+// AddNotification adds an inactive notification for the given query. An activation
+// email is sent to the given address.
+func (c *Client) AddNotification(ctx context.Context, email string, q models.Query) error {
+	params := url.Values{}
+	params.Set("email", email)
+	setParam(params, "title", q.Title)
+	setParam(params, "city", q.City)
+	setParam(params, "country", q.Country)
+	if q.Radius > 0 {
+		params.Set("radius", strconv.Itoa(q.Radius))
+	}
+	return c.do(ctx, http.MethodGet, "/api/notifications/add", params, nil, false, http.StatusCreated, nil)
+}
+
+// ActivateNotification activates a notification that has been added previously.
+func (c *Client) ActivateNotification(ctx context.Context, email, token string) (*models.Notification, error) {
+	params := url.Values{}
+	params.Set("email", email)
+	params.Set("token", token)
+	var resp models.ActivateNotificationResponse
+	if err := c.do(ctx, http.MethodGet, "/api/notifications/activate", params, nil, false, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// DeleteNotification deletes a notification.
+func (c *Client) DeleteNotification(ctx context.Context, email, token string) error {
+	params := url.Values{}
+	params.Set("email", email)
+	params.Set("token", token)
+	return c.do(ctx, http.MethodGet, "/api/notifications/delete", params, nil, false, http.StatusOK, nil)
+}
+
+// DeleteInactiveNotifications deletes all notifications that haven't been activated
+// within 24 hours.
+func (c *Client) DeleteInactiveNotifications(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/api/notifications/deleteInactive", nil, nil, true, http.StatusOK, nil)
+}
+
+// SendNotifications sends an email for every active notification with matching events.
+func (c *Client) SendNotifications(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/api/notifications/send", nil, nil, true, http.StatusOK, nil)
+}
+
+func (c *Client) doEvents(ctx context.Context, path string, events []models.Event, expected int, out *models.ValidateAndAddEventsResponse) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	var resp models.ValidateAndAddEventsResponse
+	err = c.do(ctx, http.MethodPost, path, nil, body, path == "/api/events", expected, &resp)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && len(resp.ValidationErrors) > 0 {
+		return &ValidationError{
+			StatusCode:       apiErr.StatusCode,
+			Message:          apiErr.Message,
+			ValidationErrors: resp.ValidationErrors,
+			ValidatedEvents:  resp.ValidatedEvents,
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		*out = resp
+	}
+	return nil
+}
+
+// do sends the request and decodes the response into out. Responses with a status
+// other than expected are returned as *Error; if out is not nil, the body of such a
+// response is decoded into out as well. Requests that are rate limited are retried.
+func (c *Client) do(ctx context.Context, method, path string, params url.Values, body []byte, auth bool, expected int, out any) error {
+	u := c.baseURL + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	wait := c.retryWait
+	for attempt := 0; ; attempt++ {
+		var bodyReader io.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u, bodyReader)
+		if err != nil {
+			return err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Accept", "application/json")
+		if auth {
+			req.SetBasicAuth(c.user, c.password)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < c.maxRetries {
+			d := wait
+			if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 {
+				d = time.Duration(s) * time.Second
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(d):
+			}
+			wait = min(2*wait, maxRetryWait)
+			continue
+		}
+
+		if resp.StatusCode != expected {
+			apiErr := &Error{StatusCode: resp.StatusCode}
+			var generic models.GenericResponse
+			if json.Unmarshal(respBody, &generic) == nil {
+				apiErr.Message = generic.Message
+				apiErr.Err = generic.Error
+			}
+			if out != nil {
+				json.Unmarshal(respBody, out)
+			}
+			return apiErr
+		}
+		if out != nil && len(respBody) > 0 {
+			if err := json.Unmarshal(respBody, out); err != nil {
+				return fmt.Errorf("failed to decode response: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+func setParam(params url.Values, key, value string) {
+	if value != "" {
+		params.Set(key, value)
+	}
+}
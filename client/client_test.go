@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+)
+
+func TestRetryOnTooManyRequests(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(models.GenericResponse{Success: true})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithBasicAuth("user", "secret"), WithRetries(3, time.Millisecond))
+	if err := c.DeleteScraperStatus(context.Background(), "some scraper"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls.Load())
+	}
+
+	calls.Store(0)
+	c = New(srv.URL, WithRetries(1, time.Millisecond))
+	err := c.DeleteScraperStatus(context.Background(), "some scraper")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected too many requests error, got %v", err)
+	}
+}
+
+func TestAddEventsValidationError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.ValidateAndAddEventsResponse{
+			Message: "some events were not inserted successfully into the database",
+			ValidationErrors: []models.ValidateEventError{
+				{Message: "failed to validate event", Error: "Key: 'Event.URL' Error:Field validation for 'URL' failed on the 'required' tag"},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	err := c.AddEvents(context.Background(), []models.Event{{Title: "Concert"}})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	if len(validationErr.ValidationErrors) != 1 || validationErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected validation error: %+v", validationErr)
+	}
+}
+
+func TestAllEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("city") != "Zurich" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		json.NewEncoder(w).Encode(models.GetEventsResponseSuccess{
+			Data:     []models.Event{{Title: "page " + strconv.Itoa(page)}},
+			Total:    3,
+			Page:     page,
+			LastPage: 3,
+			Limit:    1,
+		})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	var titles []string
+	for e, err := range c.AllEvents(context.Background(), models.Query{City: "Zurich", Limit: 1}) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		titles = append(titles, e.Title)
+	}
+	if len(titles) != 3 || titles[0] != "page 1" || titles[2] != "page 3" {
+		t.Fatalf("unexpected events: %v", titles)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jakopako/event-api/backup"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/controllers"
+	"github.com/jakopako/event-api/genre"
+	"github.com/jakopako/event-api/geo"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+)
+
+// command is a subcommand of the binary. The commands call the controllers directly, so
+// that jobs like sending the notifications can be run by cron without credentials.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "start the api server, the default", serve},
+	{"migrate", "apply the pending migrations of the database", migrateCommand},
+	{"archive", "move the past events to the archive", archiveCommand},
+	{"backup", "write a backup of the database to a file or stdout", backupCommand},
+	{"restore", "restore a backup from a file or stdin", restoreCommand},
+	{"send-notifications", "send the notifications about new events", sendNotificationsCommand},
+	{"purge-inactive-notifications", "delete the notifications that have never been activated", purgeInactiveNotificationsCommand},
+	{"regeocode", "look up the venues of the stored events again", regeocodeCommand},
+	{"regenre", "look up the genres of the stored concerts again", regenreCommand},
+	{"import", "insert or update the events of a JSON file like POST /api/events", importCommand},
+	{"export", "write the stored events to a JSON file or stdout", exportCommand},
+}
+
+// importBatchSize is the number of events that are imported at once.
+const importBatchSize = 100
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [command] [flags]\n\ncommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-30s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nrun a command with -h for its flags")
+}
+
+// parseSince parses a date of the form 2006-01-02. An empty date is returned as def.
+func parseSince(date string, def time.Time) (time.Time, error) {
+	if date == "" {
+		return def, nil
+	}
+	since, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		return since, fmt.Errorf("invalid date %q, expected the form 2006-01-02", date)
+	}
+	return since, nil
+}
+
+// today returns the start of the current day in UTC.
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+func migrateCommand(args []string) error {
+	flag.NewFlagSet("migrate", flag.ExitOnError).Parse(args)
+	closeStorage := setupStorage()
+	defer closeStorage(context.Background())
+	migrate()
+	return nil
+}
+
+func archiveCommand(args []string) error {
+	flag.NewFlagSet("archive", flag.ExitOnError).Parse(args)
+	if config.C.Archive.AfterDays == 0 {
+		return fmt.Errorf("ARCHIVE_AFTER_DAYS has to be configured to archive events")
+	}
+	closeStorage := setupStorage()
+	defer closeStorage(context.Background())
+	if err := archivePastEvents(context.Background()); err != nil {
+		return fmt.Errorf("failed to archive events: %w", err)
+	}
+	return nil
+}
+
+// backupCommand writes a backup to a file or stdout.
+func backupCommand(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	kindList := flags.String("kinds", "", "comma-separated list of the kinds to back up, all if empty")
+	output := flags.String("o", "-", "file to write the backup to, - for stdout")
+	flags.Parse(args)
+	kinds, err := backup.ParseKinds(*kindList)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	closeStorage := setupStorage()
+	defer closeStorage(context.Background())
+	counts, err := backup.Export(context.Background(), storage.S.Backup, w, kinds)
+	if err != nil {
+		return fmt.Errorf("failed to back up: %w", err)
+	}
+	slog.Info("backed up", "items", counts.String())
+	return nil
+}
+
+// restoreCommand restores a backup from a file or stdin.
+func restoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	kindList := flags.String("kinds", "", "comma-separated list of the kinds to restore, all if empty")
+	mode := flags.String("mode", backup.ModeMerge, "merge with the stored items or replace the stored items of the restored kinds")
+	dryRun := flags.Bool("dry-run", false, "check the backup without restoring it")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: restore [flags] file, - for stdin")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	kinds, err := backup.ParseKinds(*kindList)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	closeStorage := setupStorage()
+	defer closeStorage(context.Background())
+	counts, err := backup.Import(context.Background(), storage.S.Backup, r, backup.Options{Mode: *mode, Kinds: kinds, DryRun: *dryRun})
+	if err != nil {
+		return fmt.Errorf("failed to restore: %w", err)
+	}
+	slog.Info("restored", "items", counts.String(), "mode", *mode, "dryRun", *dryRun)
+	return nil
+}
+
+func sendNotificationsCommand(args []string) error {
+	flag.NewFlagSet("send-notifications", flag.ExitOnError).Parse(args)
+	closeStorage := setupStorage()
+	defer closeStorage(context.Background())
+	sent, err := controllers.SendAllNotifications(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to send notifications: %w", err)
+	}
+	slog.Info("sent notifications", "sent", sent)
+	return nil
+}
+
+func purgeInactiveNotificationsCommand(args []string) error {
+	flag.NewFlagSet("purge-inactive-notifications", flag.ExitOnError).Parse(args)
+	closeStorage := setupStorage()
+	defer closeStorage(context.Background())
+	deleted, err := controllers.PurgeInactiveNotifications(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to delete inactive notifications: %w", err)
+	}
+	slog.Info("deleted inactive notifications", "deleted", deleted)
+	return nil
+}
+
+func regeocodeCommand(args []string) error {
+	flags := flag.NewFlagSet("regeocode", flag.ExitOnError)
+	source := flags.String("source", "", "only the events of this source url")
+	sinceDate := flags.String("since", "", "only the events at or after this date, today if empty")
+	fallback := flags.Bool("fallback", false, "only the events that have the coordinates of their city")
+	flags.Parse(args)
+	since, err := parseSince(*sinceDate, today())
+	if err != nil {
+		return err
+	}
+	closeStorage := setupStorage()
+	defer closeStorage(context.Background())
+	geo.InitGeolocCache()
+	updated, err := controllers.RegeocodeEvents(context.Background(), *source, since, *fallback)
+	if err != nil {
+		return fmt.Errorf("failed to geocode events: %w", err)
+	}
+	slog.Info("geocoded events", "updated", updated, "fallbackOnly", *fallback)
+	return nil
+}
+
+func regenreCommand(args []string) error {
+	flags := flag.NewFlagSet("regenre", flag.ExitOnError)
+	source := flags.String("source", "", "only the events of this source url")
+	sinceDate := flags.String("since", "", "only the events at or after this date, today if empty")
+	all := flags.Bool("all", false, "also the concerts that already have genres")
+	flags.Parse(args)
+	since, err := parseSince(*sinceDate, today())
+	if err != nil {
+		return err
+	}
+	closeStorage := setupStorage()
+	defer closeStorage(context.Background())
+	genre.InitGenreCache()
+	updated, err := controllers.RegenreEvents(context.Background(), *source, since, *all)
+	if err != nil {
+		return fmt.Errorf("failed to look up genres: %w", err)
+	}
+	slog.Info("looked up genres", "updated", updated)
+	return nil
+}
+
+// importCommand imports a JSON array of events from a file or stdin. Webhooks aren't
+// notified about the changes.
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: import file, - for stdin")
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var r io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var events []models.Event
+	if err := json.NewDecoder(r).Decode(&events); err != nil {
+		return fmt.Errorf("failed to parse events: %w", err)
+	}
+
+	closeStorage := setupStorage()
+	defer closeStorage(context.Background())
+	geo.InitGeolocCache()
+	genre.InitGenreCache()
+	ctx := context.Background()
+	invalid := 0
+	for start := 0; start < len(events); start += importBatchSize {
+		batch := events[start:min(start+importBatchSize, len(events))]
+		validationErrs, err := controllers.IngestEvents(ctx, batch)
+		if err != nil {
+			return fmt.Errorf("failed to insert events: %w", err)
+		}
+		for _, e := range validationErrs {
+			slog.Warn("invalid event", "event", e.Message, "err", e.Error)
+		}
+		invalid += len(validationErrs)
+	}
+	if err := controllers.Drain(ctx); err != nil {
+		return err
+	}
+	slog.Info("imported events", "imported", len(events)-invalid, "invalid", invalid)
+	return nil
+}
+
+// exportCommand writes the stored events as a JSON array that can be imported again.
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	source := flags.String("source", "", "only the events of this source url")
+	sinceDate := flags.String("since", "", "only the events at or after this date, all if empty")
+	output := flags.String("o", "-", "file to write the events to, - for stdout")
+	flags.Parse(args)
+	since, err := parseSince(*sinceDate, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	closeStorage := setupStorage()
+	defer closeStorage(context.Background())
+	// all events are read at once, which can take longer than the storage timeout
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	events, err := storage.S.Events.FindBySource(ctx, *source, &since)
+	if err != nil {
+		return fmt.Errorf("failed to fetch events: %w", err)
+	}
+	if events == nil {
+		events = []models.Event{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(events); err != nil {
+		return err
+	}
+	slog.Info("exported events", "exported", len(events))
+	return nil
+}
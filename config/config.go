@@ -0,0 +1,389 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the api. It is loaded by Load from an optional YAML
+// file and the environment variables, which take precedence over the file.
+type Config struct {
+	Port  string `yaml:"port"`
+	Debug bool   `yaml:"debug"`
+	// ShutdownTimeout is the time the api waits for requests in progress when it is stopped.
+	ShutdownTimeout time.Duration       `yaml:"shutdownTimeout"`
+	API             APIConfig           `yaml:"api"`
+	Storage         StorageConfig       `yaml:"storage"`
+	MongoDB         MongoDBConfig       `yaml:"mongodb"`
+	Limiter         LimiterConfig       `yaml:"limiter"`
+	Cache           CacheConfig         `yaml:"cache"`
+	Archive         ArchiveConfig       `yaml:"archive"`
+	Notifications   NotificationsConfig `yaml:"notifications"`
+	Users           UsersConfig         `yaml:"users"`
+	Webhooks        WebhooksConfig      `yaml:"webhooks"`
+	Genre           GenreConfig         `yaml:"genre"`
+	Geo             GeoConfig           `yaml:"geo"`
+}
+
+// APIConfig holds the credentials of the endpoints that require basic auth.
+type APIConfig struct {
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+type StorageConfig struct {
+	// Backend is StorageMongoDB or StorageSQLite.
+	Backend          string `yaml:"backend"`
+	SQLitePath       string `yaml:"sqlitePath"`
+	MigrateOnStartup bool   `yaml:"migrateOnStartup"`
+	// Timeout is the time a database operation of a request may take.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type MongoDBConfig struct {
+	URI            string        `yaml:"uri"`
+	DB             string        `yaml:"db"`
+	ConnectTimeout time.Duration `yaml:"connectTimeout"`
+}
+
+// LimiterConfig configures the rate limit of the notification, validation and login endpoints.
+type LimiterConfig struct {
+	Max        int           `yaml:"max"`
+	Expiration time.Duration `yaml:"expiration"`
+}
+
+type CacheConfig struct {
+	Expiration time.Duration `yaml:"expiration"`
+}
+
+// ArchiveConfig configures the retention job that moves past events to the archive.
+type ArchiveConfig struct {
+	// AfterDays is the number of days after which past events are archived, 0 disables
+	// the job.
+	AfterDays int           `yaml:"afterDays"`
+	Interval  time.Duration `yaml:"interval"`
+}
+
+type NotificationsConfig struct {
+	// Enabled is nil if it hasn't been configured explicitly, see IsEnabled.
+	Enabled        *bool  `yaml:"enabled"`
+	SMTPUser       string `yaml:"smtpUser"`
+	SMTPPassword   string `yaml:"smtpPassword"`
+	SMTPHost       string `yaml:"smtpHost"`
+	SMTPPort       string `yaml:"smtpPort"`
+	ActivationURL  string `yaml:"activationUrl"`
+	QueryURL       string `yaml:"queryUrl"`
+	UnsubscribeURL string `yaml:"unsubscribeUrl"`
+}
+
+type UsersConfig struct {
+	MagicLinkURL string `yaml:"magicLinkUrl"`
+}
+
+type WebhooksConfig struct {
+	// AllowPrivateHosts allows webhooks to be delivered to loopback, private and link-local
+	// addresses, e.g. to a receiver in the same network.
+	AllowPrivateHosts bool `yaml:"allowPrivateHosts"`
+	// MaxPerUser is the number of webhooks a user may register.
+	MaxPerUser int `yaml:"maxPerUser"`
+}
+
+type GenreConfig struct {
+	LookupSpotify       bool   `yaml:"lookupSpotify"`
+	SpotifyClientID     string `yaml:"spotifyClientId"`
+	SpotifyClientSecret string `yaml:"spotifyClientSecret"`
+}
+
+type GeoConfig struct {
+	NominatimURL string `yaml:"nominatimUrl"`
+	// NominatimInterval is the minimum time between two requests to Nominatim, 0 doesn't
+	// limit the requests, e.g. for a self-hosted instance.
+	NominatimInterval time.Duration `yaml:"nominatimInterval"`
+	// BackfillInterval is how often the server looks up the venues of the events that only
+	// have the coordinates of their city again, 0 disables the job.
+	BackfillInterval time.Duration `yaml:"backfillInterval"`
+}
+
+// IsEnabled returns whether notifications are sent. Unless it is configured explicitly,
+// the feature is enabled as soon as one of its settings is given, so that an incomplete
+// configuration is reported at startup.
+func (n NotificationsConfig) IsEnabled() bool {
+	if n.Enabled != nil {
+		return *n.Enabled
+	}
+	return n.SMTPUser != "" || n.SMTPPassword != "" || n.SMTPHost != "" || n.SMTPPort != "" ||
+		n.ActivationURL != "" || n.QueryURL != "" || n.UnsubscribeURL != ""
+}
+
+// C is the configuration of the api. It holds the defaults until main replaces it with
+// the loaded configuration.
+var C = Default()
+
+// Default returns the configuration that is used for the settings that aren't given.
+func Default() *Config {
+	return &Config{
+		Port:            "5000",
+		ShutdownTimeout: 30 * time.Second,
+		Storage: StorageConfig{
+			Backend:          StorageMongoDB,
+			SQLitePath:       "events.db",
+			MigrateOnStartup: true,
+			Timeout:          10 * time.Second,
+		},
+		MongoDB: MongoDBConfig{
+			ConnectTimeout: 10 * time.Second,
+		},
+		Limiter: LimiterConfig{
+			Max:        20,
+			Expiration: time.Minute,
+		},
+		Cache: CacheConfig{
+			Expiration: time.Minute,
+		},
+		Archive: ArchiveConfig{
+			Interval: 24 * time.Hour,
+		},
+		Webhooks: WebhooksConfig{
+			MaxPerUser: 20,
+		},
+		Geo: GeoConfig{
+			NominatimURL:      "https://nominatim.openstreetmap.org/search",
+			NominatimInterval: time.Second,
+		},
+	}
+}
+
+// Load returns the configuration read from the YAML file at path, if path isn't empty,
+// and the environment variables. It returns an error if the configuration is invalid.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(content))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+	if err := c.loadEnv(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadEnv overwrites the settings for which an environment variable is set. Empty
+// variables are ignored.
+func (c *Config) loadEnv() error {
+	var errs []error
+	lookup := func(name string) (string, bool) {
+		s := os.Getenv(name)
+		return s, s != ""
+	}
+	str := func(name string, v *string) {
+		if s, ok := lookup(name); ok {
+			*v = s
+		}
+	}
+	boolean := func(name string, v *bool) {
+		if s, ok := lookup(name); ok {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s has to be true or false, got %q", name, s))
+				return
+			}
+			*v = b
+		}
+	}
+	integer := func(name string, v *int) {
+		if s, ok := lookup(name); ok {
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s has to be an integer, got %q", name, s))
+				return
+			}
+			*v = i
+		}
+	}
+	duration := func(name string, v *time.Duration) {
+		if s, ok := lookup(name); ok {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s has to be a duration like 30s or 5m, got %q", name, s))
+				return
+			}
+			*v = d
+		}
+	}
+
+	str("PORT", &c.Port)
+	boolean("DEBUG", &c.Debug)
+	duration("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	str("API_USER", &c.API.User)
+	str("API_PASSWORD", &c.API.Password)
+
+	str("STORAGE", &c.Storage.Backend)
+	str("SQLITE_PATH", &c.Storage.SQLitePath)
+	boolean("MIGRATE_ON_STARTUP", &c.Storage.MigrateOnStartup)
+
+	if os.Getenv("IN_CONTAINER") == "Yes" {
+		str("MONGO_URI", &c.MongoDB.URI)
+	} else {
+		str("LOCAL_MONGO_URI", &c.MongoDB.URI)
+	}
+	str("DB", &c.MongoDB.DB)
+	duration("DB_CONNECT_TIMEOUT", &c.MongoDB.ConnectTimeout)
+	duration("DB_TIMEOUT", &c.Storage.Timeout)
+
+	integer("LIMITER_MAX", &c.Limiter.Max)
+	duration("LIMITER_EXPIRATION", &c.Limiter.Expiration)
+	duration("CACHE_EXPIRATION", &c.Cache.Expiration)
+
+	integer("ARCHIVE_AFTER_DAYS", &c.Archive.AfterDays)
+	duration("ARCHIVE_INTERVAL", &c.Archive.Interval)
+
+	if _, ok := lookup("NOTIFICATIONS_ENABLED"); ok {
+		var enabled bool
+		boolean("NOTIFICATIONS_ENABLED", &enabled)
+		c.Notifications.Enabled = &enabled
+	}
+	str("SMTP_USER", &c.Notifications.SMTPUser)
+	str("SMTP_PASSWORD", &c.Notifications.SMTPPassword)
+	str("SMTP_HOST", &c.Notifications.SMTPHost)
+	str("SMTP_PORT", &c.Notifications.SMTPPort)
+	str("ACTIVATION_URL", &c.Notifications.ActivationURL)
+	str("QUERY_URL", &c.Notifications.QueryURL)
+	str("UNSUBSCRIBE_URL", &c.Notifications.UnsubscribeURL)
+
+	str("MAGIC_LINK_URL", &c.Users.MagicLinkURL)
+
+	boolean("WEBHOOK_ALLOW_PRIVATE_HOSTS", &c.Webhooks.AllowPrivateHosts)
+	integer("WEBHOOK_MAX_PER_USER", &c.Webhooks.MaxPerUser)
+
+	boolean("LOOKUP_SPOTIFY_GENRE", &c.Genre.LookupSpotify)
+	str("SPOTIFY_CLIENT_ID", &c.Genre.SpotifyClientID)
+	str("SPOTIFY_CLIENT_SECRET", &c.Genre.SpotifyClientSecret)
+
+	str("NOMINATIM_URL", &c.Geo.NominatimURL)
+	duration("NOMINATIM_INTERVAL", &c.Geo.NominatimInterval)
+	duration("GEO_BACKFILL_INTERVAL", &c.Geo.BackfillInterval)
+
+	return errors.Join(errs...)
+}
+
+// Validate returns all problems of the configuration. The settings are referred to by
+// the names of their environment variables.
+func (c *Config) Validate() error {
+	var errs []error
+	required := func(name, value, reason string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is required%s", name, reason))
+		}
+	}
+	absoluteURL := func(name, value string) {
+		if value == "" {
+			return
+		}
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s has to be an absolute URL, got %q", name, value))
+		}
+	}
+	positive := func(name string, value time.Duration) {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s has to be positive, got %s", name, value))
+		}
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT has to be a port number, got %q", c.Port))
+	}
+	positive("SHUTDOWN_TIMEOUT", c.ShutdownTimeout)
+
+	switch c.Storage.Backend {
+	case StorageMongoDB:
+		required("MONGO_URI or LOCAL_MONGO_URI", c.MongoDB.URI, " for the storage backend mongodb")
+		required("DB", c.MongoDB.DB, " for the storage backend mongodb")
+	case StorageSQLite:
+		required("SQLITE_PATH", c.Storage.SQLitePath, " for the storage backend sqlite")
+	default:
+		errs = append(errs, fmt.Errorf("STORAGE has to be %s or %s, got %q", StorageMongoDB, StorageSQLite, c.Storage.Backend))
+	}
+	positive("DB_CONNECT_TIMEOUT", c.MongoDB.ConnectTimeout)
+	positive("DB_TIMEOUT", c.Storage.Timeout)
+
+	if c.Limiter.Max < 1 {
+		errs = append(errs, fmt.Errorf("LIMITER_MAX has to be at least 1, got %d", c.Limiter.Max))
+	}
+	positive("LIMITER_EXPIRATION", c.Limiter.Expiration)
+	positive("CACHE_EXPIRATION", c.Cache.Expiration)
+	if c.Archive.AfterDays < 0 {
+		errs = append(errs, fmt.Errorf("ARCHIVE_AFTER_DAYS has to be at least 0, got %d", c.Archive.AfterDays))
+	}
+	positive("ARCHIVE_INTERVAL", c.Archive.Interval)
+
+	if n := c.Notifications; n.IsEnabled() {
+		reason := " when notifications are enabled"
+		required("SMTP_USER", n.SMTPUser, reason)
+		required("SMTP_PASSWORD", n.SMTPPassword, reason)
+		required("SMTP_HOST", n.SMTPHost, reason)
+		required("SMTP_PORT", n.SMTPPort, reason)
+		required("ACTIVATION_URL", n.ActivationURL, reason)
+		required("QUERY_URL", n.QueryURL, reason)
+		required("UNSUBSCRIBE_URL", n.UnsubscribeURL, reason)
+	}
+	absoluteURL("ACTIVATION_URL", c.Notifications.ActivationURL)
+	absoluteURL("QUERY_URL", c.Notifications.QueryURL)
+	absoluteURL("UNSUBSCRIBE_URL", c.Notifications.UnsubscribeURL)
+	absoluteURL("MAGIC_LINK_URL", c.Users.MagicLinkURL)
+	if c.Webhooks.MaxPerUser < 0 {
+		errs = append(errs, fmt.Errorf("WEBHOOK_MAX_PER_USER has to be at least 0, got %d", c.Webhooks.MaxPerUser))
+	}
+
+	if c.Genre.LookupSpotify {
+		reason := " when LOOKUP_SPOTIFY_GENRE is true"
+		required("SPOTIFY_CLIENT_ID", c.Genre.SpotifyClientID, reason)
+		required("SPOTIFY_CLIENT_SECRET", c.Genre.SpotifyClientSecret, reason)
+	}
+
+	required("NOMINATIM_URL", c.Geo.NominatimURL, "")
+	absoluteURL("NOMINATIM_URL", c.Geo.NominatimURL)
+	if c.Geo.NominatimInterval < 0 {
+		errs = append(errs, fmt.Errorf("NOMINATIM_INTERVAL has to be at least 0, got %s", c.Geo.NominatimInterval))
+	}
+	if c.Geo.BackfillInterval < 0 {
+		errs = append(errs, fmt.Errorf("GEO_BACKFILL_INTERVAL has to be at least 0, got %s", c.Geo.BackfillInterval))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// ValidateServe returns the problems of the settings that are only needed to serve the
+// api, so that the commands can be run without them.
+func (c *Config) ValidateServe() error {
+	var errs []error
+	if c.API.User == "" {
+		errs = append(errs, errors.New("API_USER is required"))
+	}
+	if c.API.Password == "" {
+		errs = append(errs, errors.New("API_PASSWORD is required"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jakopako/event-api/config"
+)
+
+// env are the variables that make up a valid configuration. Variables that are empty are
+// ignored by Load.
+var env = map[string]string{
+	"PORT":                        "",
+	"DEBUG":                       "",
+	"SHUTDOWN_TIMEOUT":            "",
+	"API_USER":                    "user",
+	"API_PASSWORD":                "password",
+	"STORAGE":                     "",
+	"SQLITE_PATH":                 "",
+	"MIGRATE_ON_STARTUP":          "",
+	"IN_CONTAINER":                "",
+	"MONGO_URI":                   "",
+	"LOCAL_MONGO_URI":             "mongodb://localhost:27017",
+	"DB":                          "events",
+	"DB_CONNECT_TIMEOUT":          "",
+	"DB_TIMEOUT":                  "",
+	"LIMITER_MAX":                 "",
+	"LIMITER_EXPIRATION":          "",
+	"CACHE_EXPIRATION":            "",
+	"ARCHIVE_AFTER_DAYS":          "",
+	"ARCHIVE_INTERVAL":            "",
+	"NOTIFICATIONS_ENABLED":       "",
+	"SMTP_USER":                   "",
+	"SMTP_PASSWORD":               "",
+	"SMTP_HOST":                   "",
+	"SMTP_PORT":                   "",
+	"ACTIVATION_URL":              "",
+	"QUERY_URL":                   "",
+	"UNSUBSCRIBE_URL":             "",
+	"MAGIC_LINK_URL":              "",
+	"WEBHOOK_ALLOW_PRIVATE_HOSTS": "",
+	"WEBHOOK_MAX_PER_USER":        "",
+	"LOOKUP_SPOTIFY_GENRE":        "",
+	"SPOTIFY_CLIENT_ID":           "",
+	"SPOTIFY_CLIENT_SECRET":       "",
+	"NOMINATIM_URL":               "",
+	"NOMINATIM_INTERVAL":          "",
+	"GEO_BACKFILL_INTERVAL":       "",
+}
+
+func setEnv(t *testing.T, overrides map[string]string) {
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+	for name, value := range overrides {
+		t.Setenv(name, value)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	setEnv(t, nil)
+	c, err := config.Load("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Port != "5000" || c.Storage.Backend != config.StorageMongoDB || !c.Storage.MigrateOnStartup {
+		t.Errorf("unexpected defaults: %+v", c)
+	}
+	if c.Limiter.Max != 20 || c.Limiter.Expiration != time.Minute || c.Cache.Expiration != time.Minute {
+		t.Errorf("unexpected limiter and cache defaults: %+v %+v", c.Limiter, c.Cache)
+	}
+	if c.Archive.AfterDays != 0 || c.Archive.Interval != 24*time.Hour {
+		t.Errorf("unexpected archive defaults: %+v", c.Archive)
+	}
+	if c.Geo.NominatimInterval != time.Second || c.Geo.BackfillInterval != 0 {
+		t.Errorf("unexpected geo defaults: %+v", c.Geo)
+	}
+	if c.MongoDB.URI != "mongodb://localhost:27017" || c.Notifications.IsEnabled() {
+		t.Errorf("unexpected configuration: %+v", c)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+port: "8080"
+storage:
+  backend: sqlite
+  sqlitePath: /data/events.db
+limiter:
+  max: 50
+  expiration: 2m
+cache:
+  expiration: 30s
+geo:
+  nominatimUrl: http://nominatim.local/search
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// the environment takes precedence over the file
+	setEnv(t, map[string]string{"LIMITER_MAX": "10", "PORT": ""})
+	c, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Port != "8080" || c.Storage.Backend != config.StorageSQLite || c.Storage.SQLitePath != "/data/events.db" {
+		t.Errorf("unexpected configuration: %+v", c)
+	}
+	if c.Limiter.Max != 10 || c.Limiter.Expiration != 2*time.Minute || c.Cache.Expiration != 30*time.Second {
+		t.Errorf("unexpected limiter and cache: %+v %+v", c.Limiter, c.Cache)
+	}
+	if c.Geo.NominatimURL != "http://nominatim.local/search" {
+		t.Errorf("unexpected nominatim url %q", c.Geo.NominatimURL)
+	}
+
+	if err := os.WriteFile(path, []byte("limiter:\n  maximum: 50\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Load(path); err == nil || !strings.Contains(err.Error(), "maximum") {
+		t.Errorf("expected an error about the unknown field, got %v", err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		errors []string
+	}{
+		{
+			name: "incomplete notifications",
+			env: map[string]string{
+				"SMTP_USER":       "smtp@example.com",
+				"SMTP_PASSWORD":   "secret",
+				"SMTP_HOST":       "mail.example.com",
+				"SMTP_PORT":       "587",
+				"ACTIVATION_URL":  "http://localhost:5000/api/notifications/activate",
+				"UNSUBSCRIBE_URL": "http://localhost:5000/api/notifications/delete",
+			},
+			errors: []string{"QUERY_URL is required when notifications are enabled"},
+		},
+		{
+			name:   "notifications enabled explicitly",
+			env:    map[string]string{"NOTIFICATIONS_ENABLED": "true"},
+			errors: []string{"SMTP_HOST is required", "ACTIVATION_URL is required"},
+		},
+		{
+			name:   "spotify",
+			env:    map[string]string{"LOOKUP_SPOTIFY_GENRE": "true"},
+			errors: []string{"SPOTIFY_CLIENT_ID is required when LOOKUP_SPOTIFY_GENRE is true"},
+		},
+		{
+			name:   "storage",
+			env:    map[string]string{"STORAGE": "postgres"},
+			errors: []string{`STORAGE has to be mongodb or sqlite, got "postgres"`},
+		},
+		{
+			name:   "mongodb",
+			env:    map[string]string{"LOCAL_MONGO_URI": "", "DB": ""},
+			errors: []string{"MONGO_URI or LOCAL_MONGO_URI is required", "DB is required"},
+		},
+		{
+			name:   "malformed values",
+			env:    map[string]string{"LIMITER_MAX": "many", "CACHE_EXPIRATION": "60", "DEBUG": "yes"},
+			errors: []string{"LIMITER_MAX has to be an integer", "CACHE_EXPIRATION has to be a duration", "DEBUG has to be true or false"},
+		},
+		{
+			name:   "out of range",
+			env:    map[string]string{"PORT": "0", "LIMITER_MAX": "0", "DB_TIMEOUT": "-1s", "ARCHIVE_AFTER_DAYS": "-7", "NOMINATIM_INTERVAL": "-1s"},
+			errors: []string{"PORT has to be a port number", "LIMITER_MAX has to be at least 1", "DB_TIMEOUT has to be positive", "ARCHIVE_AFTER_DAYS has to be at least 0", "NOMINATIM_INTERVAL has to be at least 0"},
+		},
+		{
+			name:   "relative url",
+			env:    map[string]string{"MAGIC_LINK_URL": "/login"},
+			errors: []string{`MAGIC_LINK_URL has to be an absolute URL, got "/login"`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setEnv(t, test.env)
+			_, err := config.Load("")
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, e := range test.errors {
+				if !strings.Contains(err.Error(), e) {
+					t.Errorf("expected error %q in %q", e, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateServe(t *testing.T) {
+	setEnv(t, map[string]string{"API_USER": "", "API_PASSWORD": ""})
+	c, err := config.Load("")
+	if err != nil {
+		t.Fatalf("the commands must not need the api credentials: %v", err)
+	}
+	err = c.ValidateServe()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, e := range []string{"API_USER is required", "API_PASSWORD is required"} {
+		if !strings.Contains(err.Error(), e) {
+			t.Errorf("expected error %q in %q", e, err)
+		}
+	}
+}
+
+func TestLoadExampleFile(t *testing.T) {
+	setEnv(t, map[string]string{"API_USER": "", "API_PASSWORD": "", "LOCAL_MONGO_URI": "", "DB": ""})
+	c, err := config.Load("../config.example.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !c.Notifications.IsEnabled() || c.MongoDB.DB != "croncert" {
+		t.Errorf("unexpected configuration: %+v", c)
+	}
+}
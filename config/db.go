@@ -4,14 +4,18 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
-	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
+// The storage backends that can be configured with STORAGE.
+const (
+	StorageMongoDB = "mongodb"
+	StorageSQLite  = "sqlite"
+)
+
 type MongoInstance struct {
 	Client *mongo.Client
 	DB     *mongo.Database
@@ -20,19 +24,12 @@ type MongoInstance struct {
 var MI MongoInstance
 
 func ConnectDB() {
-	var client *mongo.Client
-	var err error
-	if os.Getenv("IN_CONTAINER") == "Yes" {
-		client, err = mongo.NewClient(options.Client().ApplyURI(os.Getenv("MONGO_URI")))
-	} else {
-		client, err = mongo.NewClient(options.Client().ApplyURI(os.Getenv("LOCAL_MONGO_URI")))
-	}
-
+	client, err := mongo.NewClient(options.Client().ApplyURI(C.MongoDB.URI))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), C.MongoDB.ConnectTimeout)
 	defer cancel()
 
 	err = client.Connect(ctx)
@@ -49,6 +46,14 @@ func ConnectDB() {
 
 	MI = MongoInstance{
 		Client: client,
-		DB:     client.Database(os.Getenv("DB")),
+		DB:     client.Database(C.MongoDB.DB),
+	}
+}
+
+// DisconnectDB closes the connections to MongoDB after the operations in progress are done.
+func DisconnectDB(ctx context.Context) error {
+	if MI.Client == nil {
+		return nil
 	}
+	return MI.Client.Disconnect(ctx)
 }
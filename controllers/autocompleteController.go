@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/autocomplete"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/models"
+)
+
+// GetAutocomplete func gets suggestions for what a user is typing.
+// @Description This endpoint returns artists, venues, cities and genres of upcoming events that start with the query or contain a word that starts with it, ignoring case and diacritics. Exact matches come first, then prefix matches and then word matches, each ordered by the number of upcoming events.
+// @Summary Get autocomplete suggestions.
+// @Tags events
+// @Produce json
+// @Param q query string true "what the user typed"
+// @Param types query string false "comma separated list of the types of suggestions, artist, venue, city and genre, defaults to all"
+// @Param limit query int false "maximum number of suggestions per type, defaults to 5, at most 20"
+// @Success 200 {object} models.GetAutocompleteResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/autocomplete [get]
+func GetAutocomplete(c *fiber.Ctx) error {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch suggestions",
+			Error:   "q parameter is required",
+		})
+	}
+	limit, _ := strconv.Atoi(c.Query("limit", "5"))
+	if limit < 1 || limit > 20 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch suggestions",
+			Error:   "limit parameter must be between 1 and 20",
+		})
+	}
+	types := autocomplete.Types
+	if t := c.Query("types"); t != "" {
+		types = []string{}
+		for _, s := range strings.Split(t, ",") {
+			s = strings.TrimSpace(s)
+			if !slices.Contains(autocomplete.Types, s) {
+				return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+					Success: false,
+					Message: "failed to fetch suggestions",
+					Error:   fmt.Sprintf("unknown type %q, must be one of %s", s, strings.Join(autocomplete.Types, ", ")),
+				})
+			}
+			if !slices.Contains(types, s) {
+				types = append(types, s)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	suggestions, err := autocomplete.Suggest(ctx, q, types, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch suggestions",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GetAutocompleteResponse{
+		Success: true,
+		Data:    suggestions,
+	})
+}
@@ -0,0 +1,862 @@
+package controllers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/account"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/controllers"
+	"github.com/jakopako/event-api/geo"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/moderation"
+	"github.com/jakopako/event-api/routes"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+	"github.com/jakopako/event-api/storage/memory"
+	"github.com/jakopako/event-api/stream"
+	"github.com/jakopako/event-api/webhook"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	apiUser     = "user"
+	apiPassword = "password"
+)
+
+var (
+	app *fiber.App
+	// day is the start of the day after tomorrow, so that all events are in the future
+	day = time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 2)
+)
+
+func TestMain(m *testing.M) {
+	config.C.API = config.APIConfig{User: apiUser, Password: apiPassword}
+	// venues that aren't stored are looked up without waiting for each other
+	config.C.Geo.NominatimInterval = 0
+	storage.S = memory.New()
+	geo.InitGeolocCache()
+	app = fiber.New()
+	routes.SetupRoutes(app)
+	os.Exit(m.Run())
+}
+
+// seed replaces the store with a new one containing the given events and the city of Zurich.
+func seed(t *testing.T, events ...models.Event) {
+	t.Helper()
+	storage.S = memory.New()
+	ctx := context.Background()
+	zurich := models.City{Name: "zurich", Country: "switzerland"}
+	zurich.Geolocation.GeoJSONType = "Point"
+	zurich.Geolocation.Coordinates = []float64{8.5417, 47.3769}
+	if err := storage.S.Cities.Insert(ctx, zurich); err != nil {
+		t.Fatal(err)
+	}
+	for i := range events {
+		events[i].NormalizedTitle = shared.RemoveDiacritics(events[i].Title)
+		if events[i].Genres == nil {
+			events[i].Genres = []string{}
+		}
+	}
+	if _, err := storage.S.Events.Upsert(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func event(title, city string, lon, lat float64, date time.Time, genres ...string) models.Event {
+	e := models.Event{
+		Title:     title,
+		Location:  "Venue " + title,
+		City:      city,
+		Country:   "Switzerland",
+		Date:      date,
+		URL:       "http://example.com/" + url.PathEscape(title),
+		Type:      "concert",
+		SourceURL: "http://example.com/" + city,
+		Genres:    genres,
+	}
+	e.Address.Geolocacation.GeoJSONType = "Point"
+	e.Address.Geolocacation.Coordinates = []float64{lon, lat}
+	return e
+}
+
+func defaultEvents() []models.Event {
+	return []models.Event{
+		event("Jazz Night", "Zurich", 8.54, 47.37, day.Add(20*time.Hour), "jazz", "soul"),
+		event("Techno Party", "Winterthur", 8.72, 47.50, day.Add(44*time.Hour), "techno"),
+		event("Café Concert", "Bern", 7.44, 46.95, day.Add(68*time.Hour), "jazz"),
+		event("Hard Techno Rave", "Bern", 7.44, 46.95, day.Add(92*time.Hour), "hard techno"),
+	}
+}
+
+// do sends the request to the app and decodes the json response into v unless v is nil.
+func do(t *testing.T, method, target string, body any, auth bool, v any) int {
+	t.Helper()
+	return send(t, method, target, body, func(req *http.Request) {
+		if auth {
+			req.SetBasicAuth(apiUser, apiPassword)
+		}
+	}, v)
+}
+
+// doAs sends the request to the app with the session token as bearer token and decodes the
+// json response into v unless v is nil.
+func doAs(t *testing.T, token, method, target string, body any, v any) int {
+	t.Helper()
+	return send(t, method, target, body, func(req *http.Request) {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}, v)
+}
+
+// send sends the request, to which authorize adds the credentials, to the app and decodes
+// the json response into v unless v is nil.
+func send(t *testing.T, method, target string, body any, authorize func(req *http.Request), v any) int {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req := httptest.NewRequest(method, target, r)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	authorize(req)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response of %s %s: %v", method, target, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func titles(events []models.Event) []string {
+	var t []string
+	for _, e := range events {
+		t = append(t, e.Title)
+	}
+	return t
+}
+
+func TestGetAllEvents(t *testing.T) {
+	seed(t, defaultEvents()...)
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Jazz Night", "Techno Party", "Café Concert", "Hard Techno Rave"}},
+		{"title=techno", []string{"Techno Party", "Hard Techno Rave"}},
+		{"title=cafe", []string{"Café Concert"}},
+		{"city=zurich&radius=30", []string{"Jazz Night", "Techno Party"}},
+		{"city=bern", []string{"Café Concert", "Hard Techno Rave"}},
+		{"genres=jazz", []string{"Jazz Night", "Café Concert"}},
+		{"genres=jazz,soul&genreMode=all", []string{"Jazz Night"}},
+		{"genres=techno&subgenres=true", []string{"Techno Party", "Hard Techno Rave"}},
+		{"excludeGenres=jazz", []string{"Techno Party", "Hard Techno Rave"}},
+		{"filter=" + url.QueryEscape(`genres in ("jazz", "techno") and not city = "Bern"`), []string{"Jazz Night", "Techno Party"}},
+		{"date=" + url.QueryEscape(day.Format(time.RFC3339)), []string{"Jazz Night"}},
+	}
+	for _, tc := range tests {
+		var resp models.GetEventsResponseSuccess
+		if code := do(t, http.MethodGet, "/api/events?"+tc.query, nil, false, &resp); code != fiber.StatusOK {
+			t.Errorf("query %q: got status %d, want %d", tc.query, code, fiber.StatusOK)
+			continue
+		}
+		if got := titles(resp.Data); !slices.Equal(got, tc.want) {
+			t.Errorf("query %q: got %v, want %v", tc.query, got, tc.want)
+		}
+		if resp.Total != int64(len(tc.want)) {
+			t.Errorf("query %q: got total %d, want %d", tc.query, resp.Total, len(tc.want))
+		}
+	}
+}
+
+func TestGetAllEventsPaging(t *testing.T) {
+	seed(t, defaultEvents()...)
+	var resp models.GetEventsResponseSuccess
+	if code := do(t, http.MethodGet, "/api/events?limit=3&page=2", nil, false, &resp); code != fiber.StatusOK {
+		t.Fatalf("got status %d, want %d", code, fiber.StatusOK)
+	}
+	if got, want := titles(resp.Data), []string{"Hard Techno Rave"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if resp.Total != 4 || resp.LastPage != 2 {
+		t.Errorf("got total %d and last page %d, want 4 and 2", resp.Total, resp.LastPage)
+	}
+	if code := do(t, http.MethodGet, "/api/events?page=0", nil, false, nil); code != fiber.StatusBadRequest {
+		t.Errorf("got status %d for page 0, want %d", code, fiber.StatusBadRequest)
+	}
+}
+
+func TestGetAllEventsRecurring(t *testing.T) {
+	weekly := event("Weekly Jam", "Zurich", 8.54, 47.37, day.Add(-7*24*time.Hour+19*time.Hour), "jazz")
+	weekly.Recurrence = &models.Recurrence{RRule: "FREQ=WEEKLY"}
+	seed(t, append(defaultEvents(), weekly)...)
+	var resp models.GetEventsResponseSuccess
+	if code := do(t, http.MethodGet, "/api/events?genres=jazz&date="+url.QueryEscape(day.Format(time.RFC3339)), nil, false, &resp); code != fiber.StatusOK {
+		t.Fatalf("got status %d, want %d", code, fiber.StatusOK)
+	}
+	if got, want := titles(resp.Data), []string{"Weekly Jam", "Jazz Night"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if len(resp.Data) > 0 && !resp.Data[0].Date.Equal(day.Add(19*time.Hour)) {
+		t.Errorf("got occurrence at %v, want %v", resp.Data[0].Date, day.Add(19*time.Hour))
+	}
+
+	// the pages of the stored events merged with the occurrences are the same as the slices
+	// of all of them, also behind the stored events
+	events := []models.Event{weekly}
+	for i := range 25 {
+		events = append(events, event(fmt.Sprintf("Gig %d", i), "Zurich", 8.54, 47.37, day.Add(time.Duration(i)*50*time.Hour), "rock"))
+	}
+	seed(t, events...)
+	var all models.GetEventsResponseSuccess
+	do(t, http.MethodGet, "/api/events?limit=1000", nil, false, &all)
+	if all.Total != int64(len(all.Data)) || len(all.Data) < 70 {
+		t.Fatalf("got %d of %d events", len(all.Data), all.Total)
+	}
+	for _, limit := range []int{1, 3, 10} {
+		for page := 1; (page-1)*limit < len(all.Data)+limit; page++ {
+			var resp models.GetEventsResponseSuccess
+			target := fmt.Sprintf("/api/events?limit=%d&page=%d", limit, page)
+			if code := do(t, http.MethodGet, target, nil, false, &resp); code != fiber.StatusOK {
+				t.Fatalf("got status %d for %s", code, target)
+			}
+			want := all.Data[min((page-1)*limit, len(all.Data)):min(page*limit, len(all.Data))]
+			same := slices.EqualFunc(resp.Data, want, func(a, b models.Event) bool { return a.Title == b.Title && a.Date.Equal(b.Date) })
+			if !same || resp.Total != all.Total {
+				t.Errorf("got %v of %d events for %s, want %v of %d", titles(resp.Data), resp.Total, target, titles(want), all.Total)
+			}
+		}
+	}
+	if code := do(t, http.MethodGet, "/api/events?limit=10&page=1000", nil, false, nil); code != fiber.StatusOK {
+		t.Errorf("got status %d for a page behind all events", code)
+	}
+}
+
+func TestGetAllEventsIncludePast(t *testing.T) {
+	seed(t, append(defaultEvents(), event("Old Gig", "Zurich", 8.54, 47.37, day.AddDate(0, 0, -5), "jazz"))...)
+	if _, err := storage.S.Archive.MoveBefore(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"genres=jazz", []string{"Jazz Night", "Café Concert"}},
+		{"genres=jazz&includePast=true", []string{"Old Gig", "Jazz Night", "Café Concert"}},
+		{"location=" + url.QueryEscape("Venue Old Gig") + "&includePast=true", []string{"Old Gig"}},
+		{"includePast=true&date=" + url.QueryEscape(day.AddDate(0, 0, -5).Format(time.RFC3339)), []string{"Old Gig"}},
+		{"genres=jazz&includePast=true&limit=2&page=2", []string{"Café Concert"}},
+	}
+	for _, tc := range tests {
+		var resp models.GetEventsResponseSuccess
+		if code := do(t, http.MethodGet, "/api/events?"+tc.query, nil, false, &resp); code != fiber.StatusOK {
+			t.Errorf("query %q: got status %d, want %d", tc.query, code, fiber.StatusOK)
+			continue
+		}
+		if got := titles(resp.Data); !slices.Equal(got, tc.want) {
+			t.Errorf("query %q: got %v, want %v", tc.query, got, tc.want)
+		}
+	}
+
+	// merged events can only be paged through up to a limit
+	lastPage := shared.MaxMergedEvents / 10
+	for page, want := range map[int]int{lastPage: fiber.StatusOK, lastPage + 1: fiber.StatusBadRequest} {
+		target := fmt.Sprintf("/api/events?includePast=true&limit=10&page=%d", page)
+		if code := do(t, http.MethodGet, target, nil, false, nil); code != want {
+			t.Errorf("got status %d for page %d, want %d", code, page, want)
+		}
+	}
+}
+
+func TestGetDistinct(t *testing.T) {
+	seed(t, defaultEvents()...)
+	var resp models.GetDistinctFieldResponse
+	if code := do(t, http.MethodGet, "/api/events/city", nil, false, &resp); code != fiber.StatusOK {
+		t.Fatalf("got status %d, want %d", code, fiber.StatusOK)
+	}
+	counts := map[string]int64{}
+	for _, v := range resp.Data {
+		counts[v.Value] = v.Count
+	}
+	if counts["Bern"] != 2 || counts["Zurich"] != 1 || counts["Winterthur"] != 1 {
+		t.Errorf("got %v, want Bern: 2, Zurich: 1, Winterthur: 1", resp.Data)
+	}
+}
+
+func TestGetStatistics(t *testing.T) {
+	seed(t, defaultEvents()...)
+	var resp models.GetStatisticsResponse
+	if code := do(t, http.MethodGet, "/api/statistics?genres=jazz", nil, false, &resp); code != fiber.StatusOK {
+		t.Fatalf("got status %d, want %d", code, fiber.StatusOK)
+	}
+	if resp.Data.Total != 2 {
+		t.Errorf("got total %d, want 2", resp.Data.Total)
+	}
+	var calendar int64
+	for _, v := range resp.Data.Calendar {
+		calendar += v.Count
+	}
+	if calendar != 2 {
+		t.Errorf("got %d events in the calendar, want 2", calendar)
+	}
+	if len(resp.Data.Genres) == 0 || resp.Data.Genres[0] != (models.DistinctValue{Value: "jazz", Count: 2}) {
+		t.Errorf("got genres %v, want jazz first with 2 events", resp.Data.Genres)
+	}
+
+	for _, target := range []string{"/api/statistics?interval=month", "/api/statistics?months=1000", "/api/statistics?filter=genres+in"} {
+		if code := do(t, http.MethodGet, target, nil, false, nil); code != fiber.StatusBadRequest {
+			t.Errorf("got status %d for %s, want %d", code, target, fiber.StatusBadRequest)
+		}
+	}
+	storage.S.Events = failingEvents{storage.S.Events}
+	if code := do(t, http.MethodGet, "/api/statistics", nil, false, nil); code != fiber.StatusInternalServerError {
+		t.Errorf("got status %d for a failing store, want %d", code, fiber.StatusInternalServerError)
+	}
+}
+
+// failingEvents fails to count events like a store that can't be reached.
+type failingEvents struct {
+	storage.EventRepository
+}
+
+func (failingEvents) CountValues(ctx context.Context, f storage.EventFilter, keys []string) (map[string]map[string]int64, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestScraperStatus(t *testing.T) {
+	seed(t)
+	nr := 3
+	status := models.ScraperStatus{
+		ScraperName:     "bern",
+		NrItems:         &nr,
+		NrErrors:        &nr,
+		LastScrapeStart: day,
+		LastScrapeEnd:   day.Add(time.Minute),
+		ScraperLogs:     "done",
+	}
+	if code := do(t, http.MethodPost, "/api/status", status, false, nil); code != fiber.StatusUnauthorized {
+		t.Errorf("got status %d without credentials, want %d", code, fiber.StatusUnauthorized)
+	}
+	if code := do(t, http.MethodPost, "/api/status", status, true, nil); code != fiber.StatusOK {
+		t.Fatalf("got status %d, want %d", code, fiber.StatusOK)
+	}
+
+	var resp models.GetScraperStatusResponse
+	if code := do(t, http.MethodGet, "/api/status?name=bern", nil, false, &resp); code != fiber.StatusOK {
+		t.Fatalf("got status %d, want %d", code, fiber.StatusOK)
+	}
+	if resp.Total != 1 || len(resp.Data) != 1 || resp.Data[0].ScraperName != "bern" || resp.Data[0].ScraperLogs != "" {
+		t.Errorf("got %+v, want the status of bern without logs", resp)
+	}
+
+	if code := do(t, http.MethodDelete, "/api/status/bern", nil, true, nil); code != fiber.StatusOK {
+		t.Errorf("got status %d, want %d", code, fiber.StatusOK)
+	}
+	if code := do(t, http.MethodDelete, "/api/status/bern", nil, true, nil); code != fiber.StatusNotFound {
+		t.Errorf("got status %d for deleted status, want %d", code, fiber.StatusNotFound)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	seed(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for _, n := range []models.Notification{
+		{Email: "new@example.com", Token: "new", SetupDate: now},
+		{Email: "old@example.com", Token: "old", SetupDate: now.AddDate(0, 0, -2)},
+	} {
+		if _, err := storage.S.Notifications.Add(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if code := do(t, http.MethodGet, "/api/notifications/activate?email=old@example.com&token=old", nil, false, nil); code != fiber.StatusNotFound {
+		t.Errorf("got status %d for expired notification, want %d", code, fiber.StatusNotFound)
+	}
+	var resp models.ActivateNotificationResponse
+	if code := do(t, http.MethodGet, "/api/notifications/activate?email=new@example.com&token=new", nil, false, &resp); code != fiber.StatusOK {
+		t.Fatalf("got status %d, want %d", code, fiber.StatusOK)
+	}
+	if !resp.Data.Active {
+		t.Errorf("notification has not been activated")
+	}
+
+	if code := do(t, http.MethodDelete, "/api/notifications/deleteInactive", nil, true, nil); code != fiber.StatusOK {
+		t.Fatalf("got status %d, want %d", code, fiber.StatusOK)
+	}
+	if _, err := storage.S.Notifications.Get(ctx, "old@example.com", "old"); err != storage.ErrNotFound {
+		t.Errorf("got error %v for expired notification, want %v", err, storage.ErrNotFound)
+	}
+
+	if code := do(t, http.MethodGet, "/api/notifications/delete?email=new@example.com&token=new", nil, false, nil); code != fiber.StatusOK {
+		t.Fatalf("got status %d, want %d", code, fiber.StatusOK)
+	}
+	active, err := storage.S.Notifications.FindActive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 0 {
+		t.Errorf("got %d active notifications after deletion, want 0", len(active))
+	}
+}
+
+func TestRegeocodeEvents(t *testing.T) {
+	events := defaultEvents()
+	manual := models.Address{Locality: "Winterthur", Street: "Manual Street"}
+	events[1].Overrides = &models.EventOverrides{Address: &manual}
+	seed(t, events...)
+	ctx := context.Background()
+	for _, v := range []models.Venue{
+		{Name: "Venue Jazz Night", Address: models.Address{Locality: "Zurich", Country: "Switzerland", Street: "Seefeldstrasse"}},
+		{Name: "Venue Techno Party", Address: models.Address{Locality: "Winterthur", Country: "Switzerland", Street: "Lagerplatz"}},
+	} {
+		if err := storage.S.Venues.Insert(ctx, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the other venues can't be found, the address of the techno party is manual
+	updated, err := controllers.RegeocodeEvents(ctx, "", day, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 1 {
+		t.Errorf("updated %d events, want 1", updated)
+	}
+	stored, err := storage.S.Events.FindBySource(ctx, "", &day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range stored {
+		want := ""
+		if e.Title == "Jazz Night" {
+			want = "Seefeldstrasse"
+		}
+		if e.Address.Street != want {
+			t.Errorf("got street %q for %s, want %q", e.Address.Street, e.Title, want)
+		}
+	}
+
+	// nothing changes the second time
+	if updated, err := controllers.RegeocodeEvents(ctx, "", day, false); err != nil || updated != 0 {
+		t.Errorf("updated %d events with error %v, want 0", updated, err)
+	}
+}
+
+func TestRegeocodeEventsFallbackOnly(t *testing.T) {
+	events := defaultEvents()
+	// the venue of the jazz night has been found when it was added, the techno party has
+	// the coordinates of its city
+	events[0].Address.Street = "Old Street"
+	events[1].CityFallback = true
+	seed(t, events...)
+	ctx := context.Background()
+	for _, v := range []models.Venue{
+		{Name: "Venue Jazz Night", Address: models.Address{Locality: "Zurich", Country: "Switzerland", Street: "New Street"}},
+		{Name: "Venue Techno Party", Address: models.Address{Locality: "Winterthur", Country: "Switzerland", Street: "Lagerplatz"}},
+	} {
+		if err := storage.S.Venues.Insert(ctx, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fixed, err := controllers.RegeocodeEvents(ctx, "", day, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fixed != 1 {
+		t.Errorf("fixed %d events, want 1", fixed)
+	}
+	stored, err := storage.S.Events.FindBySource(ctx, "", &day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range stored {
+		want := map[string]string{"Jazz Night": "Old Street", "Techno Party": "Lagerplatz"}[e.Title]
+		if e.Address.Street != want {
+			t.Errorf("got street %q for %s, want %q", e.Address.Street, e.Title, want)
+		}
+		if e.CityFallback {
+			t.Errorf("%s is still marked as city fallback", e.Title)
+		}
+	}
+}
+
+// storedEvents returns the stored upcoming events by their titles.
+func storedEvents(t *testing.T) map[string]models.Event {
+	t.Helper()
+	stored, err := storage.S.Events.FindBySource(context.Background(), "", &time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byTitle := map[string]models.Event{}
+	for _, e := range stored {
+		byTitle[e.Title] = e
+	}
+	return byTitle
+}
+
+func TestEventOverrides(t *testing.T) {
+	seed(t, defaultEvents()...)
+	id := storedEvents(t)["Jazz Night"].ID.Hex()
+
+	title := "Jazz Night (sold out)"
+	var updated models.GetEventResponse
+	if status := do(t, "PUT", "/api/events/id/"+id+"/overrides", models.EventOverrides{Title: &title}, true, &updated); status != fiber.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	if updated.Data.Title != title {
+		t.Errorf("got title %q, want %q", updated.Data.Title, title)
+	}
+	// the scraped event still finds the overridden one
+	if e := storedEvents(t)[title]; e.SourceKey == nil || e.SourceKey.Title != "Jazz Night" {
+		t.Errorf("got source key %v", e.SourceKey)
+	}
+	var overridden models.GetEventsResponseSuccess
+	do(t, "GET", "/api/events/overrides", nil, true, &overridden)
+	if !slices.Equal(titles(overridden.Data), []string{title}) || overridden.Total != 1 {
+		t.Errorf("got overridden events %v (%d)", titles(overridden.Data), overridden.Total)
+	}
+
+	if status := do(t, "DELETE", "/api/events/id/"+id+"/overrides", nil, true, nil); status != fiber.StatusOK {
+		t.Errorf("got status %d", status)
+	}
+	do(t, "GET", "/api/events/overrides", nil, true, &overridden)
+	if overridden.Total != 0 {
+		t.Errorf("got %d overridden events after clearing", overridden.Total)
+	}
+	if status := do(t, "DELETE", "/api/events/id/"+primitive.NewObjectID().Hex()+"/overrides", nil, true, nil); status != fiber.StatusNotFound {
+		t.Errorf("got status %d for a missing event", status)
+	}
+}
+
+func TestModerateEvents(t *testing.T) {
+	events := defaultEvents()
+	events[0].ModerationStatus = moderation.StatusPending
+	events[1].ModerationStatus = moderation.StatusPending
+	seed(t, events...)
+	stored := storedEvents(t)
+
+	var pending models.GetEventsResponseSuccess
+	do(t, "GET", "/api/moderation/pending", nil, true, &pending)
+	if want := []string{"Jazz Night", "Techno Party"}; !slices.Equal(titles(pending.Data), want) {
+		t.Errorf("got pending events %v, want %v", titles(pending.Data), want)
+	}
+
+	// approved events are new to the streams and webhooks
+	payloads := make(chan models.WebhookPayload, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p models.WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("failed to decode webhook payload: %v", err)
+		}
+		payloads <- p
+	}))
+	defer server.Close()
+	if status := do(t, "POST", "/api/webhooks", models.Webhook{URL: server.URL}, true, nil); status != fiber.StatusCreated {
+		t.Fatalf("got status %d", status)
+	}
+	// the receiver listens on a loopback address
+	config.C.Webhooks.AllowPrivateHosts = true
+	webhook.InitDispatcher()
+	t.Cleanup(func() {
+		webhook.D = nil
+		config.C.Webhooks.AllowPrivateHosts = false
+	})
+	matcher, err := shared.NewEventMatcher(context.Background(), models.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := stream.H.Subscribe(matcher)
+	defer stream.H.Unsubscribe(sub)
+
+	do(t, "POST", "/api/moderation/events/"+stored["Jazz Night"].ID.Hex()+"/approve", nil, true, nil)
+	do(t, "POST", "/api/moderation/events/"+stored["Techno Party"].ID.Hex()+"/reject", nil, true, nil)
+	var visible models.GetEventsResponseSuccess
+	do(t, "GET", "/api/events?limit=10", nil, false, &visible)
+	if want := []string{"Jazz Night", "Café Concert", "Hard Techno Rave"}; !slices.Equal(titles(visible.Data), want) {
+		t.Errorf("got visible events %v, want %v", titles(visible.Data), want)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := controllers.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	close(payloads)
+	var changes []string
+	for p := range payloads {
+		changes = append(changes, p.Change+":"+p.Event.Title)
+	}
+	if want := []string{"created:Jazz Night"}; !slices.Equal(changes, want) {
+		t.Errorf("got webhook changes %v, want %v", changes, want)
+	}
+	select {
+	case e := <-sub.Events:
+		if e.Title != "Jazz Night" {
+			t.Errorf("got streamed event %s, want Jazz Night", e.Title)
+		}
+	default:
+		t.Error("the approved event hasn't been streamed")
+	}
+}
+
+func TestEventViewsAndSimilarEvents(t *testing.T) {
+	seed(t, defaultEvents()...)
+	stored := storedEvents(t)
+	id := stored["Café Concert"].ID.Hex()
+
+	for range 2 {
+		if status := do(t, "POST", "/api/events/id/"+id+"/view", nil, false, nil); status != fiber.StatusOK {
+			t.Fatalf("got status %d", status)
+		}
+	}
+	if p := storedEvents(t)["Café Concert"].Popularity; p == nil || p.Views != 2 {
+		t.Errorf("got popularity %+v, want 2 views", p)
+	}
+
+	// the jazz night shares the genre, the hard techno rave the city
+	var similar models.GetSimilarEventsResponse
+	do(t, "GET", "/api/events/id/"+id+"/similar", nil, false, &similar)
+	if want := []string{"Hard Techno Rave", "Jazz Night"}; !slices.Equal(titles(similar.Data), want) {
+		t.Errorf("got similar events %v, want %v", titles(similar.Data), want)
+	}
+}
+
+func TestGetAutocomplete(t *testing.T) {
+	seed(t, defaultEvents()...)
+	var suggestions models.GetAutocompleteResponse
+	do(t, "GET", "/api/autocomplete?q=techno", nil, false, &suggestions)
+	var got []string
+	for _, s := range suggestions.Data {
+		got = append(got, s.Type+":"+s.Value)
+	}
+	// exact matches first, then prefixes and then prefixes of words
+	want := []string{"genre:techno", "artist:Techno Party", "artist:Hard Techno Rave", "venue:Venue Hard Techno Rave", "venue:Venue Techno Party", "genre:hard techno"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got suggestions %v, want %v", got, want)
+	}
+}
+
+func TestModerationRules(t *testing.T) {
+	seed(t)
+	var added models.AddModerationRuleResponse
+	rule := models.ModerationRule{Field: "title", Pattern: "private", Action: moderation.ActionReject}
+	if status := do(t, "POST", "/api/moderation/rules", rule, true, &added); status != fiber.StatusCreated {
+		t.Fatalf("got status %d", status)
+	}
+	var rules models.GetModerationRulesResponse
+	do(t, "GET", "/api/moderation/rules", nil, true, &rules)
+	if len(rules.Data) != 1 || rules.Data[0].ID != added.Data.ID {
+		t.Errorf("got rules %+v", rules.Data)
+	}
+
+	var validated models.ValidateAndAddEventsResponse
+	events := []models.Event{event("Private Party", "Zurich", 8.54, 47.37, day)}
+	if status := do(t, "POST", "/api/events/validate", events, false, &validated); status != fiber.StatusBadRequest || len(validated.ValidationErrors) != 1 {
+		t.Errorf("got status %d and errors %+v, want the event to be rejected", status, validated.ValidationErrors)
+	}
+
+	id := added.Data.ID.Hex()
+	if status := do(t, "DELETE", "/api/moderation/rules/"+id, nil, true, nil); status != fiber.StatusOK {
+		t.Errorf("got status %d", status)
+	}
+	if status := do(t, "DELETE", "/api/moderation/rules/"+id, nil, true, nil); status != fiber.StatusNotFound {
+		t.Errorf("got status %d for a deleted rule", status)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	seed(t)
+	var added models.AddWebhookResponse
+	hook := models.Webhook{URL: "https://example.com/hook", Changes: []string{"created"}}
+	if status := do(t, "POST", "/api/webhooks", hook, true, &added); status != fiber.StatusCreated || added.Data.Secret == "" {
+		t.Fatalf("got status %d and webhook %+v", status, added.Data)
+	}
+	var hooks models.GetWebhooksResponse
+	do(t, "GET", "/api/webhooks", nil, true, &hooks)
+	if len(hooks.Data) != 1 || hooks.Data[0].Secret != "" {
+		t.Errorf("got webhooks %+v, want one without secret", hooks.Data)
+	}
+
+	id := added.Data.ID.Hex()
+	var deliveries models.GetWebhookDeliveriesResponse
+	if status := do(t, "GET", "/api/webhooks/"+id+"/deliveries", nil, true, &deliveries); status != fiber.StatusOK || deliveries.Total != 0 {
+		t.Errorf("got status %d and %d deliveries", status, deliveries.Total)
+	}
+	if status := do(t, "DELETE", "/api/webhooks/"+id, nil, true, nil); status != fiber.StatusOK {
+		t.Errorf("got status %d", status)
+	}
+	if status := do(t, "DELETE", "/api/webhooks/"+id, nil, true, nil); status != fiber.StatusNotFound {
+		t.Errorf("got status %d for a deleted webhook", status)
+	}
+}
+
+func TestWebhookOwners(t *testing.T) {
+	seed(t)
+	var admin models.AddWebhookResponse
+	do(t, "POST", "/api/webhooks", models.Webhook{URL: "https://example.com/admin"}, true, &admin)
+	var login models.LoginResponse
+	if status := do(t, "POST", "/api/users/register", models.Credentials{Email: "partner@example.com", Password: "secret password"}, false, &login); status != fiber.StatusCreated {
+		t.Fatalf("got status %d", status)
+	}
+	var added models.AddWebhookResponse
+	if status := doAs(t, login.Token, "POST", "/api/webhooks", models.Webhook{URL: "https://example.com/partner"}, &added); status != fiber.StatusCreated {
+		t.Fatalf("got status %d", status)
+	}
+
+	// users only see their own webhooks, the admin sees all of them
+	var hooks models.GetWebhooksResponse
+	doAs(t, login.Token, "GET", "/api/webhooks", nil, &hooks)
+	if len(hooks.Data) != 1 || hooks.Data[0].ID != added.Data.ID {
+		t.Errorf("got webhooks %+v, want the one of the user", hooks.Data)
+	}
+	do(t, "GET", "/api/webhooks", nil, true, &hooks)
+	if len(hooks.Data) != 2 {
+		t.Errorf("got %d webhooks for the admin, want 2", len(hooks.Data))
+	}
+
+	other := admin.Data.ID.Hex()
+	if status := doAs(t, login.Token, "GET", "/api/webhooks/"+other+"/deliveries", nil, nil); status != fiber.StatusNotFound {
+		t.Errorf("got status %d for the deliveries of another webhook", status)
+	}
+	if status := doAs(t, login.Token, "DELETE", "/api/webhooks/"+other, nil, nil); status != fiber.StatusNotFound {
+		t.Errorf("got status %d for deleting another webhook", status)
+	}
+	if status := doAs(t, "invalid", "GET", "/api/webhooks", nil, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("got status %d for an invalid token", status)
+	}
+	if status := doAs(t, login.Token, "POST", "/api/webhooks", models.Webhook{URL: "file:///etc/passwd"}, nil); status != fiber.StatusBadRequest {
+		t.Errorf("got status %d for a webhook that isn't http", status)
+	}
+
+	// users can only register a limited number of webhooks
+	maxPerUser := config.C.Webhooks.MaxPerUser
+	config.C.Webhooks.MaxPerUser = 2
+	t.Cleanup(func() { config.C.Webhooks.MaxPerUser = maxPerUser })
+	if status := doAs(t, login.Token, "POST", "/api/webhooks", models.Webhook{URL: "https://example.com/second"}, nil); status != fiber.StatusCreated {
+		t.Errorf("got status %d for the second webhook", status)
+	}
+	if status := doAs(t, login.Token, "POST", "/api/webhooks", models.Webhook{URL: "https://example.com/third"}, nil); status != fiber.StatusBadRequest {
+		t.Errorf("got status %d for too many webhooks", status)
+	}
+
+	// the webhooks of a user are deleted with the user
+	if status := doAs(t, login.Token, "DELETE", "/api/users/me", nil, nil); status != fiber.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	do(t, "GET", "/api/webhooks", nil, true, &hooks)
+	if len(hooks.Data) != 1 || hooks.Data[0].ID != admin.Data.ID {
+		t.Errorf("got webhooks %+v after deleting the user, want the one of the admin", hooks.Data)
+	}
+}
+
+func TestUserAccount(t *testing.T) {
+	seed(t, defaultEvents()...)
+	creds := models.Credentials{Email: "Someone@example.com", Password: "secret password"}
+	var login models.LoginResponse
+	if status := do(t, "POST", "/api/users/register", creds, false, &login); status != fiber.StatusCreated {
+		t.Fatalf("got status %d", status)
+	}
+	if status := do(t, "POST", "/api/users/register", creds, false, nil); status != fiber.StatusConflict {
+		t.Errorf("got status %d for a registered email address", status)
+	}
+	if status := do(t, "POST", "/api/users/login", models.Credentials{Email: creds.Email, Password: "wrong password"}, false, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("got status %d for a wrong password", status)
+	}
+	if status := do(t, "POST", "/api/users/login", creds, false, &login); status != fiber.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+
+	id := storedEvents(t)["Café Concert"].ID.Hex()
+	var user models.GetUserResponse
+	if status := doAs(t, login.Token, "PUT", "/api/users/me/favourites/"+id, nil, &user); status != fiber.StatusOK || len(user.Data.FavouriteEvents) != 1 {
+		t.Errorf("got status %d and favourites %v", status, user.Data.FavouriteEvents)
+	}
+	doAs(t, login.Token, "PUT", "/api/users/me/follows/cities?name=Zurich", nil, &user)
+	if !slices.Equal(user.Data.FollowedCities, []string{"Zurich"}) {
+		t.Errorf("got followed cities %v", user.Data.FollowedCities)
+	}
+	var feed models.GetEventsResponseSuccess
+	doAs(t, login.Token, "GET", "/api/users/me/feed", nil, &feed)
+	if want := []string{"Jazz Night"}; !slices.Equal(titles(feed.Data), want) {
+		t.Errorf("got feed %v, want %v", titles(feed.Data), want)
+	}
+	// favourites are still listed once they have been archived
+	if _, err := storage.S.Archive.MoveBefore(context.Background(), day.Add(69*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	var favourites models.GetEventsResponseSuccess
+	doAs(t, login.Token, "GET", "/api/users/me/favourites", nil, &favourites)
+	if want := []string{"Café Concert"}; !slices.Equal(titles(favourites.Data), want) || favourites.Total != 1 {
+		t.Errorf("got favourites %v (%d), want %v", titles(favourites.Data), favourites.Total, want)
+	}
+
+	if status := doAs(t, login.Token, "POST", "/api/users/logout", nil, nil); status != fiber.StatusOK {
+		t.Errorf("got status %d", status)
+	}
+	if status := doAs(t, login.Token, "GET", "/api/users/me", nil, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("got status %d after logging out", status)
+	}
+}
+
+func TestMagicLinkVerifiesEmail(t *testing.T) {
+	seed(t)
+	// somebody registers the email address of somebody else
+	creds := models.Credentials{Email: "victim@example.com", Password: "attacker password"}
+	var attacker models.LoginResponse
+	if status := do(t, "POST", "/api/users/register", creds, false, &attacker); status != fiber.StatusCreated {
+		t.Fatalf("got status %d", status)
+	}
+
+	token, err := account.CreateMagicLink(context.Background(), creds.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var owner models.LoginResponse
+	if status := do(t, "POST", "/api/users/magiclink/login", models.MagicLinkLogin{Token: token}, false, &owner); status != fiber.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	if status := do(t, "POST", "/api/users/login", creds, false, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("got status %d for the password of the unverified account", status)
+	}
+	if status := doAs(t, attacker.Token, "GET", "/api/users/me", nil, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("got status %d for a session of the unverified account", status)
+	}
+	var me models.GetUserResponse
+	if status := doAs(t, owner.Token, "GET", "/api/users/me", nil, &me); status != fiber.StatusOK || !me.Data.EmailVerified {
+		t.Errorf("got status %d and user %+v", status, me.Data)
+	}
+
+	// later logins keep the sessions
+	token, err = account.CreateMagicLink(context.Background(), creds.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do(t, "POST", "/api/users/magiclink/login", models.MagicLinkLogin{Token: token}, false, nil)
+	if status := doAs(t, owner.Token, "GET", "/api/users/me", nil, nil); status != fiber.StatusOK {
+		t.Errorf("got status %d for the session of the verified account", status)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -13,11 +15,13 @@ import (
 	"github.com/jakopako/event-api/genre"
 	"github.com/jakopako/event-api/geo"
 	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/moderation"
+	"github.com/jakopako/event-api/recurrence"
 	"github.com/jakopako/event-api/shared"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/jakopako/event-api/storage"
+	"github.com/jakopako/event-api/stream"
+	"github.com/jakopako/event-api/webhook"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"gopkg.in/go-playground/validator.v9"
 )
 
@@ -34,13 +38,48 @@ import (
 // @Param country query string false "country search string"
 // @Param radius query int false "radius around given city in kilometers"
 // @Param date query string false "date search string"
+// @Param includePast query bool false "also search the archive of past events; without a date events of any date are returned"
 // @Param genres query string false "comma-separated list of genres; events matching at least one genre are returned"
+// @Param genreMode query string false "any (default) or all; whether events have to match any or all of the genres"
+// @Param excludeGenres query string false "comma-separated list of genres; events with one of these genres are not returned"
+// @Param excludeTypes query string false "comma-separated list of types; events of these types are not returned"
+// @Param subgenres query bool false "also match the genres that contain the given genres, e.g. hard techno for techno"
+// @Param filter query string false "filter expression, e.g. genres in (\"jazz\", \"soul\") and not city = \"Bern\""
+// @Param sort query string false "date (default) or trending"
 // @Param page query int false "page number"
 // @Param limit query int false "page size"
 // @Success 200 {object} models.GetEventsResponseSuccess
 // @Failure 400 {object} models.GenericResponse
 // @Router /api/events [get]
 func GetAllEvents(c *fiber.Ctx) error {
+	query, err := parseEventsQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed fetch events",
+			Error:   err.Error(),
+		})
+	}
+	events, total, last, err := shared.FetchEvents(c.UserContext(), query)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed fetch events",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GetEventsResponseSuccess{
+		Data:     events,
+		Total:    total,
+		Page:     query.Page,
+		LastPage: last,
+		Limit:    query.Limit,
+	})
+}
+
+// parseEventsQuery parses the query parameters that are used for searching events.
+func parseEventsQuery(c *fiber.Ctx) (models.Query, error) {
 	radius, _ := strconv.Atoi(c.Query("radius", "0"))
 	page, _ := strconv.Atoi(c.Query("page", "1"))
 	limitInt, _ := strconv.Atoi(c.Query("limit", "10"))
@@ -48,18 +87,18 @@ func GetAllEvents(c *fiber.Ctx) error {
 
 	// TODO: push defining start date and end date to the caller of this endpoint
 	queryDate := c.Query("date")
+	includePast := c.QueryBool("includePast")
 	var startDate, endDate *time.Time
 	if queryDate == "" {
-		now := time.Now().UTC()
-		startDate = &now
+		// archived events are only searched for past events, so there is no lower bound
+		if !includePast {
+			now := time.Now().UTC()
+			startDate = &now
+		}
 	} else {
 		d, err := time.Parse(time.RFC3339, queryDate)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
-				Success: false,
-				Message: "failed fetch events",
-				Error:   fmt.Sprintf("couldn't parse date: %v", err),
-			})
+			return models.Query{}, fmt.Errorf("couldn't parse date: %v", err)
 		}
 		startDate = &d
 		plusOneDay := d.Add(time.Hour * 24)
@@ -74,32 +113,29 @@ func GetAllEvents(c *fiber.Ctx) error {
 		StartDate: startDate,
 		EndDate:   endDate,
 		Radius:    radius,
+		Sort:      c.Query("sort"),
 		Page:      page,
 		Limit:     limit,
 	}
-	if genresParam := c.Query("genres"); genresParam != "" {
-		for _, g := range strings.Split(genresParam, ",") {
-			if g = strings.TrimSpace(g); g != "" {
-				query.Genres = append(query.Genres, g)
-			}
+	query.Genres = parseList(c.Query("genres"))
+	query.GenreMode = c.Query("genreMode")
+	query.ExcludeGenres = parseList(c.Query("excludeGenres"))
+	query.ExcludeTypes = parseList(c.Query("excludeTypes"))
+	query.Subgenres = c.QueryBool("subgenres")
+	query.Filter = c.Query("filter")
+	query.IncludePast = includePast
+	return query, nil
+}
+
+// parseList parses a comma-separated list, ignoring empty elements.
+func parseList(param string) []string {
+	var list []string
+	for _, e := range strings.Split(param, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
 		}
 	}
-	events, total, last, err := shared.FetchEvents(query)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
-			Success: false,
-			Message: "failed fetch events",
-			Error:   err.Error(),
-		})
-	}
-
-	return c.Status(fiber.StatusOK).JSON(models.GetEventsResponseSuccess{
-		Data:     events,
-		Total:    total,
-		Page:     page,
-		LastPage: last,
-		Limit:    limit,
-	})
+	return list
 }
 
 // ValidateEvents func for validating events without inserting them into the database.
@@ -113,7 +149,7 @@ func GetAllEvents(c *fiber.Ctx) error {
 // @Failure 400 {object} models.ValidateAndAddEventsResponse
 // @Router /api/events/validate [post]
 func ValidateEvents(c *fiber.Ctx) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(c.UserContext(), 60*time.Second)
 	defer cancel()
 
 	events := new([]models.Event)
@@ -156,8 +192,7 @@ func ValidateEvents(c *fiber.Ctx) error {
 // @Failure 500 {object} models.ValidateAndAddEventsResponse
 // @Router /api/events [post]
 func AddEvents(c *fiber.Ctx) error {
-	eventCollection := config.MI.DB.Collection("events")
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(c.UserContext(), 60*time.Second)
 	defer cancel()
 
 	events := new([]models.Event)
@@ -170,55 +205,87 @@ func AddEvents(c *fiber.Ctx) error {
 		})
 	}
 
-	validatedEvents, validationErrs := validateAndSanitizeEvents(ctx, events)
-
-	slog.Debug("writing events to DB", "numEvents", len(*validatedEvents))
-	var operations []mongo.WriteModel
-	for _, event := range *validatedEvents {
-		op := mongo.NewReplaceOneModel()
-		// The filter ignores the comment assuming that the comment might be updated over time.
-		// In future versions we might need to take more factors into account to decide whether
-		// an existing event needs to be updated or a new event needs to be added.
-		filterEvent := bson.D{
-			{Key: "title", Value: event.Title},
-			{Key: "date", Value: event.Date},
-			{Key: "location", Value: event.Location},
-			{Key: "url", Value: event.URL},
-			{Key: "sourceUrl", Value: event.SourceURL},
-		}
-		op.SetFilter(filterEvent)
-		op.SetUpsert(true)
-		op.SetReplacement(event)
-		operations = append(operations, op)
-	}
-
-	if len(operations) > 0 {
-		var err error
-		bulkOption := options.BulkWriteOptions{}
-		bulkOption.SetOrdered(true)
-		_, err = eventCollection.BulkWrite(ctx, operations, &bulkOption)
-
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
-				Success: false,
-				Message: "failed to insert events",
-				Error:   err.Error(),
-			})
-		}
+	validationErrs, err := IngestEvents(ctx, *events)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to insert events",
+			Error:   err.Error(),
+		})
 	}
 
-	if len(*validationErrs) > 0 {
+	if len(validationErrs) > 0 {
 		return c.Status(400).JSON(models.ValidateAndAddEventsResponse{
 			Success:          false,
 			Message:          "some events were not inserted successfully into the database",
-			ValidationErrors: *validationErrs,
+			ValidationErrors: validationErrs,
 		})
 	}
 	return c.Status(fiber.StatusCreated).JSON(models.ValidateAndAddEventsResponse{
 		Success: true,
 		Message: "events inserted successfully",
 	})
+}
+
+// IngestEvents validates the events like AddEvents and inserts the valid ones or updates
+// their stored versions, keeping the manual overrides, moderation decisions and popularity
+// of the stored events. It returns the validation errors of the invalid events.
+func IngestEvents(ctx context.Context, events []models.Event) ([]models.ValidateEventError, error) {
+	validatedEvents, validationErrs := validateAndSanitizeEvents(ctx, &events)
+
+	// manual overrides, moderation decisions and popularity have to survive the replacement of the scraped events
+	// and the stored versions are needed to detect changes
+	storedEvents, err := findStoredEvents(ctx, *validatedEvents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch stored events: %w", err)
+	}
 
+	slog.Debug("writing events to DB", "numEvents", len(*validatedEvents))
+	var writtenEvents []models.Event
+	for _, event := range *validatedEvents {
+		key := shared.NewEventKey(event)
+		event.SourceKey = &key
+		if stored, found := storedEvents[shared.EventKeyString(key)]; found {
+			event.Overrides = stored.Overrides
+			shared.ApplyOverrides(&event)
+			switch stored.ModerationStatus {
+			case moderation.StatusRejected, moderation.StatusApproved:
+				event.ModerationStatus = stored.ModerationStatus
+			}
+			if event.Recurrence != nil && stored.Recurrence != nil {
+				event.Recurrence.Cancelled = stored.Recurrence.Cancelled
+			}
+			event.Popularity = stored.Popularity
+		}
+		writtenEvents = append(writtenEvents, event)
+	}
+
+	if len(writtenEvents) > 0 {
+		insertedIDs, err := storage.S.Events.Upsert(ctx, writtenEvents)
+		if err != nil {
+			return nil, err
+		}
+
+		changes := detectChanges(writtenEvents, storedEvents, insertedIDs)
+
+		// notify stream subscribers about events that did not exist before
+		var newEvents []models.Event
+		for _, change := range changes {
+			if change.Change == webhook.ChangeCreated {
+				newEvents = append(newEvents, change.Event)
+			}
+		}
+		stream.H.Publish(newEvents)
+
+		if webhook.HasWebhooks(ctx) {
+			if err := detectReschedules(ctx, changes); err != nil {
+				slog.Error("failed to detect rescheduled events", "err", err)
+			}
+			notifyWebhooks(changes)
+		}
+	}
+
+	return *validationErrs, nil
 }
 
 // GetTodayseventsSlack func for retrieving today's events, formatted as md for slack.
@@ -228,89 +295,58 @@ func AddEvents(c *fiber.Ctx) error {
 // @Accept x-www-form-urlencoded
 // @Produce json
 // @Param slackRequest formData models.SlackRequest true "Slack Request Info"
-// @Success 200 {object} string "A json with the results"
+// @Success 200 {object} models.SlackResponse "either a text or a markdown block with the events"
+// @Failure 400 {object} models.SlackResponse
 // @Router /api/events/today/slack [post]
 func GetTodaysEventsSlack(c *fiber.Ctx) error {
-	eventCollection := config.MI.DB.Collection("events")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	var events []models.Event
-	now := time.Now()
-	plus24h := now.Add(24 * time.Hour)
 	s := new(models.SlackRequest)
 	if err := c.BodyParser(s); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"response_type": "ephemeral",
-			"text":          "Failed to parse request body.",
+		return c.Status(fiber.StatusBadRequest).JSON(models.SlackResponse{
+			ResponseType: "ephemeral",
+			Text:         "Failed to parse request body.",
 		})
 	}
 
 	city := strings.TrimSpace(s.Text)
 	if city == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"response_type": "ephemeral",
-			"text":          "Please provide a city.",
-		})
-	}
-
-	filter := bson.M{
-		"$and": []bson.M{
-			{
-				"date": bson.M{
-					"$gte": now,
-				},
-			},
-			{
-				"date": bson.M{
-					"$lte": plus24h,
-				},
-			},
-			{
-				"city": bson.M{
-					"$regex": primitive.Regex{
-						Pattern: city,
-						Options: "i",
-					},
-				},
-			},
-		},
-	}
-
-	findOptions := options.Find()
-	findOptions.SetSort(bson.D{{Key: "date", Value: 1}})
-
-	total, _ := eventCollection.CountDocuments(ctx, filter)
-	if total == 0 {
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"response_type": "ephemeral",
-			"text":          fmt.Sprintf("Sorry, no events tonight for %s.", city),
+		return c.Status(fiber.StatusBadRequest).JSON(models.SlackResponse{
+			ResponseType: "ephemeral",
+			Text:         "Please provide a city.",
 		})
 	}
 
-	cursor, err := eventCollection.Find(ctx, filter, findOptions)
+	now := time.Now()
+	plus24h := now.Add(24 * time.Hour)
+	// recurring events are expanded to their occurrences
+	events, total, _, err := shared.FetchEvents(c.UserContext(), models.Query{
+		City:      city,
+		StartDate: &now,
+		EndDate:   &plus24h,
+		Sort:      models.SortTrending,
+		Page:      1,
+		Limit:     100,
+	})
 	if err != nil {
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"response_type": "ephemeral",
-			"text":          "Sorry, something went wrong.",
+		return c.Status(fiber.StatusOK).JSON(models.SlackResponse{
+			ResponseType: "ephemeral",
+			Text:         "Sorry, something went wrong.",
 		})
 	}
-	defer cursor.Close(ctx)
-
-	for cursor.Next(ctx) {
-		var event models.Event
-		cursor.Decode(&event)
-		events = append(events, event)
+	if total == 0 {
+		return c.Status(fiber.StatusOK).JSON(models.SlackResponse{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("Sorry, no events tonight for %s.", city),
+		})
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"response_type": "ephemeral",
-		"blocks": []fiber.Map{
+	return c.Status(fiber.StatusOK).JSON(models.SlackResponse{
+		ResponseType: "ephemeral",
+		Blocks: []models.SlackBlock{
 			{
-				"type": "section",
-				"text": fiber.Map{
-					"type": "mrkdwn",
-					"text": getMarkdownSummary(events),
+				Type: "section",
+				Text: models.SlackText{
+					Type: "mrkdwn",
+					Text: getMarkdownSummary(events),
 				},
 			},
 		},
@@ -331,17 +367,14 @@ func GetTodaysEventsSlack(c *fiber.Ctx) error {
 // @Failure 500 {object} models.GenericResponse
 // @Router /api/events [delete]
 func DeleteEvents(c *fiber.Ctx) error {
-	eventsCollection := config.MI.DB.Collection("events")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
 	defer cancel()
 
 	src := c.Query("sourceUrl")
 
 	datetimeString := c.Query("datetime")
-	var filter bson.M
-	if datetimeString == "" {
-		filter = bson.M{"sourceUrl": src}
-	} else {
+	var since *time.Time
+	if datetimeString != "" {
 		t, err := time.Parse("2006-01-02 15:04", datetimeString)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
@@ -350,25 +383,20 @@ func DeleteEvents(c *fiber.Ctx) error {
 				Error:   err.Error(),
 			})
 		}
-		if src == "" {
-			filter = bson.M{"date": bson.M{"$gte": t}}
-		} else {
-			filter = bson.M{
-				"$and": []bson.M{
-					{
-						"date": bson.M{
-							"$gte": t,
-						},
-					},
-					{
-						"sourceUrl": src,
-					},
-				},
-			}
+		since = &t
+	}
+
+	// the deleted events are only needed if somebody wants to be notified about them
+	var deletedEvents []models.Event
+	if webhook.HasWebhooks(ctx) {
+		var err error
+		deletedEvents, err = storage.S.Events.FindBySource(ctx, src, since)
+		if err != nil {
+			slog.Error("failed to fetch events before deletion", "err", err)
 		}
 	}
 
-	result, err := eventsCollection.DeleteMany(ctx, filter)
+	deletedCount, err := storage.S.Events.DeleteBySource(ctx, src, since)
 
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
@@ -377,50 +405,67 @@ func DeleteEvents(c *fiber.Ctx) error {
 			Error:   err.Error(),
 		})
 	}
+
+	if len(deletedEvents) > 0 {
+		changes := make([]models.EventChange, 0, len(deletedEvents))
+		for _, e := range deletedEvents {
+			changes = append(changes, models.EventChange{Change: webhook.ChangeDeleted, Event: e})
+		}
+		notifyWebhooks(changes)
+	}
+
 	return c.Status(fiber.StatusOK).JSON(models.GenericResponse{
 		Success: true,
-		Message: fmt.Sprintf("successfully deleted %d events with source %s", result.DeletedCount, src),
+		Message: fmt.Sprintf("successfully deleted %d events with source %s", deletedCount, src),
 	})
 }
 
 // GetDistinct func for getting distinct field values.
-// @Description This endpoint returns all distinct values for the given field. Note that past events are not considered for this query.
+// @Description This endpoint returns the distinct values of the given field among the events matching the same filters as GET /api/events, with the number of events per value, the most frequent first. Without a date only upcoming events are considered.
 // @Summary Get distinct field values.
 // @Tags events
 // @Produce json
-// @Param field path string true "field name, can only be location, city or genres"
+// @Param field path string true "field name, can be location, city, state, country, type, genres or sourceUrl"
+// @Param title query string false "title search string"
+// @Param location query string false "location search string"
+// @Param type query string false "type search string"
+// @Param city query string false "city search string"
+// @Param country query string false "country search string"
+// @Param radius query int false "radius around given city in kilometers"
+// @Param date query string false "date search string"
+// @Param includePast query bool false "also search the archive of past events; without a date events of any date are returned"
+// @Param genres query string false "comma-separated list of genres; events matching at least one genre are counted"
+// @Param genreMode query string false "any (default) or all; whether events have to match any or all of the genres"
+// @Param excludeGenres query string false "comma-separated list of genres; events with one of these genres are not counted"
+// @Param excludeTypes query string false "comma-separated list of types; events of these types are not counted"
+// @Param subgenres query bool false "also match the genres that contain the given genres, e.g. hard techno for techno"
+// @Param filter query string false "filter expression, e.g. genres in (\"jazz\", \"soul\") and not city = \"Bern\""
 // @Success 200 {object} models.GetDistinctFieldResponse
 // @Failure 400 {object} models.GenericResponse
 // @Failure 500 {object} models.GenericResponse
 // @Router /api/events/{field} [get]
 func GetDistinct(c *fiber.Ctx) error {
-	eventsCollection := config.MI.DB.Collection("events")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
 	field := c.Params("field")
-	if field != "location" && field != "city" && field != "genres" {
+	if !slices.Contains(shared.DistinctFields, field) {
 		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
 			Success: false,
 			Message: "invalid value for the field parameter",
-			Error:   "the field parameter has to be 'location', 'city' or 'genres'",
+			Error:   fmt.Sprintf("the field parameter has to be one of %s", strings.Join(shared.DistinctFields, ", ")),
 		})
 	}
-
-	d := time.Now()
-	today := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, d.Location())
-
-	filter := bson.M{
-		"$and": []bson.M{
-			{
-				"date": bson.M{
-					"$gt": today,
-				},
-			},
-		},
+	query, err := parseEventsQuery(c)
+	if err == nil {
+		err = shared.ValidateFilters(query)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse query",
+			Error:   err.Error(),
+		})
 	}
 
-	result, err := eventsCollection.Distinct(ctx, field, filter)
+	distinctValues, err := shared.CountDistinct(c.UserContext(), query, field)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
 			Success: false,
@@ -429,13 +474,6 @@ func GetDistinct(c *fiber.Ctx) error {
 		})
 	}
 
-	distinctValues := []string{}
-	for _, r := range result {
-		if str, ok := r.(string); ok {
-			distinctValues = append(distinctValues, str)
-		}
-	}
-
 	return c.Status(fiber.StatusOK).JSON(models.GetDistinctFieldResponse{
 		Success: true,
 		Data:    distinctValues,
@@ -457,6 +495,19 @@ func validateAndSanitizeEvents(ctx context.Context, events *[]models.Event) (*[]
 	validationErrs := []models.ValidateEventError{}
 	validatedEvents := []models.Event{}
 
+	// if the rules cannot be loaded we reject all events rather than risking
+	// to publish events that should have been blocked
+	rules, err := moderation.LoadRules(ctx)
+	if err != nil {
+		for _, event := range *events {
+			validationErrs = append(validationErrs, models.ValidateEventError{
+				Message: fmt.Sprintf("failed to load moderation rules for event %+v", event),
+				Error:   err.Error(),
+			})
+		}
+		return &validatedEvents, &validationErrs
+	}
+
 	for _, event := range *events {
 		err := validate.Struct(event)
 		if err != nil {
@@ -467,12 +518,43 @@ func validateAndSanitizeEvents(ctx context.Context, events *[]models.Event) (*[]
 			continue
 		}
 
+		// fields that are managed by the API itself cannot be set by scrapers
+		event.ID = primitive.NilObjectID
+		event.SourceKey = nil
+		event.Overrides = nil
+		event.Popularity = nil
+		event.ModerationStatus = ""
+
 		// lower case type
 		event.Type = strings.ToLower(event.Type)
 
+		// add offset, it is also needed to expand recurring events in their own time zone
+		_, offset := event.Date.Zone()
+		event.Offset = offset
+
+		if err := recurrence.Sanitize(&event); err != nil {
+			validationErrs = append(validationErrs, models.ValidateEventError{
+				Message: fmt.Sprintf("failed to validate recurrence of event %+v", event),
+				Error:   err.Error(),
+			})
+			continue
+		}
+
+		// check moderation rules before doing any expensive lookups
+		if rule := rules.Evaluate(event); rule != nil {
+			if rule.Action == moderation.ActionReject {
+				validationErrs = append(validationErrs, models.ValidateEventError{
+					Message: fmt.Sprintf("event rejected by moderation rule %s (event %+v)", rule.ID.Hex(), event),
+					Error:   fmt.Sprintf("%s matches blocked pattern '%s'", rule.Field, rule.Pattern),
+				})
+				continue
+			}
+			event.ModerationStatus = moderation.StatusPending
+		}
+
 		// Lookup the city coordinates
 		// We need to lookup the city coordinates in order to make sure that the radius search works correctly
-		cityGeoLoc, err := geo.LookupCityCoordinates(event.City, event.State, event.Country)
+		cityGeoLoc, err := geo.LookupCityCoordinates(ctx, event.City, event.State, event.Country)
 		if err != nil {
 			validationErrs = append(validationErrs, models.ValidateEventError{
 				Message: fmt.Sprintf("failed to find relevant coordinates for city {city: \"%s\", state: \"%s\", country: \"%s\"} (event %+v)", event.City, event.State, event.Country, event),
@@ -482,12 +564,14 @@ func validateAndSanitizeEvents(ctx context.Context, events *[]models.Event) (*[]
 		}
 
 		// Lookup venue
-		address, err := geo.LookupVenueLocation(event.Location, event.City, event.State, event.Country)
+		address, err := geo.LookupVenueLocation(ctx, event.Location, event.City, event.State, event.Country)
 		if err == nil && address != nil {
 			event.Address = *address
 		} else {
 			// If venue lookup fails, fall back to city coordinates
+			// the venue is looked up again by RegeocodeEvents, e.g. by the backfill job
 			event.Address.Geolocacation = *cityGeoLoc
+			event.CityFallback = true
 		}
 
 		// lookup genres if not given and if the event type is 'concert'
@@ -502,10 +586,6 @@ func validateAndSanitizeEvents(ctx context.Context, events *[]models.Event) (*[]
 			event.Genres = genres
 		}
 
-		// add offset
-		_, offset := event.Date.Zone()
-		event.Offset = offset
-
 		// add normalized title for diacritic-insensitive search
 		event.NormalizedTitle = shared.RemoveDiacritics(event.Title)
 
@@ -515,3 +595,130 @@ func validateAndSanitizeEvents(ctx context.Context, events *[]models.Event) (*[]
 
 	return &validatedEvents, &validationErrs
 }
+
+// findStoredEvents returns the already stored versions of the given events, mapped by
+// the string representation of their source key. Events that have been stored before
+// the source key was introduced are not returned.
+func findStoredEvents(ctx context.Context, events []models.Event) (map[string]models.Event, error) {
+	storedEvents := map[string]models.Event{}
+	if len(events) == 0 {
+		return storedEvents, nil
+	}
+
+	keys := make([]models.EventKey, 0, len(events))
+	for _, e := range events {
+		keys = append(keys, shared.NewEventKey(e))
+	}
+
+	stored, err := storage.S.Events.FindByKeys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range stored {
+		if event.SourceKey != nil {
+			storedEvents[shared.EventKeyString(*event.SourceKey)] = event
+		}
+	}
+	return storedEvents, nil
+}
+
+// detectChanges compares the written events with their previously stored versions.
+// Events without a stored version that have not been upserted count as updated, since
+// they have been stored before the source key was introduced.
+func detectChanges(writtenEvents []models.Event, storedEvents map[string]models.Event, insertedIDs map[int]primitive.ObjectID) []models.EventChange {
+	var changes []models.EventChange
+	for i, event := range writtenEvents {
+		if id, found := insertedIDs[i]; found {
+			event.ID = id
+			changes = append(changes, models.EventChange{Change: webhook.ChangeCreated, Event: event})
+			continue
+		}
+		stored, found := storedEvents[shared.EventKeyString(*event.SourceKey)]
+		if found {
+			event.ID = stored.ID
+			if !shared.EventChanged(stored, event) {
+				continue
+			}
+		}
+		changes = append(changes, models.EventChange{Change: webhook.ChangeUpdated, Event: event})
+	}
+	return changes
+}
+
+// detectReschedules turns created events into rescheduled events if an event with the same
+// title, location, url and source but a different date already exists.
+func detectReschedules(ctx context.Context, changes []models.EventChange) error {
+	var createdIDs []primitive.ObjectID
+	var urls []string
+	for _, c := range changes {
+		if c.Change == webhook.ChangeCreated {
+			createdIDs = append(createdIDs, c.Event.ID)
+			urls = append(urls, c.Event.URL)
+		}
+	}
+	if len(createdIDs) == 0 {
+		return nil
+	}
+
+	previousEvents, err := storage.S.Events.FindByURLs(ctx, urls)
+	if err != nil {
+		return err
+	}
+
+	for i, c := range changes {
+		if c.Change != webhook.ChangeCreated {
+			continue
+		}
+		for _, p := range previousEvents {
+			if slices.Contains(createdIDs, p.ID) {
+				continue
+			}
+			if p.Title == c.Event.Title && p.Location == c.Event.Location && p.URL == c.Event.URL && p.SourceURL == c.Event.SourceURL && !p.Date.Equal(c.Event.Date) {
+				previousDate := p.Date
+				changes[i].Change = webhook.ChangeRescheduled
+				changes[i].PreviousDate = &previousDate
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// background tracks the tasks that outlive the requests that started them, see Drain.
+var background sync.WaitGroup
+
+// notifyWebhooks passes the changes to the webhooks asynchronously, so that the response
+// doesn't wait for them. Nothing is passed if the dispatcher hasn't been started, e.g. by
+// the commands that import events.
+func notifyWebhooks(changes []models.EventChange) {
+	if webhook.D == nil {
+		return
+	}
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), config.C.Storage.Timeout)
+		defer cancel()
+		webhook.D.Notify(ctx, changes)
+	}()
+}
+
+// Drain waits until the tasks that have been started by requests are done, including the
+// deliveries to the webhooks, see webhook.Dispatcher.Drain. It returns the error of the
+// context if it is done first.
+func Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if webhook.D == nil {
+		return nil
+	}
+	return webhook.D.Drain(ctx)
+}
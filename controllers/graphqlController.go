@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/graph"
+	"github.com/jakopako/event-api/models"
+)
+
+// Graphql func executes a GraphQL query.
+// @Description This endpoint executes a GraphQL query against events, venues, cities, genres and scraper statuses. Queries can be sent as JSON body via POST or as query parameters via GET. Use introspection to explore the schema.
+// @Summary Execute GraphQL query.
+// @Tags graphql
+// @Accept json
+// @Produce json
+// @Param request body models.GraphqlRequest false "GraphQL request"
+// @Param query query string false "GraphQL query (GET only)"
+// @Param operationName query string false "operation name (GET only)"
+// @Param variables query string false "JSON encoded variables (GET only)"
+// @Success 200 {object} object "GraphQL result with data and errors"
+// @Failure 400 {object} models.GenericResponse
+// @Router /api/graphql [post]
+func Graphql(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	var req models.GraphqlRequest
+	if c.Method() == fiber.MethodGet {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if v := c.Query("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+					Success: false,
+					Message: "failed to parse variables",
+					Error:   err.Error(),
+				})
+			}
+		}
+	} else if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse body",
+			Error:   err.Error(),
+		})
+	}
+
+	if req.Query == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to execute query",
+			Error:   "query must not be empty",
+		})
+	}
+
+	result := graph.Execute(ctx, req.Query, req.OperationName, req.Variables)
+	return c.Status(fiber.StatusOK).JSON(result)
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/genre"
+	"github.com/jakopako/event-api/geo"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+)
+
+// RegeocodeEvents looks up the venues of the stored events of the source, or of all
+// sources if it is empty, that take place at or after since again and updates the address
+// of the events whose venue is found at another address, e.g. after the venue has been
+// added to OpenStreetMap. With fallbackOnly only the events that have the coordinates of
+// their city because their venue couldn't be found when they were added are looked up.
+// Addresses are never removed if a venue isn't found anymore and events with a manual
+// address are left as they are. It returns the number of updated events.
+func RegeocodeEvents(ctx context.Context, sourceURL string, since time.Time, fallbackOnly bool) (int, error) {
+	return updateEvents(ctx, sourceURL, since, func(e *models.Event) bool {
+		if e.Overrides != nil && e.Overrides.Address != nil {
+			return false
+		}
+		if fallbackOnly && !e.CityFallback {
+			return false
+		}
+		address, err := geo.LookupVenueLocation(ctx, e.Location, e.City, e.State, e.Country)
+		if err != nil || address == nil || reflect.DeepEqual(*address, e.Address) {
+			return false
+		}
+		e.Address = *address
+		return true
+	}, func(ctx context.Context, e models.Event) error {
+		return storage.S.Events.SetAddress(ctx, e.ID, e.Address)
+	})
+}
+
+// RegenreEvents looks up the genres of the stored concerts of the source, or of all
+// sources if it is empty, that take place at or after since and have no genres, or of all
+// of them if all is set, and updates the events whose genres have changed. Events with
+// manual genres are left as they are. It returns the number of updated events.
+func RegenreEvents(ctx context.Context, sourceURL string, since time.Time, all bool) (int, error) {
+	return updateEvents(ctx, sourceURL, since, func(e *models.Event) bool {
+		if e.Type != "concert" || (len(e.Genres) > 0 && !all) || (e.Overrides != nil && e.Overrides.Genres != nil) {
+			return false
+		}
+		// the genres of the scraper would be kept by the lookup
+		lookup := *e
+		lookup.Genres = nil
+		genres, err := genre.LookupGenres(ctx, lookup)
+		if err != nil {
+			slog.Warn("failed to look up genres", "title", e.Title, "err", err)
+			return false
+		}
+		if len(genres) == 0 || slices.Equal(genres, e.Genres) {
+			return false
+		}
+		e.Genres = genres
+		return true
+	}, func(ctx context.Context, e models.Event) error {
+		return storage.S.Events.SetGenres(ctx, e.ID, e.Genres)
+	})
+}
+
+// updateEvents calls update with every stored event of the source, or of all sources if it
+// is empty, that takes place at or after since and writes the events that update reports
+// as changed with write, which only sets the changed fields, so that concurrent changes to
+// the other fields are kept. It returns their number.
+func updateEvents(ctx context.Context, sourceURL string, since time.Time, update func(e *models.Event) bool, write func(ctx context.Context, e models.Event) error) (int, error) {
+	findCtx, cancel := context.WithTimeout(ctx, config.C.Storage.Timeout)
+	defer cancel()
+	events, err := storage.S.Events.FindBySource(findCtx, sourceURL, &since)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch events: %w", err)
+	}
+
+	updated := 0
+	for _, e := range events {
+		if ctx.Err() != nil {
+			break
+		}
+		if !update(&e) {
+			continue
+		}
+		writeCtx, cancel := context.WithTimeout(ctx, config.C.Storage.Timeout)
+		err := write(writeCtx, e)
+		cancel()
+		if errors.Is(err, storage.ErrNotFound) {
+			// the event has been deleted in the meantime
+			continue
+		}
+		if err != nil {
+			return updated, fmt.Errorf("failed to update event %s: %w", e.ID.Hex(), err)
+		}
+		updated++
+	}
+	return updated, ctx.Err()
+}
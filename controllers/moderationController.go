@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/moderation"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+	"github.com/jakopako/event-api/stream"
+	"github.com/jakopako/event-api/webhook"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetModerationRules func gets all moderation rules.
+// @Description This endpoint returns all moderation rules.
+// @Summary Get moderation rules.
+// @Tags moderation
+// @Produce json
+// @Security BasicAuth
+// @Success 200 {object} models.GetModerationRulesResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/moderation/rules [get]
+func GetModerationRules(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	rules, err := storage.S.Rules.Find(ctx)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch moderation rules",
+			Error:   err.Error(),
+		})
+	}
+	if rules == nil {
+		rules = []models.ModerationRule{}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GetModerationRulesResponse{
+		Success: true,
+		Data:    rules,
+	})
+}
+
+// AddModerationRule func adds a new moderation rule.
+// @Description This endpoint adds a new moderation rule. The field can be sourceUrl, title, location or type, the pattern is a case-insensitive regular expression and the action is either 'reject' (events are rejected during validation) or 'hold' (events are hidden until an admin approves them). Rules only apply to events that are added after the rule.
+// @Summary Add moderation rule.
+// @Tags moderation
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Param rule body models.ModerationRule true "moderation rule"
+// @Success 201 {object} models.AddModerationRuleResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/moderation/rules [post]
+func AddModerationRule(c *fiber.Ctx) error {
+	var rule models.ModerationRule
+	if err := c.BodyParser(&rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse rule",
+			Error:   err.Error(),
+		})
+	}
+	if err := moderation.ValidateRule(rule); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to validate rule",
+			Error:   err.Error(),
+		})
+	}
+	rule.ID = primitive.NewObjectID()
+	rule.CreatedAt = time.Now().UTC()
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	if err := storage.S.Rules.Insert(ctx, rule); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to insert rule",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(models.AddModerationRuleResponse{
+		Success: true,
+		Message: "rule added successfully",
+		Data:    rule,
+	})
+}
+
+// DeleteModerationRule func deletes a moderation rule.
+// @Description This endpoint deletes a moderation rule. Events that have been held back by this rule stay pending until they are approved or rejected.
+// @Summary Delete moderation rule.
+// @Tags moderation
+// @Produce json
+// @Security BasicAuth
+// @Param id path string true "rule id"
+// @Success 200 {object} models.GenericResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 404 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/moderation/rules/{id} [delete]
+func DeleteModerationRule(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse rule id",
+			Error:   err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	if err := storage.S.Rules.Delete(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
+				Success: false,
+				Message: "rule not found",
+				Error:   fmt.Sprintf("no rule found with id %s", id.Hex()),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to delete rule",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GenericResponse{
+		Success: true,
+		Message: "rule deleted successfully",
+	})
+}
+
+// GetPendingEvents func gets all events that are waiting for approval.
+// @Description This endpoint returns all events that have been held back by a moderation rule and are waiting for approval.
+// @Summary Get pending events.
+// @Tags moderation
+// @Produce json
+// @Security BasicAuth
+// @Param page query int false "page number"
+// @Param limit query int false "page size"
+// @Success 200 {object} models.GetEventsResponseSuccess
+// @Failure 400 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/moderation/pending [get]
+func GetPendingEvents(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	if page < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch pending events",
+			Error:   "page parameter must be greater than 0",
+		})
+	}
+	limitInt, _ := strconv.Atoi(c.Query("limit", "10"))
+	if limitInt < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch pending events",
+			Error:   "limit parameter must be greater than 0",
+		})
+	}
+	var limit int64 = int64(limitInt)
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	events, total, err := storage.S.Events.FindPending(ctx, (int64(page)-1)*limit, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch pending events",
+			Error:   err.Error(),
+		})
+	}
+	if events == nil {
+		events = []models.Event{}
+	}
+
+	last := int64(math.Ceil(float64(total) / float64(limit)))
+	if last < 1 && total > 0 {
+		last = 1
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GetEventsResponseSuccess{
+		Data:     events,
+		Total:    total,
+		Page:     page,
+		LastPage: last,
+		Limit:    limit,
+	})
+}
+
+// ApproveEvent func approves an event.
+// @Description This endpoint approves an event so that it becomes publicly visible. The decision is kept when the event is upserted again by a scraper.
+// @Summary Approve event.
+// @Tags moderation
+// @Produce json
+// @Security BasicAuth
+// @Param id path string true "event id"
+// @Success 200 {object} models.GenericResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 404 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/moderation/events/{id}/approve [post]
+func ApproveEvent(c *fiber.Ctx) error {
+	return setModerationStatus(c, moderation.StatusApproved)
+}
+
+// RejectEvent func rejects an event.
+// @Description This endpoint rejects an event so that it is hidden from the public. The decision is kept when the event is upserted again by a scraper.
+// @Summary Reject event.
+// @Tags moderation
+// @Produce json
+// @Security BasicAuth
+// @Param id path string true "event id"
+// @Success 200 {object} models.GenericResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 404 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/moderation/events/{id}/reject [post]
+func RejectEvent(c *fiber.Ctx) error {
+	return setModerationStatus(c, moderation.StatusRejected)
+}
+
+func setModerationStatus(c *fiber.Ctx, status string) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse event id",
+			Error:   err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	event, err := storage.S.Events.Get(ctx, id)
+	moderated := event
+	if err == nil {
+		// the source key is needed to keep the decision when the event is upserted again
+		key := shared.NewEventKey(event)
+		if event.SourceKey != nil {
+			key = *event.SourceKey
+		}
+		moderated.ModerationStatus = status
+		moderated.SourceKey = &key
+		err = storage.S.Events.SetModerationStatus(ctx, id, status, key)
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
+				Success: false,
+				Message: "event not found",
+				Error:   fmt.Sprintf("no event found with id %s", id.Hex()),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to update event",
+			Error:   err.Error(),
+		})
+	}
+
+	// held events are new to the streams and webhooks once they are approved
+	switch {
+	case !shared.IsVisible(event) && shared.IsVisible(moderated):
+		stream.H.Publish([]models.Event{moderated})
+		notifyWebhooks([]models.EventChange{{Change: webhook.ChangeCreated, Event: moderated}})
+	case shared.IsVisible(event) && !shared.IsVisible(moderated):
+		notifyWebhooks([]models.EventChange{{Change: webhook.ChangeDeleted, Event: event}})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GenericResponse{
+		Success: true,
+		Message: fmt.Sprintf("event %s successfully", status),
+	})
+}
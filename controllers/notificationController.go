@@ -4,11 +4,12 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/mail"
 	"net/smtp"
 	"net/url"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -16,8 +17,7 @@ import (
 	"github.com/jakopako/event-api/config"
 	"github.com/jakopako/event-api/models"
 	"github.com/jakopako/event-api/shared"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/jakopako/event-api/storage"
 )
 
 // AddNotification func for adding a new notification to the database.
@@ -30,23 +30,27 @@ import (
 // @Param city query string false "city search string"
 // @Param country query string false "country search string"
 // @Param radius query int false "radius around given city in kilometers"
+// @Param genres query string false "comma-separated list of genres; events matching at least one genre are notified"
+// @Param genreMode query string false "any (default) or all; whether events have to match any or all of the genres"
+// @Param excludeGenres query string false "comma-separated list of genres; events with one of these genres are not notified"
+// @Param excludeTypes query string false "comma-separated list of types; events of these types are not notified"
+// @Param subgenres query bool false "also match the genres that contain the given genres, e.g. hard techno for techno"
+// @Param filter query string false "filter expression, e.g. genres in (\"jazz\", \"soul\") and not city = \"Bern\""
 // @Param email query string false "email"
 // @Success 201 {object} models.GenericResponse
 // @Failure 400 {object} models.GenericResponse
 // @Failure 500 {object} models.GenericResponse
 // @Router /api/notifications/add [get]
 func AddNotification(c *fiber.Ctx) error {
-	baseAURL := os.Getenv("ACTIVATION_URL")
-	baseUURL := os.Getenv("UNSUBSCRIBE_URL")
+	baseAURL := config.C.Notifications.ActivationURL
+	baseUURL := config.C.Notifications.UnsubscribeURL
 	if baseAURL == "" {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
 			Success: false,
 			Message: "failed to add new notification",
-			Error:   "ACTIVATION_URL has to be provided as environment variable",
+			Error:   "ACTIVATION_URL has to be configured",
 		})
 	}
-	notificationCollection := config.MI.DB.Collection(shared.NotificationCollectionName)
-
 	// verify email
 	email := c.Query("email")
 	if _, err := mail.ParseAddress(email); err != nil {
@@ -72,23 +76,32 @@ func AddNotification(c *fiber.Ctx) error {
 		SetupDate: time.Now().UTC(),
 		Active:    false,
 		Query: models.Query{
-			Title:   c.Query("title"),
-			City:    c.Query("city"),
-			Country: c.Query("country"),
-			Radius:  c.QueryInt("radius"),
-			Limit:   10,
-			Page:    1,
+			Title:         c.Query("title"),
+			City:          c.Query("city"),
+			Country:       c.Query("country"),
+			Location:      c.Query("location"),
+			Genres:        parseList(c.Query("genres")),
+			GenreMode:     c.Query("genreMode"),
+			ExcludeGenres: parseList(c.Query("excludeGenres")),
+			ExcludeTypes:  parseList(c.Query("excludeTypes")),
+			Subgenres:     c.QueryBool("subgenres"),
+			Filter:        c.Query("filter"),
+			Radius:        c.QueryInt("radius"),
+			Limit:         10,
+			Page:          1,
 		},
 	}
-
-	update := bson.M{
-		"$setOnInsert": n,
+	if err := shared.ValidateFilters(n.Query); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "invalid notification query",
+			Error:   err.Error(),
+		})
 	}
 
-	filter := bson.D{{Key: "email", Value: n.Email}, {Key: "query", Value: n.Query}}
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	opts := options.Update().SetUpsert(true)
-	result, err := notificationCollection.UpdateOne(ctx, filter, update, opts)
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+	added, err := storage.S.Notifications.Add(ctx, n)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
 			Success: false,
@@ -97,7 +110,7 @@ func AddNotification(c *fiber.Ctx) error {
 		})
 	}
 
-	if result.MatchedCount == 1 {
+	if !added {
 		return c.Status(fiber.StatusCreated).JSON(models.GenericResponse{
 			Success: true,
 			Message: "notification already exists in database",
@@ -140,23 +153,21 @@ Your ConcertCloud team
 // @Produce json
 // @Param email query string false "email"
 // @Param token query string false "token"
-// @Success 200 {object} models.ActivateNotificationResponse
+// @Success 200 {object} models.ActivateNotificationResponse "a models.GenericResponse if the notification was already active"
 // @Failure 404 {object} models.GenericResponse
 // @Failure 500 {object} models.GenericResponse
 // @Router /api/notifications/activate [get]
 func ActivateNotification(c *fiber.Ctx) error {
-	notificationCollection := config.MI.DB.Collection(shared.NotificationCollectionName)
 	email := c.Query("email")
 	token := c.Query("token")
 
 	// check notifications
 	now := time.Now().UTC()
 	then := now.AddDate(0, 0, -1)
-	filter := bson.D{{Key: "email", Value: email}, {Key: "token", Value: token}, {Key: "setupDate", Value: bson.M{"$gt": then}}}
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	var not models.Notification
-	err := notificationCollection.FindOne(ctx, filter).Decode(&not)
-	if err != nil {
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+	not, err := storage.S.Notifications.Get(ctx, email, token)
+	if err != nil || !not.SetupDate.After(then) {
 		return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
 			Success: false,
 			Message: "failed to activate notification",
@@ -170,8 +181,7 @@ func ActivateNotification(c *fiber.Ctx) error {
 		})
 	}
 
-	update := bson.D{{Key: "$set", Value: bson.D{{Key: "active", Value: true}}}}
-	_, err = notificationCollection.UpdateOne(ctx, filter, update)
+	err = storage.S.Notifications.Activate(ctx, email, token)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
 			Success: false,
@@ -194,17 +204,16 @@ func ActivateNotification(c *fiber.Ctx) error {
 // @Produce json
 // @Param email query string false "email"
 // @Param token query string false "token"
-// @Success 200
+// @Success 200 {string} string "OK, also if no notification matched"
 // @Failure 500 {object} models.GenericResponse
 // @Router /api/notifications/delete [get]
 func DeleteNotification(c *fiber.Ctx) error {
-	notificationCollection := config.MI.DB.Collection(shared.NotificationCollectionName)
 	email := c.Query("email")
 	token := c.Query("token")
 
-	filter := bson.D{{Key: "email", Value: email}, {Key: "token", Value: token}}
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	_, err := notificationCollection.DeleteOne(ctx, filter)
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+	err := storage.S.Notifications.Delete(ctx, email, token)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
 			Success: false,
@@ -222,18 +231,11 @@ func DeleteNotification(c *fiber.Ctx) error {
 // @Tags notifications
 // @Produce json
 // @Security BasicAuth
-// @Success 200
+// @Success 200 {string} string "OK"
 // @Failure 500 {object} models.GenericResponse
 // @Router /api/notifications/deleteInactive [delete]
 func DeleteInactiveNotifictions(c *fiber.Ctx) error {
-	notificationCollection := config.MI.DB.Collection(shared.NotificationCollectionName)
-
-	now := time.Now().UTC()
-	then := now.AddDate(0, 0, -1)
-	filter := bson.D{{Key: "active", Value: false}, {Key: "setupDate", Value: bson.M{"$lt": then}}}
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	_, err := notificationCollection.DeleteMany(ctx, filter)
-	if err != nil {
+	if _, err := PurgeInactiveNotifications(c.UserContext()); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
 			Success: false,
 			Message: "failed to delete notification",
@@ -243,67 +245,77 @@ func DeleteInactiveNotifictions(c *fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusOK)
 }
 
+// PurgeInactiveNotifications deletes the notifications that haven't been activated within
+// 24 hours and returns their number.
+func PurgeInactiveNotifications(ctx context.Context) (int64, error) {
+	then := time.Now().UTC().AddDate(0, 0, -1)
+	ctx, cancel := context.WithTimeout(ctx, config.C.Storage.Timeout)
+	defer cancel()
+	return storage.S.Notifications.DeleteInactive(ctx, then)
+}
+
 // SendNotifications func for sending all notifications via email.
 // @Description This endpoint sends an email for every active notification whose query returns a result.
 // @Summary Send notifications.
 // @Tags notifications
 // @Produce json
 // @Security BasicAuth
-// @Success 200
+// @Success 200 {string} string "OK"
 // @Failure 500 {object} models.GenericResponse
 // @Router /api/notifications/send [get]
 func SendNotifications(c *fiber.Ctx) error {
-	// fetch active notifications
-	notificationCollection := config.MI.DB.Collection(shared.NotificationCollectionName)
-	filter := bson.D{{Key: "active", Value: true}}
-
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	cursor, err := notificationCollection.Find(ctx, filter)
-	if err != nil {
+	if _, err := SendAllNotifications(c.UserContext()); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
 			Success: false,
-			Message: "failed to retreive active notification from database",
+			Message: "failed to send notifications",
 			Error:   err.Error(),
 		})
 	}
+	return c.SendStatus(fiber.StatusOK)
+}
 
-	var results []models.Notification
-	if err = cursor.All(ctx, &results); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
-			Success: false,
-			Message: "failed to retreive active notification from database",
-			Error:   err.Error(),
-		})
+// SendAllNotifications sends an email for every active notification whose query returns a
+// result and returns the number of sent emails. Failures of single notifications are
+// logged.
+func SendAllNotifications(ctx context.Context) (int, error) {
+	baseQURL := config.C.Notifications.QueryURL
+	baseUURL := config.C.Notifications.UnsubscribeURL
+	if baseQURL == "" || baseUURL == "" {
+		return 0, errors.New("QUERY_URL and UNSUBSCRIBE_URL have to be configured")
 	}
 
-	baseQURL := os.Getenv("QUERY_URL")
-	baseUURL := os.Getenv("UNSUBSCRIBE_URL")
-	if baseQURL == "" || baseUURL == "" {
-		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
-			Success: false,
-			Message: "failed to send emails",
-			Error:   "QUERY_URL and UNSUBSCRIBE_URL have to be provided as environment variables",
-		})
+	// fetch active notifications
+	findCtx, cancel := context.WithTimeout(ctx, config.C.Storage.Timeout)
+	defer cancel()
+	results, err := storage.S.Notifications.FindActive(findCtx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve active notifications from database: %w", err)
 	}
 
+	sent := 0
 	for _, n := range results {
-		cursor.Decode(&n)
 		// the start date of a notification query is always now, the moment the notification is sent
 		now := time.Now().UTC()
 		n.Query.StartDate = &now
-		_, total, _, err := shared.FetchEvents(n.Query)
+		_, total, _, err := shared.FetchEvents(ctx, n.Query)
 		if err != nil {
 			log.Errorf("couldn't fetch events for query %v", n.Query)
 		}
 		if total > 0 {
 			// send notification email
-			qUrl := fmt.Sprintf("%s?title=%s&city=%s&country=%s&location=%s&radius=%d",
+			qUrl := fmt.Sprintf("%s?title=%s&city=%s&country=%s&location=%s&radius=%d&genres=%s&genreMode=%s&excludeGenres=%s&excludeTypes=%s&subgenres=%t&filter=%s&sort=trending",
 				baseQURL,
 				url.QueryEscape(n.Query.Title),
 				url.QueryEscape(n.Query.City),
 				url.QueryEscape(n.Query.Country),
 				url.QueryEscape(n.Query.Location),
-				n.Query.Radius)
+				n.Query.Radius,
+				url.QueryEscape(strings.Join(n.Query.Genres, ",")),
+				url.QueryEscape(n.Query.GenreMode),
+				url.QueryEscape(strings.Join(n.Query.ExcludeGenres, ",")),
+				url.QueryEscape(strings.Join(n.Query.ExcludeTypes, ",")),
+				n.Query.Subgenres,
+				url.QueryEscape(n.Query.Filter))
 			uUrl := fmt.Sprintf("%s?token=%s&email=%s", baseUURL, url.QueryEscape(n.Token), url.QueryEscape(n.Email))
 			mTempl := `
 Hi,
@@ -320,11 +332,11 @@ Your ConcertCloud team
 				log.Errorf("couldn't send notification email to %s. Error: %v", n.Email, err)
 			} else {
 				log.Infof("sent notification email to %s", n.Email)
+				sent++
 			}
 		}
 	}
-
-	return c.SendStatus(fiber.StatusOK)
+	return sent, nil
 }
 
 func generateRandomString(length int) (string, error) {
@@ -337,16 +349,16 @@ func generateRandomString(length int) (string, error) {
 }
 
 func sendEmail(to, subject, message string) error {
-	user := os.Getenv("SMTP_USER")
-	password := os.Getenv("SMTP_PASSWORD")
+	user := config.C.Notifications.SMTPUser
+	password := config.C.Notifications.SMTPPassword
 
 	from := user
 	toList := []string{
 		to,
 	}
 
-	host := os.Getenv("SMTP_HOST")
-	addr := fmt.Sprintf("%s:%s", host, os.Getenv("SMTP_PORT"))
+	host := config.C.Notifications.SMTPHost
+	addr := fmt.Sprintf("%s:%s", host, config.C.Notifications.SMTPPort)
 
 	msg := []byte(fmt.Sprintf("From: %s\r\n"+
 		"To: %s\r\n"+
@@ -0,0 +1,18 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/openapi"
+)
+
+// GetOpenAPI func returns the OpenAPI document.
+// @Description This endpoint returns the OpenAPI 3.1 document of this api in YAML format. It can be used to generate clients in other languages.
+// @Summary Get OpenAPI document.
+// @Tags docs
+// @Produce application/yaml
+// @Success 200 {string} string "OpenAPI document"
+// @Router /api/openapi [get]
+func GetOpenAPI(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "application/yaml")
+	return c.Status(fiber.StatusOK).Send(openapi.Spec)
+}
@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+	"github.com/jakopako/event-api/webhook"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetEventOverrides func gets all events that have manual overrides.
+// @Description This endpoint returns all events that have manual overrides, including the overrides themselves.
+// @Summary Get overridden events.
+// @Tags events
+// @Produce json
+// @Security BasicAuth
+// @Param page query int false "page number"
+// @Param limit query int false "page size"
+// @Success 200 {object} models.GetEventsResponseSuccess
+// @Failure 400 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/events/overrides [get]
+func GetEventOverrides(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	if page < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch overrides",
+			Error:   "page parameter must be greater than 0",
+		})
+	}
+	limitInt, _ := strconv.Atoi(c.Query("limit", "10"))
+	if limitInt < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch overrides",
+			Error:   "limit parameter must be greater than 0",
+		})
+	}
+	var limit int64 = int64(limitInt)
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	events, total, err := storage.S.Events.FindOverridden(ctx, (int64(page)-1)*limit, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch overrides",
+			Error:   err.Error(),
+		})
+	}
+	if events == nil {
+		events = []models.Event{}
+	}
+
+	last := int64(math.Ceil(float64(total) / float64(limit)))
+	if last < 1 && total > 0 {
+		last = 1
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GetEventsResponseSuccess{
+		Data:     events,
+		Total:    total,
+		Page:     page,
+		LastPage: last,
+		Limit:    limit,
+	})
+}
+
+// SetEventOverrides func sets the manual overrides of an event.
+// @Description This endpoint replaces the manual overrides of an event and applies them immediately. Overrides are re-applied every time the event is upserted by a scraper.
+// @Summary Set event overrides.
+// @Tags events
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Param id path string true "event id"
+// @Param overrides body models.EventOverrides true "overrides"
+// @Success 200 {object} models.GetEventResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 404 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/events/id/{id}/overrides [put]
+func SetEventOverrides(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse event id",
+			Error:   err.Error(),
+		})
+	}
+
+	var overrides models.EventOverrides
+	if err := c.BodyParser(&overrides); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse overrides",
+			Error:   err.Error(),
+		})
+	}
+	if a := overrides.Address; a != nil {
+		if len(a.Geolocacation.Coordinates) != 2 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+				Success: false,
+				Message: "failed to validate overrides",
+				Error:   "address.geolocation.coordinates must contain longitude and latitude",
+			})
+		}
+		a.Geolocacation.GeoJSONType = "Point"
+	}
+	overrides.UpdatedAt = time.Now().UTC()
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	event, err := storage.S.Events.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
+				Success: false,
+				Message: "event not found",
+				Error:   fmt.Sprintf("no event found with id %s", id.Hex()),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch event",
+			Error:   err.Error(),
+		})
+	}
+
+	// the key has to be set before the overrides are applied, otherwise
+	// the next upsert of the scraped event wouldn't find this event anymore
+	if event.SourceKey == nil {
+		key := shared.NewEventKey(event)
+		event.SourceKey = &key
+	}
+	event.Overrides = &overrides
+	shared.ApplyOverrides(&event)
+
+	if err := storage.S.Events.SetOverrides(ctx, event); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
+				Success: false,
+				Message: "event not found",
+				Error:   fmt.Sprintf("no event found with id %s", id.Hex()),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to update event",
+			Error:   err.Error(),
+		})
+	}
+
+	notifyWebhooks([]models.EventChange{{Change: webhook.ChangeUpdated, Event: event}})
+
+	return c.Status(fiber.StatusOK).JSON(models.GetEventResponse{
+		Success: true,
+		Data:    event,
+	})
+}
+
+// DeleteEventOverrides func clears the manual overrides of an event.
+// @Description This endpoint clears the manual overrides of an event. The scraped values are restored the next time the event is upserted by a scraper.
+// @Summary Clear event overrides.
+// @Tags events
+// @Produce json
+// @Security BasicAuth
+// @Param id path string true "event id"
+// @Success 200 {object} models.GenericResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 404 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/events/id/{id}/overrides [delete]
+func DeleteEventOverrides(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse event id",
+			Error:   err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	if err := storage.S.Events.ClearOverrides(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
+				Success: false,
+				Message: "event not found",
+				Error:   fmt.Sprintf("no event found with id %s", id.Hex()),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to clear overrides",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GenericResponse{
+		Success: true,
+		Message: "overrides cleared successfully",
+	})
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/popularity"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RedirectToEvent func redirects to the url of an event and counts the click.
+// @Description This endpoint counts a click on the event and redirects to the url of the event. Links to event pages should point here so that the popularity of events can be tracked.
+// @Summary Redirect to the url of an event.
+// @Tags events
+// @Param id path string true "event id"
+// @Success 302
+// @Failure 400 {object} models.GenericResponse
+// @Failure 404 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/events/id/{id}/redirect [get]
+func RedirectToEvent(c *fiber.Ctx) error {
+	event, status, resp := recordInteraction(c, popularity.InteractionClick)
+	if resp != nil {
+		return c.Status(status).JSON(resp)
+	}
+	return c.Redirect(event.URL, fiber.StatusFound)
+}
+
+// AddEventView func counts a view of an event.
+// @Description This endpoint counts a view of the event. Clients should call it whenever the details of an event are shown.
+// @Summary Count a view of an event.
+// @Tags events
+// @Produce json
+// @Param id path string true "event id"
+// @Success 200 {object} models.GenericResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 404 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/events/id/{id}/view [post]
+func AddEventView(c *fiber.Ctx) error {
+	_, status, resp := recordInteraction(c, popularity.InteractionView)
+	if resp != nil {
+		return c.Status(status).JSON(resp)
+	}
+	return c.Status(fiber.StatusOK).JSON(models.GenericResponse{
+		Success: true,
+		Message: "view counted successfully",
+	})
+}
+
+// recordInteraction updates the popularity of the event with the id of the request. If
+// the interaction cannot be recorded, the error response and its status are returned.
+func recordInteraction(c *fiber.Ctx, interaction string) (models.Event, int, *models.GenericResponse) {
+	var event models.Event
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return event, fiber.StatusBadRequest, &models.GenericResponse{
+			Success: false,
+			Message: "failed to parse event id",
+			Error:   err.Error(),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	event, err = storage.S.Events.RecordInteraction(ctx, id, interaction, time.Now().UTC())
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return event, fiber.StatusNotFound, &models.GenericResponse{
+				Success: false,
+				Message: "event not found",
+				Error:   fmt.Sprintf("no event found with id %s", id.Hex()),
+			}
+		}
+		return event, fiber.StatusInternalServerError, &models.GenericResponse{
+			Success: false,
+			Message: fmt.Sprintf("failed to count %s", interaction),
+			Error:   err.Error(),
+		}
+	}
+	return event, 0, nil
+}
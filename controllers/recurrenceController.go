@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/ical"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/recurrence"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+	"github.com/jakopako/event-api/webhook"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetEventsICS func exports events as iCalendar.
+// @Description This endpoint returns the events matching the query as iCalendar document. Recurring events are exported once with their recurrence rule, cancelled occurrences are exported as cancelled instances of the series.
+// @Summary Export events as iCalendar.
+// @Tags events
+// @Produce text/calendar
+// @Param title query string false "title search string"
+// @Param location query string false "location search string"
+// @Param city query string false "city search string"
+// @Param country query string false "country search string"
+// @Param radius query int false "radius around given city in kilometers"
+// @Param date query string false "date search string"
+// @Param includePast query bool false "also search the archive of past events; without a date events of any date are returned"
+// @Param genres query string false "comma-separated list of genres; events matching at least one genre are returned"
+// @Param genreMode query string false "any (default) or all; whether events have to match any or all of the genres"
+// @Param excludeGenres query string false "comma-separated list of genres; events with one of these genres are not returned"
+// @Param excludeTypes query string false "comma-separated list of types; events of these types are not returned"
+// @Param subgenres query bool false "also match the genres that contain the given genres, e.g. hard techno for techno"
+// @Param filter query string false "filter expression, e.g. genres in (\"jazz\", \"soul\") and not city = \"Bern\""
+// @Param page query int false "page number"
+// @Param limit query int false "page size, defaults to 100"
+// @Success 200 {string} string
+// @Failure 400 {object} models.GenericResponse
+// @Router /api/events/ics [get]
+func GetEventsICS(c *fiber.Ctx) error {
+	query, err := parseEventsQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed fetch events",
+			Error:   err.Error(),
+		})
+	}
+	if c.Query("limit") == "" {
+		query.Limit = 100
+	}
+	events, _, _, err := shared.FetchEvents(c.UserContext(), query)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed fetch events",
+			Error:   err.Error(),
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := ical.Write(&buf, events); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to export events",
+			Error:   err.Error(),
+		})
+	}
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	return c.Status(fiber.StatusOK).Send(buf.Bytes())
+}
+
+// CancelOccurrence func cancels a single occurrence of a recurring event.
+// @Description This endpoint cancels the occurrence of a recurring event at the given date. Cancelled occurrences are not returned anymore and are kept when the event is upserted by a scraper.
+// @Summary Cancel an occurrence.
+// @Tags events
+// @Produce json
+// @Security BasicAuth
+// @Param id path string true "event id"
+// @Param date query string true "date of the occurrence (RFC3339)"
+// @Success 200 {object} models.GetEventResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 404 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/events/id/{id}/cancel [post]
+func CancelOccurrence(c *fiber.Ctx) error {
+	return updateOccurrence(c, recurrence.Cancel)
+}
+
+// RestoreOccurrence func restores a cancelled occurrence of a recurring event.
+// @Description This endpoint restores the cancelled occurrence of a recurring event at the given date.
+// @Summary Restore an occurrence.
+// @Tags events
+// @Produce json
+// @Security BasicAuth
+// @Param id path string true "event id"
+// @Param date query string true "date of the occurrence (RFC3339)"
+// @Success 200 {object} models.GetEventResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 404 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/events/id/{id}/cancel [delete]
+func RestoreOccurrence(c *fiber.Ctx) error {
+	return updateOccurrence(c, recurrence.Restore)
+}
+
+// updateOccurrence applies the given update to the occurrences of the recurring
+// event and stores the cancelled occurrences.
+func updateOccurrence(c *fiber.Ctx, update func(*models.Event, time.Time) error) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse event id",
+			Error:   err.Error(),
+		})
+	}
+	date, err := time.Parse(time.RFC3339, c.Query("date"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse date",
+			Error:   err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	event, err := storage.S.Events.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
+				Success: false,
+				Message: "event not found",
+				Error:   fmt.Sprintf("no event found with id %s", id.Hex()),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch event",
+			Error:   err.Error(),
+		})
+	}
+	if event.Recurrence == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to update occurrence",
+			Error:   fmt.Sprintf("event with id %s is not recurring", id.Hex()),
+		})
+	}
+	if err := update(&event, date); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to update occurrence",
+			Error:   err.Error(),
+		})
+	}
+
+	// the key has to be set so that the next upsert of the scraped event
+	// finds this event and keeps the cancelled occurrences
+	if event.SourceKey == nil {
+		key := shared.NewEventKey(event)
+		event.SourceKey = &key
+	}
+	if err := storage.S.Events.SetCancelled(ctx, id, event.Recurrence.Cancelled, *event.SourceKey); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
+				Success: false,
+				Message: "event not found",
+				Error:   fmt.Sprintf("no event found with id %s", id.Hex()),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to update occurrence",
+			Error:   err.Error(),
+		})
+	}
+
+	notifyWebhooks([]models.EventChange{{Change: webhook.ChangeUpdated, Event: event}})
+
+	return c.Status(fiber.StatusOK).JSON(models.GetEventResponse{
+		Success: true,
+		Data:    event,
+	})
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/similar"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetSimilarEvents func gets upcoming events that are similar to an event.
+// @Description This endpoint returns upcoming events that are similar to the given event, the most similar first. Events are compared by their genres, the artists in their titles, the distance between their venues and the distance between their dates.
+// @Summary Get similar events.
+// @Tags events
+// @Produce json
+// @Param id path string true "event id"
+// @Param limit query int false "maximum number of events, defaults to 5, at most 50"
+// @Success 200 {object} models.GetSimilarEventsResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 404 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/events/id/{id}/similar [get]
+func GetSimilarEvents(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse event id",
+			Error:   err.Error(),
+		})
+	}
+	limit, _ := strconv.Atoi(c.Query("limit", "5"))
+	if limit < 1 || limit > 50 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch similar events",
+			Error:   "limit parameter must be between 1 and 50",
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	found, err := storage.S.Events.FindByIDs(ctx, []primitive.ObjectID{id})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch event",
+			Error:   err.Error(),
+		})
+	}
+	if len(found) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
+			Success: false,
+			Message: "event not found",
+			Error:   fmt.Sprintf("no event found with id %s", id.Hex()),
+		})
+	}
+
+	events, err := similar.Find(ctx, found[0], limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch similar events",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GetSimilarEventsResponse{
+		Success: true,
+		Data:    events,
+	})
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/shared"
+)
+
+// GetStatistics func gets statistics of events.
+// @Description This endpoint counts the events matching the same filters as GET /api/events per day or week, per city, per genre and per source. Cities, genres and sources are ordered by the number of events and limited by the limit parameter. The history contains the number of matching events of each of the past months, regardless of the date parameter.
+// @Summary Get statistics of events.
+// @Tags events
+// @Produce json
+// @Param title query string false "title search string"
+// @Param location query string false "location search string"
+// @Param type query string false "type search string"
+// @Param city query string false "city search string"
+// @Param country query string false "country search string"
+// @Param radius query int false "radius around given city in kilometers"
+// @Param date query string false "date search string"
+// @Param includePast query bool false "also search the archive of past events; without a date events of any date are returned"
+// @Param genres query string false "comma-separated list of genres; events matching at least one genre are counted"
+// @Param genreMode query string false "any (default) or all; whether events have to match any or all of the genres"
+// @Param excludeGenres query string false "comma-separated list of genres; events with one of these genres are not counted"
+// @Param excludeTypes query string false "comma-separated list of types; events of these types are not counted"
+// @Param subgenres query bool false "also match the genres that contain the given genres, e.g. hard techno for techno"
+// @Param filter query string false "filter expression, e.g. genres in (\"jazz\", \"soul\") and not city = \"Bern\""
+// @Param interval query string false "day (default) or week; the interval of the calendar"
+// @Param months query int false "number of past months of the history, defaults to 12, at most 120"
+// @Param limit query int false "maximum number of cities, genres and sources, defaults to 10"
+// @Success 200 {object} models.GetStatisticsResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/statistics [get]
+func GetStatistics(c *fiber.Ctx) error {
+	query, err := parseEventsQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to compute statistics",
+			Error:   err.Error(),
+		})
+	}
+	months, err := strconv.Atoi(c.Query("months", "12"))
+	if err != nil {
+		months = -1
+	}
+
+	stats, err := shared.FetchStatistics(c.UserContext(), query, c.Query("interval", shared.IntervalDay), months)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		var invalid *shared.InvalidQueryError
+		if errors.As(err, &invalid) {
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to compute statistics",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GetStatisticsResponse{
+		Success: true,
+		Data:    stats,
+	})
+}
@@ -2,18 +2,16 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"net/url"
 	"strconv"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/jakopako/event-api/config"
 	"github.com/jakopako/event-api/models"
-	"github.com/jakopako/event-api/shared"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/jakopako/event-api/storage"
 	"gopkg.in/go-playground/validator.v9"
 )
 
@@ -56,29 +54,10 @@ func GetScraperStatus(c *fiber.Ctx) error {
 		returnScraperLogs = true
 	}
 
-	statusCollection := config.MI.DB.Collection(shared.ScraperStatusCollectionName)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
 	defer cancel()
 
-	var statuses []models.ScraperStatus
-	filter := bson.M{}
-	if name != "" {
-		filter = bson.M{
-			"scraperName": name,
-		}
-	}
-
-	findOptions := options.Find()
-	findOptions.SetSort(bson.D{{Key: "scraperName", Value: 1}})
-	findOptions.SetSkip((int64(page) - 1) * limit)
-	findOptions.SetLimit(limit)
-	if !returnScraperLogs {
-		findOptions.SetProjection(bson.M{"scraperLogs": 0})
-	}
-
-	total, _ := statusCollection.CountDocuments(ctx, filter)
-
-	cursor, err := statusCollection.Find(ctx, filter, findOptions)
+	statuses, total, err := storage.S.Statuses.Find(ctx, name, (int64(page)-1)*limit, limit, returnScraperLogs)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
 			Success: false,
@@ -86,13 +65,6 @@ func GetScraperStatus(c *fiber.Ctx) error {
 			Error:   err.Error(),
 		})
 	}
-	defer cursor.Close(ctx)
-
-	for cursor.Next(ctx) {
-		var status models.ScraperStatus
-		cursor.Decode(&status)
-		statuses = append(statuses, status)
-	}
 
 	last := int64(math.Ceil(float64(total) / float64(limit)))
 	if last < 1 && total > 0 {
@@ -121,8 +93,7 @@ func GetScraperStatus(c *fiber.Ctx) error {
 // @Failure 500 {object} models.GenericResponse
 // @Router /api/status [post]
 func UpsertScraperStatus(c *fiber.Ctx) error {
-	statusCollection := config.MI.DB.Collection(shared.ScraperStatusCollectionName)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
 	defer cancel()
 
 	validate := validator.New()
@@ -153,12 +124,7 @@ func UpsertScraperStatus(c *fiber.Ctx) error {
 		})
 	}
 
-	filter := bson.M{"scraperName": status.ScraperName}
-	opts := options.Update().SetUpsert(true)
-	update := bson.M{
-		"$set": status,
-	}
-	_, err = statusCollection.UpdateOne(ctx, filter, update, opts)
+	err = storage.S.Statuses.Upsert(ctx, status)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
 			Success: false,
@@ -187,8 +153,7 @@ func UpsertScraperStatus(c *fiber.Ctx) error {
 // @Failure 500 {object} models.GenericResponse
 // @Router /api/status/{name} [delete]
 func DeleteScraperStatus(c *fiber.Ctx) error {
-	statusCollection := config.MI.DB.Collection(shared.ScraperStatusCollectionName)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
 	defer cancel()
 
 	scraperName, err := url.QueryUnescape(c.Params("name"))
@@ -207,8 +172,14 @@ func DeleteScraperStatus(c *fiber.Ctx) error {
 		})
 	}
 
-	filter := bson.M{"scraperName": scraperName}
-	result, err := statusCollection.DeleteOne(ctx, filter)
+	err = storage.S.Statuses.Delete(ctx, scraperName)
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
+			Success: false,
+			Message: "status not found",
+			Error:   fmt.Sprintf("no status found for scraper: %s", scraperName),
+		})
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
 			Success: false,
@@ -217,14 +188,6 @@ func DeleteScraperStatus(c *fiber.Ctx) error {
 		})
 	}
 
-	if result.DeletedCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
-			Success: false,
-			Message: "status not found",
-			Error:   fmt.Sprintf("no status found for scraper: %s", scraperName),
-		})
-	}
-
 	return c.Status(fiber.StatusOK).JSON(models.GenericResponse{
 		Success: true,
 		Message: "status deleted successfully",
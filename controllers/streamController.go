@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/stream"
+)
+
+// streamKeepAliveInterval is the interval in which comments are sent to keep idle connections open.
+const streamKeepAliveInterval = 15 * time.Second
+
+// StreamEvents func streams newly added events.
+// @Description This endpoint streams newly added events matching the search terms as server-sent events. Every event is sent as a message of type 'event' with the JSON encoded event as data. Only events that did not exist before are sent, updates of existing events are not.
+// @Summary Stream newly added events.
+// @Tags events
+// @Produce text/event-stream
+// @Param title query string false "title search string"
+// @Param location query string false "location search string"
+// @Param type query string false "type search string"
+// @Param city query string false "city search string"
+// @Param country query string false "country search string"
+// @Param radius query int false "radius around given city in kilometers"
+// @Param date query string false "date search string"
+// @Param genres query string false "comma-separated list of genres; events matching at least one genre are returned"
+// @Param genreMode query string false "any (default) or all; whether events have to match any or all of the genres"
+// @Param excludeGenres query string false "comma-separated list of genres; events with one of these genres are not returned"
+// @Param excludeTypes query string false "comma-separated list of types; events of these types are not returned"
+// @Param subgenres query bool false "also match the genres that contain the given genres, e.g. hard techno for techno"
+// @Param filter query string false "filter expression, e.g. genres in (\"jazz\", \"soul\") and not city = \"Bern\""
+// @Success 200 {object} models.Event "stream of events"
+// @Failure 400 {object} models.GenericResponse
+// @Router /api/events/stream [get]
+func StreamEvents(c *fiber.Ctx) error {
+	query, err := parseEventsQuery(c)
+	var matcher *shared.EventMatcher
+	if err == nil {
+		matcher, err = shared.NewEventMatcher(c.UserContext(), query)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to subscribe to events",
+			Error:   err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		sub := stream.H.Subscribe(matcher)
+		defer stream.H.Unsubscribe(sub)
+
+		ticker := time.NewTicker(streamKeepAliveInterval)
+		defer ticker.Stop()
+
+		fmt.Fprint(w, ": subscribed\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+		for {
+			select {
+			case event := <-sub.Events:
+				data, err := json.Marshal(event)
+				if err != nil {
+					slog.Error("failed to marshal streamed event", "err", err)
+					continue
+				}
+				fmt.Fprintf(w, "event: event\nid: %s\ndata: %s\n\n", event.ID.Hex(), data)
+			case <-ticker.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case <-sub.Done:
+				return
+			}
+			// flushing fails as soon as the client has disconnected
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
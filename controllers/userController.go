@@ -0,0 +1,630 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/account"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// RegisterUser func registers a new user with a password.
+// @Description This endpoint creates a new user account and logs the user in.
+// @Summary Register a user.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param credentials body models.Credentials true "email and password"
+// @Success 201 {object} models.LoginResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 409 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/users/register [post]
+func RegisterUser(c *fiber.Ctx) error {
+	creds, resp := parseCredentials(c)
+	if resp != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(resp)
+	}
+
+	hash, err := account.HashPassword(creds.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to hash password",
+			Error:   err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	u := newUser(creds.Email)
+	u.PasswordHash = hash
+	// users that logged in with a magic link before can't set a password this way
+	inserted, err := storage.S.Users.Insert(ctx, u)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to insert user",
+			Error:   err.Error(),
+		})
+	}
+	if !inserted {
+		return c.Status(fiber.StatusConflict).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to register user",
+			Error:   "a user with this email address already exists",
+		})
+	}
+	return login(ctx, c, u.ID, fiber.StatusCreated)
+}
+
+// LoginUser func logs a user in with their password.
+// @Description This endpoint checks the password of the user and returns a session token that has to be sent as bearer token.
+// @Summary Log in with a password.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param credentials body models.Credentials true "email and password"
+// @Success 200 {object} models.LoginResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 401 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/users/login [post]
+func LoginUser(c *fiber.Ctx) error {
+	creds, resp := parseCredentials(c)
+	if resp != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(resp)
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	u, err := storage.S.Users.GetByEmail(ctx, account.NormalizeEmail(creds.Email))
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch user",
+			Error:   err.Error(),
+		})
+	}
+	if !account.CheckPassword(u, creds.Password) {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to log in",
+			Error:   "wrong email address or password",
+		})
+	}
+	return login(ctx, c, u.ID, fiber.StatusOK)
+}
+
+// RequestMagicLink func sends a magic link to a user.
+// @Description This endpoint sends a link to log in to the given email address. The account is created when the link is used for the first time.
+// @Summary Request a magic link.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body models.MagicLinkRequest true "email"
+// @Success 200 {object} models.GenericResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/users/magiclink [post]
+func RequestMagicLink(c *fiber.Ctx) error {
+	baseMURL := config.C.Users.MagicLinkURL
+	if baseMURL == "" {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to send magic link",
+			Error:   "MAGIC_LINK_URL has to be configured",
+		})
+	}
+
+	var req models.MagicLinkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse body",
+			Error:   err.Error(),
+		})
+	}
+	if err := validator.New().Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to validate email address",
+			Error:   err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+	token, err := account.CreateMagicLink(ctx, req.Email)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to create magic link",
+			Error:   err.Error(),
+		})
+	}
+
+	mUrl := fmt.Sprintf("%s?token=%s", baseMURL, url.QueryEscape(token))
+	mTempl := `
+Hi,
+<br><br>
+Click <a href=%s>here</a> to log in to concertcloud.live. The link is valid for %d minutes.
+<br><br>
+If you did not request this link you can safely ignore this email.
+<br><br>
+Your ConcertCloud team
+`
+	message := fmt.Sprintf(mTempl, mUrl, int(account.MagicLinkDuration.Minutes()))
+	if err := sendEmail(req.Email, "log in to concertcloud.live", message); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to send magic link",
+			Error:   err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(models.GenericResponse{
+		Success: true,
+		Message: "magic link sent successfully",
+	})
+}
+
+// LoginWithMagicLink func logs a user in with the token of a magic link.
+// @Description This endpoint exchanges the token of a magic link for a session token. Users that don't exist yet are created. The first time the email address of a user that registered with a password is verified this way, the password is removed and all other sessions are logged out, as the address might have been registered by somebody else.
+// @Summary Log in with a magic link.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param login body models.MagicLinkLogin true "token of the magic link"
+// @Success 200 {object} models.LoginResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 401 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/users/magiclink/login [post]
+func LoginWithMagicLink(c *fiber.Ctx) error {
+	var req models.MagicLinkLogin
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse body",
+			Error:   err.Error(),
+		})
+	}
+	if err := validator.New().Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to validate token",
+			Error:   err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+	email, err := account.RedeemMagicLink(ctx, req.Token)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if err == account.ErrInvalidToken {
+			status = fiber.StatusUnauthorized
+		}
+		return c.Status(status).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to log in",
+			Error:   err.Error(),
+		})
+	}
+
+	u := newUser(email)
+	u.EmailVerified = true
+	u, err = storage.S.Users.GetOrInsert(ctx, u)
+	if err == nil {
+		u, err = account.VerifyEmail(ctx, u)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch user",
+			Error:   err.Error(),
+		})
+	}
+	return login(ctx, c, u.ID, fiber.StatusOK)
+}
+
+// LogoutUser func logs the user out.
+// @Description This endpoint invalidates the session token of the request.
+// @Summary Log out.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.GenericResponse
+// @Failure 401 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/users/logout [post]
+func LogoutUser(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+	if err := account.DeleteSession(ctx, account.BearerToken(c)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to log out",
+			Error:   err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(models.GenericResponse{
+		Success: true,
+		Message: "logged out successfully",
+	})
+}
+
+// GetCurrentUser func gets the logged in user.
+// @Description This endpoint returns the logged in user including their favourite events and what they follow.
+// @Summary Get the current user.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.GetUserResponse
+// @Failure 401 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/users/me [get]
+func GetCurrentUser(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(models.GetUserResponse{
+		Success: true,
+		Data:    account.User(c),
+	})
+}
+
+// DeleteCurrentUser func deletes the logged in user.
+// @Description This endpoint deletes the account of the logged in user and all their sessions.
+// @Summary Delete the current user.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.GenericResponse
+// @Failure 401 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/users/me [delete]
+func DeleteCurrentUser(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+	if err := account.DeleteUser(ctx, account.User(c).ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to delete user",
+			Error:   err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(models.GenericResponse{
+		Success: true,
+		Message: "user deleted successfully",
+	})
+}
+
+// GetFavouriteEvents func gets the favourite events of the logged in user.
+// @Description This endpoint returns the favourite events of the logged in user, the latest first. Past events are included.
+// @Summary Get favourite events.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "page number"
+// @Param limit query int false "page size"
+// @Success 200 {object} models.GetEventsResponseSuccess
+// @Failure 400 {object} models.GenericResponse
+// @Failure 401 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/users/me/favourites [get]
+func GetFavouriteEvents(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	if page < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch favourite events",
+			Error:   "page parameter must be greater than 0",
+		})
+	}
+	limitInt, _ := strconv.Atoi(c.Query("limit", "10"))
+	if limitInt < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch favourite events",
+			Error:   "limit parameter must be greater than 0",
+		})
+	}
+	var limit int64 = int64(limitInt)
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	// past favourites have been moved to the archive
+	var events []models.Event
+	for _, r := range []storage.EventRepository{storage.S.Events, storage.S.Archive} {
+		found, err := r.FindByIDs(ctx, account.User(c).FavouriteEvents)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+				Success: false,
+				Message: "failed to fetch favourite events",
+				Error:   err.Error(),
+			})
+		}
+		events = append(events, found...)
+	}
+	slices.SortStableFunc(events, func(a, b models.Event) int { return b.Date.Compare(a.Date) })
+	total := int64(len(events))
+	skip := (int64(page) - 1) * limit
+	events = append([]models.Event{}, events[min(skip, total):min(skip+limit, total)]...)
+
+	last := int64(math.Ceil(float64(total) / float64(limit)))
+	if last < 1 && total > 0 {
+		last = 1
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GetEventsResponseSuccess{
+		Data:     events,
+		Total:    total,
+		Page:     page,
+		LastPage: last,
+		Limit:    limit,
+	})
+}
+
+// AddFavouriteEvent func adds an event to the favourites of the logged in user.
+// @Description This endpoint adds an event to the favourites of the logged in user.
+// @Summary Add a favourite event.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "event id"
+// @Success 200 {object} models.GetUserResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 401 {object} models.GenericResponse
+// @Failure 404 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/users/me/favourites/{id} [put]
+func AddFavouriteEvent(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse event id",
+			Error:   err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	if found, err := storage.S.Events.FindByIDs(ctx, []primitive.ObjectID{id}); err != nil || len(found) == 0 {
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+				Success: false,
+				Message: "failed to fetch event",
+				Error:   err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
+			Success: false,
+			Message: "event not found",
+			Error:   fmt.Sprintf("no event found with id %s", id.Hex()),
+		})
+	}
+	u, err := storage.S.Users.AddFavourite(ctx, account.User(c).ID, id)
+	return respondUser(c, u, err)
+}
+
+// DeleteFavouriteEvent func removes an event from the favourites of the logged in user.
+// @Description This endpoint removes an event from the favourites of the logged in user.
+// @Summary Remove a favourite event.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "event id"
+// @Success 200 {object} models.GetUserResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 401 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/users/me/favourites/{id} [delete]
+func DeleteFavouriteEvent(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse event id",
+			Error:   err.Error(),
+		})
+	}
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+	u, err := storage.S.Users.RemoveFavourite(ctx, account.User(c).ID, id)
+	return respondUser(c, u, err)
+}
+
+// AddFollow func lets the logged in user follow an artist, a venue or a city.
+// @Description This endpoint lets the logged in user follow an artist, a venue or a city. Their events show up in the feed of the user.
+// @Summary Follow an artist, venue or city.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param type path string true "artists, venues or cities"
+// @Param name query string true "name of the artist, venue or city"
+// @Success 200 {object} models.GetUserResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 401 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/users/me/follows/{type} [put]
+func AddFollow(c *fiber.Ctx) error {
+	kind, name, resp := parseFollow(c)
+	if resp != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(resp)
+	}
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+	u, err := storage.S.Users.AddFollow(ctx, account.User(c).ID, kind, name)
+	return respondUser(c, u, err)
+}
+
+// DeleteFollow func lets the logged in user unfollow an artist, a venue or a city.
+// @Description This endpoint lets the logged in user unfollow an artist, a venue or a city.
+// @Summary Unfollow an artist, venue or city.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param type path string true "artists, venues or cities"
+// @Param name query string true "name of the artist, venue or city"
+// @Success 200 {object} models.GetUserResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 401 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/users/me/follows/{type} [delete]
+func DeleteFollow(c *fiber.Ctx) error {
+	kind, name, resp := parseFollow(c)
+	if resp != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(resp)
+	}
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+	u, err := storage.S.Users.RemoveFollow(ctx, account.User(c).ID, kind, name)
+	return respondUser(c, u, err)
+}
+
+// GetUserFeed func gets the personalized feed of the logged in user.
+// @Description This endpoint returns the upcoming events of the artists, venues and cities the logged in user follows.
+// @Summary Get the feed of the current user.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param sort query string false "date (default) or trending"
+// @Param page query int false "page number"
+// @Param limit query int false "page size"
+// @Success 200 {object} models.GetEventsResponseSuccess
+// @Failure 400 {object} models.GenericResponse
+// @Failure 401 {object} models.GenericResponse
+// @Router /api/users/me/feed [get]
+func GetUserFeed(c *fiber.Ctx) error {
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "10"))
+	now := time.Now().UTC()
+	query := models.Query{
+		StartDate: &now,
+		Sort:      c.Query("sort"),
+		Page:      page,
+		Limit:     int64(limit),
+	}
+
+	events, total, last, err := shared.FetchEventsWithFilter(c.UserContext(), query, account.Follows(account.User(c)))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed fetch events",
+			Error:   err.Error(),
+		})
+	}
+	if events == nil {
+		events = []models.Event{}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GetEventsResponseSuccess{
+		Data:     events,
+		Total:    total,
+		Page:     query.Page,
+		LastPage: last,
+		Limit:    query.Limit,
+	})
+}
+
+// newUser returns a new user without password, favourites and follows.
+func newUser(email string) models.User {
+	return models.User{
+		ID:              primitive.NewObjectID(),
+		Email:           account.NormalizeEmail(email),
+		FavouriteEvents: []primitive.ObjectID{},
+		FollowedArtists: []string{},
+		FollowedVenues:  []string{},
+		FollowedCities:  []string{},
+		CreatedAt:       time.Now().UTC(),
+	}
+}
+
+// parseCredentials parses and validates the credentials in the body of the request.
+func parseCredentials(c *fiber.Ctx) (models.Credentials, *models.GenericResponse) {
+	var creds models.Credentials
+	if err := c.BodyParser(&creds); err != nil {
+		return creds, &models.GenericResponse{
+			Success: false,
+			Message: "failed to parse body",
+			Error:   err.Error(),
+		}
+	}
+	if err := validator.New().Struct(creds); err != nil {
+		return creds, &models.GenericResponse{
+			Success: false,
+			Message: "failed to validate credentials",
+			Error:   err.Error(),
+		}
+	}
+	return creds, nil
+}
+
+// parseFollow returns the kind and the name of what the user wants to (un)follow.
+func parseFollow(c *fiber.Ctx) (string, string, *models.GenericResponse) {
+	kind := c.Params("type")
+	if !slices.Contains(storage.FollowKinds, kind) {
+		return "", "", &models.GenericResponse{
+			Success: false,
+			Message: "failed to parse type",
+			Error:   "type must be artists, venues or cities",
+		}
+	}
+	name := c.Query("name")
+	if name == "" {
+		return "", "", &models.GenericResponse{
+			Success: false,
+			Message: "failed to parse name",
+			Error:   "name must not be empty",
+		}
+	}
+	return kind, name, nil
+}
+
+// login creates a new session for the user and returns its token.
+func login(ctx context.Context, c *fiber.Ctx, userID primitive.ObjectID, status int) error {
+	token, expiresAt, err := account.CreateSession(ctx, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to create session",
+			Error:   err.Error(),
+		})
+	}
+	return c.Status(status).JSON(models.LoginResponse{
+		Success:   true,
+		Message:   "logged in successfully",
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// respondUser responds with the user that has been updated or with the error of the update.
+func respondUser(c *fiber.Ctx, u models.User, err error) error {
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to update user",
+			Error:   err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(models.GetUserResponse{
+		Success: true,
+		Data:    u,
+	})
+}
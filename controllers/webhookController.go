@@ -0,0 +1,328 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/account"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+	"github.com/jakopako/event-api/webhook"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// AddWebhook func registers a new webhook.
+// @Description This endpoint registers a webhook that receives a signed POST request whenever an event matching the query is created, updated, rescheduled or deleted. The body of the request is signed with HMAC-SHA256 using the returned secret and the signature is sent in the X-Event-Api-Signature header as 'sha256=<hex>'. The secret is only returned once. Failed deliveries are retried with exponential backoff. Webhooks that are registered with a session token belong to its user, who is the only one besides the admin that can see and delete them, and a user can register at most WEBHOOK_MAX_PER_USER webhooks. Webhooks are only delivered to public addresses by http or https and redirects aren't followed.
+// @Summary Add webhook.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param webhook body models.Webhook true "webhook; changes can contain created, updated, rescheduled and deleted, all changes are sent if empty"
+// @Success 201 {object} models.AddWebhookResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/webhooks [post]
+func AddWebhook(c *fiber.Ctx) error {
+	var hook models.Webhook
+	if err := c.BodyParser(&hook); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse webhook",
+			Error:   err.Error(),
+		})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(hook); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to validate webhook",
+			Error:   err.Error(),
+		})
+	}
+	if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to validate webhook",
+			Error:   "url has to be an http or https URL",
+		})
+	}
+	for _, change := range hook.Changes {
+		if !slices.Contains(webhook.Changes, change) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+				Success: false,
+				Message: "failed to validate webhook",
+				Error:   fmt.Sprintf("changes can only contain %v", webhook.Changes),
+			})
+		}
+	}
+	// dates make no sense for webhooks, they apply to all future changes
+	hook.Query.StartDate = nil
+	hook.Query.EndDate = nil
+	if _, err := shared.NewEventMatcher(c.UserContext(), hook.Query); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to validate webhook",
+			Error:   err.Error(),
+		})
+	}
+
+	secret, err := generateRandomString(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to generate secret",
+			Error:   err.Error(),
+		})
+	}
+	hook.ID = primitive.NewObjectID()
+	hook.Secret = secret
+	hook.CreatedAt = time.Now().UTC()
+	hook.Owner = account.User(c).ID
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	if owner := webhookOwner(c); owner != nil {
+		hooks, err := storage.S.Webhooks.Find(ctx, owner)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+				Success: false,
+				Message: "failed to insert webhook",
+				Error:   err.Error(),
+			})
+		}
+		if len(hooks) >= config.C.Webhooks.MaxPerUser {
+			return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+				Success: false,
+				Message: "failed to insert webhook",
+				Error:   fmt.Sprintf("a user can't register more than %d webhooks", config.C.Webhooks.MaxPerUser),
+			})
+		}
+	}
+	if err := storage.S.Webhooks.Insert(ctx, hook); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to insert webhook",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(models.AddWebhookResponse{
+		Success: true,
+		Message: "webhook added successfully",
+		Data:    hook,
+	})
+}
+
+// GetWebhooks func gets all webhooks.
+// @Description This endpoint returns the webhooks of the logged in user or, for the admin, all registered webhooks without their secrets.
+// @Summary Get webhooks.
+// @Tags webhooks
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Success 200 {object} models.GetWebhooksResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/webhooks [get]
+func GetWebhooks(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	hooks, err := storage.S.Webhooks.Find(ctx, webhookOwner(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch webhooks",
+			Error:   err.Error(),
+		})
+	}
+	if hooks == nil {
+		hooks = []models.Webhook{}
+	}
+	// the secrets are only returned when the webhooks are added
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GetWebhooksResponse{
+		Success: true,
+		Data:    hooks,
+	})
+}
+
+// DeleteWebhook func deletes a webhook.
+// @Description This endpoint deletes a webhook and its delivery log.
+// @Summary Delete webhook.
+// @Tags webhooks
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "webhook id"
+// @Success 200 {object} models.GenericResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 404 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/webhooks/{id} [delete]
+func DeleteWebhook(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse webhook id",
+			Error:   err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	err = checkWebhookOwner(ctx, c, id)
+	if err == nil {
+		err = storage.S.Webhooks.Delete(ctx, id)
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
+				Success: false,
+				Message: "webhook not found",
+				Error:   fmt.Sprintf("no webhook found with id %s", id.Hex()),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to delete webhook",
+			Error:   err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GenericResponse{
+		Success: true,
+		Message: "webhook deleted successfully",
+	})
+}
+
+// GetWebhookDeliveries func gets the delivery log of a webhook.
+// @Description This endpoint returns all delivery attempts of a webhook, the latest first.
+// @Summary Get webhook deliveries.
+// @Tags webhooks
+// @Produce json
+// @Security BasicAuth
+// @Security BearerAuth
+// @Param id path string true "webhook id"
+// @Param page query int false "page number"
+// @Param limit query int false "page size"
+// @Success 200 {object} models.GetWebhookDeliveriesResponse
+// @Failure 400 {object} models.GenericResponse
+// @Failure 404 {object} models.GenericResponse
+// @Failure 500 {object} models.GenericResponse
+// @Router /api/webhooks/{id}/deliveries [get]
+func GetWebhookDeliveries(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to parse webhook id",
+			Error:   err.Error(),
+		})
+	}
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	if page < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch deliveries",
+			Error:   "page parameter must be greater than 0",
+		})
+	}
+	limitInt, _ := strconv.Atoi(c.Query("limit", "10"))
+	if limitInt < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch deliveries",
+			Error:   "limit parameter must be greater than 0",
+		})
+	}
+	var limit int64 = int64(limitInt)
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), config.C.Storage.Timeout)
+	defer cancel()
+
+	if err := checkWebhookOwner(ctx, c, id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.GenericResponse{
+				Success: false,
+				Message: "webhook not found",
+				Error:   fmt.Sprintf("no webhook found with id %s", id.Hex()),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch deliveries",
+			Error:   err.Error(),
+		})
+	}
+	deliveries, total, err := storage.S.Webhooks.FindDeliveries(ctx, id, (int64(page)-1)*limit, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.GenericResponse{
+			Success: false,
+			Message: "failed to fetch deliveries",
+			Error:   err.Error(),
+		})
+	}
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+
+	last := int64(math.Ceil(float64(total) / float64(limit)))
+	if last < 1 && total > 0 {
+		last = 1
+	}
+
+	return c.Status(fiber.StatusOK).JSON(models.GetWebhookDeliveriesResponse{
+		Data:     deliveries,
+		Total:    total,
+		Page:     page,
+		LastPage: last,
+		Limit:    limit,
+	})
+}
+
+// webhookOwner returns the id of the user that the request has been authenticated with or
+// nil for the admin, who manages all webhooks.
+func webhookOwner(c *fiber.Ctx) *primitive.ObjectID {
+	u := account.User(c)
+	if u.ID.IsZero() {
+		return nil
+	}
+	return &u.ID
+}
+
+// checkWebhookOwner returns storage.ErrNotFound if the user of the request doesn't own the
+// webhook with the given id. The admin may access all webhooks.
+func checkWebhookOwner(ctx context.Context, c *fiber.Ctx, id primitive.ObjectID) error {
+	owner := webhookOwner(c)
+	if owner == nil {
+		return nil
+	}
+	hook, err := storage.S.Webhooks.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if hook.Owner != *owner {
+		// other webhooks don't exist for the user
+		return storage.ErrNotFound
+	}
+	return nil
+}
@@ -16,23 +16,21 @@ import (
 
 	"github.com/jakopako/event-api/config"
 	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
 	cache "github.com/patrickmn/go-cache"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // GenreCache defines what is needed for querying and caching artist's genres
 type GenreCache struct {
 	memCache *cache.Cache
-	// we use an extra collection to make search faster
-	// this way we can store all the titles in lowercase and
-	// lowercase the input too when searching for a genre. In
-	// the events collection we want to keep the title's case
-	// so searching we need to use regex every time which is
-	// slow. We're doing that currently in shared.FetchEvents
-	// but there it doesn't matter to much for now since these
-	// are mostly user-triggered queries.
-	genresColl         *mongo.Collection
+	// the genres of the artists are stored in storage.S.ArtistGenres
+	// to make search faster. This way we can store all the titles
+	// in lowercase and lowercase the input too when searching for
+	// a genre. In the events collection we want to keep the title's
+	// case so searching we need to use regex every time which is
+	// slow. We're doing that currently in shared.FetchEvents but
+	// there it doesn't matter to much for now since these are
+	// mostly user-triggered queries.
 	allGenres          map[string]bool
 	lookupSpotifyGenre bool
 	spotifyToken       string
@@ -80,14 +78,14 @@ type spotifyArtistsResponse struct {
 
 var GC *GenreCache
 
-func (gc *GenreCache) renewSpotifyToken() error {
+func (gc *GenreCache) renewSpotifyToken(ctx context.Context) error {
 	client := http.Client{}
 	if gc.spotifyToken == "" || gc.spotifyTokenExpiry.Before(time.Now().UTC()) {
 		tokenUrl := "https://accounts.spotify.com/api/token"
-		clientId := os.Getenv("SPOTIFY_CLIENT_ID")
-		clientSecret := os.Getenv("SPOTIFY_CLIENT_SECRET")
+		clientId := config.C.Genre.SpotifyClientID
+		clientSecret := config.C.Genre.SpotifyClientSecret
 		if clientId == "" || clientSecret == "" {
-			return fmt.Errorf("env vars SPOTIFY_CLIENT_ID and/or SPOTIFY_CLIENT_SECRET are empty")
+			return fmt.Errorf("SPOTIFY_CLIENT_ID and/or SPOTIFY_CLIENT_SECRET are empty")
 		}
 
 		form := url.Values{}
@@ -95,7 +93,7 @@ func (gc *GenreCache) renewSpotifyToken() error {
 		form.Add("client_id", clientId)
 		form.Add("client_secret", clientSecret)
 
-		req, _ := http.NewRequest(http.MethodPost, tokenUrl, strings.NewReader(form.Encode()))
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, tokenUrl, strings.NewReader(form.Encode()))
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		resp, err := client.Do(req)
 		if err != nil {
@@ -119,12 +117,12 @@ func (gc *GenreCache) renewSpotifyToken() error {
 	return nil
 }
 
-func (gc *GenreCache) querySpotifyGenres(artist string) ([]string, error) {
+func (gc *GenreCache) querySpotifyGenres(ctx context.Context, artist string) ([]string, error) {
 	slog.Debug("querying spotify genres for artist", "artist", artist)
 	client := http.Client{}
 	requestUrl := fmt.Sprintf("https://api.spotify.com/v1/search?q=%s&type=artist", url.QueryEscape(strings.ToLower(artist)))
 	bearer := "Bearer " + gc.spotifyToken
-	req, _ := http.NewRequest(http.MethodGet, requestUrl, nil)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
 	req.Header.Add("Authorization", bearer)
 	resp, err := client.Do(req)
 	if err != nil {
@@ -156,15 +154,12 @@ func (gc *GenreCache) queryDBGenres(ctx context.Context, artist string) []string
 	// - empty list: we have queried the genres in the past and the answer from Spotify was empty
 	// - non-empty list: we have queried the genres in the past and the answer was non-empty
 	// only in the first case do we want to query Spotify at a later in querySpotifyGenres
-	filter := bson.D{{"title", strings.ToLower(artist)}}
-
-	var result models.TitleGenre
-	err := gc.genresColl.FindOne(ctx, filter).Decode(&result)
+	genres, err := storage.S.ArtistGenres.Get(ctx, strings.ToLower(artist))
 	if err != nil {
 		return nil
 	}
 
-	return result.Genres
+	return genres
 }
 
 func (gc *GenreCache) extractGenresFromText(genresText string) []string {
@@ -230,7 +225,7 @@ func (gc *GenreCache) extractArtistsFromTitle(title string) []string {
 
 func (gc *GenreCache) writeDBGenres(ctx context.Context, artist string, genres []string) {
 	// we ignore errors for now
-	_, _ = gc.genresColl.InsertOne(ctx, models.TitleGenre{Title: strings.ToLower(artist), Genres: genres})
+	_ = storage.S.ArtistGenres.Insert(ctx, strings.ToLower(artist), genres)
 }
 
 func (gc *GenreCache) lookupGenres(ctx context.Context, event models.Event) ([]string, error) {
@@ -263,11 +258,11 @@ func (gc *GenreCache) lookupGenres(ctx context.Context, event models.Event) ([]s
 			}
 
 			// query spotify
-			if err := gc.renewSpotifyToken(); err != nil {
+			if err := gc.renewSpotifyToken(ctx); err != nil {
 				return nil, err
 			}
 
-			genresA, err := gc.querySpotifyGenres(a)
+			genresA, err := gc.querySpotifyGenres(ctx, a)
 			if err != nil {
 				return nil, err
 			}
@@ -305,11 +300,10 @@ func loadGenresFromFile() map[string]bool {
 }
 
 func InitGenreCache() {
-	// this code assumes that the DB has already been initialized
+	// the genres of the artists are stored in storage.S, which has to be set up first
 	GC = &GenreCache{
-		lookupSpotifyGenre: os.Getenv("LOOKUP_SPOTIFY_GENRE") == "true",
+		lookupSpotifyGenre: config.C.Genre.LookupSpotify,
 		memCache:           cache.New(10*time.Minute, 15*time.Minute),
-		genresColl:         config.MI.DB.Collection("genres"),
 		allGenres:          loadGenresFromFile(),
 	}
 }
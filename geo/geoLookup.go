@@ -3,9 +3,11 @@ package geo
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -17,15 +19,13 @@ import (
 
 	"github.com/jakopako/event-api/config"
 	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
 	cache "github.com/patrickmn/go-cache"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
-	nominatimSearchURL = "https://nominatim.openstreetmap.org/search?"
+	// EarthRadiusKm is the earth radius used for radius searches
+	EarthRadiusKm = 6378.1
 )
 
 type GeolocCache struct {
@@ -36,27 +36,52 @@ type GeolocCache struct {
 	// for the negative cache (non-existing locations & cities) we use a cache library
 	// to be able to set expiration times and not worry about memory
 	negMemCache   *cache.Cache
-	cityColl      *mongo.Collection
 	cityMu        sync.RWMutex
 	venueMemCache map[string]*models.Venue
-	venueColl     *mongo.Collection
 	venueMu       sync.RWMutex
+	// nextRequest is the earliest time of the next request to Nominatim, see
+	// waitForNominatim
+	nextRequest time.Time
+	requestMu   sync.Mutex
 }
 
 var GC *GeolocCache
 
 func InitGeolocCache() {
-	// this code assumes that the DB has already been initialized
+	// the cities and venues are stored in storage.S, which has to be set up first
 	GC = &GeolocCache{
 		cityMemCache:  make(map[string]*models.GeocodedLocation),
 		negMemCache:   cache.New(10*time.Minute, 15*time.Minute),
-		cityColl:      config.MI.DB.Collection("cities"),
 		venueMemCache: make(map[string]*models.Venue),
-		venueColl:     config.MI.DB.Collection("venues"),
 	}
 }
 
-func LookupCityCoordinates(city, state, country string) (*models.GeocodedLocation, error) {
+// waitForNominatim waits until the next request to Nominatim may be sent, so that the
+// requests of all lookups are at least config.C.Geo.NominatimInterval apart. It returns
+// the error of the context if it is done first.
+func (gc *GeolocCache) waitForNominatim(ctx context.Context) error {
+	gc.requestMu.Lock()
+	now := time.Now()
+	at := now
+	if gc.nextRequest.After(now) {
+		at = gc.nextRequest
+	}
+	gc.nextRequest = at.Add(config.C.Geo.NominatimInterval)
+	gc.requestMu.Unlock()
+	if !at.After(now) {
+		return nil
+	}
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func LookupCityCoordinates(ctx context.Context, city, state, country string) (*models.GeocodedLocation, error) {
 	// this function is used when inserting new events and not when a user enters a search.
 	// Otherwise we risk flooding the external geo service.
 	city = strings.ToLower(city)
@@ -80,26 +105,27 @@ func LookupCityCoordinates(city, state, country string) (*models.GeocodedLocatio
 	}
 
 	// check database
-	filter := bson.D{{Key: "name", Value: city}, {Key: "state", Value: state}, {Key: "country", Value: country}}
-	var result models.City
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, config.C.Storage.Timeout)
 	defer cancel()
-	err := GC.cityColl.FindOne(ctx, filter).Decode(&result)
+	result, err := storage.S.Cities.Get(ctx, city, state, country)
 
 	// fetch if not in database and write
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, storage.ErrNotFound) {
 			// check if we've gotten a negative result from nominatim in the past
 			nominatimErr, found := GC.negMemCache.Get(internalSearchKey)
 			if found {
 				return nil, nominatimErr.(error)
 			}
 
-			geoLoc, err := queryNominatimForCityGeoloc(city, state, country)
+			geoLoc, err := queryNominatimForCityGeoloc(ctx, city, state, country)
 			if err != nil {
 				// write error to negative cache
 				// we don't want to flood the external service
-				GC.negMemCache.Set(internalSearchKey, err, cache.DefaultExpiration)
+				// a cancelled request says nothing about the city though
+				if ctx.Err() == nil {
+					GC.negMemCache.Set(internalSearchKey, err, cache.DefaultExpiration)
+				}
 				return nil, err
 			}
 			// write city to database and cache
@@ -107,7 +133,7 @@ func LookupCityCoordinates(city, state, country string) (*models.GeocodedLocatio
 			GC.cityMemCache[internalSearchKey] = geoLoc
 			GC.cityMu.Unlock()
 			newCity := models.City{Name: city, State: state, Country: country, Geolocation: *geoLoc}
-			_, err = GC.cityColl.InsertOne(ctx, newCity)
+			err = storage.S.Cities.Insert(ctx, newCity)
 			return geoLoc, err
 		} else {
 			return nil, err
@@ -120,35 +146,26 @@ func LookupCityCoordinates(city, state, country string) (*models.GeocodedLocatio
 	return &result.Geolocation, nil
 }
 
-func AllMatchesCityCoordinates(city, country string) ([]*models.MongoGeolocation, error) {
+func AllMatchesCityCoordinates(ctx context.Context, city, country string) ([]*models.MongoGeolocation, error) {
 	// We want all cities with the given name.
 	// Since we do not know how many there are we search the database without consulting
 	// the cache.
 	city = strings.ToLower(city)
 	country = strings.ToLower(country)
-	var filter primitive.D
-	if country == "" {
-		filter = bson.D{{Key: "name", Value: city}}
-	} else {
-		filter = bson.D{{Key: "name", Value: city}, {Key: "country", Value: country}}
-	}
-	var geolocs []*models.MongoGeolocation
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, config.C.Storage.Timeout)
 	defer cancel()
-	cursor, err := GC.cityColl.Find(ctx, filter, &options.FindOptions{})
+	cities, err := storage.S.Cities.FindByName(ctx, city, country)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
-	for cursor.Next(ctx) {
-		var city models.City
-		cursor.Decode(&city)
-		geolocs = append(geolocs, &city.Geolocation.MongoGeolocation)
+	var geolocs []*models.MongoGeolocation
+	for _, c := range cities {
+		geolocs = append(geolocs, &c.Geolocation.MongoGeolocation)
 	}
 	return geolocs, nil
 }
 
-func queryNominatimForCityGeoloc(city, state, country string) (*models.GeocodedLocation, error) {
+func queryNominatimForCityGeoloc(ctx context.Context, city, state, country string) (*models.GeocodedLocation, error) {
 	slog.Debug("querying Nominatim for city geolocation", "city", city, "country", country)
 	client := &http.Client{}
 	params := url.Values{}
@@ -161,9 +178,12 @@ func queryNominatimForCityGeoloc(city, state, country string) (*models.GeocodedL
 	}
 	params.Set("format", "jsonv2")
 
-	requestUrl := nominatimSearchURL + params.Encode()
+	requestUrl := config.C.Geo.NominatimURL + "?" + params.Encode()
+	if err := GC.waitForNominatim(ctx); err != nil {
+		return nil, err
+	}
 	slog.Debug("sending request for city to Nominatim", "url", requestUrl)
-	req, _ := http.NewRequest(http.MethodGet, requestUrl, nil)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
 	req.Header.Set("accept-language", "en-US")
 	req.Header.Set("user-agent", "https://github.com/jakopako/event-api (uses Nominatim for geocoding)")
 	resp, err := client.Do(req)
@@ -238,7 +258,7 @@ func isValidLocalityAddressType(addressType string) bool {
 
 // LookupVenueLocation tries to find coordinates for a specific venue (location) in a city using Nominatim.
 // Returns a Venue struct if found, otherwise returns nil and an error.
-func LookupVenueLocation(location, city, state, country string) (*models.Address, error) {
+func LookupVenueLocation(ctx context.Context, location, city, state, country string) (*models.Address, error) {
 	if location == "" || city == "" {
 		return nil, fmt.Errorf("location and city must be provided for venue lookup")
 	}
@@ -252,28 +272,19 @@ func LookupVenueLocation(location, city, state, country string) (*models.Address
 	venueKey = strings.ReplaceAll(venueKey, " ", "+")
 
 	// Check memory cache first
-	GC.cityMu.RLock()
+	GC.venueMu.RLock()
 	venueCached, found := GC.venueMemCache[venueKey]
-	GC.cityMu.RUnlock()
+	GC.venueMu.RUnlock()
 	if found && venueCached != nil {
 		return &venueCached.Address, nil
 	}
 
 	// Check database
-	filter := bson.D{{Key: "name", Value: location}, {Key: "address.locality", Value: city}}
-	if state != "" {
-		filter = append(filter, bson.E{Key: "address.state", Value: state})
-	}
-	if country != "" {
-		filter = append(filter, bson.E{Key: "address.country", Value: country})
-	}
-
-	var result models.Venue
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, config.C.Storage.Timeout)
 	defer cancel()
-	err := GC.venueColl.FindOne(ctx, filter).Decode(&result)
+	result, err := storage.S.Venues.Get(ctx, location, city, state, country)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, storage.ErrNotFound) {
 			// check if we've gotten a negative result from nominatim in the past
 			nominatimErr, found := GC.negMemCache.Get(venueKey)
 			if found {
@@ -281,17 +292,20 @@ func LookupVenueLocation(location, city, state, country string) (*models.Address
 			}
 
 			// If not found in database, query Nominatim
-			venue, err := queryNominatimForVenue(location, city, state, country)
+			venue, err := queryNominatimForVenue(ctx, location, city, state, country)
 			if err != nil {
 				// Cache the error in negative cache to avoid flooding Nominatim
-				GC.negMemCache.Set(venueKey, err, cache.DefaultExpiration)
+				// unless the request has been cancelled
+				if ctx.Err() == nil {
+					GC.negMemCache.Set(venueKey, err, cache.DefaultExpiration)
+				}
 				return nil, err
 			}
 			// Cache the venue in memory and database
 			GC.venueMu.Lock()
 			GC.venueMemCache[venueKey] = venue
 			GC.venueMu.Unlock()
-			_, err = GC.venueColl.InsertOne(ctx, venue)
+			err = storage.S.Venues.Insert(ctx, *venue)
 			if err != nil {
 				return nil, fmt.Errorf("failed to insert venue into database: %w", err)
 			}
@@ -307,7 +321,7 @@ func LookupVenueLocation(location, city, state, country string) (*models.Address
 	return &result.Address, nil
 }
 
-func queryNominatimForVenue(location, city, state, country string) (*models.Venue, error) {
+func queryNominatimForVenue(ctx context.Context, location, city, state, country string) (*models.Venue, error) {
 	slog.Debug("querying Nominatim for venue location", "location", location, "city", city, "state", state, "country", country)
 	client := &http.Client{}
 	params := url.Values{}
@@ -321,9 +335,12 @@ func queryNominatimForVenue(location, city, state, country string) (*models.Venu
 	if state != "" {
 		params.Set("state", state)
 	}
-	requestUrl := nominatimSearchURL + params.Encode()
+	requestUrl := config.C.Geo.NominatimURL + "?" + params.Encode()
+	if err := GC.waitForNominatim(ctx); err != nil {
+		return nil, err
+	}
 	slog.Debug("sending request for venue to Nominatim", "url", requestUrl)
-	req, _ := http.NewRequest(http.MethodGet, requestUrl, nil)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
 	req.Header.Set("accept-language", "en-US")
 	req.Header.Set("user-agent", "https://github.com/jakopako/event-api (uses Nominatim for geocoding)")
 	resp, err := client.Do(req)
@@ -437,3 +454,14 @@ func isValidAmenityType(amenityType string) bool {
 	}
 	return slices.Contains(validTypes, amenityType)
 }
+
+// DistanceKm returns the great-circle distance in kilometers between two [longitude, latitude] coordinates.
+func DistanceKm(a, b []float64) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return math.Inf(1)
+	}
+	lon1, lat1 := a[0]*math.Pi/180, a[1]*math.Pi/180
+	lon2, lat2 := b[0]*math.Pi/180, b[1]*math.Pi/180
+	h := math.Pow(math.Sin((lat2-lat1)/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin((lon2-lon1)/2), 2)
+	return 2 * EarthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
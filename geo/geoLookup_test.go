@@ -0,0 +1,56 @@
+package geo_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jakopako/event-api/geo"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"github.com/jakopako/event-api/storage/memory"
+)
+
+// TestConcurrentLookups looks up cities and venues that are stored but not cached yet from
+// many goroutines, so that the caches are read and written at the same time. Run it with
+// -race.
+func TestConcurrentLookups(t *testing.T) {
+	storage.S = memory.New()
+	geo.InitGeolocCache()
+	ctx := context.Background()
+	zurich := models.City{Name: "zurich", Country: "switzerland"}
+	zurich.Geolocation.GeoJSONType = "Point"
+	zurich.Geolocation.Coordinates = []float64{8.5417, 47.3769}
+	if err := storage.S.Cities.Insert(ctx, zurich); err != nil {
+		t.Fatal(err)
+	}
+	const numVenues = 5
+	for i := range numVenues {
+		venue := models.Venue{Name: fmt.Sprintf("Venue %d", i), Address: models.Address{Locality: "Zurich", Street: fmt.Sprintf("Street %d", i)}}
+		if err := storage.S.Venues.Insert(ctx, venue); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := geo.LookupCityCoordinates(ctx, "Zurich", "", "Switzerland"); err != nil {
+				t.Errorf("failed to look up city: %v", err)
+			}
+			n := i % numVenues
+			address, err := geo.LookupVenueLocation(ctx, fmt.Sprintf("Venue %d", n), "Zurich", "", "")
+			if err != nil {
+				t.Errorf("failed to look up venue %d: %v", n, err)
+				return
+			}
+			if want := fmt.Sprintf("Street %d", n); address.Street != want {
+				t.Errorf("got street %q for venue %d, want %q", address.Street, n, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
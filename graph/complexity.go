@@ -0,0 +1,133 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+const (
+	// MaxLimit is the largest page that a list of the schema returns.
+	MaxLimit = 100
+	// MaxDepth is the deepest that fields may be nested in a query.
+	MaxDepth = 8
+	// MaxComplexity is the most fields that a query may resolve, see complexity.
+	MaxComplexity = 5000
+)
+
+// listSizes are the numbers of items that the lists of the schema return if their limit
+// isn't given.
+var listSizes = map[string]int{
+	"events":          10,
+	"upcomingEvents":  10,
+	"venues":          50,
+	"cities":          50,
+	"scraperStatuses": 100,
+}
+
+// checkComplexity returns an error if the operation of the query nests its fields deeper
+// than MaxDepth or resolves more than MaxComplexity fields. Queries that can't be parsed
+// are left to the validation of the schema.
+func checkComplexity(query, operationName string, variables map[string]any) error {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return nil
+	}
+	fragments := map[string]*ast.FragmentDefinition{}
+	var op *ast.OperationDefinition
+	for _, d := range doc.Definitions {
+		switch def := d.(type) {
+		case *ast.FragmentDefinition:
+			fragments[def.Name.Value] = def
+		case *ast.OperationDefinition:
+			if op == nil || (def.Name != nil && def.Name.Value == operationName) {
+				op = def
+			}
+		}
+	}
+	if op == nil {
+		return nil
+	}
+	c := complexity{fragments: fragments, variables: variables, visiting: map[string]bool{}}
+	n, err := c.selections(op.SelectionSet, 1, 1)
+	if err != nil {
+		return err
+	}
+	if n > MaxComplexity {
+		return fmt.Errorf("the query resolves up to %d fields, at most %d are allowed", n, MaxComplexity)
+	}
+	return nil
+}
+
+// complexity counts the fields that a query resolves at most. The fields within a list
+// count once for every item of the list.
+type complexity struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]any
+	// visiting are the fragments that are being counted, so that cycles end
+	visiting map[string]bool
+}
+
+func (c complexity) selections(set *ast.SelectionSet, items, depth int) (int, error) {
+	if set == nil {
+		return 0, nil
+	}
+	if depth > MaxDepth {
+		return 0, fmt.Errorf("the query is nested deeper than %d fields", MaxDepth)
+	}
+	n := 0
+	for _, s := range set.Selections {
+		var m int
+		var err error
+		switch sel := s.(type) {
+		case *ast.Field:
+			// introspection is answered from the schema
+			if strings.HasPrefix(sel.Name.Value, "__") {
+				continue
+			}
+			m, err = c.selections(sel.SelectionSet, items*c.listSize(sel), depth+1)
+			m += items
+		case *ast.InlineFragment:
+			m, err = c.selections(sel.SelectionSet, items, depth)
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			if f, ok := c.fragments[name]; ok && !c.visiting[name] {
+				c.visiting[name] = true
+				m, err = c.selections(f.SelectionSet, items, depth)
+				delete(c.visiting, name)
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// listSize returns the number of items that the field returns at most.
+func (c complexity) listSize(f *ast.Field) int {
+	size, ok := listSizes[f.Name.Value]
+	if !ok {
+		return 1
+	}
+	for _, arg := range f.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			fmt.Sscan(v.Value, &size)
+		case *ast.Variable:
+			switch value := c.variables[v.Name.Value].(type) {
+			case float64:
+				size = int(value)
+			case int:
+				size = value
+			}
+		}
+	}
+	return max(min(size, MaxLimit), 1)
+}
@@ -0,0 +1,444 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/moderation"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Schema is the GraphQL schema of the API. Struct fields are resolved by their json
+// names, so only fields that need extra work have their own resolvers.
+var Schema graphql.Schema
+
+var (
+	geolocationType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Geolocation",
+		Fields: graphql.Fields{
+			"longitude": &graphql.Field{
+				Type: graphql.Float,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return coordinate(p.Source, 0), nil
+				},
+			},
+			"latitude": &graphql.Field{
+				Type: graphql.Float,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return coordinate(p.Source, 1), nil
+				},
+			},
+		},
+	})
+
+	addressType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Address",
+		Fields: graphql.Fields{
+			"locality":    &graphql.Field{Type: graphql.String},
+			"postCode":    &graphql.Field{Type: graphql.String},
+			"street":      &graphql.Field{Type: graphql.String},
+			"houseNumber": &graphql.Field{Type: graphql.String},
+			"country":     &graphql.Field{Type: graphql.String},
+			"state":       &graphql.Field{Type: graphql.String},
+			"geolocation": &graphql.Field{Type: geolocationType},
+		},
+	})
+
+	// eventType and venueType reference each other, so their fields are added in init
+	eventType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Event",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.ID,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return p.Source.(models.Event).ID.Hex(), nil
+				},
+			},
+			"title":     &graphql.Field{Type: graphql.String},
+			"location":  &graphql.Field{Type: graphql.String},
+			"city":      &graphql.Field{Type: graphql.String},
+			"state":     &graphql.Field{Type: graphql.String},
+			"country":   &graphql.Field{Type: graphql.String},
+			"date":      &graphql.Field{Type: graphql.DateTime},
+			"url":       &graphql.Field{Type: graphql.String},
+			"imageUrl":  &graphql.Field{Type: graphql.String},
+			"comment":   &graphql.Field{Type: graphql.String},
+			"type":      &graphql.Field{Type: graphql.String},
+			"sourceUrl": &graphql.Field{Type: graphql.String},
+			"genres":    &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"address":   &graphql.Field{Type: addressType},
+		},
+	})
+
+	venueType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Venue",
+		Fields: graphql.Fields{
+			"name":    &graphql.Field{Type: graphql.String},
+			"type":    &graphql.Field{Type: graphql.String},
+			"address": &graphql.Field{Type: addressType},
+		},
+	})
+
+	cityType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "City",
+		Fields: graphql.Fields{
+			"name":        &graphql.Field{Type: graphql.String},
+			"state":       &graphql.Field{Type: graphql.String},
+			"country":     &graphql.Field{Type: graphql.String},
+			"geolocation": &graphql.Field{Type: geolocationType},
+		},
+	})
+
+	eventPageType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "EventPage",
+		Fields: graphql.Fields{
+			"data":     &graphql.Field{Type: graphql.NewList(eventType)},
+			"total":    &graphql.Field{Type: graphql.Int},
+			"page":     &graphql.Field{Type: graphql.Int},
+			"lastPage": &graphql.Field{Type: graphql.Int},
+			"limit":    &graphql.Field{Type: graphql.Int},
+		},
+	})
+
+	scraperStatusType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "ScraperStatus",
+		Fields: graphql.Fields{
+			"scraperName":     &graphql.Field{Type: graphql.String},
+			"nrItems":         &graphql.Field{Type: graphql.Int},
+			"nrErrors":        &graphql.Field{Type: graphql.Int},
+			"lastScrapeStart": &graphql.Field{Type: graphql.DateTime},
+			"lastScrapeEnd":   &graphql.Field{Type: graphql.DateTime},
+			"scraperLogs":     &graphql.Field{Type: graphql.String},
+		},
+	})
+)
+
+var eventFilterArgs = graphql.FieldConfigArgument{
+	"title":         &graphql.ArgumentConfig{Type: graphql.String, Description: "title search string"},
+	"location":      &graphql.ArgumentConfig{Type: graphql.String, Description: "location search string"},
+	"type":          &graphql.ArgumentConfig{Type: graphql.String, Description: "type search string"},
+	"city":          &graphql.ArgumentConfig{Type: graphql.String, Description: "city search string"},
+	"country":       &graphql.ArgumentConfig{Type: graphql.String, Description: "country search string"},
+	"radius":        &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0, Description: "radius around given city in kilometers"},
+	"genres":        &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String), Description: "events matching at least one genre are returned"},
+	"genreMode":     &graphql.ArgumentConfig{Type: graphql.String, Description: "any (default) or all; whether events have to match any or all of the genres"},
+	"excludeGenres": &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String), Description: "events with one of these genres are not returned"},
+	"excludeTypes":  &graphql.ArgumentConfig{Type: graphql.NewList(graphql.String), Description: "events of these types are not returned"},
+	"filter":        &graphql.ArgumentConfig{Type: graphql.String, Description: "filter expression, e.g. genres in (\"jazz\", \"soul\") and not city = \"Bern\""},
+	"subgenres":     &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false, Description: "also match the genres that contain the given genres, e.g. hard techno for techno"},
+	"startDate":     &graphql.ArgumentConfig{Type: graphql.DateTime, Description: "defaults to now"},
+	"endDate":       &graphql.ArgumentConfig{Type: graphql.DateTime},
+	"page":          &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 1},
+	"limit":         &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 10},
+}
+
+func init() {
+	venueType.AddFieldConfig("upcomingEvents", &graphql.Field{
+		Type:        graphql.NewList(eventType),
+		Description: "upcoming events at this venue",
+		Args: graphql.FieldConfigArgument{
+			"limit": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 10},
+		},
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			v := p.Source.(models.Venue)
+			return upcomingEvents(p, models.Query{Location: v.Name, City: v.Address.Locality})
+		},
+	})
+	cityType.AddFieldConfig("upcomingEvents", &graphql.Field{
+		Type:        graphql.NewList(eventType),
+		Description: "upcoming events in this city",
+		Args: graphql.FieldConfigArgument{
+			"limit": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 10},
+		},
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			c := p.Source.(models.City)
+			return upcomingEvents(p, models.Query{City: c.Name, Country: c.Country})
+		},
+	})
+	eventType.AddFieldConfig("venue", &graphql.Field{
+		Type:        venueType,
+		Description: "the venue of this event, if it is known",
+		Resolve:     resolveVenue,
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"events": &graphql.Field{
+				Type:        eventPageType,
+				Description: "events matching the search terms, the same as GET /api/events",
+				Args:        eventFilterArgs,
+				Resolve:     resolveEvents,
+			},
+			"event": &graphql.Field{
+				Type: eventType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: resolveEvent,
+			},
+			"venues": &graphql.Field{
+				Type: graphql.NewList(venueType),
+				Args: graphql.FieldConfigArgument{
+					"city":  &graphql.ArgumentConfig{Type: graphql.String},
+					"name":  &graphql.ArgumentConfig{Type: graphql.String, Description: "name search string"},
+					"limit": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 50},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					city, _ := p.Args["city"].(string)
+					name, _ := p.Args["name"].(string)
+					limit, err := limitArg(p)
+					if err != nil {
+						return nil, err
+					}
+					return storage.S.Venues.Find(p.Context, city, name, int64(limit))
+				},
+			},
+			"cities": &graphql.Field{
+				Type: graphql.NewList(cityType),
+				Args: graphql.FieldConfigArgument{
+					"country": &graphql.ArgumentConfig{Type: graphql.String},
+					"limit":   &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 50},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					country, _ := p.Args["country"].(string)
+					limit, err := limitArg(p)
+					if err != nil {
+						return nil, err
+					}
+					return storage.S.Cities.Find(p.Context, country, int64(limit))
+				},
+			},
+			"genres": &graphql.Field{
+				Type:        graphql.NewList(graphql.String),
+				Description: "all genres of upcoming events",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return shared.FetchDistinct(p.Context, "genres")
+				},
+			},
+			"scraperStatuses": &graphql.Field{
+				Type: graphql.NewList(scraperStatusType),
+				Args: graphql.FieldConfigArgument{
+					"name": &graphql.ArgumentConfig{Type: graphql.String, Description: "scraper name"},
+				},
+				Resolve: resolveScraperStatuses,
+			},
+		},
+	})
+
+	var err error
+	Schema, err = graphql.NewSchema(graphql.SchemaConfig{Query: queryType})
+	if err != nil {
+		panic(fmt.Sprintf("invalid graphql schema: %v", err))
+	}
+}
+
+// Execute runs the given GraphQL request against the schema. Requests that are too complex
+// are refused, see checkComplexity.
+func Execute(ctx context.Context, query, operationName string, variables map[string]any) *graphql.Result {
+	if err := checkComplexity(query, operationName, variables); err != nil {
+		return &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.FormatError(err)}}
+	}
+	// the venues of the events are looked up once per request
+	ctx = context.WithValue(ctx, venuesKey{}, &venueCache{venues: map[string]*models.Venue{}})
+	return graphql.Do(graphql.Params{
+		Schema:         Schema,
+		RequestString:  query,
+		OperationName:  operationName,
+		VariableValues: variables,
+		Context:        ctx,
+	})
+}
+
+type venuesKey struct{}
+
+// venueCache holds the venues that have been looked up during a request, nil if a venue
+// isn't known.
+type venueCache struct {
+	mu     sync.Mutex
+	venues map[string]*models.Venue
+}
+
+func resolveVenue(p graphql.ResolveParams) (any, error) {
+	e := p.Source.(models.Event)
+	cache, _ := p.Context.Value(venuesKey{}).(*venueCache)
+	if cache == nil {
+		cache = &venueCache{venues: map[string]*models.Venue{}}
+	}
+	key := e.Location + "\x00" + e.City
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if v, ok := cache.venues[key]; ok {
+		return nilIfUnknown(v), nil
+	}
+	v, err := storage.S.Venues.Get(p.Context, e.Location, e.City, "", "")
+	if errors.Is(err, storage.ErrNotFound) {
+		cache.venues[key] = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cache.venues[key] = &v
+	return v, nil
+}
+
+// nilIfUnknown returns the venue or an untyped nil, so that unknown venues are null.
+func nilIfUnknown(v *models.Venue) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+// limitArg returns the limit argument of the field, which has to be between 1 and MaxLimit.
+func limitArg(p graphql.ResolveParams) (int, error) {
+	limit := p.Args["limit"].(int)
+	if limit < 1 || limit > MaxLimit {
+		return 0, fmt.Errorf("limit has to be between 1 and %d", MaxLimit)
+	}
+	return limit, nil
+}
+
+func resolveEvents(p graphql.ResolveParams) (any, error) {
+	limit, err := limitArg(p)
+	if err != nil {
+		return nil, err
+	}
+	q := models.Query{
+		Radius: p.Args["radius"].(int),
+		Page:   p.Args["page"].(int),
+		Limit:  int64(limit),
+	}
+	q.Title, _ = p.Args["title"].(string)
+	q.Location, _ = p.Args["location"].(string)
+	q.Type, _ = p.Args["type"].(string)
+	q.City, _ = p.Args["city"].(string)
+	q.Country, _ = p.Args["country"].(string)
+	q.Genres = stringList(p.Args["genres"])
+	q.GenreMode, _ = p.Args["genreMode"].(string)
+	q.ExcludeGenres = stringList(p.Args["excludeGenres"])
+	q.ExcludeTypes = stringList(p.Args["excludeTypes"])
+	q.Subgenres, _ = p.Args["subgenres"].(bool)
+	q.Filter, _ = p.Args["filter"].(string)
+	if start, ok := p.Args["startDate"].(time.Time); ok {
+		q.StartDate = &start
+	} else {
+		now := time.Now().UTC()
+		q.StartDate = &now
+	}
+	if end, ok := p.Args["endDate"].(time.Time); ok {
+		q.EndDate = &end
+	}
+
+	events, total, last, err := shared.FetchEvents(p.Context, q)
+	if err != nil {
+		return nil, err
+	}
+	return models.GetEventsResponseSuccess{
+		Data:     events,
+		Total:    total,
+		Page:     q.Page,
+		LastPage: last,
+		Limit:    q.Limit,
+	}, nil
+}
+
+// stringList returns the non-empty strings of a list argument.
+func stringList(arg any) []string {
+	var list []string
+	if values, ok := arg.([]any); ok {
+		for _, v := range values {
+			if s, ok := v.(string); ok && s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+func resolveEvent(p graphql.ResolveParams) (any, error) {
+	id, err := primitive.ObjectIDFromHex(p.Args["id"].(string))
+	if err != nil {
+		return nil, fmt.Errorf("invalid event id: %w", err)
+	}
+	event, err := storage.S.Events.Get(p.Context, id)
+	if errors.Is(err, storage.ErrNotFound) || slices.Contains(moderation.HiddenStatuses, event.ModerationStatus) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func resolveScraperStatuses(p graphql.ResolveParams) (any, error) {
+	name, _ := p.Args["name"].(string)
+	// the logs can be huge, so we only fetch them if they have been asked for
+	statuses, _, err := storage.S.Statuses.Find(p.Context, name, 0, 0, selectsField(p.Info.FieldASTs, "scraperLogs"))
+	if err != nil {
+		return nil, err
+	}
+	if statuses == nil {
+		statuses = []models.ScraperStatus{}
+	}
+	return statuses, nil
+}
+
+func upcomingEvents(p graphql.ResolveParams, q models.Query) ([]models.Event, error) {
+	limit, err := limitArg(p)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	q.StartDate = &now
+	q.Page = 1
+	q.Limit = int64(limit)
+	events, _, _, err := shared.FetchEvents(p.Context, q)
+	return events, err
+}
+
+func coordinate(source any, i int) any {
+	var coords []float64
+	switch s := source.(type) {
+	case models.GeocodedLocation:
+		coords = s.Coordinates
+	case *models.GeocodedLocation:
+		coords = s.Coordinates
+	}
+	if len(coords) <= i {
+		return nil
+	}
+	return coords[i]
+}
+
+// selectsField checks whether the given field is part of the selection sets of the given fields.
+// Fragments are not inspected, so for those the field is assumed to be selected.
+func selectsField(fields []*ast.Field, name string) bool {
+	for _, f := range fields {
+		if f.SelectionSet == nil {
+			continue
+		}
+		for _, s := range f.SelectionSet.Selections {
+			switch sel := s.(type) {
+			case *ast.Field:
+				if sel.Name != nil && sel.Name.Value == name {
+					return true
+				}
+			default:
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package graph
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"github.com/jakopako/event-api/storage/memory"
+)
+
+func TestSelectsField(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected bool
+	}{
+		{"{ scraperStatuses { scraperName nrItems } }", false},
+		{"{ scraperStatuses { scraperName scraperLogs } }", true},
+		{"{ scraperStatuses { ...f } } fragment f on ScraperStatus { scraperName }", true},
+	}
+	for _, tt := range tests {
+		doc, err := parser.Parse(parser.ParseParams{Source: tt.query})
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", tt.query, err)
+		}
+		op := doc.Definitions[0].(*ast.OperationDefinition)
+		field := op.SelectionSet.Selections[0].(*ast.Field)
+		if got := selectsField([]*ast.Field{field}, "scraperLogs"); got != tt.expected {
+			t.Errorf("selectsField for %q = %v; want %v", tt.query, got, tt.expected)
+		}
+	}
+}
+
+func TestSchemaValidation(t *testing.T) {
+	result := Execute(context.Background(), "{ events { data { unknownField } } }", "", nil)
+	if len(result.Errors) == 0 {
+		t.Errorf("expected validation error for unknown field")
+	}
+
+	result = Execute(context.Background(), "{ __type(name: \"Venue\") { fields { name } } }", "", nil)
+	if len(result.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+}
+
+func TestComplexity(t *testing.T) {
+	tests := []struct {
+		query     string
+		variables map[string]any
+		ok        bool
+	}{
+		{"{ events(limit: 100) { total data { title venue { name } } } }", nil, true},
+		{"{ events(limit: 100) { data { venue { upcomingEvents(limit: 100) { title } } } } }", nil, false},
+		{"query q($n: Int) { cities(limit: $n) { upcomingEvents(limit: $n) { title city } } }", map[string]any{"n": float64(40)}, true},
+		{"query q($n: Int) { cities(limit: $n) { upcomingEvents(limit: $n) { title city } } }", map[string]any{"n": float64(100)}, false},
+		{"{ events { data { venue { upcomingEvents { venue { upcomingEvents { venue { upcomingEvents { title } } } } } } } } }", nil, false},
+		{"{ events(limit: 100) { data { ...f } } } fragment f on Event { venue { upcomingEvents(limit: 100) { title } } }", nil, false},
+		{"{ __schema { types { fields { type { ofType { ofType { ofType { ofType { ofType { name } } } } } } } } } }", nil, true},
+	}
+	for _, tc := range tests {
+		if err := checkComplexity(tc.query, "", tc.variables); (err == nil) != tc.ok {
+			t.Errorf("got error %v for %q", err, tc.query)
+		}
+	}
+}
+
+// countingVenues counts the venues that are looked up.
+type countingVenues struct {
+	storage.VenueRepository
+	gets int
+}
+
+func (r *countingVenues) Get(ctx context.Context, name, city, state, country string) (models.Venue, error) {
+	r.gets++
+	return r.VenueRepository.Get(ctx, name, city, state, country)
+}
+
+func TestEventVenues(t *testing.T) {
+	storage.S = memory.New()
+	venues := &countingVenues{VenueRepository: storage.S.Venues}
+	storage.S.Venues = venues
+	ctx := context.Background()
+	if err := storage.S.Venues.Insert(ctx, models.Venue{Name: "Moods", Address: models.Address{Locality: "Zurich", Street: "Schiffbaustrasse"}}); err != nil {
+		t.Fatal(err)
+	}
+	date := time.Now().UTC().Add(24 * time.Hour)
+	var events []models.Event
+	for _, location := range []string{"Moods", "Moods", "Moods", "Unknown"} {
+		events = append(events, models.Event{Title: "Jazz Night", Location: location, City: "Zurich", Date: date, SourceURL: "https://moods.ch", Genres: []string{}})
+		date = date.Add(time.Hour)
+	}
+	if _, err := storage.S.Events.Upsert(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+
+	result := Execute(ctx, "{ events { data { location venue { address { street } } } } }", "", nil)
+	if len(result.Errors) > 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	data := result.Data.(map[string]any)["events"].(map[string]any)["data"].([]any)
+	var streets []string
+	for _, e := range data {
+		venue, _ := e.(map[string]any)["venue"].(map[string]any)
+		if venue == nil {
+			streets = append(streets, "")
+			continue
+		}
+		streets = append(streets, venue["address"].(map[string]any)["street"].(string))
+	}
+	if got := strings.Join(streets, ","); got != "Schiffbaustrasse,Schiffbaustrasse,Schiffbaustrasse," {
+		t.Errorf("got streets %q", got)
+	}
+	if venues.gets != 2 {
+		t.Errorf("looked up venues %d times, want 2", venues.gets)
+	}
+
+	result = Execute(ctx, "{ events(limit: 1000) { total } }", "", nil)
+	if len(result.Errors) == 0 {
+		t.Error("expected an error for a limit greater than the maximum")
+	}
+}
@@ -0,0 +1,181 @@
+// Package ical exports events as iCalendar (RFC 5545) documents.
+package ical
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+)
+
+const (
+	prodID        = "-//jakopako//event-api//EN"
+	utcFormat     = "20060102T150405Z"
+	localFormat   = "20060102T150405"
+	maxLineOctets = 75
+	uidSuffix     = "@event-api"
+)
+
+// Write writes the events as VCALENDAR. Recurring events are written once as VEVENT
+// with their recurrence rule, no matter how many of their occurrences are given.
+// Cancelled occurrences are written as separate VEVENTs with STATUS:CANCELLED.
+func Write(w io.Writer, events []models.Event) error {
+	cw := &calendarWriter{w: w}
+	cw.line("BEGIN:VCALENDAR")
+	cw.line("VERSION:2.0")
+	cw.line("PRODID:" + prodID)
+	cw.line("CALSCALE:GREGORIAN")
+
+	// recurring events are written in their own time zone so that the rule is
+	// evaluated correctly by the client
+	var offsets []int
+	for _, e := range events {
+		if e.Recurrence != nil && !slices.Contains(offsets, e.Offset) {
+			offsets = append(offsets, e.Offset)
+		}
+	}
+	for _, offset := range offsets {
+		cw.timezone(offset)
+	}
+
+	now := time.Now().UTC()
+	written := map[string]bool{}
+	for _, e := range events {
+		uid := uid(e)
+		if e.Recurrence != nil {
+			if written[uid] {
+				continue
+			}
+			written[uid] = true
+		}
+		cw.event(e, uid, now)
+	}
+	cw.line("END:VCALENDAR")
+	return cw.err
+}
+
+type calendarWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (cw *calendarWriter) event(e models.Event, uid string, now time.Time) {
+	cw.line("BEGIN:VEVENT")
+	cw.line("UID:" + uid)
+	cw.line("DTSTAMP:" + now.Format(utcFormat))
+	if e.Recurrence == nil {
+		cw.line("DTSTART:" + e.Date.UTC().Format(utcFormat))
+	} else {
+		cw.line(fmt.Sprintf("DTSTART;TZID=%s:%s", tzid(e.Offset), local(e, e.Date)))
+		cw.line("RRULE:" + e.Recurrence.RRule)
+		for _, d := range e.Recurrence.ExDates {
+			cw.line(fmt.Sprintf("EXDATE;TZID=%s:%s", tzid(e.Offset), local(e, d)))
+		}
+	}
+	cw.details(e)
+	cw.line("END:VEVENT")
+
+	if e.Recurrence == nil {
+		return
+	}
+	for _, d := range e.Recurrence.Cancelled {
+		cw.line("BEGIN:VEVENT")
+		cw.line("UID:" + uid)
+		cw.line("DTSTAMP:" + now.Format(utcFormat))
+		cw.line(fmt.Sprintf("RECURRENCE-ID;TZID=%s:%s", tzid(e.Offset), local(e, d)))
+		cw.line(fmt.Sprintf("DTSTART;TZID=%s:%s", tzid(e.Offset), local(e, d)))
+		cw.line("STATUS:CANCELLED")
+		cw.details(e)
+		cw.line("END:VEVENT")
+	}
+}
+
+func (cw *calendarWriter) details(e models.Event) {
+	cw.line("SUMMARY:" + escape(e.Title))
+	location := e.Location
+	if a := e.Address; a.Street != "" {
+		location = fmt.Sprintf("%s, %s %s, %s %s", e.Location, a.Street, a.HouseNumber, a.PostCode, a.Locality)
+	} else if e.City != "" {
+		location = fmt.Sprintf("%s, %s", e.Location, e.City)
+	}
+	cw.line("LOCATION:" + escape(location))
+	if coords := e.Address.Geolocacation.Coordinates; len(coords) == 2 {
+		cw.line(fmt.Sprintf("GEO:%f;%f", coords[1], coords[0]))
+	}
+	if e.Comment != "" {
+		cw.line("DESCRIPTION:" + escape(e.Comment))
+	}
+	if e.URL != "" {
+		cw.line("URL:" + e.URL)
+	}
+	if len(e.Genres) > 0 {
+		genres := make([]string, 0, len(e.Genres))
+		for _, g := range e.Genres {
+			genres = append(genres, escape(g))
+		}
+		cw.line("CATEGORIES:" + strings.Join(genres, ","))
+	}
+}
+
+// timezone writes a VTIMEZONE with a fixed offset.
+func (cw *calendarWriter) timezone(offset int) {
+	cw.line("BEGIN:VTIMEZONE")
+	cw.line("TZID:" + tzid(offset))
+	cw.line("BEGIN:STANDARD")
+	cw.line("DTSTART:19700101T000000")
+	cw.line("TZOFFSETFROM:" + utcOffset(offset))
+	cw.line("TZOFFSETTO:" + utcOffset(offset))
+	cw.line("END:STANDARD")
+	cw.line("END:VTIMEZONE")
+}
+
+// line writes a content line, folded after 75 octets as required by RFC 5545.
+func (cw *calendarWriter) line(l string) {
+	if cw.err != nil {
+		return
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range l {
+		size := len(string(r))
+		if n+size > maxLineOctets {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	b.WriteString("\r\n")
+	_, cw.err = io.WriteString(cw.w, b.String())
+}
+
+func uid(e models.Event) string {
+	if !e.ID.IsZero() {
+		return e.ID.Hex() + uidSuffix
+	}
+	return fmt.Sprintf("%d-%x%s", e.Date.Unix(), e.Title, uidSuffix)
+}
+
+func tzid(offset int) string {
+	return "UTC" + utcOffset(offset)
+}
+
+func utcOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+}
+
+func local(e models.Event, d time.Time) string {
+	return d.In(time.FixedZone("", e.Offset)).Format(localFormat)
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,72 @@
+package ical_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jakopako/event-api/ical"
+	"github.com/jakopako/event-api/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWrite(t *testing.T) {
+	id, _ := primitive.ObjectIDFromHex("6717a2d1c5f1a2b3c4d5e6f7")
+	series := models.Event{
+		ID:       id,
+		Title:    "Jam Session; open stage, bring your instrument",
+		Location: "Moods",
+		City:     "Zurich",
+		Date:     time.Date(2024, 10, 3, 18, 0, 0, 0, time.UTC),
+		Offset:   2 * 3600,
+		Comment:  strings.Repeat("a very long comment ", 10),
+		Recurrence: &models.Recurrence{
+			RRule:     "FREQ=WEEKLY;BYDAY=TH",
+			ExDates:   []time.Time{time.Date(2024, 10, 10, 18, 0, 0, 0, time.UTC)},
+			Cancelled: []time.Time{time.Date(2024, 10, 17, 18, 0, 0, 0, time.UTC)},
+		},
+	}
+	occurrence := series
+	occurrence.Date = time.Date(2024, 10, 24, 18, 0, 0, 0, time.UTC)
+	single := models.Event{
+		Title:    "Concert",
+		Location: "Rote Fabrik",
+		Date:     time.Date(2024, 10, 5, 19, 0, 0, 0, time.UTC),
+		Offset:   2 * 3600,
+	}
+
+	var buf bytes.Buffer
+	if err := ical.Write(&buf, []models.Event{series, single, occurrence}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := buf.String()
+
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"TZID:UTC+0200\r\n",
+		"TZOFFSETTO:+0200\r\n",
+		"UID:6717a2d1c5f1a2b3c4d5e6f7@event-api\r\n",
+		"DTSTART;TZID=UTC+0200:20241003T200000\r\n",
+		"RRULE:FREQ=WEEKLY;BYDAY=TH\r\n",
+		"EXDATE;TZID=UTC+0200:20241010T200000\r\n",
+		"RECURRENCE-ID;TZID=UTC+0200:20241017T200000\r\n",
+		"STATUS:CANCELLED\r\n",
+		"SUMMARY:Jam Session\\; open stage\\, bring your instrument\r\n",
+		"DTSTART:20241005T190000Z\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected output to contain %q:\n%s", expected, out)
+		}
+	}
+	// the series and its cancelled occurrence, the single event
+	if n := strings.Count(out, "BEGIN:VEVENT"); n != 3 {
+		t.Errorf("expected 3 events, got %d", n)
+	}
+	for _, line := range strings.Split(out, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line is not folded: %q", line)
+		}
+	}
+}
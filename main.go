@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"log/slog"
 	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -14,24 +20,61 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/utils"
 	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/controllers"
 	_ "github.com/jakopako/event-api/docs"
 	"github.com/jakopako/event-api/genre"
 	"github.com/jakopako/event-api/geo"
 	"github.com/jakopako/event-api/routes"
+	"github.com/jakopako/event-api/storage"
+	"github.com/jakopako/event-api/storage/mongodb"
+	"github.com/jakopako/event-api/storage/sqlite"
+	"github.com/jakopako/event-api/stream"
+	"github.com/jakopako/event-api/webhook"
 	_ "github.com/joho/godotenv/autoload"
 )
 
-func setupRoutes(app *fiber.App) {
-	api := app.Group("/api")
-	routes.EventsRoute(api.Group("/events"))
-	routes.NotificationsRoute(api.Group("/notifications"))
-	routes.StatusRoute(api.Group("/status"))
-	routes.SwaggerRoute(api.Group("/swagger"))
-}
-
 // https://dev.to/mikefmeyer/build-a-go-rest-api-with-fiber-and-mongodb-44og
 // https://dev.to/koddr/build-a-restful-api-on-go-fiber-postgresql-jwt-and-swagger-docs-in-isolated-docker-containers-475j
 func main() {
+	// without a command the server is started
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage()
+		return
+	}
+	i := slices.IndexFunc(commands, func(c command) bool { return c.name == name })
+	if i < 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("Error %v", err)
+	}
+	config.C = cfg
+
+	// debug log to console
+	if config.C.Debug {
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	}
+	slog.Debug("enabled debug logging")
+
+	if err := commands[i].run(args); err != nil {
+		log.Fatalf("Error %v", err)
+	}
+}
+
+// serve runs the api server until it receives SIGINT or SIGTERM.
+func serve(args []string) error {
+	flag.NewFlagSet("serve", flag.ExitOnError).Parse(args)
+	if err := config.C.ValidateServe(); err != nil {
+		return err
+	}
+
 	app := fiber.New()
 
 	app.Use(cors.New())
@@ -39,44 +82,199 @@ func main() {
 		// Format: "[${time}] ${status} ${latency} ${method} ${url} ${ip}\n",
 	}))
 
+	// every request gets its own context that ends with the request. The requests that
+	// are still in progress when the shutdown timeout expires are cancelled.
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	app.Use(func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithCancel(requestsCtx)
+		defer cancel()
+		c.SetUserContext(ctx)
+		return c.Next()
+	})
+
 	app.Use(limiter.New(limiter.Config{
 		Next: func(c *fiber.Ctx) bool {
-			return !strings.HasPrefix(c.Path(), "/api/notifications") && !strings.HasPrefix(c.Path(), "/api/events/validate")
+			return !isLimited(c.Path())
 		},
-		Max:               20,
-		Expiration:        1 * time.Minute,
+		Max:               config.C.Limiter.Max,
+		Expiration:        config.C.Limiter.Expiration,
 		LimiterMiddleware: limiter.SlidingWindow{},
 	}))
 
 	app.Use(cache.New(cache.Config{
 		Next: func(c *fiber.Ctx) bool {
-			return (c.Path() == "/api/events" && (c.Method() == "POST" || c.Method() == "DELETE")) || strings.HasPrefix(c.Path(), "/api/notifications")
+			// authenticated responses must never be served to other clients
+			if c.Get(fiber.HeaderAuthorization) != "" {
+				return true
+			}
+			// redirects are counted as clicks, so they must reach the handler
+			if strings.HasPrefix(c.Path(), "/api/events/id/") && strings.HasSuffix(c.Path(), "/redirect") {
+				return true
+			}
+			return (c.Path() == "/api/events" && (c.Method() == "POST" || c.Method() == "DELETE")) || c.Path() == "/api/events/stream" || strings.HasPrefix(c.Path(), "/api/notifications")
 		},
-		Expiration: 1 * time.Minute,
+		Expiration: config.C.Cache.Expiration,
 		KeyGenerator: func(c *fiber.Ctx) string {
 			return utils.CopyString(c.OriginalURL())
 		},
 	}))
 
-	// debug log to console
-	debug := os.Getenv("DEBUG")
-	if debug == "true" {
-		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})))
-	}
-	slog.Debug("enabled debug logging")
-
 	// initialize DB and geoloc cache
-	config.ConnectDB()
+	closeStorage := setupStorage()
+	if config.C.Storage.MigrateOnStartup {
+		migrate()
+	}
 	geo.InitGeolocCache()
 	genre.InitGenreCache()
+	webhook.InitDispatcher()
+
+	routes.SetupRoutes(app)
+
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	if config.C.Archive.AfterDays > 0 {
+		go archivePeriodically(jobsCtx)
+	}
+	if config.C.Geo.BackfillInterval > 0 {
+		go backfillPeriodically(jobsCtx)
+	}
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listen(":" + config.C.Port)
+	}()
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-listenErr:
+		return fmt.Errorf("app failed to start: %w", err)
+	case <-signalCtx.Done():
+	}
+	// a second signal terminates the api immediately
+	stop()
+
+	slog.Info("shutting down", "timeout", config.C.ShutdownTimeout)
+	stopJobs()
+	ctx, cancel := context.WithTimeout(context.Background(), config.C.ShutdownTimeout)
+	defer cancel()
+	// streams never end on their own
+	stream.H.Close()
+	// stops accepting connections and waits for the requests in progress, e.g. ingest batches
+	if err := app.ShutdownWithContext(ctx); err != nil {
+		slog.Error("failed to finish the requests in progress", "err", err)
+	}
+	cancelRequests()
+	if err := controllers.Drain(ctx); err != nil {
+		slog.Error("failed to finish the background tasks", "err", err)
+	}
+	if err := closeStorage(ctx); err != nil {
+		slog.Error("failed to close the storage", "err", err)
+	}
+	slog.Info("shut down")
+	return nil
+}
+
+// setupStorage connects to the configured storage backend. It returns the function that
+// closes the connection.
+func setupStorage() func(ctx context.Context) error {
+	switch backend := config.C.Storage.Backend; backend {
+	case config.StorageMongoDB:
+		config.ConnectDB()
+		storage.S = mongodb.New(config.MI.DB)
+		return config.DisconnectDB
+	case config.StorageSQLite:
+		path := config.C.Storage.SQLitePath
+		db, err := sqlite.Open(path)
+		if err != nil {
+			log.Fatalf("Error failed to open %s: %v", path, err)
+		}
+		storage.S = sqlite.New(db)
+		return func(context.Context) error { return db.Close() }
+	default:
+		log.Fatalf("Error unknown storage backend %q", backend)
+		return nil
+	}
+}
 
-	setupRoutes(app)
+// migrate applies the pending migrations of MongoDB. The schema of SQLite is created when
+// the database is opened.
+func migrate() {
+	if config.MI.DB == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	applied, err := mongodb.Migrate(ctx, config.MI.DB)
+	if err != nil {
+		log.Fatalf("Error failed to migrate the database: %v", err)
+	}
+	slog.Info("migrated the database", "applied", len(applied))
+}
 
-	port := os.Getenv("PORT")
-	err := app.Listen(":" + port)
+// archivePeriodically moves the events that are older than the configured number of days
+// to the archive, right away and then at the configured interval until ctx is done.
+func archivePeriodically(ctx context.Context) {
+	ticker := time.NewTicker(config.C.Archive.Interval)
+	defer ticker.Stop()
+	for {
+		if err := archivePastEvents(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("failed to archive events", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
 
+// archivePastEvents moves the events that are older than the configured number of days
+// to the archive. Recurring events are archived after their last occurrence.
+func archivePastEvents(ctx context.Context) error {
+	before := time.Now().UTC().AddDate(0, 0, -config.C.Archive.AfterDays)
+	moved, err := storage.S.Archive.MoveBefore(ctx, before)
 	if err != nil {
-		log.Fatalf("Error app failed to start: %v", err)
-		panic(err)
+		return err
 	}
+	slog.Info("archived past events", "before", before, "moved", moved)
+	return nil
+}
+
+// backfillPeriodically looks up the venues of the upcoming events that only have the
+// coordinates of their city again at the configured interval until ctx is done.
+func backfillPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(config.C.Geo.BackfillInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fixed, err := controllers.RegeocodeEvents(ctx, "", today(), true)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("failed to backfill venue locations", "err", err)
+			continue
+		}
+		slog.Info("backfilled venue locations", "fixed", fixed)
+	}
+}
+
+// limitedPaths are the prefixes of the paths that are rate limited, the endpoints that send
+// emails or check passwords.
+var limitedPaths = []string{
+	"/api/notifications",
+	"/api/events/validate",
+	"/api/users/register",
+	"/api/users/login",
+	"/api/users/magiclink",
+}
+
+func isLimited(path string) bool {
+	return slices.ContainsFunc(limitedPaths, func(prefix string) bool { return strings.HasPrefix(path, prefix) })
 }
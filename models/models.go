@@ -2,25 +2,85 @@ package models
 
 import (
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Event struct {
-	Title           string    `bson:"title,omitempty" json:"title,omitempty" validate:"required" example:"ExcitingTitle"`
-	NormalizedTitle string    `bson:"normalizedTitle,omitempty" json:"-"`
-	Location        string    `bson:"location,omitempty" json:"location,omitempty" validate:"required" example:"SuperLocation"`
-	City            string    `bson:"city,omitempty" json:"city,omitempty" validate:"required" example:"SuperCity"`
-	State           string    `bson:"state,omitempty" json:"state,omitempty" example:"SuperState"`
-	Country         string    `bson:"country,omitempty" json:"country,omitempty" example:"SuperCountry"`
-	Date            time.Time `bson:"date,omitempty" json:"date,omitempty" validate:"required" example:"2021-10-31T19:00:00.000Z"`
-	Offset          int       `bson:"offset,omitempty" json:"offset,omitempty"`
-	URL             string    `bson:"url,omitempty" json:"url,omitempty" validate:"required,url" example:"http://link.to/concert/page"`
-	ImageURL        string    `bson:"imageUrl,omitempty" json:"imageUrl,omitempty" validate:"omitempty,url" example:"http://link.to/concert/image.jpg"`
-	Comment         string    `bson:"comment,omitempty" json:"comment,omitempty" example:"Super exciting comment."`
-	Type            string    `bson:"type,omitempty" json:"type,omitempty" validate:"required" example:"concert"`
-	SourceURL       string    `bson:"sourceUrl,omitempty" json:"sourceUrl,omitempty" validate:"required,url" example:"http://link.to/source"`
-	Genres          []string  `bson:"genres" json:"genres" example:"german trap"`
-	GenresText      string    `bson:"-" json:"genresText,omitempty" example:"begleitet von diversen Berner Hip-Hop Acts. Von Trap und Phonk bis zu Afrobeats - Free Quenzy's Produktionen bieten eine breite Palette an Sounds."`
-	Address         Address   `bson:"address,omitempty" json:"address"`
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id,omitzero"`
+	Title           string             `bson:"title,omitempty" json:"title,omitempty" validate:"required" example:"ExcitingTitle"`
+	NormalizedTitle string             `bson:"normalizedTitle,omitempty" json:"-"`
+	Location        string             `bson:"location,omitempty" json:"location,omitempty" validate:"required" example:"SuperLocation"`
+	City            string             `bson:"city,omitempty" json:"city,omitempty" validate:"required" example:"SuperCity"`
+	State           string             `bson:"state,omitempty" json:"state,omitempty" example:"SuperState"`
+	Country         string             `bson:"country,omitempty" json:"country,omitempty" example:"SuperCountry"`
+	Date            time.Time          `bson:"date,omitempty" json:"date,omitempty" validate:"required" example:"2021-10-31T19:00:00.000Z"`
+	Offset          int                `bson:"offset,omitempty" json:"offset,omitempty"`
+	URL             string             `bson:"url,omitempty" json:"url,omitempty" validate:"required,url" example:"http://link.to/concert/page"`
+	ImageURL        string             `bson:"imageUrl,omitempty" json:"imageUrl,omitempty" validate:"omitempty,url" example:"http://link.to/concert/image.jpg"`
+	Comment         string             `bson:"comment,omitempty" json:"comment,omitempty" example:"Super exciting comment."`
+	Type            string             `bson:"type,omitempty" json:"type,omitempty" validate:"required" example:"concert"`
+	SourceURL       string             `bson:"sourceUrl,omitempty" json:"sourceUrl,omitempty" validate:"required,url" example:"http://link.to/source"`
+	Genres          []string           `bson:"genres" json:"genres" example:"german trap"`
+	GenresText      string             `bson:"-" json:"genresText,omitempty" example:"begleitet von diversen Berner Hip-Hop Acts. Von Trap und Phonk bis zu Afrobeats - Free Quenzy's Produktionen bieten eine breite Palette an Sounds."`
+	Address         Address            `bson:"address,omitempty" json:"address"`
+	SourceKey       *EventKey          `bson:"sourceKey,omitempty" json:"-"`
+	Overrides       *EventOverrides    `bson:"overrides,omitempty" json:"overrides,omitempty"`
+	Recurrence      *Recurrence        `bson:"recurrence,omitempty" json:"recurrence,omitempty"`
+	Popularity      *Popularity        `bson:"popularity,omitempty" json:"popularity,omitempty"`
+	// ModerationStatus is empty for events that have not been moderated
+	ModerationStatus string `bson:"moderationStatus,omitempty" json:"moderationStatus,omitempty"`
+	// CityFallback is set if the venue couldn't be found and the address only has the
+	// coordinates of the city
+	CityFallback bool `bson:"cityFallback,omitempty" json:"-"`
+}
+
+// EventKey identifies an event the way a scraper sends it. It is stored
+// alongside the event so that upserts still find the event after its
+// title or location has been overridden manually.
+type EventKey struct {
+	Title     string    `bson:"title"`
+	Date      time.Time `bson:"date"`
+	Location  string    `bson:"location"`
+	URL       string    `bson:"url"`
+	SourceURL string    `bson:"sourceUrl"`
+}
+
+// EventOverrides contains manual corrections of an event. Fields that are
+// nil are left as they are scraped.
+type EventOverrides struct {
+	Title     *string   `bson:"title,omitempty" json:"title,omitempty" example:"CorrectedTitle"`
+	Location  *string   `bson:"location,omitempty" json:"location,omitempty" example:"CorrectedLocation"`
+	City      *string   `bson:"city,omitempty" json:"city,omitempty" example:"CorrectedCity"`
+	State     *string   `bson:"state,omitempty" json:"state,omitempty"`
+	Country   *string   `bson:"country,omitempty" json:"country,omitempty"`
+	Type      *string   `bson:"type,omitempty" json:"type,omitempty" example:"concert"`
+	ImageURL  *string   `bson:"imageUrl,omitempty" json:"imageUrl,omitempty"`
+	Comment   *string   `bson:"comment,omitempty" json:"comment,omitempty"`
+	Genres    *[]string `bson:"genres,omitempty" json:"genres,omitempty" example:"jazz"`
+	Address   *Address  `bson:"address,omitempty" json:"address,omitempty"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Recurrence describes how an event repeats, see RFC 5545. The date of the event is
+// the start of the series. Cancelled occurrences are managed through the api and are
+// kept when the event is upserted again.
+type Recurrence struct {
+	RRule     string      `bson:"rrule" json:"rrule" example:"FREQ=WEEKLY;BYDAY=TH"`
+	ExDates   []time.Time `bson:"exDates,omitempty" json:"exDates,omitempty"`
+	Cancelled []time.Time `bson:"cancelled,omitempty" json:"cancelled,omitempty"`
+	// LastDate is the date of the last occurrence, nil if the series is infinite
+	LastDate *time.Time `bson:"lastDate,omitempty" json:"-"`
+}
+
+// Popularity counts the interactions of users with an event. It is only updated through
+// the api and is kept when the event is upserted again.
+type Popularity struct {
+	Views           int64     `bson:"views" json:"views"`
+	Clicks          int64     `bson:"clicks" json:"clicks"`
+	LastInteraction time.Time `bson:"lastInteraction" json:"lastInteraction"`
+	// Trend is the time-decayed score of the interactions, see package popularity
+	Trend float64 `bson:"trend" json:"-"`
 }
 
 type TitleGenre struct {
@@ -92,24 +152,168 @@ type Notification struct {
 	Active    bool      `bson:"active" json:"active"`
 }
 
+// User is a local user account. Users either log in with their password or with
+// magic links, in which case they have no password.
+type User struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitzero"`
+	Email        string             `bson:"email" json:"email" example:"someone@example.com"`
+	PasswordHash []byte             `bson:"passwordHash,omitempty" json:"-"`
+	// EmailVerified is set once the user has logged in with a magic link
+	EmailVerified   bool                 `bson:"emailVerified,omitempty" json:"emailVerified"`
+	FavouriteEvents []primitive.ObjectID `bson:"favouriteEvents" json:"favouriteEvents"`
+	FollowedArtists []string             `bson:"followedArtists" json:"followedArtists" example:"Bonobo"`
+	FollowedVenues  []string             `bson:"followedVenues" json:"followedVenues" example:"Moods"`
+	FollowedCities  []string             `bson:"followedCities" json:"followedCities" example:"Zurich"`
+	CreatedAt       time.Time            `bson:"createdAt" json:"createdAt"`
+}
+
+// Session is a login of a user. Only the hash of its token is stored.
+type Session struct {
+	TokenHash string             `bson:"tokenHash"`
+	UserID    primitive.ObjectID `bson:"userId"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
+
+// MagicLink is a link to log in that has been sent to an email address. Only the hash of
+// its token is stored.
+type MagicLink struct {
+	TokenHash string    `bson:"tokenHash"`
+	Email     string    `bson:"email"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// Credentials are used to register and to log in with a password.
+type Credentials struct {
+	Email    string `json:"email" validate:"required,email" example:"someone@example.com"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email" example:"someone@example.com"`
+}
+
+type MagicLinkLogin struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// Query describes a search for events. If Subgenres is set, the included and excluded
+// genres also match the genres that contain them, e.g. techno matches hard techno. Filter
+// is an expression that events have to match in addition, see package search.
 type Query struct {
-	Title     string     `bson:"title" json:"title"`
-	City      string     `bson:"city" json:"city"`
-	Country   string     `bson:"country" json:"country"`
-	Location  string     `bson:"location" json:"location"`
-	Type      string     `bson:"type" json:"type"`
-	Genres    []string   `bson:"genres" json:"genres"`
-	StartDate *time.Time `bson:"startDate" json:"startDate"`
-	EndDate   *time.Time `bson:"endDate" json:"endDate"`
-	Radius    int        `bson:"radius" json:"radius"`
-	Page      int        `bson:"page" json:"-"`
-	Limit     int64      `bson:"limit" json:"-"`
+	Title         string     `bson:"title" json:"title"`
+	City          string     `bson:"city" json:"city"`
+	Country       string     `bson:"country" json:"country"`
+	Location      string     `bson:"location" json:"location"`
+	Type          string     `bson:"type" json:"type"`
+	Genres        []string   `bson:"genres" json:"genres"`
+	GenreMode     string     `bson:"genreMode,omitempty" json:"genreMode,omitempty"`
+	ExcludeGenres []string   `bson:"excludeGenres,omitempty" json:"excludeGenres,omitempty"`
+	ExcludeTypes  []string   `bson:"excludeTypes,omitempty" json:"excludeTypes,omitempty"`
+	Subgenres     bool       `bson:"subgenres,omitempty" json:"subgenres,omitempty"`
+	Filter        string     `bson:"filter,omitempty" json:"filter,omitempty"`
+	IncludePast   bool       `bson:"includePast,omitempty" json:"includePast,omitempty"`
+	StartDate     *time.Time `bson:"startDate" json:"startDate"`
+	EndDate       *time.Time `bson:"endDate" json:"endDate"`
+	Radius        int        `bson:"radius" json:"radius"`
+	Sort          string     `bson:"sort,omitempty" json:"-"`
+	Page          int        `bson:"page" json:"-"`
+	Limit         int64      `bson:"limit" json:"-"`
+}
+
+// The orders in which events can be sorted. Events are sorted by date if
+// the sort of the query is empty.
+const (
+	SortDate     = "date"
+	SortTrending = "trending"
+)
+
+// The modes in which the genres of a query are matched. Events have to match any
+// of the genres if the genre mode of the query is empty.
+const (
+	GenreModeAny = "any"
+	GenreModeAll = "all"
+)
+
+// ModerationRule either rejects or holds back events whose field matches the
+// (case-insensitive) regex pattern.
+type ModerationRule struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitzero"`
+	Field     string             `bson:"field" json:"field" example:"sourceUrl"`
+	Pattern   string             `bson:"pattern" json:"pattern" example:"private\\.example\\.com"`
+	Action    string             `bson:"action" json:"action" example:"hold"`
+	Comment   string             `bson:"comment,omitempty" json:"comment,omitempty" example:"private events"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// EventChange describes a change of an event that is pushed to webhooks.
+type EventChange struct {
+	Change       string     `json:"change" example:"created"`
+	Event        Event      `json:"event"`
+	PreviousDate *time.Time `json:"previousDate,omitempty"`
+}
+
+// Webhook is a URL that receives signed POST requests for changes of events that match its query.
+type Webhook struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitzero"`
+	URL       string             `bson:"url" json:"url" validate:"required,url" example:"https://partner.example.com/hooks/events"`
+	Secret    string             `bson:"secret" json:"secret,omitempty"`
+	Query     Query              `bson:"query" json:"query"`
+	Changes   []string           `bson:"changes" json:"changes" example:"created"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	// Owner is the user that registered the webhook, it is empty for webhooks of the admin
+	Owner primitive.ObjectID `bson:"owner,omitempty" json:"-"`
+}
+
+type WebhookPayload struct {
+	DeliveryID string `json:"deliveryId"`
+	EventChange
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WebhookDelivery logs a single attempt to deliver a payload to a webhook.
+type WebhookDelivery struct {
+	DeliveryID string             `bson:"deliveryId" json:"deliveryId"`
+	WebhookID  primitive.ObjectID `bson:"webhookId" json:"webhookId"`
+	Change     string             `bson:"change" json:"change"`
+	EventID    primitive.ObjectID `bson:"eventId,omitempty" json:"eventId,omitzero"`
+	Attempt    int                `bson:"attempt" json:"attempt"`
+	StatusCode int                `bson:"statusCode,omitempty" json:"statusCode,omitempty"`
+	Error      string             `bson:"error,omitempty" json:"error,omitempty"`
+	Success    bool               `bson:"success" json:"success"`
+	Timestamp  time.Time          `bson:"timestamp" json:"timestamp"`
 }
 
 type SlackRequest struct {
 	Text string `json:"text" form:"text"`
 }
 
+// SlackResponse is the response to a slash command. It either contains a plain
+// text or blocks, see https://api.slack.com/interactivity/slash-commands.
+type SlackResponse struct {
+	ResponseType string       `json:"response_type" example:"ephemeral"`
+	Text         string       `json:"text,omitempty"`
+	Blocks       []SlackBlock `json:"blocks,omitempty"`
+}
+
+type SlackBlock struct {
+	Type string    `json:"type" example:"section"`
+	Text SlackText `json:"text"`
+}
+
+type SlackText struct {
+	Type string `json:"type" example:"mrkdwn"`
+	Text string `json:"text"`
+}
+
+// Suggestion is an artist, venue, city or genre of upcoming events that matches what a user is
+// typing. Count is the number of upcoming events with this value.
+type Suggestion struct {
+	Type  string `bson:"type" json:"type" example:"venue"`
+	Value string `bson:"value" json:"value" example:"Moods"`
+	City  string `bson:"city,omitempty" json:"city,omitempty" example:"Zurich"`
+	Count int64  `bson:"count" json:"count" example:"12"`
+}
+
 type ScraperStatus struct {
 	ScraperName     string    `bson:"scraperName" json:"scraperName" validate:"required" example:"Helsinki"`
 	NrItems         *int      `bson:"nrItems" json:"nrItems" validate:"required,gte=0" example:"100"`
@@ -129,6 +333,21 @@ type GetEventsResponseSuccess struct {
 	Limit    int64   `json:"limit"`
 }
 
+type GetEventResponse struct {
+	Success bool  `json:"success"`
+	Data    Event `json:"data"`
+}
+
+type GetSimilarEventsResponse struct {
+	Success bool    `json:"success"`
+	Data    []Event `json:"data"`
+}
+
+type GetAutocompleteResponse struct {
+	Success bool         `json:"success"`
+	Data    []Suggestion `json:"data"`
+}
+
 type GenericResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
@@ -148,9 +367,73 @@ type ValidateEventError struct {
 	Error   string `json:"error"`
 }
 
+// DistinctValue is a value of a field of events and the number of events with this value.
+type DistinctValue struct {
+	Value string `bson:"value" json:"value" example:"Zurich"`
+	Count int64  `bson:"count" json:"count" example:"42"`
+}
+
+// Statistics summarizes the events matching a query. The calendar contains the number of events
+// per day or week, the history the number of events per past month.
+type Statistics struct {
+	Total    int64           `json:"total"`
+	Calendar []DistinctValue `json:"calendar"`
+	Cities   []DistinctValue `json:"cities"`
+	Genres   []DistinctValue `json:"genres"`
+	Sources  []DistinctValue `json:"sources"`
+	History  []DistinctValue `json:"history"`
+}
+
+type GetStatisticsResponse struct {
+	Success bool       `json:"success"`
+	Data    Statistics `json:"data"`
+}
+
 type GetDistinctFieldResponse struct {
-	Success bool     `json:"success"`
-	Data    []string `json:"data"`
+	Success bool            `json:"success"`
+	Data    []DistinctValue `json:"data"`
+}
+
+// Moderation response models
+
+type GetModerationRulesResponse struct {
+	Success bool             `json:"success"`
+	Data    []ModerationRule `json:"data"`
+}
+
+type AddModerationRuleResponse struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message"`
+	Data    ModerationRule `json:"data"`
+}
+
+// GraphQL request models
+
+type GraphqlRequest struct {
+	Query         string         `json:"query" example:"{ events(city: \"Zurich\") { total data { title date } } }"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// Webhook response models
+
+type GetWebhooksResponse struct {
+	Success bool      `json:"success"`
+	Data    []Webhook `json:"data"`
+}
+
+type AddWebhookResponse struct {
+	Success bool    `json:"success"`
+	Message string  `json:"message"`
+	Data    Webhook `json:"data"`
+}
+
+type GetWebhookDeliveriesResponse struct {
+	Data     []WebhookDelivery `json:"data"`
+	Total    int64             `json:"total"`
+	Page     int               `json:"page"`
+	LastPage int64             `json:"lastPage"`
+	Limit    int64             `json:"limit"`
 }
 
 // Notification response models
@@ -161,6 +444,20 @@ type ActivateNotificationResponse struct {
 	Message string       `json:"message"`
 }
 
+// User response models
+
+type LoginResponse struct {
+	Success   bool      `json:"success"`
+	Message   string    `json:"message"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type GetUserResponse struct {
+	Success bool `json:"success"`
+	Data    User `json:"data"`
+}
+
 // Scraper status response models
 
 type GetScraperStatusResponse struct {
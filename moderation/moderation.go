@@ -0,0 +1,114 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+)
+
+const (
+	RuleCollectionName = "moderationRules"
+
+	// ActionReject rejects matching events during validation.
+	ActionReject = "reject"
+	// ActionHold stores matching events in a pending state until an admin approves them.
+	ActionHold = "hold"
+
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+var ruleFields = []string{"sourceUrl", "title", "location", "type"}
+
+// HiddenStatuses contains the moderation statuses of events that must not be publicly visible.
+var HiddenStatuses = []string{StatusPending, StatusRejected}
+
+type compiledRule struct {
+	rule  models.ModerationRule
+	regex *regexp.Regexp
+}
+
+// RuleSet is a set of moderation rules that can be evaluated against events.
+type RuleSet struct {
+	rules []compiledRule
+}
+
+// ValidateRule checks whether the given rule can be used for moderation.
+func ValidateRule(r models.ModerationRule) error {
+	if !slices.Contains(ruleFields, r.Field) {
+		return fmt.Errorf("field has to be one of %v", ruleFields)
+	}
+	if r.Action != ActionReject && r.Action != ActionHold {
+		return fmt.Errorf("action has to be '%s' or '%s'", ActionReject, ActionHold)
+	}
+	if r.Pattern == "" {
+		return fmt.Errorf("pattern must not be empty")
+	}
+	if _, err := regexp.Compile(r.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	return nil
+}
+
+// NewRuleSet compiles the given rules. Patterns are matched case-insensitively.
+func NewRuleSet(rules []models.ModerationRule) (*RuleSet, error) {
+	rs := &RuleSet{}
+	for _, r := range rules {
+		if err := ValidateRule(r); err != nil {
+			return nil, fmt.Errorf("invalid moderation rule %s: %w", r.ID.Hex(), err)
+		}
+		rs.rules = append(rs.rules, compiledRule{rule: r, regex: regexp.MustCompile("(?i)" + r.Pattern)})
+	}
+	return rs, nil
+}
+
+// LoadRules fetches all moderation rules from the store. It fails if no store has been
+// set up, so that events are never published without having been moderated.
+func LoadRules(ctx context.Context) (*RuleSet, error) {
+	if storage.S.Rules == nil {
+		return nil, errors.New("no store for moderation rules has been set up")
+	}
+	rules, err := storage.S.Rules.Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewRuleSet(rules)
+}
+
+// Evaluate returns the rule that applies to the given event or nil if no rule matches.
+// Rejecting rules take precedence over holding rules.
+func (rs *RuleSet) Evaluate(e models.Event) *models.ModerationRule {
+	var hold *models.ModerationRule
+	for i, cr := range rs.rules {
+		if !cr.regex.MatchString(fieldValue(e, cr.rule.Field)) {
+			continue
+		}
+		if cr.rule.Action == ActionReject {
+			return &rs.rules[i].rule
+		}
+		if hold == nil {
+			hold = &rs.rules[i].rule
+		}
+	}
+	return hold
+}
+
+func fieldValue(e models.Event, field string) string {
+	switch field {
+	case "sourceUrl":
+		return e.SourceURL
+	case "title":
+		return e.Title
+	case "location":
+		return e.Location
+	case "type":
+		return e.Type
+	}
+	return ""
+}
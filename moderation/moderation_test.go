@@ -0,0 +1,88 @@
+package moderation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestEvaluate(t *testing.T) {
+	rs, err := NewRuleSet([]models.ModerationRule{
+		{Field: "title", Pattern: `^test\b`, Action: ActionReject},
+		{Field: "sourceUrl", Pattern: `private\.example\.com`, Action: ActionHold},
+		{Field: "type", Pattern: `^party$`, Action: ActionHold},
+		{Field: "location", Pattern: `secret bar`, Action: ActionReject},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		event    models.Event
+		expected string
+	}{
+		{"no match", models.Event{Title: "Jazz Night", SourceURL: "https://example.com", Type: "concert"}, ""},
+		{"reject title case-insensitive", models.Event{Title: "TEST event", SourceURL: "https://example.com"}, ActionReject},
+		{"hold source", models.Event{Title: "Jazz Night", SourceURL: "https://private.example.com/events"}, ActionHold},
+		{"reject wins over hold", models.Event{Title: "Jazz Night", Location: "The Secret Bar", Type: "party"}, ActionReject},
+		{"hold type", models.Event{Title: "Jazz Night", Type: "party"}, ActionHold},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action := ""
+			if r := rs.Evaluate(tt.event); r != nil {
+				action = r.Action
+			}
+			if action != tt.expected {
+				t.Errorf("expected action %q, got %q", tt.expected, action)
+			}
+		})
+	}
+}
+
+func TestValidateRule(t *testing.T) {
+	invalid := []models.ModerationRule{
+		{Field: "city", Pattern: "x", Action: ActionReject},
+		{Field: "title", Pattern: "x", Action: "delete"},
+		{Field: "title", Pattern: "", Action: ActionHold},
+		{Field: "title", Pattern: "(", Action: ActionHold},
+	}
+	for _, r := range invalid {
+		if err := ValidateRule(r); err == nil {
+			t.Errorf("expected rule %+v to be invalid", r)
+		}
+	}
+}
+
+// ruleStore is a storage.RuleRepository for the tests. The storage backends can't be
+// imported here since they depend on this package.
+type ruleStore []models.ModerationRule
+
+func (r ruleStore) Find(ctx context.Context) ([]models.ModerationRule, error) { return r, nil }
+
+func (r ruleStore) Insert(ctx context.Context, rule models.ModerationRule) error { return nil }
+
+func (r ruleStore) Delete(ctx context.Context, id primitive.ObjectID) error { return nil }
+
+func TestLoadRules(t *testing.T) {
+	ctx := context.Background()
+	storage.S = storage.Store{}
+	if _, err := LoadRules(ctx); err == nil {
+		t.Error("expected an error without a store for the rules")
+	}
+
+	rule := models.ModerationRule{ID: primitive.NewObjectID(), Field: "title", Pattern: "^test", Action: ActionReject}
+	storage.S.Rules = ruleStore{rule}
+	rs, err := LoadRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := rs.Evaluate(models.Event{Title: "Test Event"}); r == nil || r.ID != rule.ID {
+		t.Errorf("got rule %v, want %v", r, rule)
+	}
+}
@@ -0,0 +1,9 @@
+// Package openapi contains the OpenAPI 3.1 document of the api. Unlike the swagger
+// document in the docs package it is maintained by hand and checked against the
+// handlers in the tests of this package.
+package openapi
+
+import _ "embed"
+
+//go:embed openapi.yaml
+var Spec []byte
@@ -0,0 +1,443 @@
+package openapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/openapi"
+	"github.com/jakopako/event-api/routes"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"gopkg.in/yaml.v3"
+)
+
+const specURL = "openapi.json"
+
+var methods = []string{"get", "post", "put", "delete", "patch"}
+
+// loadSpec returns the spec converted to plain JSON values and a compiler that
+// knows the spec as a resource.
+func loadSpec(t *testing.T) (map[string]any, *jsonschema.Compiler) {
+	t.Helper()
+	var raw any
+	if err := yaml.Unmarshal(openapi.Spec, &raw); err != nil {
+		t.Fatalf("failed to parse spec: %v", err)
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		t.Fatalf("failed to convert spec to json: %v", err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("failed to convert spec to json: %v", err)
+	}
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+	if err := c.AddResource(specURL, doc); err != nil {
+		t.Fatalf("failed to add spec: %v", err)
+	}
+	return doc.(map[string]any), c
+}
+
+// resolve follows a local $ref like '#/components/responses/GenericError'.
+func resolve(doc map[string]any, obj map[string]any) (map[string]any, string) {
+	ref, ok := obj["$ref"].(string)
+	if !ok {
+		return obj, ""
+	}
+	var cur any = doc
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, ref
+		}
+		cur = m[part]
+	}
+	m, _ := cur.(map[string]any)
+	return m, ref
+}
+
+func pointerEscape(s string) string {
+	return url.PathEscape(strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1"))
+}
+
+func TestSpecIsValid(t *testing.T) {
+	doc, c := loadSpec(t)
+	if v, _ := doc["openapi"].(string); !strings.HasPrefix(v, "3.1.") {
+		t.Fatalf("expected openapi version 3.1.x, got %q", v)
+	}
+
+	// every $ref has to point to an existing object
+	var walk func(v any, at string)
+	walk = func(v any, at string) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				if target, _ := resolve(doc, v); target == nil {
+					t.Errorf("%s: unresolvable $ref %s", at, ref)
+				}
+			}
+			for k, e := range v {
+				walk(e, at+"/"+k)
+			}
+		case []any:
+			for i, e := range v {
+				walk(e, at+"/"+strconv.Itoa(i))
+			}
+		}
+	}
+	walk(doc, "#")
+
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	for name := range schemas {
+		if _, err := c.Compile(specURL + "#/components/schemas/" + name); err != nil {
+			t.Errorf("invalid schema %s: %v", name, err)
+		}
+	}
+
+	operationIDs := map[string]bool{}
+	for path, item := range doc["paths"].(map[string]any) {
+		for _, method := range methods {
+			op, ok := item.(map[string]any)[method].(map[string]any)
+			if !ok {
+				continue
+			}
+			id, _ := op["operationId"].(string)
+			if id == "" || operationIDs[id] {
+				t.Errorf("%s %s: operationId %q is missing or not unique", method, path, id)
+			}
+			operationIDs[id] = true
+			if responses, _ := op["responses"].(map[string]any); len(responses) == 0 {
+				t.Errorf("%s %s: no responses", method, path)
+			}
+		}
+	}
+}
+
+func newApp(t *testing.T) *fiber.App {
+	setConfig(t, func(c *config.Config) {
+		c.API = config.APIConfig{User: "user", Password: "password"}
+	})
+	app := fiber.New()
+	routes.SetupRoutes(app)
+	return app
+}
+
+// setConfig changes the configuration for the duration of the test.
+func setConfig(t *testing.T, change func(c *config.Config)) {
+	previous := *config.C
+	t.Cleanup(func() { *config.C = previous })
+	change(config.C)
+}
+
+// specPath converts a fiber path like /api/events/:field to /api/events/{field}.
+func specPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") {
+			parts[i] = "{" + strings.TrimPrefix(p, ":") + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func TestRoutesAreDocumented(t *testing.T) {
+	doc, _ := loadSpec(t)
+	app := newApp(t)
+
+	registered := map[string]bool{}
+	for _, r := range app.GetRoutes(true) {
+		if r.Method == fiber.MethodHead || strings.HasPrefix(r.Path, "/api/swagger") {
+			continue
+		}
+		registered[strings.ToLower(r.Method)+" "+specPath(strings.TrimSuffix(r.Path, "/"))] = true
+	}
+	documented := map[string]bool{}
+	for path, item := range doc["paths"].(map[string]any) {
+		for _, method := range methods {
+			if _, ok := item.(map[string]any)[method]; ok {
+				documented[method+" "+path] = true
+			}
+		}
+	}
+
+	for op := range registered {
+		if !documented[op] {
+			t.Errorf("route %s is not documented", op)
+		}
+	}
+	for op := range documented {
+		if !registered[op] {
+			t.Errorf("documented operation %s does not exist", op)
+		}
+	}
+}
+
+// jsonFields returns the json names of all fields that are marshalled for the given type.
+func jsonFields(typ reflect.Type) []string {
+	var fields []string
+	for i := range typ.NumField() {
+		f := typ.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, jsonFields(f.Type)...)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestSchemasMatchModels(t *testing.T) {
+	doc, _ := loadSpec(t)
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+
+	types := []any{
+		models.Event{},
+		models.EventOverrides{},
+		models.Recurrence{},
+		models.Popularity{},
+		models.Address{},
+		models.GeocodedLocation{},
+		models.Query{},
+		models.Notification{},
+		models.ScraperStatus{},
+		models.ModerationRule{},
+		models.Webhook{},
+		models.WebhookDelivery{},
+		models.EventChange{},
+		models.WebhookPayload{},
+		models.SlackRequest{},
+		models.SlackResponse{},
+		models.SlackBlock{},
+		models.SlackText{},
+		models.GraphqlRequest{},
+		models.GenericResponse{},
+		models.ValidateEventError{},
+		models.ValidateAndAddEventsResponse{},
+		models.GetEventsResponseSuccess{},
+		models.GetEventResponse{},
+		models.GetSimilarEventsResponse{},
+		models.Suggestion{},
+		models.GetAutocompleteResponse{},
+		models.User{},
+		models.Credentials{},
+		models.MagicLinkRequest{},
+		models.MagicLinkLogin{},
+		models.LoginResponse{},
+		models.GetUserResponse{},
+		models.DistinctValue{},
+		models.GetDistinctFieldResponse{},
+		models.Statistics{},
+		models.GetStatisticsResponse{},
+		models.ActivateNotificationResponse{},
+		models.GetScraperStatusResponse{},
+		models.UpsertScraperStatusResponse{},
+		models.GetModerationRulesResponse{},
+		models.AddModerationRuleResponse{},
+		models.GetWebhooksResponse{},
+		models.AddWebhookResponse{},
+		models.GetWebhookDeliveriesResponse{},
+	}
+	for _, v := range types {
+		typ := reflect.TypeOf(v)
+		schema, ok := schemas[typ.Name()].(map[string]any)
+		if !ok {
+			t.Errorf("no schema for models.%s", typ.Name())
+			continue
+		}
+		var props []string
+		for p := range schema["properties"].(map[string]any) {
+			props = append(props, p)
+		}
+		sort.Strings(props)
+		if fields := jsonFields(typ); !slices.Equal(fields, props) {
+			t.Errorf("schema %s has properties %v but the model has fields %v", typ.Name(), props, fields)
+		}
+	}
+}
+
+// checkResponse validates the response against the documented response of the operation.
+func checkResponse(t *testing.T, doc map[string]any, c *jsonschema.Compiler, method, path string, resp *http.Response) {
+	t.Helper()
+	item, ok := doc["paths"].(map[string]any)[path].(map[string]any)
+	if !ok {
+		t.Fatalf("path %s is not documented", path)
+	}
+	op, ok := item[strings.ToLower(method)].(map[string]any)
+	if !ok {
+		t.Fatalf("operation %s %s is not documented", method, path)
+	}
+	status := strconv.Itoa(resp.StatusCode)
+	response, ok := op["responses"].(map[string]any)[status].(map[string]any)
+	if !ok {
+		t.Fatalf("%s %s: status %s is not documented", method, path, status)
+	}
+	pointer := "#/paths/" + pointerEscape(path) + "/" + strings.ToLower(method) + "/responses/" + status
+	if resolved, ref := resolve(doc, response); ref != "" {
+		response, pointer = resolved, ref
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get(fiber.HeaderContentType))
+	content, _ := response["content"].(map[string]any)
+	if _, ok := content[mediaType]; !ok {
+		t.Fatalf("%s %s: content type %s of status %s is not documented", method, path, mediaType, status)
+	}
+	sch, err := c.Compile(specURL + pointer + "/content/" + pointerEscape(mediaType) + "/schema")
+	if err != nil {
+		t.Fatalf("%s %s: failed to compile schema: %v", method, path, err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	var inst any = string(body)
+	if mediaType == fiber.MIMEApplicationJSON {
+		if inst, err = jsonschema.UnmarshalJSON(bytes.NewReader(body)); err != nil {
+			t.Fatalf("%s %s: invalid json: %v", method, path, err)
+		}
+	}
+	if err := sch.Validate(inst); err != nil {
+		t.Errorf("%s %s: response %s does not match the spec: %v", method, path, body, err)
+	}
+}
+
+func TestUnauthorizedResponses(t *testing.T) {
+	doc, c := loadSpec(t)
+	app := newApp(t)
+
+	for path, item := range doc["paths"].(map[string]any) {
+		for _, method := range methods {
+			op, ok := item.(map[string]any)[method].(map[string]any)
+			if !ok {
+				continue
+			}
+			_, protected := op["security"]
+			// logins respond with 401 to wrong credentials without being protected themselves
+			unauthorized, _ := op["responses"].(map[string]any)["401"].(map[string]any)
+			documented := unauthorized["$ref"] == "#/components/responses/Unauthorized" || unauthorized["$ref"] == "#/components/responses/UnauthorizedUser"
+			if protected != documented {
+				t.Errorf("%s %s: security and 401 response have to be documented together", method, path)
+			}
+			if !protected {
+				continue
+			}
+			// path parameters don't matter, the auth middleware runs first
+			req := httptest.NewRequest(strings.ToUpper(method), strings.NewReplacer("{", "", "}", "").Replace(path), nil)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("%s %s: %v", method, path, err)
+			}
+			if resp.StatusCode != fiber.StatusUnauthorized {
+				t.Errorf("%s %s: expected status 401 without credentials, got %d", method, path, resp.StatusCode)
+				continue
+			}
+			checkResponse(t, doc, c, method, path, resp)
+		}
+	}
+}
+
+// TestHandlerResponses checks the responses of requests that are answered without
+// accessing the database.
+func TestHandlerResponses(t *testing.T) {
+	doc, c := loadSpec(t)
+	setConfig(t, func(c *config.Config) {
+		c.Notifications.ActivationURL = ""
+	})
+	app := newApp(t)
+
+	tests := []struct {
+		method      string
+		target      string
+		path        string
+		contentType string
+		body        string
+		auth        bool
+		status      int
+	}{
+		{method: "GET", target: "/api/events?date=tomorrow", path: "/api/events", status: 400},
+		{method: "GET", target: "/api/events?page=0", path: "/api/events", status: 400},
+		{method: "POST", target: "/api/events/validate", path: "/api/events/validate", contentType: fiber.MIMEApplicationJSON, body: "{", status: 400},
+		{method: "GET", target: "/api/events/stream?radius=-1", path: "/api/events/stream", status: 400},
+		{method: "GET", target: "/api/events/overrides?limit=0", path: "/api/events/overrides", auth: true, status: 400},
+		{method: "PUT", target: "/api/events/id/nonsense/overrides", path: "/api/events/id/{id}/overrides", contentType: fiber.MIMEApplicationJSON, body: "{}", auth: true, status: 400},
+		{method: "DELETE", target: "/api/events/id/nonsense/overrides", path: "/api/events/id/{id}/overrides", auth: true, status: 400},
+		{method: "GET", target: "/api/events/ics?date=tomorrow", path: "/api/events/ics", status: 400},
+		{method: "GET", target: "/api/events/id/nonsense/similar", path: "/api/events/id/{id}/similar", status: 400},
+		{method: "GET", target: "/api/events/id/6717a2d1c5f1a2b3c4d5e6f7/similar?limit=100", path: "/api/events/id/{id}/similar", status: 400},
+		{method: "GET", target: "/api/events?sort=popular", path: "/api/events", status: 400},
+		{method: "GET", target: "/api/events?genres=jazz,funk&genreMode=both", path: "/api/events", status: 400},
+		{method: "GET", target: "/api/events?filter=city%20%3D%20Bern", path: "/api/events", status: 400},
+		{method: "GET", target: "/api/events/id/nonsense/redirect", path: "/api/events/id/{id}/redirect", status: 400},
+		{method: "POST", target: "/api/events/id/nonsense/view", path: "/api/events/id/{id}/view", status: 400},
+		{method: "POST", target: "/api/events/id/nonsense/cancel?date=2021-10-31T19:00:00Z", path: "/api/events/id/{id}/cancel", auth: true, status: 400},
+		{method: "DELETE", target: "/api/events/id/6717a2d1c5f1a2b3c4d5e6f7/cancel?date=tomorrow", path: "/api/events/id/{id}/cancel", auth: true, status: 400},
+		{method: "GET", target: "/api/events/title", path: "/api/events/{field}", status: 400},
+		{method: "GET", target: "/api/events/city?radius=-5", path: "/api/events/{field}", status: 400},
+		{method: "POST", target: "/api/events/today/slack", path: "/api/events/today/slack", contentType: fiber.MIMEApplicationForm, body: "text=", status: 400},
+		{method: "GET", target: "/api/notifications/add?email=someone@example.com&genres=jazz", path: "/api/notifications/add", status: 500},
+		{method: "GET", target: "/api/status?page=0", path: "/api/status", status: 400},
+		{method: "GET", target: "/api/moderation/pending?page=0", path: "/api/moderation/pending", auth: true, status: 400},
+		{method: "POST", target: "/api/moderation/rules", path: "/api/moderation/rules", contentType: fiber.MIMEApplicationJSON, body: `{"field":"date","pattern":".*","action":"hold"}`, auth: true, status: 400},
+		{method: "DELETE", target: "/api/moderation/rules/nonsense", path: "/api/moderation/rules/{id}", auth: true, status: 400},
+		{method: "POST", target: "/api/moderation/events/nonsense/approve", path: "/api/moderation/events/{id}/approve", auth: true, status: 400},
+		{method: "POST", target: "/api/moderation/events/nonsense/reject", path: "/api/moderation/events/{id}/reject", auth: true, status: 400},
+		{method: "GET", target: "/api/graphql", path: "/api/graphql", status: 400},
+		{method: "GET", target: "/api/graphql?query=%7Bgenres%7D&variables=%7B", path: "/api/graphql", status: 400},
+		{method: "POST", target: "/api/graphql", path: "/api/graphql", contentType: fiber.MIMEApplicationJSON, body: "{}", status: 400},
+		{method: "POST", target: "/api/webhooks", path: "/api/webhooks", contentType: fiber.MIMEApplicationJSON, body: `{"url":"no url"}`, auth: true, status: 400},
+		{method: "POST", target: "/api/webhooks", path: "/api/webhooks", contentType: fiber.MIMEApplicationJSON, body: `{"url":"https://example.com","changes":["moved"]}`, auth: true, status: 400},
+		{method: "DELETE", target: "/api/webhooks/nonsense", path: "/api/webhooks/{id}", auth: true, status: 400},
+		{method: "GET", target: "/api/webhooks/nonsense/deliveries", path: "/api/webhooks/{id}/deliveries", auth: true, status: 400},
+		{method: "POST", target: "/api/users/register", path: "/api/users/register", contentType: fiber.MIMEApplicationJSON, body: `{"email":"someone@example.com","password":"short"}`, status: 400},
+		{method: "POST", target: "/api/users/login", path: "/api/users/login", contentType: fiber.MIMEApplicationJSON, body: `{"email":"no email","password":"long enough"}`, status: 400},
+		{method: "POST", target: "/api/users/magiclink", path: "/api/users/magiclink", contentType: fiber.MIMEApplicationJSON, body: `{"email":"someone@example.com"}`, status: 500},
+		{method: "POST", target: "/api/users/magiclink/login", path: "/api/users/magiclink/login", contentType: fiber.MIMEApplicationJSON, body: `{}`, status: 400},
+		{method: "GET", target: "/api/autocomplete?q=%20", path: "/api/autocomplete", status: 400},
+		{method: "GET", target: "/api/autocomplete?q=bon&types=artist,label", path: "/api/autocomplete", status: 400},
+		{method: "GET", target: "/api/statistics?interval=month", path: "/api/statistics", status: 400},
+		{method: "GET", target: "/api/statistics?months=200", path: "/api/statistics", status: 400},
+		{method: "GET", target: "/api/openapi", path: "/api/openapi", status: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.method, tt.target), func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set(fiber.HeaderContentType, tt.contentType)
+			}
+			if tt.auth {
+				req.SetBasicAuth("user", "password")
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				body, _ := io.ReadAll(resp.Body)
+				t.Fatalf("expected status %d, got %d: %s", tt.status, resp.StatusCode, body)
+			}
+			checkResponse(t, doc, c, tt.method, tt.path, resp)
+		})
+	}
+}
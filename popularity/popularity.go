@@ -0,0 +1,135 @@
+// Package popularity keeps track of how popular events are. Every interaction with
+// an event adds to its trend, a score that decays over time, so that recent
+// interactions count more than old ones.
+//
+// The trend is stored as log2 of the decayed score shifted by the time of the
+// interaction, which makes it comparable between events without having to update
+// the trend of all events as time passes:
+//
+//	trend = log2(sum(weight * 2^(t / HalfLife)))
+package popularity
+
+import (
+	"math"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// HalfLife is the time after which an interaction counts half.
+	HalfLife = 3 * 24 * time.Hour
+	// DateHalfLife is the time after which an upcoming event counts half when sorting
+	// by trend, the same interactions given.
+	DateHalfLife = 7 * 24 * time.Hour
+
+	// InteractionView is a view of the event page.
+	InteractionView = "view"
+	// InteractionClick is a click on the url of the event.
+	InteractionClick = "click"
+
+	viewWeight  = 1.0
+	clickWeight = 3.0
+	// every event is treated as if it had a tenth of a view at the time of the query
+	baselineWeight = 0.1
+)
+
+// timeUnits returns the time in units of the half-life.
+func timeUnits(t time.Time) float64 {
+	return float64(t.UnixMilli()) / float64(HalfLife.Milliseconds())
+}
+
+// baseline returns the trend of events without interactions at the given time.
+func baseline(now time.Time) float64 {
+	return math.Log2(baselineWeight) + timeUnits(now)
+}
+
+// SortKey returns the key that trending events are sorted by in descending order. It
+// combines the trend of the event with the distance of its date. Every event gets the
+// trend of a baseline interaction, so that old interactions never make an event less
+// popular than one without interactions.
+func SortKey(e models.Event, now time.Time) float64 {
+	trend := baseline(now)
+	if e.Popularity != nil && !e.Popularity.LastInteraction.IsZero() {
+		hi, lo := math.Max(e.Popularity.Trend, trend), math.Min(e.Popularity.Trend, trend)
+		trend = hi + math.Log2(1+math.Exp2(lo-hi))
+	}
+	return trend - float64(e.Date.UnixMilli())/float64(DateHalfLife.Milliseconds())
+}
+
+// SortKeyExpression returns the aggregation expression that computes SortKey in the database.
+func SortKeyExpression(now time.Time) bson.M {
+	return bson.M{
+		"$subtract": bson.A{
+			bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$type": "$popularity.trend"}, "missing"}},
+				baseline(now),
+				logSumExp2("$popularity.trend", baseline(now)),
+			}},
+			bson.M{"$divide": bson.A{bson.M{"$toLong": "$date"}, DateHalfLife.Milliseconds()}},
+		},
+	}
+}
+
+// Update returns the update pipeline that records an interaction of the given kind at
+// the given time. The update is computed in the database, so that concurrent
+// interactions are not lost.
+func Update(interaction string, t time.Time) bson.A {
+	counter, other := "views", "clicks"
+	if interaction == InteractionClick {
+		counter, other = "clicks", "views"
+	}
+	trend := interactionTrend(interaction, t)
+	return bson.A{
+		bson.M{"$set": bson.M{
+			"popularity." + counter: bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$popularity." + counter, 0}}, 1}},
+			"popularity." + other:   bson.M{"$ifNull": bson.A{"$popularity." + other, 0}},
+			"popularity.trend": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$type": "$popularity.trend"}, "missing"}},
+				trend,
+				logSumExp2("$popularity.trend", trend),
+			}},
+			"popularity.lastInteraction": t,
+		}},
+	}
+}
+
+// Record records an interaction of the given kind at the given time like Update does in
+// the database. Callers have to make sure that concurrent interactions are not lost.
+func Record(e *models.Event, interaction string, t time.Time) {
+	trend := interactionTrend(interaction, t)
+	if e.Popularity == nil {
+		e.Popularity = &models.Popularity{Trend: trend}
+	} else {
+		hi, lo := math.Max(e.Popularity.Trend, trend), math.Min(e.Popularity.Trend, trend)
+		e.Popularity.Trend = hi + math.Log2(1+math.Exp2(lo-hi))
+	}
+	if interaction == InteractionClick {
+		e.Popularity.Clicks++
+	} else {
+		e.Popularity.Views++
+	}
+	e.Popularity.LastInteraction = t
+}
+
+// interactionTrend returns the trend of a single interaction of the given kind at the given time.
+func interactionTrend(interaction string, t time.Time) float64 {
+	if interaction == InteractionClick {
+		return math.Log2(clickWeight) + timeUnits(t)
+	}
+	return math.Log2(viewWeight) + timeUnits(t)
+}
+
+// logSumExp2 returns the aggregation expression for log2(2^a + 2^b) that doesn't overflow.
+func logSumExp2(a, b any) bson.M {
+	hi := bson.M{"$max": bson.A{a, b}}
+	lo := bson.M{"$min": bson.A{a, b}}
+	return bson.M{"$add": bson.A{
+		hi,
+		bson.M{"$log": bson.A{
+			bson.M{"$add": bson.A{1, bson.M{"$pow": bson.A{2, bson.M{"$subtract": bson.A{lo, hi}}}}}},
+			2,
+		}},
+	}}
+}
@@ -0,0 +1,80 @@
+package popularity_test
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/popularity"
+)
+
+func TestSortKey(t *testing.T) {
+	now := time.Date(2024, 10, 3, 12, 0, 0, 0, time.UTC)
+	tomorrow := now.Add(24 * time.Hour)
+	nextMonth := now.Add(30 * 24 * time.Hour)
+
+	// the trend the update pipeline stores for a single interaction
+	trend := func(weight float64, t time.Time) *models.Popularity {
+		return &models.Popularity{
+			Views:           1,
+			LastInteraction: t,
+			Trend:           math.Log2(weight) + float64(t.UnixMilli())/float64(popularity.HalfLife.Milliseconds()),
+		}
+	}
+
+	events := []models.Event{
+		{Title: "tomorrow, no interactions", Date: tomorrow},
+		{Title: "next month, clicked now", Date: nextMonth, Popularity: trend(3, now)},
+		{Title: "tomorrow, viewed now", Date: tomorrow, Popularity: trend(1, now)},
+		{Title: "tomorrow, viewed two weeks ago", Date: tomorrow, Popularity: trend(1, now.Add(-14*24*time.Hour))},
+		{Title: "next month, no interactions", Date: nextMonth},
+	}
+	slices.SortStableFunc(events, func(a, b models.Event) int {
+		ka, kb := popularity.SortKey(a, now), popularity.SortKey(b, now)
+		switch {
+		case ka > kb:
+			return -1
+		case ka < kb:
+			return 1
+		}
+		return 0
+	})
+
+	var titles []string
+	for _, e := range events {
+		titles = append(titles, e.Title)
+	}
+	expected := []string{
+		"tomorrow, viewed now",
+		"next month, clicked now",
+		"tomorrow, viewed two weeks ago",
+		"tomorrow, no interactions",
+		"next month, no interactions",
+	}
+	if !slices.Equal(titles, expected) {
+		t.Errorf("expected %v, got %v", expected, titles)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	now := time.Date(2024, 10, 3, 12, 0, 0, 0, time.UTC)
+	units := func(t time.Time) float64 {
+		return float64(t.UnixMilli()) / float64(popularity.HalfLife.Milliseconds())
+	}
+
+	var e models.Event
+	popularity.Record(&e, popularity.InteractionView, now.Add(-popularity.HalfLife))
+	popularity.Record(&e, popularity.InteractionClick, now)
+
+	p := e.Popularity
+	if p.Views != 1 || p.Clicks != 1 || !p.LastInteraction.Equal(now) {
+		t.Fatalf("unexpected popularity %+v", p)
+	}
+	// a view a half-life ago counts half, a click now three times
+	want := math.Log2(3.5) + units(now)
+	if math.Abs(p.Trend-want) > 1e-9 {
+		t.Errorf("expected trend %f, got %f", want, p.Trend)
+	}
+}
@@ -0,0 +1,176 @@
+// Package recurrence expands recurring events. The date of a recurring event is the
+// start of the series (DTSTART), its recurrence rule and exceptions follow RFC 5545.
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/teambition/rrule-go"
+)
+
+const (
+	// Horizon limits the expansion of series for queries without an end date.
+	Horizon = 365 * 24 * time.Hour
+	// MaxOccurrences limits the number of occurrences a single series is expanded to.
+	MaxOccurrences = 1000
+)
+
+// set returns the recurrence set of the given event. All dates are evaluated in the
+// time zone of the event so that e.g. weekly events keep their weekday.
+func set(e models.Event, withCancelled bool) (*rrule.Set, error) {
+	if e.Recurrence == nil {
+		return nil, errors.New("event is not recurring")
+	}
+	loc := time.FixedZone("", e.Offset)
+	opt, err := rrule.StrToROptionInLocation(e.Recurrence.RRule, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rrule: %w", err)
+	}
+	if !opt.Dtstart.IsZero() {
+		return nil, errors.New("invalid rrule: DTSTART is taken from the date of the event")
+	}
+	// the occurrences are enumerated from the start of the series, so events recur at most
+	// daily, at several hours of the day at the most
+	if opt.Freq > rrule.DAILY {
+		return nil, errors.New("invalid rrule: events can't recur more often than daily")
+	}
+	if len(opt.Byminute) > 1 || len(opt.Bysecond) > 1 {
+		return nil, errors.New("invalid rrule: events can't recur more often than once per hour")
+	}
+	if opt.Count > MaxOccurrences {
+		return nil, fmt.Errorf("invalid rrule: COUNT can't be greater than %d", MaxOccurrences)
+	}
+	opt.Dtstart = e.Date.In(loc)
+	r, err := rrule.NewRRule(*opt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rrule: %w", err)
+	}
+
+	s := &rrule.Set{}
+	s.RRule(r)
+	// DTSTART is always the first occurrence, even if it doesn't match the rule
+	s.RDate(e.Date.In(loc))
+	for _, d := range e.Recurrence.ExDates {
+		s.ExDate(d.In(loc))
+	}
+	if !withCancelled {
+		for _, d := range e.Recurrence.Cancelled {
+			s.ExDate(d.In(loc))
+		}
+	}
+	return s, nil
+}
+
+// Sanitize validates the recurrence of the given event and normalizes it. It also
+// computes the date of the last occurrence if the series is finite.
+func Sanitize(e *models.Event) error {
+	if e.Recurrence == nil {
+		return nil
+	}
+	e.Recurrence.RRule = strings.TrimPrefix(strings.TrimSpace(e.Recurrence.RRule), "RRULE:")
+	e.Recurrence.Cancelled = nil
+	e.Recurrence.LastDate = nil
+	s, err := set(*e, false)
+	if err != nil {
+		return err
+	}
+	if opt := s.GetRRule().OrigOptions; opt.Count > 0 || !opt.Until.IsZero() {
+		next := s.Iterator()
+		var last time.Time
+		n := 0
+		for d, ok := next(); ok; d, ok = next() {
+			last = d
+			n++
+			// series that end with COUNT are short, the last occurrence of long series that
+			// end with UNTIL is its end at the latest
+			if n == MaxOccurrences && !opt.Until.IsZero() {
+				if _, ok := next(); ok {
+					last = opt.Until
+				}
+				break
+			}
+		}
+		if n == 0 {
+			return errors.New("the recurrence rule has no occurrences")
+		}
+		last = last.UTC()
+		e.Recurrence.LastDate = &last
+	}
+	return nil
+}
+
+// Between returns the dates of all occurrences that are not cancelled between from and
+// to, including both. At most MaxOccurrences dates are returned.
+func Between(e models.Event, from, to time.Time) ([]time.Time, error) {
+	s, err := set(e, false)
+	if err != nil {
+		return nil, err
+	}
+	var dates []time.Time
+	next := s.Iterator()
+	for d, ok := next(); ok && !d.After(to) && len(dates) < MaxOccurrences; d, ok = next() {
+		if !d.Before(from) {
+			dates = append(dates, d.UTC())
+		}
+	}
+	return dates, nil
+}
+
+// Expand returns one event per occurrence between from and to. The occurrences are
+// copies of the series with the date of the occurrence.
+func Expand(e models.Event, from, to time.Time) ([]models.Event, error) {
+	dates, err := Between(e, from, to)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]models.Event, 0, len(dates))
+	for _, d := range dates {
+		o := e
+		o.Date = d
+		events = append(events, o)
+	}
+	return events, nil
+}
+
+// IsOccurrence checks whether the series has an occurrence at the given date,
+// regardless of whether it is cancelled.
+func IsOccurrence(e models.Event, date time.Time) bool {
+	s, err := set(e, true)
+	if err != nil {
+		return false
+	}
+	return s.After(date, true).Equal(date)
+}
+
+// Cancel marks the occurrence at the given date as cancelled.
+func Cancel(e *models.Event, date time.Time) error {
+	if !IsOccurrence(*e, date) {
+		return fmt.Errorf("the event has no occurrence at %s", date.UTC().Format(time.RFC3339))
+	}
+	if !slices.ContainsFunc(e.Recurrence.Cancelled, date.Equal) {
+		e.Recurrence.Cancelled = append(e.Recurrence.Cancelled, date.UTC())
+		slices.SortFunc(e.Recurrence.Cancelled, func(a, b time.Time) int { return a.Compare(b) })
+	}
+	return nil
+}
+
+// Restore removes the cancellation of the occurrence at the given date.
+func Restore(e *models.Event, date time.Time) error {
+	if e.Recurrence == nil {
+		return errors.New("event is not recurring")
+	}
+	i := slices.IndexFunc(e.Recurrence.Cancelled, date.Equal)
+	if i < 0 {
+		return fmt.Errorf("the occurrence at %s is not cancelled", date.UTC().Format(time.RFC3339))
+	}
+	e.Recurrence.Cancelled = slices.Delete(e.Recurrence.Cancelled, i, i+1)
+	if len(e.Recurrence.Cancelled) == 0 {
+		e.Recurrence.Cancelled = nil
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package recurrence_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/recurrence"
+)
+
+func weekly(rrule string) models.Event {
+	// Thursday 20:00 in Zurich (CEST), 18:00 UTC
+	return models.Event{
+		Title:      "Jam Session",
+		Date:       time.Date(2024, 10, 3, 18, 0, 0, 0, time.UTC),
+		Offset:     2 * 3600,
+		Recurrence: &models.Recurrence{RRule: rrule},
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	e := weekly("RRULE:FREQ=WEEKLY;COUNT=3")
+	e.Recurrence.Cancelled = []time.Time{e.Date}
+	if err := recurrence.Sanitize(&e); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e.Recurrence.RRule != "FREQ=WEEKLY;COUNT=3" {
+		t.Errorf("unexpected rrule %q", e.Recurrence.RRule)
+	}
+	if e.Recurrence.Cancelled != nil {
+		t.Errorf("cancelled occurrences should be cleared, got %v", e.Recurrence.Cancelled)
+	}
+	if expected := time.Date(2024, 10, 17, 18, 0, 0, 0, time.UTC); e.Recurrence.LastDate == nil || !e.Recurrence.LastDate.Equal(expected) {
+		t.Errorf("expected last date %v, got %v", expected, e.Recurrence.LastDate)
+	}
+
+	e = weekly("FREQ=WEEKLY")
+	if err := recurrence.Sanitize(&e); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e.Recurrence.LastDate != nil {
+		t.Errorf("infinite series should have no last date, got %v", e.Recurrence.LastDate)
+	}
+
+	// long series aren't enumerated up to their end
+	e = weekly("FREQ=DAILY;UNTIL=20991231T000000Z")
+	if err := recurrence.Sanitize(&e); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := time.Date(2099, 12, 31, 0, 0, 0, 0, time.UTC); e.Recurrence.LastDate == nil || !e.Recurrence.LastDate.Equal(expected) {
+		t.Errorf("expected last date %v, got %v", expected, e.Recurrence.LastDate)
+	}
+
+	for _, rrule := range []string{
+		"FREQ=SOMETIMES",
+		"DTSTART:20241003T180000Z\nRRULE:FREQ=WEEKLY",
+		"",
+		"FREQ=SECONDLY;UNTIL=20991231T000000Z",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;BYMINUTE=0,1,2,3",
+		"FREQ=DAILY;COUNT=100000000",
+	} {
+		e = weekly(rrule)
+		if err := recurrence.Sanitize(&e); err == nil {
+			t.Errorf("expected error for rrule %q", rrule)
+		}
+	}
+}
+
+func TestBetween(t *testing.T) {
+	e := weekly("FREQ=WEEKLY;BYDAY=TH")
+	e.Recurrence.ExDates = []time.Time{time.Date(2024, 10, 10, 18, 0, 0, 0, time.UTC)}
+	e.Recurrence.Cancelled = []time.Time{time.Date(2024, 10, 17, 18, 0, 0, 0, time.UTC)}
+
+	dates, err := recurrence.Between(e, e.Date, time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []time.Time{
+		time.Date(2024, 10, 3, 18, 0, 0, 0, time.UTC),
+		time.Date(2024, 10, 24, 18, 0, 0, 0, time.UTC),
+		time.Date(2024, 10, 31, 18, 0, 0, 0, time.UTC),
+	}
+	if len(dates) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, dates)
+	}
+	for i := range dates {
+		if !dates[i].Equal(expected[i]) || dates[i].Location() != time.UTC {
+			t.Errorf("expected %v, got %v", expected[i], dates[i])
+		}
+	}
+}
+
+func TestBetweenKeepsWeekdayInTimeZone(t *testing.T) {
+	// Friday 00:30 in Zurich is still Thursday in UTC
+	e := weekly("FREQ=WEEKLY;BYDAY=FR;COUNT=2")
+	e.Date = time.Date(2024, 10, 3, 22, 30, 0, 0, time.UTC)
+
+	dates, err := recurrence.Between(e, e.Date, e.Date.Add(14*24*time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dates) != 2 || !dates[1].Equal(time.Date(2024, 10, 10, 22, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected occurrences %v", dates)
+	}
+}
+
+func TestCancelAndRestore(t *testing.T) {
+	e := weekly("FREQ=WEEKLY")
+	second := e.Date.Add(7 * 24 * time.Hour)
+
+	if err := recurrence.Cancel(&e, second.Add(time.Hour)); err == nil {
+		t.Error("expected error when cancelling a date that is not an occurrence")
+	}
+	if err := recurrence.Cancel(&e, second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := recurrence.Cancel(&e, second); err != nil {
+		t.Fatalf("cancelling twice should not fail: %v", err)
+	}
+	if len(e.Recurrence.Cancelled) != 1 {
+		t.Fatalf("expected one cancelled occurrence, got %v", e.Recurrence.Cancelled)
+	}
+	if !recurrence.IsOccurrence(e, second) {
+		t.Error("cancelled occurrences should still be occurrences")
+	}
+	dates, _ := recurrence.Between(e, second, second)
+	if len(dates) != 0 {
+		t.Errorf("cancelled occurrence should not be returned, got %v", dates)
+	}
+
+	if err := recurrence.Restore(&e, second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e.Recurrence.Cancelled != nil {
+		t.Errorf("expected no cancelled occurrences, got %v", e.Recurrence.Cancelled)
+	}
+	if err := recurrence.Restore(&e, second); err == nil {
+		t.Error("expected error when restoring an occurrence that is not cancelled")
+	}
+}
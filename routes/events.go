@@ -1,10 +1,9 @@
 package routes
 
 import (
-	"os"
-
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/basicauth"
+	"github.com/jakopako/event-api/config"
 	"github.com/jakopako/event-api/controllers"
 )
 
@@ -12,13 +11,23 @@ func EventsRoute(route fiber.Router) {
 	// for some reason auth cannot be defined outside this function
 	auth := basicauth.New(basicauth.Config{
 		Users: map[string]string{
-			os.Getenv("API_USER"): os.Getenv("API_PASSWORD"),
+			config.C.API.User: config.C.API.Password,
 		},
 	})
 	route.Get("/", controllers.GetAllEvents)
 	route.Post("/", auth, controllers.AddEvents)
 	route.Post("/validate", controllers.ValidateEvents)
 	route.Delete("/", auth, controllers.DeleteEvents)
+	route.Get("/stream", controllers.StreamEvents)
+	route.Get("/ics", controllers.GetEventsICS)
+	route.Get("/overrides", auth, controllers.GetEventOverrides)
+	route.Put("/id/:id/overrides", auth, controllers.SetEventOverrides)
+	route.Delete("/id/:id/overrides", auth, controllers.DeleteEventOverrides)
+	route.Get("/id/:id/similar", controllers.GetSimilarEvents)
+	route.Get("/id/:id/redirect", controllers.RedirectToEvent)
+	route.Post("/id/:id/view", controllers.AddEventView)
+	route.Post("/id/:id/cancel", auth, controllers.CancelOccurrence)
+	route.Delete("/id/:id/cancel", auth, controllers.RestoreOccurrence)
 	route.Get("/:field", controllers.GetDistinct)
 	route.Post("/today/slack", controllers.GetTodaysEventsSlack)
 }
@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/controllers"
+)
+
+func GraphqlRoute(route fiber.Router) {
+	route.Get("/", controllers.Graphql)
+	route.Post("/", controllers.Graphql)
+}
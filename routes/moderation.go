@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/basicauth"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/controllers"
+)
+
+func ModerationRoute(route fiber.Router) {
+	// for some reason auth cannot be defined outside this function
+	auth := basicauth.New(basicauth.Config{
+		Users: map[string]string{
+			config.C.API.User: config.C.API.Password,
+		},
+	})
+	route.Use(auth)
+	route.Get("/rules", controllers.GetModerationRules)
+	route.Post("/rules", controllers.AddModerationRule)
+	route.Delete("/rules/:id", controllers.DeleteModerationRule)
+	route.Get("/pending", controllers.GetPendingEvents)
+	route.Post("/events/:id/approve", controllers.ApproveEvent)
+	route.Post("/events/:id/reject", controllers.RejectEvent)
+}
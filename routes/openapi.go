@@ -0,0 +1,10 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/controllers"
+)
+
+func OpenAPIRoute(route fiber.Router) {
+	route.Get("/", controllers.GetOpenAPI)
+}
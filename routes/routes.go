@@ -0,0 +1,19 @@
+package routes
+
+import "github.com/gofiber/fiber/v2"
+
+// SetupRoutes registers all routes of the api.
+func SetupRoutes(app *fiber.App) {
+	api := app.Group("/api")
+	EventsRoute(api.Group("/events"))
+	NotificationsRoute(api.Group("/notifications"))
+	StatusRoute(api.Group("/status"))
+	ModerationRoute(api.Group("/moderation"))
+	GraphqlRoute(api.Group("/graphql"))
+	WebhooksRoute(api.Group("/webhooks"))
+	UsersRoute(api.Group("/users"))
+	AutocompleteRoute(api.Group("/autocomplete"))
+	StatisticsRoute(api.Group("/statistics"))
+	OpenAPIRoute(api.Group("/openapi"))
+	SwaggerRoute(api.Group("/swagger"))
+}
@@ -1,10 +1,9 @@
 package routes
 
 import (
-	"os"
-
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/basicauth"
+	"github.com/jakopako/event-api/config"
 	"github.com/jakopako/event-api/controllers"
 )
 
@@ -12,7 +11,7 @@ func StatusRoute(route fiber.Router) {
 	// for some reason auth cannot be defined outside this function
 	auth := basicauth.New(basicauth.Config{
 		Users: map[string]string{
-			os.Getenv("API_USER"): os.Getenv("API_PASSWORD"),
+			config.C.API.User: config.C.API.Password,
 		},
 	})
 	route.Get("/", controllers.GetScraperStatus)
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/jakopako/event-api/account"
+	"github.com/jakopako/event-api/controllers"
+)
+
+func UsersRoute(route fiber.Router) {
+	route.Post("/register", controllers.RegisterUser)
+	route.Post("/login", controllers.LoginUser)
+	route.Post("/magiclink", controllers.RequestMagicLink)
+	route.Post("/magiclink/login", controllers.LoginWithMagicLink)
+	route.Post("/logout", account.RequireUser, controllers.LogoutUser)
+
+	me := route.Group("/me", account.RequireUser)
+	me.Get("/", controllers.GetCurrentUser)
+	me.Delete("/", controllers.DeleteCurrentUser)
+	me.Get("/feed", controllers.GetUserFeed)
+	me.Get("/favourites", controllers.GetFavouriteEvents)
+	me.Put("/favourites/:id", controllers.AddFavouriteEvent)
+	me.Delete("/favourites/:id", controllers.DeleteFavouriteEvent)
+	me.Put("/follows/:type", controllers.AddFollow)
+	me.Delete("/follows/:type", controllers.DeleteFollow)
+}
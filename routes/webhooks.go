@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/basicauth"
+	"github.com/jakopako/event-api/account"
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/controllers"
+)
+
+func WebhooksRoute(route fiber.Router) {
+	// for some reason auth cannot be defined outside this function
+	auth := basicauth.New(basicauth.Config{
+		Users: map[string]string{
+			config.C.API.User: config.C.API.Password,
+		},
+	})
+	// users manage their own webhooks with their session token
+	route.Use(func(c *fiber.Ctx) error {
+		if account.BearerToken(c) != "" {
+			return account.RequireUser(c)
+		}
+		return auth(c)
+	})
+	route.Get("/", controllers.GetWebhooks)
+	route.Post("/", controllers.AddWebhook)
+	route.Delete("/:id", controllers.DeleteWebhook)
+	route.Get("/:id/deliveries", controllers.GetWebhookDeliveries)
+}
@@ -0,0 +1,357 @@
+package search
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxLength limits the length of filter expressions.
+const MaxLength = 2000
+
+// ParseError describes why an expression could not be parsed and where.
+type ParseError struct {
+	// Pos is the position of the character at which the error was found, starting at 0
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid filter at position %d: %s", e.Pos, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenDate
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of filter"
+	}
+	if t.kind == tokenString {
+		return fmt.Sprintf("%q", t.text)
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// isKeyword checks whether the token is the given keyword, ignoring case.
+func (t token) isKeyword(keyword string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, keyword)
+}
+
+// lex splits the expression into tokens.
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case c == '"':
+			start := i
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(s) {
+					return nil, &ParseError{start, "unterminated string"}
+				}
+				if s[i] == '"' {
+					i++
+					break
+				}
+				if s[i] == '\\' {
+					if i+1 >= len(s) || (s[i+1] != '"' && s[i+1] != '\\') {
+						return nil, &ParseError{i, `only \" and \\ can be escaped in strings`}
+					}
+					i++
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			tokens = append(tokens, token{tokenString, b.String(), start})
+		case strings.ContainsRune("=!<>~", rune(c)):
+			start := i
+			op := string(c)
+			if i+1 < len(s) && ((c != '=' && c != '~' && s[i+1] == '=') || (c == '!' && s[i+1] == '~')) {
+				op += string(s[i+1])
+			}
+			if op == "!" {
+				return nil, &ParseError{start, "unknown operator '!', use 'not' or '!='"}
+			}
+			i += len(op)
+			tokens = append(tokens, token{tokenOp, op, start})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(s) && strings.ContainsRune("0123456789-:.TZ+", rune(s[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokenDate, s[start:i], start})
+		default:
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if !unicode.IsLetter(r) && r != '_' {
+				return nil, &ParseError{i, fmt.Sprintf("unexpected character %q", r)}
+			}
+			start := i
+			for i += size; i < len(s); i += size {
+				r, size = utf8.DecodeRuneInString(s[i:])
+				if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+					break
+				}
+			}
+			tokens = append(tokens, token{tokenWord, s[start:i], start})
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(s)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return &ParseError{t.pos, fmt.Sprintf(format, args...)}
+}
+
+// Parse parses a filter expression. An empty expression returns nil.
+//
+//	expr       = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | "(" expr ")" | comparison
+//	comparison = field op value | field [ "not" ] "in" "(" value { "," value } ")"
+//	op         = "=" | "!=" | "<" | "<=" | ">" | ">=" | "~" | "!~"
+func Parse(s string) (Expr, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	if len(s) > MaxLength {
+		return nil, &ParseError{MaxLength, fmt.Sprintf("filter must not be longer than %d characters", MaxLength)}
+	}
+	e, err := parse(s)
+	if parseErr, ok := err.(*ParseError); ok {
+		// the tokens know their position in bytes
+		parseErr.Pos = utf8.RuneCountInString(s[:parseErr.Pos])
+	}
+	return e, err
+}
+
+func parse(s string) (Expr, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.parseOr(false)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "expected 'and', 'or' or end of filter, found %s", t)
+	}
+	return e, nil
+}
+
+// The parse functions push negations down to the comparisons, so that the resulting
+// expression only consists of conjunctions, disjunctions and comparisons.
+
+func (p *parser) parseOr(negated bool) (Expr, error) {
+	var operands []Expr
+	for {
+		e, err := p.parseAnd(negated)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, e)
+		if !p.peek().isKeyword("or") {
+			break
+		}
+		p.next()
+	}
+	// not (a or b) is (not a) and (not b)
+	return combine(operands, negated), nil
+}
+
+func (p *parser) parseAnd(negated bool) (Expr, error) {
+	var operands []Expr
+	for {
+		e, err := p.parseUnary(negated)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, e)
+		if !p.peek().isKeyword("and") {
+			break
+		}
+		p.next()
+	}
+	return combine(operands, !negated), nil
+}
+
+func combine(operands []Expr, conjunction bool) Expr {
+	if len(operands) == 1 {
+		return operands[0]
+	}
+	if conjunction {
+		return and(operands)
+	}
+	return or(operands)
+}
+
+func (p *parser) parseUnary(negated bool) (Expr, error) {
+	t := p.peek()
+	switch {
+	case t.isKeyword("not"):
+		p.next()
+		return p.parseUnary(!negated)
+	case t.kind == tokenLParen:
+		p.next()
+		e, err := p.parseOr(negated)
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, p.errorf(t, "expected ')', found %s", t)
+		}
+		return e, nil
+	}
+	return p.parseComparison(negated)
+}
+
+func (p *parser) parseComparison(negated bool) (Expr, error) {
+	t := p.next()
+	if t.kind != tokenWord {
+		return nil, p.errorf(t, "expected a field, found %s", t)
+	}
+	f, found := fields[strings.ToLower(t.text)]
+	if !found {
+		return nil, p.errorf(t, "unknown field '%s', must be one of %s", t.text, strings.Join(FieldNames(), ", "))
+	}
+
+	opToken := p.next()
+	op := opToken.text
+	switch {
+	case opToken.isKeyword("in"):
+		op = opIn
+	case opToken.isKeyword("not"):
+		if t := p.next(); !t.isKeyword("in") {
+			return nil, p.errorf(t, "expected 'in' after 'not', found %s", t)
+		}
+		op = opNotIn
+	case opToken.kind != tokenOp:
+		return nil, p.errorf(opToken, "expected an operator after '%s', found %s", t.text, opToken)
+	}
+	if !slices.Contains(f.ops, op) {
+		return nil, p.errorf(opToken, "operator '%s' is not supported for field '%s'", op, f.name)
+	}
+
+	var values []token
+	if op == opIn || op == opNotIn {
+		if t := p.next(); t.kind != tokenLParen {
+			return nil, p.errorf(t, "expected '(' after '%s', found %s", op, t)
+		}
+		for {
+			v := p.next()
+			if v.kind != tokenString && v.kind != tokenDate {
+				return nil, p.errorf(v, "expected a value, found %s", v)
+			}
+			values = append(values, v)
+			t := p.next()
+			if t.kind == tokenRParen {
+				break
+			}
+			if t.kind != tokenComma {
+				return nil, p.errorf(t, "expected ',' or ')', found %s", t)
+			}
+		}
+	} else {
+		values = append(values, p.next())
+	}
+
+	if negated {
+		op = negations[op]
+	}
+	if f.date {
+		if len(values) != 1 {
+			return nil, p.errorf(opToken, "operator '%s' is not supported for field '%s'", op, f.name)
+		}
+		return dateComparison(f, op, values[0])
+	}
+	c := &comparison{field: f, op: op}
+	for _, v := range values {
+		if v.kind != tokenString {
+			return nil, p.errorf(v, "expected a quoted string, found %s", v)
+		}
+		c.values = append(c.values, v.text)
+	}
+	return c, nil
+}
+
+// dateComparison returns the comparison of the date field with the given date or time.
+// Dates without time stand for the whole day in UTC, e.g. date = 2026-11-01 matches all
+// events of that day.
+func dateComparison(f field, op string, v token) (Expr, error) {
+	if v.kind == tokenString {
+		v.kind = tokenDate
+	}
+	if v.kind != tokenDate {
+		return nil, &ParseError{v.pos, fmt.Sprintf("expected a date like 2026-11-01 or 2026-11-01T20:00:00Z, found %s", v)}
+	}
+	if t, err := time.Parse(time.RFC3339, v.text); err == nil {
+		return &comparison{field: f, op: op, time: t}, nil
+	}
+	day, err := time.Parse(time.DateOnly, v.text)
+	if err != nil {
+		return nil, &ParseError{v.pos, fmt.Sprintf("expected a date like 2026-11-01 or 2026-11-01T20:00:00Z, found %s", v)}
+	}
+	nextDay := day.AddDate(0, 0, 1)
+	switch op {
+	case opEq:
+		return and{&comparison{field: f, op: opGte, time: day}, &comparison{field: f, op: opLt, time: nextDay}}, nil
+	case opNe:
+		return or{&comparison{field: f, op: opLt, time: day}, &comparison{field: f, op: opGte, time: nextDay}}, nil
+	case opLte:
+		return &comparison{field: f, op: opLt, time: nextDay}, nil
+	case opGt:
+		return &comparison{field: f, op: opGte, time: nextDay}, nil
+	}
+	return &comparison{field: f, op: op, time: day}, nil
+}
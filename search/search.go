@@ -0,0 +1,240 @@
+// Package search parses filter expressions for events like
+//
+//	genres in ("jazz", "soul") and city = "Zürich" and date >= 2026-11-01 and not type = "party"
+//
+// into database filters. Values are never interpreted as patterns or operators, so
+// expressions can be taken from users as they are.
+package search
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The comparison operators. Strings are compared ignoring case, ~ checks whether a
+// string contains the value.
+const (
+	opEq       = "="
+	opNe       = "!="
+	opLt       = "<"
+	opLte      = "<="
+	opGt       = ">"
+	opGte      = ">="
+	opContains = "~"
+	opExcludes = "!~"
+	opIn       = "in"
+	opNotIn    = "not in"
+)
+
+var negations = map[string]string{
+	opEq: opNe, opNe: opEq,
+	opLt: opGte, opGte: opLt,
+	opGt: opLte, opLte: opGt,
+	opContains: opExcludes, opExcludes: opContains,
+	opIn: opNotIn, opNotIn: opIn,
+}
+
+var (
+	stringOps = []string{opEq, opNe, opContains, opExcludes, opIn, opNotIn}
+	dateOps   = []string{opEq, opNe, opLt, opLte, opGt, opGte}
+)
+
+type field struct {
+	name string
+	// the name of the field in the database
+	key   string
+	ops   []string
+	date  bool
+	value func(e models.Event) []string
+}
+
+var fields = map[string]field{}
+
+func init() {
+	for _, f := range []field{
+		{name: "title", key: "title", value: func(e models.Event) []string { return []string{e.Title} }},
+		{name: "location", key: "location", value: func(e models.Event) []string { return []string{e.Location} }},
+		{name: "city", key: "city", value: func(e models.Event) []string { return []string{e.City} }},
+		{name: "state", key: "state", value: func(e models.Event) []string { return []string{e.State} }},
+		{name: "country", key: "country", value: func(e models.Event) []string { return []string{e.Country} }},
+		{name: "type", key: "type", value: func(e models.Event) []string { return []string{e.Type} }},
+		{name: "genres", key: "genres", value: func(e models.Event) []string { return e.Genres }},
+		{name: "sourceUrl", key: "sourceUrl", value: func(e models.Event) []string { return []string{e.SourceURL} }},
+		{name: "date", key: "date", date: true},
+	} {
+		if f.date {
+			f.ops = dateOps
+		} else {
+			f.ops = stringOps
+		}
+		fields[strings.ToLower(f.name)] = f
+	}
+}
+
+// FieldNames returns the names of the fields that can be used in expressions.
+func FieldNames() []string {
+	var names []string
+	for _, f := range fields {
+		names = append(names, f.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Expr is a parsed filter expression.
+type Expr interface {
+	// Filter returns the database filter of the expression.
+	Filter() bson.M
+	// SeriesFilter returns a database filter for recurring events. The date of a
+	// recurring event is the start of the series, so conditions on the date are left
+	// out and have to be checked for each occurrence with Match.
+	SeriesFilter() bson.M
+	// Match checks whether the event matches the expression.
+	Match(e models.Event) bool
+}
+
+type and []Expr
+
+func (a and) Filter() bson.M {
+	return bson.M{"$and": filters(a, Expr.Filter)}
+}
+
+func (a and) SeriesFilter() bson.M {
+	return bson.M{"$and": filters(a, Expr.SeriesFilter)}
+}
+
+func (a and) Match(e models.Event) bool {
+	for _, x := range a {
+		if !x.Match(e) {
+			return false
+		}
+	}
+	return true
+}
+
+type or []Expr
+
+func (o or) Filter() bson.M {
+	return bson.M{"$or": filters(o, Expr.Filter)}
+}
+
+func (o or) SeriesFilter() bson.M {
+	return bson.M{"$or": filters(o, Expr.SeriesFilter)}
+}
+
+func (o or) Match(e models.Event) bool {
+	return slices.ContainsFunc(o, func(x Expr) bool { return x.Match(e) })
+}
+
+func filters(exprs []Expr, filter func(Expr) bson.M) []bson.M {
+	var f []bson.M
+	for _, x := range exprs {
+		f = append(f, filter(x))
+	}
+	return f
+}
+
+// comparison compares a field with one or more strings or with a time. Expressions
+// are in negation normal form, i.e. negations are part of the operator.
+type comparison struct {
+	field  field
+	op     string
+	values []string
+	time   time.Time
+}
+
+func (c *comparison) Filter() bson.M {
+	if c.field.date {
+		switch c.op {
+		case opEq:
+			return bson.M{c.field.key: c.time}
+		case opNe:
+			return bson.M{c.field.key: bson.M{"$ne": c.time}}
+		case opLt:
+			return bson.M{c.field.key: bson.M{"$lt": c.time}}
+		case opLte:
+			return bson.M{c.field.key: bson.M{"$lte": c.time}}
+		case opGt:
+			return bson.M{c.field.key: bson.M{"$gt": c.time}}
+		default:
+			return bson.M{c.field.key: bson.M{"$gte": c.time}}
+		}
+	}
+
+	var regexes []any
+	for _, v := range c.values {
+		pattern := regexp.QuoteMeta(v)
+		if c.op != opContains && c.op != opExcludes {
+			pattern = "^" + pattern + "$"
+		}
+		regexes = append(regexes, primitive.Regex{Pattern: pattern, Options: "i"})
+	}
+	switch c.op {
+	case opEq, opContains:
+		return bson.M{c.field.key: regexes[0]}
+	case opNe, opExcludes:
+		// for lists like genres this means that none of the elements matches
+		return bson.M{c.field.key: bson.M{"$not": regexes[0]}}
+	case opIn:
+		return bson.M{c.field.key: bson.M{"$in": regexes}}
+	default:
+		return bson.M{c.field.key: bson.M{"$nin": regexes}}
+	}
+}
+
+func (c *comparison) SeriesFilter() bson.M {
+	if c.field.date {
+		// matches all events
+		return bson.M{}
+	}
+	return c.Filter()
+}
+
+func (c *comparison) Match(e models.Event) bool {
+	if c.field.date {
+		switch c.op {
+		case opEq:
+			return e.Date.Equal(c.time)
+		case opNe:
+			return !e.Date.Equal(c.time)
+		case opLt:
+			return e.Date.Before(c.time)
+		case opLte:
+			return !e.Date.After(c.time)
+		case opGt:
+			return e.Date.After(c.time)
+		default:
+			return !e.Date.Before(c.time)
+		}
+	}
+
+	matches := func(v string) bool {
+		return slices.ContainsFunc(c.field.value(e), func(s string) bool {
+			if s == "" {
+				// empty fields are not stored
+				return false
+			}
+			if c.op == opContains || c.op == opExcludes {
+				return strings.Contains(strings.ToLower(s), strings.ToLower(v))
+			}
+			return strings.EqualFold(s, v)
+		})
+	}
+	switch c.op {
+	case opEq, opContains:
+		return matches(c.values[0])
+	case opNe, opExcludes:
+		return !matches(c.values[0])
+	case opIn:
+		return slices.ContainsFunc(c.values, matches)
+	default:
+		return !slices.ContainsFunc(c.values, matches)
+	}
+}
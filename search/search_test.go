@@ -0,0 +1,124 @@
+package search_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/search"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMatch(t *testing.T) {
+	event := models.Event{
+		Title:  "Nubya Garcia",
+		City:   "Zürich",
+		Type:   "concert",
+		Date:   time.Date(2026, 11, 1, 20, 0, 0, 0, time.UTC),
+		Genres: []string{"jazz", "nu jazz"},
+	}
+
+	tests := []struct {
+		filter   string
+		expected bool
+	}{
+		{`genres in ("jazz","soul") and city = "Zürich" and date >= 2026-11-01 and not type = "party"`, true},
+		{`city = "zürich"`, true},
+		{`city = "Zür"`, false},
+		{`city ~ "zür"`, true},
+		{`title !~ "garcia"`, false},
+		{`genres = "soul" or city = "Zürich"`, true},
+		{`genres = "soul" or city = "Bern"`, false},
+		{`genres != "jazz"`, false},
+		{`genres not in ("soul", "funk")`, true},
+		{`GENRES IN ("Nu Jazz")`, true},
+		{`not (city = "Zürich" and type = "concert")`, false},
+		{`not (city = "Bern" or type = "party")`, true},
+		{`not not city = "Zürich"`, true},
+		{`date = 2026-11-01`, true},
+		{`date != 2026-11-01`, false},
+		{`date = "2026-11-02"`, false},
+		{`date < 2026-11-01`, false},
+		{`date <= 2026-11-01`, true},
+		{`date > 2026-11-01`, false},
+		{`date > 2026-11-01T19:00:00Z`, true},
+		{`date = 2026-11-01T21:00:00+01:00`, true},
+		{`state = "Zurich"`, false},
+		{`state != "Zurich"`, true},
+	}
+	for _, tt := range tests {
+		e, err := search.Parse(tt.filter)
+		if err != nil {
+			t.Errorf("Parse(%q) returned an error: %v", tt.filter, err)
+			continue
+		}
+		if got := e.Match(event); got != tt.expected {
+			t.Errorf("Parse(%q).Match() = %v, expected %v", tt.filter, got, tt.expected)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		filter string
+		pos    int
+	}{
+		{`city = Zürich`, 7},
+		{`town = "Zürich"`, 0},
+		{`city < "Zürich"`, 5},
+		{`date ~ 2026-11-01`, 5},
+		{`date = 2026-13-01`, 7},
+		{`city = "Zürich`, 7},
+		{`city = "Zürich" and`, 19},
+		{`city = "Zürich" city = "Bern"`, 16},
+		{`(city = "Zürich"`, 16},
+		{`genres in "jazz"`, 10},
+		{`genres in ("jazz",)`, 18},
+		{`genres not ("jazz")`, 11},
+		{`city ! "Zürich"`, 5},
+		{`city = "Zürich" & type = "party"`, 16},
+		{`date in (2026-11-01)`, 5},
+	}
+	for _, tt := range tests {
+		_, err := search.Parse(tt.filter)
+		var parseErr *search.ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("Parse(%q) returned %v, expected a parse error", tt.filter, err)
+			continue
+		}
+		if parseErr.Pos != tt.pos {
+			t.Errorf("Parse(%q) returned an error at position %d, expected %d: %v", tt.filter, parseErr.Pos, tt.pos, err)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	e, err := search.Parse(`not (city = "a.b" or date >= 2026-11-01)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	expected := bson.M{"$and": []bson.M{
+		{"city": bson.M{"$not": primitive.Regex{Pattern: `^a\.b$`, Options: "i"}}},
+		{"date": bson.M{"$lt": day}},
+	}}
+	if got := e.Filter(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Filter() = %v, expected %v", got, expected)
+	}
+
+	expected = bson.M{"$and": []bson.M{
+		{"city": bson.M{"$not": primitive.Regex{Pattern: `^a\.b$`, Options: "i"}}},
+		{},
+	}}
+	if got := e.SeriesFilter(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("SeriesFilter() = %v, expected %v", got, expected)
+	}
+
+	if e, err := search.Parse("  "); e != nil || err != nil {
+		t.Errorf("Parse() of an empty filter = %v, %v, expected nil, nil", e, err)
+	}
+}
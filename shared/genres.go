@@ -0,0 +1,75 @@
+package shared
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/jakopako/event-api/models"
+)
+
+// genreFilter filters events by the genres and types of a query in memory.
+type genreFilter struct {
+	q models.Query
+	// the patterns of the genres if subgenres are included
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func newGenreFilter(q models.Query) genreFilter {
+	f := genreFilter{q: q}
+	if q.Subgenres {
+		for _, g := range q.Genres {
+			f.include = append(f.include, regexp.MustCompile("(?i)"+SubgenrePattern(g)))
+		}
+		for _, g := range q.ExcludeGenres {
+			f.exclude = append(f.exclude, regexp.MustCompile("(?i)"+SubgenrePattern(g)))
+		}
+	}
+	return f
+}
+
+// SubgenrePattern returns the pattern that matches the genre and all genres that contain
+// it as separate words, e.g. hard techno and techno house for techno.
+func SubgenrePattern(genre string) string {
+	return `(^|[\s-])` + regexp.QuoteMeta(strings.TrimSpace(genre)) + `([\s-]|$)`
+}
+
+// match checks whether the event matches the genres and types of the query.
+func (f genreFilter) match(e models.Event) bool {
+	if len(f.q.Genres) > 0 {
+		found := 0
+		for i, g := range f.q.Genres {
+			if f.hasGenre(e, g, f.include, i) {
+				found++
+			}
+		}
+		if found == 0 || (f.q.GenreMode == models.GenreModeAll && found < len(f.q.Genres)) {
+			return false
+		}
+	}
+	for i, g := range f.q.ExcludeGenres {
+		if f.hasGenre(e, g, f.exclude, i) {
+			return false
+		}
+	}
+	return !slices.Contains(LowerAll(f.q.ExcludeTypes), e.Type)
+}
+
+// LowerAll returns the values in lower case, the way types and genres are stored.
+func LowerAll(values []string) []string {
+	lower := make([]string, len(values))
+	for i, v := range values {
+		lower[i] = strings.ToLower(v)
+	}
+	return lower
+}
+
+// hasGenre checks whether the event has the i-th genre or, if subgenres are included,
+// one of its subgenres.
+func (f genreFilter) hasGenre(e models.Event, genre string, regexes []*regexp.Regexp, i int) bool {
+	if f.q.Subgenres {
+		return slices.ContainsFunc(e.Genres, regexes[i].MatchString)
+	}
+	return slices.Contains(e.Genres, genre)
+}
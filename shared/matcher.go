@@ -0,0 +1,141 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/jakopako/event-api/geo"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/recurrence"
+	"github.com/jakopako/event-api/search"
+)
+
+// EventMatcher matches single events against a query in memory. It uses the same
+// semantics as the filter that FetchEvents sends to the database.
+type EventMatcher struct {
+	q         models.Query
+	title     *regexp.Regexp
+	normTitle *regexp.Regexp
+	location  *regexp.Regexp
+	country   *regexp.Regexp
+	typ       *regexp.Regexp
+	city      *regexp.Regexp
+	center    []float64
+	genres    genreFilter
+	expr      search.Expr
+}
+
+// NewEventMatcher creates a matcher for the given query. Page and limit are ignored.
+// If a radius is given, the coordinates of the city are looked up once.
+func NewEventMatcher(ctx context.Context, q models.Query) (*EventMatcher, error) {
+	if err := ValidateFilters(q); err != nil {
+		return nil, err
+	}
+	expr, err := search.Parse(q.Filter)
+	if err != nil {
+		return nil, err
+	}
+	m := &EventMatcher{
+		q:         q,
+		title:     containsRegex(q.Title),
+		normTitle: containsRegex(RemoveDiacritics(q.Title)),
+		location:  containsRegex(q.Location),
+		country:   containsRegex(q.Country),
+		typ:       containsRegex(q.Type),
+		genres:    newGenreFilter(q),
+		expr:      expr,
+	}
+	if q.City != "" {
+		// like in FetchEvents the city is used as a regex pattern
+		r, err := regexp.Compile("(?i)" + q.City)
+		if err != nil {
+			return nil, fmt.Errorf("invalid city: %w", err)
+		}
+		m.city = r
+		if q.Radius > 0 {
+			if geolocs, err := geo.AllMatchesCityCoordinates(ctx, q.City, q.Country); err == nil && len(geolocs) > 0 {
+				m.center = geolocs[0].Coordinates
+			}
+		}
+	}
+	return m, nil
+}
+
+// Match checks whether the given event matches the query of the matcher.
+func (m *EventMatcher) Match(e models.Event) bool {
+	if !IsVisible(e) {
+		return false
+	}
+	if m.q.StartDate != nil && e.Recurrence != nil {
+		if !m.hasOccurrence(e) {
+			return false
+		}
+	} else if m.q.StartDate != nil {
+		if m.q.EndDate == nil {
+			if !e.Date.After(*m.q.StartDate) {
+				return false
+			}
+		} else if e.Date.Before(*m.q.StartDate) || e.Date.After(*m.q.EndDate) {
+			return false
+		}
+	}
+	if m.title != nil && !m.title.MatchString(e.Title) && !m.normTitle.MatchString(e.NormalizedTitle) {
+		return false
+	}
+	if m.location != nil && !m.location.MatchString(e.Location) {
+		return false
+	}
+	if m.country != nil && !m.country.MatchString(e.Country) {
+		return false
+	}
+	if m.typ != nil && !m.typ.MatchString(e.Type) {
+		return false
+	}
+	if !m.genres.match(e) {
+		return false
+	}
+	if m.city != nil && !m.city.MatchString(e.City) {
+		if m.center == nil || geo.DistanceKm(m.center, e.Address.Geolocacation.Coordinates) > float64(m.q.Radius) {
+			return false
+		}
+	}
+	// for recurring events the expression is checked per occurrence by hasOccurrence
+	if m.expr != nil && (m.q.StartDate == nil || e.Recurrence == nil) && !m.expr.Match(e) {
+		return false
+	}
+	return true
+}
+
+// hasOccurrence checks whether a recurring event has an occurrence within the date window of the query.
+func (m *EventMatcher) hasOccurrence(e models.Event) bool {
+	to := m.q.StartDate.Add(recurrence.Horizon)
+	if m.q.EndDate != nil {
+		to = *m.q.EndDate
+	}
+	dates, err := recurrence.Between(e, *m.q.StartDate, to)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(dates, func(d time.Time) bool {
+		// like for other events the start date is exclusive if there is no end date
+		if m.q.EndDate == nil && !d.After(*m.q.StartDate) {
+			return false
+		}
+		if m.expr != nil {
+			o := e
+			o.Date = d
+			return m.expr.Match(o)
+		}
+		return true
+	})
+}
+
+func containsRegex(s string) *regexp.Regexp {
+	if s == "" {
+		return nil
+	}
+	return regexp.MustCompile("(?i)" + regexp.QuoteMeta(s))
+}
@@ -0,0 +1,89 @@
+package shared_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/moderation"
+	"github.com/jakopako/event-api/shared"
+)
+
+func TestEventMatcher(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tomorrow := now.Add(24 * time.Hour)
+	event := models.Event{
+		Title:           "Björk Live",
+		NormalizedTitle: "Bjork Live",
+		Location:        "Rote Fabrik",
+		City:            "Zürich",
+		Country:         "Switzerland",
+		Type:            "concert",
+		Date:            now.Add(2 * time.Hour),
+		Genres:          []string{"art pop", "electronica"},
+	}
+
+	weekly := func(e *models.Event) { e.Recurrence = &models.Recurrence{RRule: "FREQ=WEEKLY"} }
+
+	tests := []struct {
+		name     string
+		query    models.Query
+		modify   func(e *models.Event)
+		expected bool
+	}{
+		{"empty query", models.Query{}, nil, true},
+		{"title", models.Query{Title: "björk"}, nil, true},
+		{"normalized title", models.Query{Title: "bjork"}, nil, true},
+		{"wrong title", models.Query{Title: "bjarne"}, nil, false},
+		{"location and type", models.Query{Location: "fabrik", Type: "CONCERT"}, nil, true},
+		{"city", models.Query{City: "zür"}, nil, true},
+		{"wrong city without radius", models.Query{City: "Bern", Radius: 0}, nil, false},
+		{"genres", models.Query{Genres: []string{"jazz", "electronica"}}, nil, true},
+		{"wrong genres", models.Query{Genres: []string{"jazz"}}, nil, false},
+		{"all genres", models.Query{Genres: []string{"art pop", "electronica"}, GenreMode: models.GenreModeAll}, nil, true},
+		{"not all genres", models.Query{Genres: []string{"art pop", "jazz"}, GenreMode: models.GenreModeAll}, nil, false},
+		{"excluded genre", models.Query{ExcludeGenres: []string{"electronica"}}, nil, false},
+		{"excluded type", models.Query{ExcludeTypes: []string{"concert"}}, nil, false},
+		{"other excluded type", models.Query{ExcludeTypes: []string{"festival"}}, nil, true},
+		{"excluded type in upper case", models.Query{ExcludeTypes: []string{"Concert"}}, nil, false},
+		{"genre without subgenres", models.Query{Genres: []string{"pop"}}, nil, false},
+		{"subgenres", models.Query{Genres: []string{"Pop"}, Subgenres: true}, nil, true},
+		{"no subgenre", models.Query{Genres: []string{"art"}, Subgenres: true}, func(e *models.Event) { e.Genres = []string{"artcore"} }, false},
+		{"filter", models.Query{Filter: `city = "Bern" or genres in ("art pop", "jazz")`}, nil, true},
+		{"wrong filter", models.Query{Filter: `not type = "concert"`}, nil, false},
+		{"filter with date of an occurrence", models.Query{StartDate: &now, Filter: `date = 2026-10-25`}, weekly, true},
+		{"filter with date without occurrence", models.Query{StartDate: &now, Filter: `date = 2026-10-24`}, weekly, false},
+		{"excluded subgenre", models.Query{Genres: []string{"pop"}, ExcludeGenres: []string{"art pop"}, Subgenres: true}, nil, false},
+		{"start date", models.Query{StartDate: &now}, nil, true},
+		{"past event", models.Query{StartDate: &tomorrow}, nil, false},
+		{"date window", models.Query{StartDate: &now, EndDate: &tomorrow}, nil, true},
+		{"pending event", models.Query{}, func(e *models.Event) { e.ModerationStatus = moderation.StatusPending }, false},
+		{"approved event", models.Query{}, func(e *models.Event) { e.ModerationStatus = moderation.StatusApproved }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := shared.NewEventMatcher(context.Background(), tt.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			e := event
+			if tt.modify != nil {
+				tt.modify(&e)
+			}
+			if got := m.Match(e); got != tt.expected {
+				t.Errorf("Match() = %v; want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEventMatcherInvalidQuery(t *testing.T) {
+	if _, err := shared.NewEventMatcher(context.Background(), models.Query{GenreMode: "some"}); err == nil {
+		t.Error("expected an error for an invalid genre mode")
+	}
+	if _, err := shared.NewEventMatcher(context.Background(), models.Query{Filter: `city = Bern`}); err == nil {
+		t.Error("expected an error for an invalid filter")
+	}
+}
@@ -1,20 +1,26 @@
 package shared
 
 import (
+	"cmp"
 	"context"
-	"errors"
 	"fmt"
+	"log/slog"
 	"math"
-	"regexp"
+	"reflect"
+	"slices"
+	"strings"
 	"time"
 	"unicode"
 
 	"github.com/jakopako/event-api/config"
-	"github.com/jakopako/event-api/geo"
 	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/moderation"
+	"github.com/jakopako/event-api/popularity"
+	"github.com/jakopako/event-api/recurrence"
+	"github.com/jakopako/event-api/search"
+	"github.com/jakopako/event-api/storage"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/text/runes"
 	"golang.org/x/text/transform"
 	"golang.org/x/text/unicode/norm"
@@ -22,6 +28,7 @@ import (
 
 const (
 	EventCollectionName         = "events"
+	ArchiveCollectionName       = "eventsArchive"
 	NotificationCollectionName  = "notifications"
 	ScraperStatusCollectionName = "status"
 )
@@ -36,156 +43,496 @@ func RemoveDiacritics(s string) string {
 	return result
 }
 
-func FetchEvents(q models.Query) ([]models.Event, int64, int64, error) {
-	eventCollection := config.MI.DB.Collection(EventCollectionName)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// NewEventKey returns the key that identifies the given event as it was sent by a scraper.
+func NewEventKey(e models.Event) models.EventKey {
+	return models.EventKey{
+		Title:     e.Title,
+		Date:      e.Date,
+		Location:  e.Location,
+		URL:       e.URL,
+		SourceURL: e.SourceURL,
+	}
+}
 
-	var events []models.Event
+// UpsertKey returns the key by which the event replaces a stored event, its source key
+// or, if it has none, the key of its fields.
+func UpsertKey(e models.Event) models.EventKey {
+	if e.SourceKey != nil {
+		return *e.SourceKey
+	}
+	return NewEventKey(e)
+}
 
-	if q.Page < 1 {
-		return events, 0, 0, errors.New("page parameter must be greater than 0")
+// EventKeyString returns a string representation of the given key that can be used
+// as a map key. Dates are compared with millisecond precision, the same as in the DB.
+func EventKeyString(k models.EventKey) string {
+	return fmt.Sprintf("%s|%d|%s|%s|%s", k.Title, k.Date.UnixMilli(), k.Location, k.URL, k.SourceURL)
+}
+
+// ApplyOverrides overwrites the fields of the given event with its manual overrides, if any.
+func ApplyOverrides(e *models.Event) {
+	o := e.Overrides
+	if o == nil {
+		return
 	}
-	if q.Limit < 1 {
-		return events, 0, 0, errors.New("limit parameter must be greater than 0")
+	if o.Title != nil {
+		e.Title = *o.Title
+		e.NormalizedTitle = RemoveDiacritics(e.Title)
 	}
-	if q.Radius < 0 {
-		return events, 0, 0, errors.New("radius parameter must be greater than or equal to 0")
+	if o.Location != nil {
+		e.Location = *o.Location
+	}
+	if o.City != nil {
+		e.City = *o.City
+	}
+	if o.State != nil {
+		e.State = *o.State
+	}
+	if o.Country != nil {
+		e.Country = *o.Country
+	}
+	if o.Type != nil {
+		e.Type = strings.ToLower(*o.Type)
+	}
+	if o.ImageURL != nil {
+		e.ImageURL = *o.ImageURL
+	}
+	if o.Comment != nil {
+		e.Comment = *o.Comment
+	}
+	if o.Genres != nil {
+		e.Genres = *o.Genres
+	}
+	if o.Address != nil {
+		e.Address = *o.Address
 	}
+}
 
-	var filter primitive.M
-	if q.StartDate != nil {
-		if q.EndDate == nil {
-			filter = bson.M{
-				"$and": []bson.M{
-					{
-						"date": bson.M{
-							"$gt": q.StartDate,
-						},
-					},
-				},
-			}
-		} else {
-			filter = bson.M{
-				"$and": []bson.M{
-					{
-						"date": bson.M{
-							"$gte": q.StartDate,
-						},
-					},
-					{
-						"date": bson.M{
-							"$lte": q.EndDate,
-						},
-					},
-				},
+// EventChanged checks whether the content of an event differs between two versions of it.
+// Fields that are managed by the database or are not stored are ignored.
+func EventChanged(a, b models.Event) bool {
+	normalize := func(e models.Event) models.Event {
+		e.ID = primitive.NilObjectID
+		e.SourceKey = nil
+		e.GenresText = ""
+		e.Date = normalizeTime(e.Date)
+		if len(e.Genres) == 0 {
+			e.Genres = nil
+		}
+		if e.Overrides != nil {
+			o := *e.Overrides
+			o.UpdatedAt = normalizeTime(o.UpdatedAt)
+			e.Overrides = &o
+		}
+		if e.Recurrence != nil {
+			r := *e.Recurrence
+			r.ExDates = normalizeTimes(r.ExDates)
+			r.Cancelled = normalizeTimes(r.Cancelled)
+			if r.LastDate != nil {
+				last := normalizeTime(*r.LastDate)
+				r.LastDate = &last
 			}
+			e.Recurrence = &r
 		}
-	} else {
-		filter = bson.M{
-			"$and": []bson.M{},
-		}
-	}
-
-	findOptions := options.Find()
-	findOptions.SetSort(bson.D{{Key: "date", Value: 1}})
-
-	// Special handling for title to include normalized search
-	if q.Title != "" {
-		normalizedTitle := RemoveDiacritics(q.Title)
-		filter["$and"] = append(filter["$and"].([]bson.M), bson.M{
-			"$or": []bson.M{
-				{
-					"title": bson.M{
-						"$regex": primitive.Regex{
-							Pattern: regexp.QuoteMeta(q.Title),
-							Options: "i",
-						},
-					},
-				},
-				{
-					"normalizedTitle": bson.M{
-						"$regex": primitive.Regex{
-							Pattern: regexp.QuoteMeta(normalizedTitle),
-							Options: "i",
-						},
-					},
+		if e.Popularity != nil {
+			p := *e.Popularity
+			p.LastInteraction = normalizeTime(p.LastInteraction)
+			e.Popularity = &p
+		}
+		return e
+	}
+	return !reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+// normalizeTime returns t the way it is read back from the database.
+func normalizeTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Millisecond)
+}
+
+func normalizeTimes(ts []time.Time) []time.Time {
+	if len(ts) == 0 {
+		return nil
+	}
+	normalized := make([]time.Time, len(ts))
+	for i, t := range ts {
+		normalized[i] = normalizeTime(t)
+	}
+	return normalized
+}
+
+// VisibleEventsFilter returns a filter that excludes events that are held back or rejected by moderation.
+func VisibleEventsFilter() bson.M {
+	return bson.M{"moderationStatus": bson.M{"$nin": moderation.HiddenStatuses}}
+}
+
+// IsVisible checks whether the event matches VisibleEventsFilter.
+func IsVisible(e models.Event) bool {
+	return !slices.Contains(moderation.HiddenStatuses, e.ModerationStatus)
+}
+
+// UpcomingEventsFilter returns a filter that matches events after the given date,
+// including recurring events that started before but still have upcoming occurrences.
+func UpcomingEventsFilter(since time.Time) bson.M {
+	return bson.M{
+		"$or": []bson.M{
+			{
+				"date": bson.M{
+					"$gt": since,
 				},
 			},
-		})
+			{
+				"recurrence":          bson.M{"$exists": true},
+				"recurrence.lastDate": bson.M{"$not": bson.M{"$lt": since}},
+			},
+		},
 	}
+}
 
-	for searchKey, searchValue := range map[string]string{"location": q.Location, "country": q.Country, "type": q.Type} {
-		if searchValue != "" {
-			filter["$and"] = append(filter["$and"].([]bson.M), bson.M{
-				searchKey: bson.M{
-					"$regex": primitive.Regex{
-						Pattern: regexp.QuoteMeta(searchValue),
-						Options: "i",
-					},
-				},
-			})
+// IsUpcoming checks whether the event matches UpcomingEventsFilter.
+func IsUpcoming(e models.Event, since time.Time) bool {
+	if e.Date.After(since) {
+		return true
+	}
+	return e.Recurrence != nil && (e.Recurrence.LastDate == nil || !e.Recurrence.LastDate.Before(since))
+}
+
+// FetchDistinct returns all distinct values of the given field, the most frequent first.
+// Past events are not considered.
+func FetchDistinct(ctx context.Context, field string) ([]string, error) {
+	d := time.Now()
+	today := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, d.Location())
+	counted, err := CountDistinct(ctx, models.Query{StartDate: &today}, field)
+	if err != nil {
+		return nil, err
+	}
+
+	distinctValues := []string{}
+	for _, v := range counted {
+		distinctValues = append(distinctValues, v.Value)
+	}
+	return distinctValues, nil
+}
+
+// DistinctFields are the fields of events whose distinct values can be counted.
+var DistinctFields = []string{"location", "city", "state", "country", "type", "genres", "sourceUrl"}
+
+// CountDistinct returns the distinct values of the given field among the events matching
+// the query, with the number of events per value, the most frequent first. Every occurrence
+// of a recurring event counts, like in the results of FetchEvents.
+func CountDistinct(ctx context.Context, q models.Query, field string) ([]models.DistinctValue, error) {
+	if !slices.Contains(DistinctFields, field) {
+		return nil, fmt.Errorf("field must be one of %s", strings.Join(DistinctFields, ", "))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.C.Storage.Timeout)
+	defer cancel()
+
+	expr, err := search.Parse(q.Filter)
+	if err != nil {
+		return nil, err
+	}
+	f := storage.EventFilter{Query: q, Expr: expr}
+	counts, err := countValues(ctx, f, []string{field})
+	if err != nil {
+		return nil, err
+	}
+
+	values := []models.DistinctValue{}
+	for v, c := range counts[field] {
+		if v != "" {
+			values = append(values, models.DistinctValue{Value: v, Count: c})
 		}
 	}
+	slices.SortFunc(values, func(a, b models.DistinctValue) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Value, b.Value)
+	})
+	return values, nil
+}
 
-	if len(q.Genres) > 0 {
-		filter["$and"] = append(filter["$and"].([]bson.M), bson.M{
-			"genres": bson.M{
-				"$in": q.Genres,
-			},
-		})
-	}
-
-	if q.City != "" {
-		cityFilter := bson.M{
-			"$or": []bson.M{
-				{
-					"city": bson.M{
-						"$regex": primitive.Regex{
-							Pattern: q.City,
-							Options: "i",
-						},
-					},
-				},
-			},
+// countValues counts the events matching the filter per value of each of the given keys,
+// including every occurrence of the recurring events.
+func countValues(ctx context.Context, f storage.EventFilter, keys []string) (map[string]map[string]int64, error) {
+	counts := map[string]map[string]int64{}
+	for _, key := range keys {
+		counts[key] = map[string]int64{}
+	}
+	for _, repo := range eventRepositories(f.Query) {
+		c, err := repo.CountValues(ctx, f, keys)
+		if err != nil {
+			return nil, err
 		}
-		if q.Radius > 0 {
-			// near in or not supported: https://jira.mongodb.org/browse/SERVER-13974
-			if geolocs, err := geo.AllMatchesCityCoordinates(q.City, q.Country); err == nil && len(geolocs) > 0 {
-				earthRadiusKm := 6378.1
-				radiusFilter := bson.D{
-					{Key: "address.geolocation", Value: bson.D{
-						{Key: "$geoWithin", Value: bson.D{ // we need to use geoWithin for CountDocuments to properly work, see https://www.mongodb.com/docs/manual/reference/method/db.collection.countDocuments/#query-restrictions
-							{Key: "$centerSphere", Value: bson.A{geolocs[0].Coordinates, float64(q.Radius) / earthRadiusKm}},
-						}},
-					}},
-				}
-				cityFilter["$or"] = append(cityFilter["$or"].([]bson.M), radiusFilter.Map())
+		for key, values := range c {
+			for v, n := range values {
+				counts[key][v] += n
 			}
 		}
-		filter["$and"] = append(filter["$and"].([]bson.M), cityFilter)
 	}
+	occurrences, err := fetchOccurrences(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range occurrences {
+		for _, key := range keys {
+			for _, v := range EventValues(o, key) {
+				counts[key][v]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+// EventValues returns the values of the event that are counted for the key, see
+// storage.EventRepository.CountValues.
+func EventValues(e models.Event, key string) []string {
+	switch key {
+	case IntervalDay, IntervalWeek, IntervalMonth:
+		return []string{IntervalKey(e.Date, e.Offset, key)}
+	case "location":
+		return []string{e.Location}
+	case "city":
+		return []string{e.City}
+	case "state":
+		return []string{e.State}
+	case "country":
+		return []string{e.Country}
+	case "type":
+		return []string{e.Type}
+	case "genres":
+		// genres that are listed twice count once
+		return slices.Compact(slices.Sorted(slices.Values(e.Genres)))
+	case "sourceUrl":
+		return []string{e.SourceURL}
+	}
+	return nil
+}
 
-	total, _ := eventCollection.CountDocuments(ctx, filter)
+// ValidateFilters checks the parameters of the query that filter events.
+func ValidateFilters(q models.Query) error {
+	if q.Radius < 0 {
+		return invalidQuery("radius parameter must be greater than or equal to 0")
+	}
+	if q.GenreMode != "" && q.GenreMode != models.GenreModeAny && q.GenreMode != models.GenreModeAll {
+		return invalidQuery("genreMode parameter must be %s or %s", models.GenreModeAny, models.GenreModeAll)
+	}
+	if _, err := search.Parse(q.Filter); err != nil {
+		return &InvalidQueryError{Err: err}
+	}
+	return nil
+}
+
+// InvalidQueryError is returned for invalid parameters of a query, as opposed to the
+// errors of the store.
+type InvalidQueryError struct {
+	Err error
+}
+
+func (e *InvalidQueryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InvalidQueryError) Unwrap() error {
+	return e.Err
+}
+
+func invalidQuery(format string, a ...any) error {
+	return &InvalidQueryError{Err: fmt.Errorf(format, a...)}
+}
 
-	findOptions.SetSkip((int64(q.Page) - 1) * q.Limit)
-	findOptions.SetLimit(q.Limit)
+// MaxMergedEvents is the number of events that can be paged through if past events are
+// included, since all events up to the requested page are fetched from the events and the
+// archive to merge them then.
+const MaxMergedEvents = 1000
 
-	cursor, err := eventCollection.Find(ctx, filter, findOptions)
+// FetchEvents returns a page of events matching the query, sorted by date or by trend.
+// Recurring events are expanded to their occurrences within the date window of the query.
+// If past events are included, only the pages within the first MaxMergedEvents events can
+// be fetched.
+func FetchEvents(ctx context.Context, q models.Query) ([]models.Event, int64, int64, error) {
+	return FetchEventsWithFilter(ctx, q, nil)
+}
+
+// FetchEventsWithFilter is like FetchEvents but only returns the events of the follows, if
+// they are set.
+func FetchEventsWithFilter(ctx context.Context, q models.Query, follows *storage.Follows) ([]models.Event, int64, int64, error) {
+	var events []models.Event
+
+	if q.Page < 1 {
+		return events, 0, 0, invalidQuery("page parameter must be greater than 0")
+	}
+	if q.Limit < 1 {
+		return events, 0, 0, invalidQuery("limit parameter must be greater than 0")
+	}
+	if err := ValidateFilters(q); err != nil {
+		return events, 0, 0, err
+	}
+	expr, err := search.Parse(q.Filter)
+	if err != nil {
+		return events, 0, 0, &InvalidQueryError{Err: err}
+	}
+	if q.Sort != "" && q.Sort != models.SortDate && q.Sort != models.SortTrending {
+		return events, 0, 0, invalidQuery("sort parameter must be %s or %s", models.SortDate, models.SortTrending)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.C.Storage.Timeout)
+	defer cancel()
+
+	f := storage.EventFilter{Query: q, Expr: expr, Follows: follows}
+	occurrences, err := fetchOccurrences(ctx, f)
 	if err != nil {
 		return events, 0, 0, fmt.Errorf("events not found: %v", err)
 	}
-	defer cursor.Close(ctx)
 
-	for cursor.Next(ctx) {
-		var event models.Event
-		cursor.Decode(&event)
-		events = append(events, event)
+	now := time.Now()
+	compare := func(a, b models.Event) int { return a.Date.Compare(b.Date) }
+	if q.Sort == models.SortTrending {
+		compare = func(a, b models.Event) int {
+			if c := cmp.Compare(popularity.SortKey(b, now), popularity.SortKey(a, now)); c != 0 {
+				return c
+			}
+			return a.Date.Compare(b.Date)
+		}
+	}
+	slices.SortStableFunc(occurrences, compare)
+
+	repos := eventRepositories(q)
+	skip := (int64(q.Page) - 1) * q.Limit
+	var total int64
+	if len(repos) == 1 {
+		events, total, err = fetchPage(ctx, repos[0], f, occurrences, skip, q.Limit, compare)
+		if err != nil {
+			return events, 0, 0, fmt.Errorf("events not found: %v", err)
+		}
+	} else {
+		// the archived events are merged with the other events, so all events up to the
+		// requested page are needed from every repository
+		if skip+q.Limit > MaxMergedEvents {
+			return events, 0, 0, invalidQuery("only the first %d events can be paged through if past events are included, narrow down the query", MaxMergedEvents)
+		}
+		for _, repo := range repos {
+			found, n, err := repo.Find(ctx, f, 0, skip+q.Limit)
+			if err != nil {
+				return events, 0, 0, fmt.Errorf("events not found: %v", err)
+			}
+			events = append(events, found...)
+			total += n
+		}
+		slices.SortStableFunc(events, compare)
+		events = merge(events, occurrences, compare)
+		events = events[min(skip, int64(len(events))):min(skip+q.Limit, int64(len(events)))]
+		total += int64(len(occurrences))
 	}
 
 	last := int64(math.Ceil(float64(total) / float64(q.Limit)))
 	if last < 1 && total > 0 {
 		last = 1
 	}
+	if len(repos) > 1 {
+		last = min(last, max(MaxMergedEvents/q.Limit, 1))
+	}
 	return events, total, last, nil
 }
+
+// fetchPage returns the page of the events of the repository merged with the occurrences,
+// which are sorted like the events, and the total number of events. Since at most all
+// occurrences precede an event of the page, only the stored events from skip minus the
+// number of occurrences on are fetched.
+func fetchPage(ctx context.Context, repo storage.EventRepository, f storage.EventFilter, occurrences []models.Event, skip, limit int64, compare func(a, b models.Event) int) ([]models.Event, int64, error) {
+	start := max(skip-int64(len(occurrences)), 0)
+	found, n, err := repo.Find(ctx, f, start, skip+limit-start)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(found) == 0 && start > 0 {
+		// the page is behind the stored events, the last one of them tells which
+		// occurrences follow them
+		start = max(n-1, 0)
+		if found, n, err = repo.Find(ctx, f, start, skip+limit-start); err != nil {
+			return nil, 0, err
+		}
+	}
+	// the stored events before start precede the fetched events, so the merged events are
+	// at position start and later, except for the occurrences that precede the events
+	// before start, which come first and are before the page
+	events := merge(found, occurrences, compare)
+	offset := skip - start
+	events = events[min(offset, int64(len(events))):min(offset+limit, int64(len(events)))]
+	return events, n + int64(len(occurrences)), nil
+}
+
+// merge merges the sorted events and occurrences. Events precede occurrences that are
+// equal to them.
+func merge(events, occurrences []models.Event, compare func(a, b models.Event) int) []models.Event {
+	merged := make([]models.Event, 0, len(events)+len(occurrences))
+	i, j := 0, 0
+	for i < len(events) && j < len(occurrences) {
+		if compare(occurrences[j], events[i]) < 0 {
+			merged = append(merged, occurrences[j])
+			j++
+		} else {
+			merged = append(merged, events[i])
+			i++
+		}
+	}
+	merged = append(merged, events[i:]...)
+	return append(merged, occurrences[j:]...)
+}
+
+// eventRepositories returns the repositories of the events that are searched for the
+// query, the archive only if the query includes past events.
+func eventRepositories(q models.Query) []storage.EventRepository {
+	if q.IncludePast {
+		return []storage.EventRepository{storage.S.Events, storage.S.Archive}
+	}
+	return []storage.EventRepository{storage.S.Events}
+}
+
+// fetchOccurrences returns the occurrences of all recurring events that match the
+// filter within the date window of its query.
+func fetchOccurrences(ctx context.Context, f storage.EventFilter) ([]models.Event, error) {
+	q := f.Query
+	from := time.Time{}
+	if q.StartDate != nil {
+		from = *q.StartDate
+	}
+	var to time.Time
+	if q.EndDate != nil {
+		to = *q.EndDate
+	} else if q.StartDate != nil {
+		to = q.StartDate.Add(recurrence.Horizon)
+	} else {
+		to = time.Now().Add(recurrence.Horizon)
+	}
+
+	var series []models.Event
+	for _, repo := range eventRepositories(q) {
+		found, err := repo.FindSeries(ctx, f, from, to)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, found...)
+	}
+
+	var occurrences []models.Event
+	for _, e := range series {
+		expanded, err := recurrence.Expand(e, from, to)
+		if err != nil {
+			slog.Warn("failed to expand recurring event", "id", e.ID.Hex(), "err", err)
+			continue
+		}
+		for _, o := range expanded {
+			// like for other events the start date is exclusive if there is no end date
+			if q.StartDate != nil && q.EndDate == nil && !o.Date.After(*q.StartDate) {
+				continue
+			}
+			// conditions on the date can only be checked for single occurrences
+			if f.Expr != nil && !f.Expr.Match(o) {
+				continue
+			}
+			occurrences = append(occurrences, o)
+		}
+	}
+	return occurrences, nil
+}
@@ -2,7 +2,10 @@ package shared_test
 
 import (
 	"testing"
+	"time"
 
+	"github.com/go-test/deep"
+	"github.com/jakopako/event-api/models"
 	"github.com/jakopako/event-api/shared"
 )
 
@@ -36,3 +39,73 @@ func TestRemoveDiacritics(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyOverrides(t *testing.T) {
+	title := "Ásgeir Live"
+	genres := []string{"folk"}
+	event := models.Event{
+		Title:  "asgeir",
+		City:   "Zurich",
+		Type:   "concert",
+		Genres: []string{"pop"},
+		Overrides: &models.EventOverrides{
+			Title:  &title,
+			Genres: &genres,
+		},
+	}
+
+	shared.ApplyOverrides(&event)
+
+	if event.Title != title {
+		t.Errorf("expected title %q, got %q", title, event.Title)
+	}
+	if event.NormalizedTitle != "Asgeir Live" {
+		t.Errorf("expected normalized title %q, got %q", "Asgeir Live", event.NormalizedTitle)
+	}
+	if diff := deep.Equal(genres, event.Genres); diff != nil {
+		t.Errorf("unexpected genres. diff: %v", diff)
+	}
+	if event.City != "Zurich" || event.Type != "concert" {
+		t.Errorf("fields without overrides must not change, got city %q and type %q", event.City, event.Type)
+	}
+}
+
+func TestEventKeyString(t *testing.T) {
+	d := time.Date(2026, 10, 31, 20, 0, 0, 0, time.FixedZone("CET", 3600))
+	e := models.Event{Title: "t", Date: d, Location: "l", URL: "u", SourceURL: "s"}
+	k1 := shared.EventKeyString(shared.NewEventKey(e))
+	e.Date = d.UTC()
+	k2 := shared.EventKeyString(shared.NewEventKey(e))
+	if k1 != k2 {
+		t.Errorf("keys of the same instant in different time zones must be equal, got %q and %q", k1, k2)
+	}
+}
+
+func TestEventChanged(t *testing.T) {
+	d := time.Date(2026, 10, 31, 20, 0, 0, 123456789, time.FixedZone("CET", 3600))
+	stored := models.Event{Title: "t", Date: d.UTC().Truncate(time.Millisecond), Genres: nil, SourceKey: &models.EventKey{Title: "t"}}
+	scraped := models.Event{Title: "t", Date: d, Genres: []string{}, GenresText: "some text"}
+	if shared.EventChanged(stored, scraped) {
+		t.Errorf("expected events to be equal")
+	}
+	scraped.Comment = "new comment"
+	if !shared.EventChanged(stored, scraped) {
+		t.Errorf("expected events to differ")
+	}
+
+	// all dates of recurring events are compared in UTC
+	last := d.AddDate(0, 1, 0)
+	storedLast := last.UTC().Truncate(time.Millisecond)
+	stored.Recurrence = &models.Recurrence{RRule: "FREQ=WEEKLY", ExDates: []time.Time{d.UTC().Truncate(time.Millisecond)}, LastDate: &storedLast}
+	stored.Popularity = &models.Popularity{Views: 1, LastInteraction: d.UTC().Truncate(time.Millisecond)}
+	scraped = models.Event{Title: "t", Date: d}
+	scraped.Recurrence = &models.Recurrence{RRule: "FREQ=WEEKLY", ExDates: []time.Time{d}, Cancelled: []time.Time{}, LastDate: &last}
+	scraped.Popularity = &models.Popularity{Views: 1, LastInteraction: d}
+	if shared.EventChanged(stored, scraped) {
+		t.Errorf("expected recurring events to be equal")
+	}
+	scraped.Recurrence.ExDates = append(scraped.Recurrence.ExDates, d.AddDate(0, 0, 7))
+	if !shared.EventChanged(stored, scraped) {
+		t.Errorf("expected recurring events to differ")
+	}
+}
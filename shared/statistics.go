@@ -0,0 +1,125 @@
+package shared
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/search"
+	"github.com/jakopako/event-api/storage"
+)
+
+// The intervals by which events are counted, see IntervalKey.
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+// MaxHistoryMonths limits the number of past months of the statistics.
+const MaxHistoryMonths = 120
+
+// IntervalKey returns the day (2026-11-01), the ISO week (2026-W44) or the month (2026-11)
+// of the date in the time zone of the event with the given offset.
+func IntervalKey(date time.Time, offset int, interval string) string {
+	local := date.UTC().Add(time.Duration(offset) * time.Second)
+	if interval == IntervalWeek {
+		year, week := local.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	if interval == IntervalMonth {
+		return local.Format("2006-01")
+	}
+	return local.Format(time.DateOnly)
+}
+
+// FetchStatistics counts the events matching the query per day or week, city, genre and
+// source, the cities, genres and sources with the most events first and at most q.Limit of
+// them. The history contains the number of events per month of the given number of past
+// months, regardless of the date of the query, and includes archived events. Every
+// occurrence of a recurring event counts. Invalid parameters cause an InvalidQueryError.
+func FetchStatistics(ctx context.Context, q models.Query, interval string, months int) (models.Statistics, error) {
+	var stats models.Statistics
+	if interval != IntervalDay && interval != IntervalWeek {
+		return stats, invalidQuery("interval parameter must be %s or %s", IntervalDay, IntervalWeek)
+	}
+	if months < 0 || months > MaxHistoryMonths {
+		return stats, invalidQuery("months parameter must be between 0 and %d", MaxHistoryMonths)
+	}
+	if q.Limit < 1 {
+		return stats, invalidQuery("limit parameter must be greater than 0")
+	}
+	if err := ValidateFilters(q); err != nil {
+		return stats, err
+	}
+	expr, err := search.Parse(q.Filter)
+	if err != nil {
+		return stats, &InvalidQueryError{Err: err}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	f := storage.EventFilter{Query: q, Expr: expr}
+	counts, err := countValues(ctx, f, []string{interval, "city", "genres", "sourceUrl"})
+	if err != nil {
+		return stats, err
+	}
+	for _, c := range counts[interval] {
+		stats.Total += c
+	}
+	stats.Calendar = sortedByValue(counts[interval])
+	stats.Cities = sortedByCount(counts["city"], q.Limit)
+	stats.Genres = sortedByCount(counts["genres"], q.Limit)
+	stats.Sources = sortedByCount(counts["sourceUrl"], q.Limit)
+
+	stats.History, err = fetchHistory(ctx, f, months)
+	return stats, err
+}
+
+// fetchHistory counts the events matching the filter per month of the given number of
+// past months. Months without events are included.
+func fetchHistory(ctx context.Context, f storage.EventFilter, months int) ([]models.DistinctValue, error) {
+	history := []models.DistinctValue{}
+	if months == 0 {
+		return history, nil
+	}
+	now := time.Now().UTC()
+	end := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	start := end.AddDate(0, -months, 0)
+	// the end date of the query is inclusive
+	last := end.Add(-time.Nanosecond)
+	f.Query.StartDate, f.Query.EndDate = &start, &last
+	f.Query.IncludePast = true
+
+	counts, err := countValues(ctx, f, []string{IntervalMonth})
+	if err != nil {
+		return nil, err
+	}
+	for m := start; m.Before(end); m = m.AddDate(0, 1, 0) {
+		key := IntervalKey(m, 0, IntervalMonth)
+		history = append(history, models.DistinctValue{Value: key, Count: counts[IntervalMonth][key]})
+	}
+	return history, nil
+}
+
+// sortedByValue returns the counts ordered by their values.
+func sortedByValue(counts map[string]int64) []models.DistinctValue {
+	values := []models.DistinctValue{}
+	for v, c := range counts {
+		values = append(values, models.DistinctValue{Value: v, Count: c})
+	}
+	slices.SortFunc(values, func(a, b models.DistinctValue) int { return cmp.Compare(a.Value, b.Value) })
+	return values
+}
+
+// sortedByCount returns at most limit counts, the highest first. Empty values are left out.
+func sortedByCount(counts map[string]int64, limit int64) []models.DistinctValue {
+	delete(counts, "")
+	values := sortedByValue(counts)
+	slices.SortStableFunc(values, func(a, b models.DistinctValue) int { return cmp.Compare(b.Count, a.Count) })
+	return values[:min(int64(len(values)), limit)]
+}
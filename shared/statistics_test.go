@@ -0,0 +1,34 @@
+package shared_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jakopako/event-api/shared"
+)
+
+func TestIntervalKey(t *testing.T) {
+	// a Sunday evening in Zurich, which is already Monday in UTC+14
+	date := time.Date(2026, 11, 1, 22, 30, 0, 0, time.UTC)
+	tests := []struct {
+		offset   int
+		interval string
+		expected string
+	}{
+		{0, shared.IntervalDay, "2026-11-01"},
+		{3600, shared.IntervalDay, "2026-11-01"},
+		{14 * 3600, shared.IntervalDay, "2026-11-02"},
+		{-11 * 3600, shared.IntervalDay, "2026-11-01"},
+		{0, shared.IntervalWeek, "2026-W44"},
+		{14 * 3600, shared.IntervalWeek, "2026-W45"},
+	}
+	for _, tt := range tests {
+		if got := shared.IntervalKey(date, tt.offset, tt.interval); got != tt.expected {
+			t.Errorf("IntervalKey(%v, %d, %s) = %s, expected %s", date, tt.offset, tt.interval, got, tt.expected)
+		}
+	}
+	// the week of the first days of a year can belong to the previous year
+	if got := shared.IntervalKey(time.Date(2027, 1, 1, 12, 0, 0, 0, time.UTC), 0, shared.IntervalWeek); got != "2026-W53" {
+		t.Errorf("IntervalKey() = %s, expected 2026-W53", got)
+	}
+}
@@ -0,0 +1,189 @@
+// Package similar recommends upcoming events that are similar to a given event.
+// Similarity is computed in-process from the genres, the artists in the title,
+// the distance between the venues and the distance between the dates.
+package similar
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jakopako/event-api/geo"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/recurrence"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+)
+
+const (
+	// RadiusKm is the radius around the venue of the event in which other events are considered.
+	RadiusKm = 50
+	// MaxCandidates limits the number of events that are scored.
+	MaxCandidates = 500
+
+	genreWeight     = 0.4
+	artistWeight    = 0.3
+	proximityWeight = 0.2
+	dateWeight      = 0.1
+
+	// the distance and the number of days at which proximity and date closeness are halved
+	proximityHalfKm  = 10
+	dateHalfLifeDays = 14
+)
+
+// artistSeparator splits event titles like "Artist A & Artist B feat. C" into artists.
+var artistSeparator = regexp.MustCompile(`(?i)\s*(?:,|&|\+|/|\||\bx\b|\band\b|\bund\b|\bfeat\.?|\bft\.?|\bw/|\bwith\b|\bsupport:?|:|\s-\s)\s*`)
+
+// ArtistNames returns the names of the artists in the title of an event as they are written.
+func ArtistNames(title string) []string {
+	var names []string
+	for _, a := range artistSeparator.Split(title, -1) {
+		a = strings.TrimSpace(a)
+		// very short parts are rather words like "DJ" or numbers than artists
+		if len([]rune(a)) >= 3 {
+			names = append(names, a)
+		}
+	}
+	return names
+}
+
+// Artists returns the normalized names of the artists in the title of an event.
+func Artists(title string) []string {
+	var artists []string
+	for _, a := range ArtistNames(title) {
+		a = strings.ToLower(shared.RemoveDiacritics(a))
+		if !slices.Contains(artists, a) {
+			artists = append(artists, a)
+		}
+	}
+	return artists
+}
+
+// Score returns how similar the candidate is to the event, between 0 and 1. Events that
+// share neither genres, artists nor the venue or its surroundings have a score of 0.
+func Score(e, candidate models.Event) float64 {
+	genres := jaccard(e.Genres, candidate.Genres)
+	artists := jaccard(Artists(e.Title), Artists(candidate.Title))
+
+	proximity := 0.0
+	if e.Location != "" && strings.EqualFold(e.Location, candidate.Location) && strings.EqualFold(e.City, candidate.City) {
+		proximity = 1
+	} else if d := geo.DistanceKm(e.Address.Geolocacation.Coordinates, candidate.Address.Geolocacation.Coordinates); d <= RadiusKm {
+		proximity = math.Exp2(-d / proximityHalfKm)
+	}
+
+	if genres == 0 && artists == 0 && proximity == 0 {
+		return 0
+	}
+	days := math.Abs(candidate.Date.Sub(e.Date).Hours()) / 24
+	date := math.Exp2(-days / dateHalfLifeDays)
+
+	return genreWeight*genres + artistWeight*artists + proximityWeight*proximity + dateWeight*date
+}
+
+// Rank returns at most limit candidates with a positive score, the most similar first.
+// Candidates with the same id as the event are skipped.
+func Rank(e models.Event, candidates []models.Event, limit int) []models.Event {
+	type scored struct {
+		event models.Event
+		score float64
+	}
+	var ranked []scored
+	for _, c := range candidates {
+		if c.ID == e.ID && !e.ID.IsZero() {
+			continue
+		}
+		if s := Score(e, c); s > 0 {
+			ranked = append(ranked, scored{c, s})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].event.Date.Before(ranked[j].event.Date)
+	})
+
+	events := []models.Event{}
+	for _, r := range ranked[:min(limit, len(ranked))] {
+		events = append(events, r.event)
+	}
+	return events
+}
+
+// Find returns at most limit upcoming events that are similar to the given event.
+func Find(ctx context.Context, e models.Event, limit int) ([]models.Event, error) {
+	now := time.Now().UTC()
+
+	candidates, err := storage.S.Events.FindSimilar(ctx, storage.SimilarFilter{
+		ExcludeID:   e.ID,
+		Since:       now,
+		Genres:      e.Genres,
+		Artists:     Artists(e.Title),
+		Location:    e.Location,
+		City:        e.City,
+		Coordinates: e.Address.Geolocacation.Coordinates,
+		RadiusKm:    RadiusKm,
+	}, MaxCandidates)
+	if err != nil {
+		return nil, err
+	}
+
+	// recurring events are recommended with their next occurrence
+	upcoming := candidates[:0]
+	for _, c := range candidates {
+		if c.Recurrence != nil {
+			dates, err := recurrence.Between(c, now, now.Add(recurrence.Horizon))
+			if err != nil {
+				slog.Warn("failed to expand recurring event", "id", c.ID.Hex(), "err", err)
+				continue
+			}
+			if len(dates) == 0 {
+				continue
+			}
+			c.Date = dates[0]
+		}
+		upcoming = append(upcoming, c)
+	}
+
+	// the event itself might be an occurrence of a series in the past
+	if e.Recurrence != nil {
+		if dates, err := recurrence.Between(e, now, now.Add(recurrence.Horizon)); err == nil && len(dates) > 0 {
+			e.Date = dates[0]
+		}
+	}
+	return Rank(e, upcoming, limit), nil
+}
+
+// jaccard returns the size of the intersection divided by the size of the union
+// of both sets, ignoring case.
+func jaccard(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	set := map[string]bool{}
+	for _, s := range a {
+		set[strings.ToLower(s)] = true
+	}
+	union := len(set)
+	intersection := 0
+	seen := map[string]bool{}
+	for _, s := range b {
+		s = strings.ToLower(s)
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		if set[s] {
+			intersection++
+		} else {
+			union++
+		}
+	}
+	return float64(intersection) / float64(union)
+}
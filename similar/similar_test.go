@@ -0,0 +1,58 @@
+package similar_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/similar"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestArtists(t *testing.T) {
+	tests := []struct {
+		title    string
+		expected []string
+	}{
+		{"Bonobo", []string{"bonobo"}},
+		{"Ásgeir & Band feat. Sóley", []string{"asgeir", "band", "soley"}},
+		{"Jazz Night: Nils Petter Molvær, Eivind Aarset", []string{"jazz night", "nils petter molvær", "eivind aarset"}},
+		{"DJ X", nil},
+	}
+	for _, tt := range tests {
+		if got := similar.Artists(tt.title); !slices.Equal(got, tt.expected) {
+			t.Errorf("Artists(%q) = %v, expected %v", tt.title, got, tt.expected)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	d := time.Date(2024, 10, 3, 20, 0, 0, 0, time.UTC)
+	zurich := models.Address{Geolocacation: models.GeocodedLocation{MongoGeolocation: models.MongoGeolocation{Coordinates: []float64{8.5417, 47.3769}}}}
+	winterthur := models.Address{Geolocacation: models.GeocodedLocation{MongoGeolocation: models.MongoGeolocation{Coordinates: []float64{8.7241, 47.4988}}}}
+	bern := models.Address{Geolocacation: models.GeocodedLocation{MongoGeolocation: models.MongoGeolocation{Coordinates: []float64{7.4474, 46.9480}}}}
+
+	event := models.Event{ID: primitive.NewObjectID(), Title: "Bonobo", Location: "Moods", City: "Zurich", Date: d, Genres: []string{"electronic", "downtempo"}, Address: zurich}
+	candidates := []models.Event{
+		event,
+		{Title: "Unrelated", Location: "Bierhübeli", City: "Bern", Date: d, Genres: []string{"metal"}, Address: bern},
+		{Title: "Same Venue", Location: "Moods", City: "Zurich", Date: d.Add(60 * 24 * time.Hour), Genres: []string{"metal"}, Address: zurich},
+		{Title: "Bonobo", Location: "Bierhübeli", City: "Bern", Date: d.Add(24 * time.Hour), Genres: []string{"electronic", "downtempo"}, Address: bern},
+		{Title: "Nearby Electronic", Location: "Kraftfeld", City: "Winterthur", Date: d.Add(24 * time.Hour), Genres: []string{"electronic"}, Address: winterthur},
+		{Title: "Far Electronic", Location: "Bierhübeli", City: "Bern", Date: d.Add(24 * time.Hour), Genres: []string{"electronic"}, Address: bern},
+	}
+
+	var titles []string
+	for _, e := range similar.Rank(event, candidates, 4) {
+		titles = append(titles, e.Title+" "+e.City)
+	}
+	expected := []string{"Bonobo Bern", "Nearby Electronic Winterthur", "Far Electronic Bern", "Same Venue Zurich"}
+	if !slices.Equal(titles, expected) {
+		t.Errorf("expected %v, got %v", expected, titles)
+	}
+
+	if n := len(similar.Rank(event, candidates, 10)); n != 4 {
+		t.Errorf("unrelated events and the event itself should not be returned, got %d events", n)
+	}
+}
@@ -0,0 +1,36 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+)
+
+// archive keeps the events that have been moved out of from.
+type archive struct {
+	*events
+	from *events
+}
+
+func (r *archive) MoveBefore(ctx context.Context, before time.Time) (int64, error) {
+	archived := func(e models.Event) bool {
+		if e.Recurrence == nil {
+			return e.Date.Before(before)
+		}
+		return e.Recurrence.LastDate != nil && e.Recurrence.LastDate.Before(before)
+	}
+	r.from.mu.Lock()
+	defer r.from.mu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(r.from.events)
+	for _, e := range r.from.events {
+		if archived(e) {
+			r.events.events = append(r.events.events, e)
+		}
+	}
+	r.from.events = slices.DeleteFunc(r.from.events, archived)
+	return int64(n - len(r.from.events)), nil
+}
@@ -0,0 +1,191 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type backup struct {
+	events        *events
+	archive       *events
+	notifications *notifications
+	statuses      *statuses
+	cities        *cities
+	venues        *venues
+	artistGenres  *artistGenres
+}
+
+func (r *backup) Dump(ctx context.Context, kind string, fn func(doc bson.Raw) error) error {
+	var items []any
+	switch kind {
+	case storage.KindEvents, storage.KindArchive:
+		store := r.eventStore(kind)
+		store.mu.RLock()
+		for _, e := range store.events {
+			items = append(items, e)
+		}
+		store.mu.RUnlock()
+	case storage.KindVenues:
+		r.venues.mu.RLock()
+		for _, v := range r.venues.venues {
+			items = append(items, v)
+		}
+		r.venues.mu.RUnlock()
+	case storage.KindCities:
+		r.cities.mu.RLock()
+		for _, c := range r.cities.cities {
+			items = append(items, c)
+		}
+		r.cities.mu.RUnlock()
+	case storage.KindGenres:
+		r.artistGenres.mu.RLock()
+		for _, artist := range slices.Sorted(maps.Keys(r.artistGenres.genres)) {
+			items = append(items, models.TitleGenre{Title: artist, Genres: r.artistGenres.genres[artist]})
+		}
+		r.artistGenres.mu.RUnlock()
+	case storage.KindNotifications:
+		r.notifications.mu.RLock()
+		for _, n := range r.notifications.notifications {
+			items = append(items, n)
+		}
+		r.notifications.mu.RUnlock()
+	case storage.KindStatuses:
+		r.statuses.mu.RLock()
+		for _, name := range slices.Sorted(maps.Keys(r.statuses.statuses)) {
+			items = append(items, r.statuses.statuses[name])
+		}
+		r.statuses.mu.RUnlock()
+	default:
+		return fmt.Errorf("unknown kind %q", kind)
+	}
+	for _, item := range items {
+		doc, err := bson.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *backup) Restore(ctx context.Context, kind string, docs []bson.Raw) error {
+	switch kind {
+	case storage.KindEvents, storage.KindArchive:
+		store := r.eventStore(kind)
+		return restore(docs, &store.mu, &store.events, func(a, b models.Event) bool { return a.ID == b.ID })
+	case storage.KindVenues:
+		return restore(docs, &r.venues.mu, &r.venues.venues, func(a, b models.Venue) bool {
+			return a.Name == b.Name && a.Address.Locality == b.Address.Locality
+		})
+	case storage.KindCities:
+		return restore(docs, &r.cities.mu, &r.cities.cities, func(a, b models.City) bool {
+			return a.Name == b.Name && a.State == b.State && a.Country == b.Country
+		})
+	case storage.KindGenres:
+		var genres []models.TitleGenre
+		if err := unmarshalAll(docs, &genres); err != nil {
+			return err
+		}
+		r.artistGenres.mu.Lock()
+		defer r.artistGenres.mu.Unlock()
+		for _, g := range genres {
+			r.artistGenres.genres[g.Title] = g.Genres
+		}
+		return nil
+	case storage.KindNotifications:
+		return restore(docs, &r.notifications.mu, &r.notifications.notifications, func(a, b models.Notification) bool {
+			return a.Email == b.Email && a.Token == b.Token
+		})
+	case storage.KindStatuses:
+		var statuses []models.ScraperStatus
+		if err := unmarshalAll(docs, &statuses); err != nil {
+			return err
+		}
+		r.statuses.mu.Lock()
+		defer r.statuses.mu.Unlock()
+		for _, s := range statuses {
+			r.statuses.statuses[s.ScraperName] = s
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown kind %q", kind)
+}
+
+func (r *backup) Clear(ctx context.Context, kind string) error {
+	switch kind {
+	case storage.KindEvents, storage.KindArchive:
+		store := r.eventStore(kind)
+		store.mu.Lock()
+		store.events = nil
+		store.mu.Unlock()
+	case storage.KindVenues:
+		r.venues.mu.Lock()
+		r.venues.venues = nil
+		r.venues.mu.Unlock()
+	case storage.KindCities:
+		r.cities.mu.Lock()
+		r.cities.cities = nil
+		r.cities.mu.Unlock()
+	case storage.KindGenres:
+		r.artistGenres.mu.Lock()
+		clear(r.artistGenres.genres)
+		r.artistGenres.mu.Unlock()
+	case storage.KindNotifications:
+		r.notifications.mu.Lock()
+		r.notifications.notifications = nil
+		r.notifications.mu.Unlock()
+	case storage.KindStatuses:
+		r.statuses.mu.Lock()
+		clear(r.statuses.statuses)
+		r.statuses.mu.Unlock()
+	default:
+		return fmt.Errorf("unknown kind %q", kind)
+	}
+	return nil
+}
+
+func (r *backup) eventStore(kind string) *events {
+	if kind == storage.KindArchive {
+		return r.archive
+	}
+	return r.events
+}
+
+// restore decodes the documents and replaces the items that are the same according to
+// same or appends them.
+func restore[T any](docs []bson.Raw, mu *sync.RWMutex, items *[]T, same func(a, b T) bool) error {
+	var restored []T
+	if err := unmarshalAll(docs, &restored); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, item := range restored {
+		if i := slices.IndexFunc(*items, func(stored T) bool { return same(stored, item) }); i >= 0 {
+			(*items)[i] = item
+		} else {
+			*items = append(*items, item)
+		}
+	}
+	return nil
+}
+
+func unmarshalAll[T any](docs []bson.Raw, items *[]T) error {
+	for _, doc := range docs {
+		var item T
+		if err := bson.Unmarshal(doc, &item); err != nil {
+			return err
+		}
+		*items = append(*items, item)
+	}
+	return nil
+}
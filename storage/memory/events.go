@@ -0,0 +1,357 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jakopako/event-api/geo"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/moderation"
+	"github.com/jakopako/event-api/popularity"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type events struct {
+	mu     sync.RWMutex
+	events []models.Event
+}
+
+func (r *events) Get(ctx context.Context, id primitive.ObjectID) (models.Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, e := range r.events {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return models.Event{}, storage.ErrNotFound
+}
+
+func (r *events) Find(ctx context.Context, f storage.EventFilter, skip, limit int64) ([]models.Event, int64, error) {
+	matched, err := r.findSingle(ctx, f)
+	if err != nil {
+		return nil, 0, err
+	}
+	if f.Query.Sort == models.SortTrending {
+		now := time.Now()
+		slices.SortStableFunc(matched, func(a, b models.Event) int {
+			if c := cmp.Compare(popularity.SortKey(b, now), popularity.SortKey(a, now)); c != 0 {
+				return c
+			}
+			return a.Date.Compare(b.Date)
+		})
+	} else {
+		slices.SortStableFunc(matched, func(a, b models.Event) int { return a.Date.Compare(b.Date) })
+	}
+	return page(matched, skip, limit)
+}
+
+// findSingle returns the events that don't recur and match the filter within the date
+// window of its query.
+func (r *events) findSingle(ctx context.Context, f storage.EventFilter) ([]models.Event, error) {
+	m, err := shared.NewEventMatcher(ctx, f.Query)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var matched []models.Event
+	for _, e := range r.events {
+		if e.Recurrence == nil && m.Match(e) && (f.Follows == nil || f.Follows.Match(e)) {
+			matched = append(matched, e)
+		}
+	}
+	return matched, nil
+}
+
+func (r *events) FindSeries(ctx context.Context, f storage.EventFilter, from, to time.Time) ([]models.Event, error) {
+	// the dates and the filter expression are checked per occurrence by the caller
+	q := f.Query
+	q.StartDate, q.EndDate, q.Filter = nil, nil, ""
+	m, err := shared.NewEventMatcher(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var series []models.Event
+	for _, e := range r.events {
+		if e.Recurrence == nil || e.Date.After(to) || (e.Recurrence.LastDate != nil && e.Recurrence.LastDate.Before(from)) {
+			continue
+		}
+		if m.Match(e) && (f.Follows == nil || f.Follows.Match(e)) {
+			series = append(series, e)
+		}
+	}
+	return series, nil
+}
+
+func (r *events) CountValues(ctx context.Context, f storage.EventFilter, keys []string) (map[string]map[string]int64, error) {
+	matched, err := r.findSingle(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]map[string]int64{}
+	for _, key := range keys {
+		counts[key] = map[string]int64{}
+		for _, e := range matched {
+			for _, v := range shared.EventValues(e, key) {
+				counts[key][v]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+func (r *events) FindByKeys(ctx context.Context, keys []models.EventKey) ([]models.Event, error) {
+	wanted := map[string]bool{}
+	for _, k := range keys {
+		wanted[shared.EventKeyString(k)] = true
+	}
+	return r.filter(func(e models.Event) bool {
+		return e.SourceKey != nil && wanted[shared.EventKeyString(*e.SourceKey)]
+	}), nil
+}
+
+func (r *events) FindByURLs(ctx context.Context, urls []string) ([]models.Event, error) {
+	return r.filter(func(e models.Event) bool { return slices.Contains(urls, e.URL) }), nil
+}
+
+func (r *events) Upsert(ctx context.Context, events []models.Event) (map[int]primitive.ObjectID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	insertedIDs := map[int]primitive.ObjectID{}
+	for i, event := range events {
+		// dates are stored with millisecond precision in UTC, like in MongoDB
+		event.Date = event.Date.UTC().Truncate(time.Millisecond)
+		key := shared.EventKeyString(shared.UpsertKey(event))
+		j := slices.IndexFunc(r.events, func(e models.Event) bool {
+			if e.SourceKey != nil {
+				return shared.EventKeyString(*e.SourceKey) == key
+			}
+			// events stored before the source key was introduced are matched by their fields
+			return shared.EventKeyString(shared.NewEventKey(e)) == key
+		})
+		if j >= 0 {
+			event.ID = r.events[j].ID
+			r.events[j] = event
+			continue
+		}
+		event.ID = primitive.NewObjectID()
+		r.events = append(r.events, event)
+		insertedIDs[i] = event.ID
+	}
+	return insertedIDs, nil
+}
+
+func (r *events) FindBySource(ctx context.Context, sourceURL string, since *time.Time) ([]models.Event, error) {
+	return r.filter(sourceFilter(sourceURL, since)), nil
+}
+
+func (r *events) DeleteBySource(ctx context.Context, sourceURL string, since *time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(r.events)
+	r.events = slices.DeleteFunc(r.events, sourceFilter(sourceURL, since))
+	return int64(n - len(r.events)), nil
+}
+
+func sourceFilter(sourceURL string, since *time.Time) func(e models.Event) bool {
+	return func(e models.Event) bool {
+		if since == nil {
+			return e.SourceURL == sourceURL
+		}
+		return (sourceURL == "" || e.SourceURL == sourceURL) && !e.Date.Before(*since)
+	}
+}
+
+// filter returns the events for which the function returns true.
+func (r *events) filter(f func(e models.Event) bool) []models.Event {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var events []models.Event
+	for _, e := range r.events {
+		if f(e) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func (r *events) FindByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.Event, error) {
+	return r.filter(func(e models.Event) bool { return slices.Contains(ids, e.ID) && shared.IsVisible(e) }), nil
+}
+
+func (r *events) FindOverridden(ctx context.Context, skip, limit int64) ([]models.Event, int64, error) {
+	overridden := r.filter(func(e models.Event) bool { return e.Overrides != nil })
+	slices.SortStableFunc(overridden, func(a, b models.Event) int { return b.Overrides.UpdatedAt.Compare(a.Overrides.UpdatedAt) })
+	return page(overridden, skip, limit)
+}
+
+func (r *events) FindPending(ctx context.Context, skip, limit int64) ([]models.Event, int64, error) {
+	pending := r.filter(func(e models.Event) bool { return e.ModerationStatus == moderation.StatusPending })
+	slices.SortStableFunc(pending, func(a, b models.Event) int { return a.Date.Compare(b.Date) })
+	return page(pending, skip, limit)
+}
+
+// page returns the events between skip and skip+limit and the total number of events.
+func page(events []models.Event, skip, limit int64) ([]models.Event, int64, error) {
+	total := int64(len(events))
+	return events[min(skip, total):min(skip+limit, total)], total, nil
+}
+
+func (r *events) SetOverrides(ctx context.Context, e models.Event) error {
+	_, err := r.update(e.ID, func(stored *models.Event) bool {
+		*stored = e
+		return true
+	})
+	return err
+}
+
+func (r *events) ClearOverrides(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.update(id, func(e *models.Event) bool {
+		e.Overrides = nil
+		return true
+	})
+	return err
+}
+
+func (r *events) SetModerationStatus(ctx context.Context, id primitive.ObjectID, status string, key models.EventKey) error {
+	_, err := r.update(id, func(e *models.Event) bool {
+		e.ModerationStatus = status
+		e.SourceKey = &key
+		return true
+	})
+	return err
+}
+
+func (r *events) SetCancelled(ctx context.Context, id primitive.ObjectID, cancelled []time.Time, key models.EventKey) error {
+	_, err := r.update(id, func(e *models.Event) bool {
+		if e.Recurrence != nil {
+			// the recurrence is shared with the events that have been returned before
+			rec := *e.Recurrence
+			rec.Cancelled = cancelled
+			e.Recurrence = &rec
+		}
+		e.SourceKey = &key
+		return true
+	})
+	return err
+}
+
+func (r *events) SetAddress(ctx context.Context, id primitive.ObjectID, address models.Address) error {
+	_, err := r.update(id, func(e *models.Event) bool {
+		e.Address = address
+		e.CityFallback = false
+		return true
+	})
+	return err
+}
+
+func (r *events) SetGenres(ctx context.Context, id primitive.ObjectID, genres []string) error {
+	_, err := r.update(id, func(e *models.Event) bool {
+		e.Genres = genres
+		return true
+	})
+	return err
+}
+
+func (r *events) RecordInteraction(ctx context.Context, id primitive.ObjectID, interaction string, t time.Time) (models.Event, error) {
+	return r.update(id, func(e *models.Event) bool {
+		if !shared.IsVisible(*e) {
+			return false
+		}
+		if e.Popularity != nil {
+			p := *e.Popularity
+			e.Popularity = &p
+		}
+		popularity.Record(e, interaction, t)
+		return true
+	})
+}
+
+// update calls fn with the event with the given id while the events are locked and returns
+// the updated event. If fn returns false or there is no such event, ErrNotFound is returned.
+func (r *events) update(id primitive.ObjectID, fn func(e *models.Event) bool) (models.Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := slices.IndexFunc(r.events, func(e models.Event) bool { return e.ID == id })
+	if i < 0 {
+		return models.Event{}, storage.ErrNotFound
+	}
+	e := r.events[i]
+	if !fn(&e) {
+		return models.Event{}, storage.ErrNotFound
+	}
+	r.events[i] = e
+	return e, nil
+}
+
+func (r *events) FindSimilar(ctx context.Context, f storage.SimilarFilter, limit int64) ([]models.Event, error) {
+	var artists []*regexp.Regexp
+	for _, a := range f.Artists {
+		artists = append(artists, regexp.MustCompile("(?i)"+regexp.QuoteMeta(a)))
+	}
+	related := func(e models.Event) bool {
+		if slices.ContainsFunc(e.Genres, func(g string) bool { return slices.Contains(f.Genres, g) }) {
+			return true
+		}
+		if slices.ContainsFunc(artists, func(a *regexp.Regexp) bool { return a.MatchString(e.NormalizedTitle) }) {
+			return true
+		}
+		if f.Location != "" && e.Location == f.Location && e.City == f.City {
+			return true
+		}
+		return len(f.Coordinates) == 2 && geo.DistanceKm(f.Coordinates, e.Address.Geolocacation.Coordinates) <= f.RadiusKm
+	}
+	candidates := r.filter(func(e models.Event) bool {
+		return e.ID != f.ExcludeID && shared.IsVisible(e) && shared.IsUpcoming(e, f.Since) && related(e)
+	})
+	slices.SortStableFunc(candidates, func(a, b models.Event) int { return a.Date.Compare(b.Date) })
+	return candidates[:min(limit, int64(len(candidates)))], nil
+}
+
+func (r *events) AutocompleteValues(ctx context.Context, f storage.AutocompleteFilter) (storage.AutocompleteValues, error) {
+	var values storage.AutocompleteValues
+	var title *regexp.Regexp
+	if f.TitlePattern != "" {
+		var err error
+		if title, err = regexp.Compile("(?i)" + f.TitlePattern); err != nil {
+			return values, err
+		}
+	}
+	type venue struct{ location, city string }
+	venues, cities, genres := map[venue]int64{}, map[string]int64{}, map[string]int64{}
+	for _, e := range r.filter(func(e models.Event) bool { return shared.IsVisible(e) && shared.IsUpcoming(e, f.Since) }) {
+		venues[venue{e.Location, e.City}]++
+		cities[e.City]++
+		for _, g := range e.Genres {
+			genres[g]++
+		}
+		if title != nil && int64(len(values.Titles)) < f.MaxTitles && title.MatchString(e.NormalizedTitle) {
+			values.Titles = append(values.Titles, e.Title)
+		}
+	}
+	if f.Venues {
+		for v, n := range venues {
+			values.Venues = append(values.Venues, models.Suggestion{Value: v.location, City: v.city, Count: n})
+		}
+	}
+	if f.Cities {
+		for c, n := range cities {
+			values.Cities = append(values.Cities, models.Suggestion{Value: c, Count: n})
+		}
+	}
+	if f.Genres {
+		for g, n := range genres {
+			values.Genres = append(values.Genres, models.Suggestion{Value: g, Count: n})
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,123 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+)
+
+type cities struct {
+	mu     sync.RWMutex
+	cities []models.City
+}
+
+func (r *cities) Get(ctx context.Context, name, state, country string) (models.City, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.cities {
+		if c.Name == name && c.State == state && c.Country == country {
+			return c, nil
+		}
+	}
+	return models.City{}, storage.ErrNotFound
+}
+
+func (r *cities) FindByName(ctx context.Context, name, country string) ([]models.City, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var found []models.City
+	for _, c := range r.cities {
+		if c.Name == name && (country == "" || c.Country == country) {
+			found = append(found, c)
+		}
+	}
+	return found, nil
+}
+
+func (r *cities) Find(ctx context.Context, country string, limit int64) ([]models.City, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	found := []models.City{}
+	for _, c := range r.cities {
+		if country == "" || c.Country == strings.ToLower(country) {
+			found = append(found, c)
+		}
+	}
+	slices.SortStableFunc(found, func(a, b models.City) int { return cmp.Compare(a.Name, b.Name) })
+	return found[:min(int64(len(found)), limit)], nil
+}
+
+func (r *cities) Insert(ctx context.Context, c models.City) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cities = append(r.cities, c)
+	return nil
+}
+
+type venues struct {
+	mu     sync.RWMutex
+	venues []models.Venue
+}
+
+func (r *venues) Get(ctx context.Context, name, city, state, country string) (models.Venue, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, v := range r.venues {
+		a := v.Address
+		if v.Name == name && a.Locality == city && (state == "" || a.State == state) && (country == "" || a.Country == country) {
+			return v, nil
+		}
+	}
+	return models.Venue{}, storage.ErrNotFound
+}
+
+func (r *venues) Find(ctx context.Context, city, name string, limit int64) ([]models.Venue, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	found := []models.Venue{}
+	for _, v := range r.venues {
+		if city != "" && !strings.EqualFold(v.Address.Locality, city) {
+			continue
+		}
+		if name != "" && !strings.Contains(strings.ToLower(v.Name), strings.ToLower(name)) {
+			continue
+		}
+		found = append(found, v)
+	}
+	slices.SortStableFunc(found, func(a, b models.Venue) int { return cmp.Compare(a.Name, b.Name) })
+	return found[:min(int64(len(found)), limit)], nil
+}
+
+func (r *venues) Insert(ctx context.Context, v models.Venue) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.venues = append(r.venues, v)
+	return nil
+}
+
+type artistGenres struct {
+	mu     sync.RWMutex
+	genres map[string][]string
+}
+
+func (r *artistGenres) Get(ctx context.Context, artist string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	genres, found := r.genres[artist]
+	if !found {
+		return nil, storage.ErrNotFound
+	}
+	return genres, nil
+}
+
+func (r *artistGenres) Insert(ctx context.Context, artist string, genres []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.genres[artist] = genres
+	return nil
+}
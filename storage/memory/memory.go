@@ -0,0 +1,36 @@
+// Package memory implements the repositories of package storage in memory. It uses the
+// same matching as the notifications and webhooks, see shared.EventMatcher, and is meant
+// for tests and local development.
+package memory
+
+import (
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+)
+
+// New returns an empty store.
+func New() storage.Store {
+	b := &backup{
+		events:        &events{},
+		archive:       &events{},
+		notifications: &notifications{},
+		statuses:      &statuses{statuses: map[string]models.ScraperStatus{}},
+		cities:        &cities{},
+		venues:        &venues{},
+		artistGenres:  &artistGenres{genres: map[string][]string{}},
+	}
+	return storage.Store{
+		Events:        b.events,
+		Archive:       &archive{events: b.archive, from: b.events},
+		Notifications: b.notifications,
+		Statuses:      b.statuses,
+		Cities:        b.cities,
+		Venues:        b.venues,
+		ArtistGenres:  b.artistGenres,
+		Rules:         &rules{},
+		Webhooks:      &webhooks{},
+		Users:         &users{},
+		Sessions:      &sessions{},
+		Backup:        b,
+	}
+}
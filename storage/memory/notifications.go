@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+)
+
+type notifications struct {
+	mu            sync.RWMutex
+	notifications []models.Notification
+}
+
+func (r *notifications) Add(ctx context.Context, n models.Notification) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if slices.ContainsFunc(r.notifications, func(s models.Notification) bool {
+		return s.Email == n.Email && reflect.DeepEqual(s.Query, n.Query)
+	}) {
+		return false, nil
+	}
+	r.notifications = append(r.notifications, n)
+	return true, nil
+}
+
+func (r *notifications) Get(ctx context.Context, email, token string) (models.Notification, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if i := r.index(email, token); i >= 0 {
+		return r.notifications[i], nil
+	}
+	return models.Notification{}, storage.ErrNotFound
+}
+
+func (r *notifications) Activate(ctx context.Context, email, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.index(email, token); i >= 0 {
+		r.notifications[i].Active = true
+	}
+	return nil
+}
+
+func (r *notifications) Delete(ctx context.Context, email, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.index(email, token); i >= 0 {
+		r.notifications = slices.Delete(r.notifications, i, i+1)
+	}
+	return nil
+}
+
+func (r *notifications) DeleteInactive(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(r.notifications)
+	r.notifications = slices.DeleteFunc(r.notifications, func(s models.Notification) bool {
+		return !s.Active && s.SetupDate.Before(before)
+	})
+	return int64(n - len(r.notifications)), nil
+}
+
+func (r *notifications) FindActive(ctx context.Context) ([]models.Notification, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var active []models.Notification
+	for _, n := range r.notifications {
+		if n.Active {
+			active = append(active, n)
+		}
+	}
+	return active, nil
+}
+
+// index returns the index of the notification or -1. The caller has to hold the lock.
+func (r *notifications) index(email, token string) int {
+	return slices.IndexFunc(r.notifications, func(n models.Notification) bool {
+		return n.Email == email && n.Token == token
+	})
+}
@@ -0,0 +1,40 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type rules struct {
+	mu    sync.RWMutex
+	rules []models.ModerationRule
+}
+
+func (r *rules) Find(ctx context.Context) ([]models.ModerationRule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.rules), nil
+}
+
+func (r *rules) Insert(ctx context.Context, rule models.ModerationRule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = append(r.rules, rule)
+	return nil
+}
+
+func (r *rules) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := slices.IndexFunc(r.rules, func(rule models.ModerationRule) bool { return rule.ID == id })
+	if i < 0 {
+		return storage.ErrNotFound
+	}
+	r.rules = slices.Delete(r.rules, i, i+1)
+	return nil
+}
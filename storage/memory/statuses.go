@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+)
+
+type statuses struct {
+	mu       sync.RWMutex
+	statuses map[string]models.ScraperStatus
+}
+
+func (r *statuses) Find(ctx context.Context, name string, skip, limit int64, withLogs bool) ([]models.ScraperStatus, int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var found []models.ScraperStatus
+	for _, n := range slices.Sorted(maps.Keys(r.statuses)) {
+		if name != "" && n != name {
+			continue
+		}
+		s := r.statuses[n]
+		if !withLogs {
+			s.ScraperLogs = ""
+		}
+		found = append(found, s)
+	}
+	total := int64(len(found))
+	end := total
+	if limit > 0 {
+		end = min(skip+limit, total)
+	}
+	return found[min(skip, total):end], total, nil
+}
+
+func (r *statuses) Upsert(ctx context.Context, s models.ScraperStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses[s.ScraperName] = s
+	return nil
+}
+
+func (r *statuses) Delete(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, found := r.statuses[name]; !found {
+		return storage.ErrNotFound
+	}
+	delete(r.statuses, name)
+	return nil
+}
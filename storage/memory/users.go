@@ -0,0 +1,195 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type users struct {
+	mu    sync.RWMutex
+	users []models.User
+}
+
+func (r *users) Get(ctx context.Context, id primitive.ObjectID) (models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.find(func(u models.User) bool { return u.ID == id })
+}
+
+func (r *users) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.find(func(u models.User) bool { return u.Email == email })
+}
+
+func (r *users) Insert(ctx context.Context, u models.User) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.find(func(s models.User) bool { return s.Email == u.Email }); err == nil {
+		return false, nil
+	}
+	r.users = append(r.users, u)
+	return true, nil
+}
+
+func (r *users) GetOrInsert(ctx context.Context, u models.User) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if found, err := r.find(func(s models.User) bool { return s.Email == u.Email }); err == nil {
+		return found, nil
+	}
+	r.users = append(r.users, u)
+	return u, nil
+}
+
+func (r *users) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users = slices.DeleteFunc(r.users, func(u models.User) bool { return u.ID == id })
+	return nil
+}
+
+func (r *users) VerifyEmail(ctx context.Context, id primitive.ObjectID) (models.User, error) {
+	return r.update(id, func(u *models.User) {
+		u.EmailVerified = true
+		u.PasswordHash = nil
+	})
+}
+
+func (r *users) AddFavourite(ctx context.Context, id, eventID primitive.ObjectID) (models.User, error) {
+	return r.update(id, func(u *models.User) {
+		u.FavouriteEvents = addToSet(u.FavouriteEvents, eventID)
+	})
+}
+
+func (r *users) RemoveFavourite(ctx context.Context, id, eventID primitive.ObjectID) (models.User, error) {
+	return r.update(id, func(u *models.User) {
+		u.FavouriteEvents = pull(u.FavouriteEvents, eventID)
+	})
+}
+
+func (r *users) AddFollow(ctx context.Context, id primitive.ObjectID, kind, name string) (models.User, error) {
+	if !slices.Contains(storage.FollowKinds, kind) {
+		return models.User{}, fmt.Errorf("unknown kind %q", kind)
+	}
+	return r.update(id, func(u *models.User) {
+		names := storage.Followed(u, kind)
+		*names = addToSet(*names, name)
+	})
+}
+
+func (r *users) RemoveFollow(ctx context.Context, id primitive.ObjectID, kind, name string) (models.User, error) {
+	if !slices.Contains(storage.FollowKinds, kind) {
+		return models.User{}, fmt.Errorf("unknown kind %q", kind)
+	}
+	return r.update(id, func(u *models.User) {
+		names := storage.Followed(u, kind)
+		*names = pull(*names, name)
+	})
+}
+
+// find returns the first user that matches or ErrNotFound. The caller has to hold the lock.
+func (r *users) find(match func(models.User) bool) (models.User, error) {
+	if i := slices.IndexFunc(r.users, match); i >= 0 {
+		return r.users[i], nil
+	}
+	return models.User{}, storage.ErrNotFound
+}
+
+// update applies fn to the user with the given id and returns the updated user. The
+// slices of the user are copied, so that the users that have been returned before don't
+// change.
+func (r *users) update(id primitive.ObjectID, fn func(u *models.User)) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := slices.IndexFunc(r.users, func(u models.User) bool { return u.ID == id })
+	if i < 0 {
+		return models.User{}, storage.ErrNotFound
+	}
+	u := r.users[i]
+	u.FavouriteEvents = slices.Clone(u.FavouriteEvents)
+	u.FollowedArtists = slices.Clone(u.FollowedArtists)
+	u.FollowedVenues = slices.Clone(u.FollowedVenues)
+	u.FollowedCities = slices.Clone(u.FollowedCities)
+	fn(&u)
+	r.users[i] = u
+	return u, nil
+}
+
+func addToSet[T comparable](values []T, v T) []T {
+	if slices.Contains(values, v) {
+		return values
+	}
+	return append(values, v)
+}
+
+func pull[T comparable](values []T, v T) []T {
+	return slices.DeleteFunc(values, func(s T) bool { return s == v })
+}
+
+type sessions struct {
+	mu         sync.Mutex
+	sessions   []models.Session
+	magicLinks []models.MagicLink
+}
+
+func (r *sessions) Add(ctx context.Context, s models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions = append(r.sessions, s)
+	return nil
+}
+
+func (r *sessions) Get(ctx context.Context, tokenHash string, now time.Time) (models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := slices.IndexFunc(r.sessions, func(s models.Session) bool {
+		return s.TokenHash == tokenHash && s.ExpiresAt.After(now)
+	})
+	if i < 0 {
+		return models.Session{}, storage.ErrNotFound
+	}
+	return r.sessions[i], nil
+}
+
+func (r *sessions) Delete(ctx context.Context, tokenHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions = slices.DeleteFunc(r.sessions, func(s models.Session) bool { return s.TokenHash == tokenHash })
+	return nil
+}
+
+func (r *sessions) DeleteByUser(ctx context.Context, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions = slices.DeleteFunc(r.sessions, func(s models.Session) bool { return s.UserID == userID })
+	return nil
+}
+
+func (r *sessions) AddMagicLink(ctx context.Context, l models.MagicLink) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.magicLinks = append(r.magicLinks, l)
+	return nil
+}
+
+func (r *sessions) RedeemMagicLink(ctx context.Context, tokenHash string, now time.Time) (models.MagicLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := slices.IndexFunc(r.magicLinks, func(l models.MagicLink) bool {
+		return l.TokenHash == tokenHash && l.ExpiresAt.After(now)
+	})
+	if i < 0 {
+		return models.MagicLink{}, storage.ErrNotFound
+	}
+	l := r.magicLinks[i]
+	r.magicLinks = slices.Delete(r.magicLinks, i, i+1)
+	return l, nil
+}
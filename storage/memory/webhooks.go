@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type webhooks struct {
+	mu         sync.RWMutex
+	webhooks   []models.Webhook
+	deliveries []models.WebhookDelivery
+}
+
+func (r *webhooks) Find(ctx context.Context, owner *primitive.ObjectID) ([]models.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if owner == nil {
+		return slices.Clone(r.webhooks), nil
+	}
+	var found []models.Webhook
+	for _, w := range r.webhooks {
+		if w.Owner == *owner {
+			found = append(found, w)
+		}
+	}
+	return found, nil
+}
+
+func (r *webhooks) Get(ctx context.Context, id primitive.ObjectID) (models.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	i := slices.IndexFunc(r.webhooks, func(w models.Webhook) bool { return w.ID == id })
+	if i < 0 {
+		return models.Webhook{}, storage.ErrNotFound
+	}
+	return r.webhooks[i], nil
+}
+
+func (r *webhooks) Count(ctx context.Context) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return int64(len(r.webhooks)), nil
+}
+
+func (r *webhooks) Insert(ctx context.Context, w models.Webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.webhooks = append(r.webhooks, w)
+	return nil
+}
+
+func (r *webhooks) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := slices.IndexFunc(r.webhooks, func(w models.Webhook) bool { return w.ID == id })
+	if i < 0 {
+		return storage.ErrNotFound
+	}
+	r.webhooks = slices.Delete(r.webhooks, i, i+1)
+	r.deliveries = slices.DeleteFunc(r.deliveries, func(d models.WebhookDelivery) bool { return d.WebhookID == id })
+	return nil
+}
+
+func (r *webhooks) AddDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deliveries = append(r.deliveries, d)
+	return nil
+}
+
+func (r *webhooks) FindDeliveries(ctx context.Context, webhookID primitive.ObjectID, skip, limit int64) ([]models.WebhookDelivery, int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var found []models.WebhookDelivery
+	for _, d := range r.deliveries {
+		if d.WebhookID == webhookID {
+			found = append(found, d)
+		}
+	}
+	slices.SortStableFunc(found, func(a, b models.WebhookDelivery) int { return b.Timestamp.Compare(a.Timestamp) })
+	total := int64(len(found))
+	return found[min(skip, total):min(skip+limit, total)], total, nil
+}
@@ -0,0 +1,61 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// archiveBatchSize is the number of events that are moved to the archive at once.
+const archiveBatchSize = 1000
+
+// archive is a collection of events with the same layout as the events collection.
+type archive struct {
+	events
+	// from is the collection of the events that are archived
+	from *mongo.Collection
+}
+
+func (r archive) MoveBefore(ctx context.Context, before time.Time) (int64, error) {
+	filter := bson.M{"$or": []bson.M{
+		{"recurrence": bson.M{"$exists": false}, "date": bson.M{"$lt": before}},
+		{"recurrence.lastDate": bson.M{"$lt": before}},
+	}}
+	var moved int64
+	for {
+		cursor, err := r.from.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(archiveBatchSize))
+		if err != nil {
+			return moved, err
+		}
+		var ids []bson.M
+		if err := cursor.All(ctx, &ids); err != nil {
+			return moved, err
+		}
+		if len(ids) == 0 {
+			return moved, nil
+		}
+		in := bson.A{}
+		for _, id := range ids {
+			in = append(in, id["_id"])
+		}
+		// the events are only deleted after they have been copied, copying them again if
+		// the deletion fails just replaces them
+		batch := bson.M{"_id": bson.M{"$in": in}}
+		cursor, err = r.from.Aggregate(ctx, bson.A{
+			bson.M{"$match": batch},
+			bson.M{"$merge": bson.M{"into": r.coll.Name(), "on": "_id", "whenMatched": "replace", "whenNotMatched": "insert"}},
+		})
+		if err != nil {
+			return moved, err
+		}
+		cursor.Close(ctx)
+		result, err := r.from.DeleteMany(ctx, batch)
+		if err != nil {
+			return moved, err
+		}
+		moved += result.DeletedCount
+	}
+}
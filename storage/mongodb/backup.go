@@ -0,0 +1,112 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// backup reads and writes the documents of the collections as they are.
+type backup struct {
+	db *mongo.Database
+	// collections are the names of the collections by kind
+	collections map[string]string
+}
+
+// backupKeys are the fields that identify the documents of each kind.
+var backupKeys = map[string][]string{
+	storage.KindEvents:        {"_id"},
+	storage.KindArchive:       {"_id"},
+	storage.KindVenues:        {"name", "address.locality"},
+	storage.KindCities:        {"name", "state", "country"},
+	storage.KindGenres:        {"title"},
+	storage.KindNotifications: {"email", "token"},
+	storage.KindStatuses:      {"scraperName"},
+}
+
+func (r backup) collection(kind string) (*mongo.Collection, error) {
+	name, ok := r.collections[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown kind %q", kind)
+	}
+	return r.db.Collection(name), nil
+}
+
+func (r backup) Dump(ctx context.Context, kind string, fn func(doc bson.Raw) error) error {
+	coll, err := r.collection(kind)
+	if err != nil {
+		return err
+	}
+	cursor, err := coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		if err := fn(cursor.Current); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (r backup) Restore(ctx context.Context, kind string, docs []bson.Raw) error {
+	coll, err := r.collection(kind)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	keys := backupKeys[kind]
+	writes := make([]mongo.WriteModel, 0, len(docs))
+	for _, doc := range docs {
+		filter := bson.D{}
+		for _, key := range keys {
+			value, err := doc.LookupErr(strings.Split(key, ".")...)
+			if err != nil {
+				return fmt.Errorf("%s document without %s: %w", kind, key, err)
+			}
+			filter = append(filter, bson.E{Key: key, Value: value})
+		}
+		// other documents are matched by their keys and keep the id they are stored with
+		replacement := doc
+		if keys[0] != "_id" {
+			if replacement, err = withoutID(doc); err != nil {
+				return err
+			}
+		}
+		writes = append(writes, mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(replacement).SetUpsert(true))
+	}
+	_, err = coll.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+func (r backup) Clear(ctx context.Context, kind string) error {
+	coll, err := r.collection(kind)
+	if err != nil {
+		return err
+	}
+	_, err = coll.DeleteMany(ctx, bson.M{})
+	return err
+}
+
+// withoutID returns the document without its _id field.
+func withoutID(doc bson.Raw) (bson.Raw, error) {
+	elements, err := doc.Elements()
+	if err != nil {
+		return nil, err
+	}
+	d := bson.D{}
+	for _, e := range elements {
+		if e.Key() != "_id" {
+			d = append(d, bson.E{Key: e.Key(), Value: e.Value()})
+		}
+	}
+	return bson.Marshal(d)
+}
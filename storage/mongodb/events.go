@@ -0,0 +1,593 @@
+package mongodb
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/jakopako/event-api/geo"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/moderation"
+	"github.com/jakopako/event-api/popularity"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// intervalFormats are the formats of the keys of shared.IntervalKey in MongoDB.
+var intervalFormats = map[string]string{
+	shared.IntervalDay:   "%Y-%m-%d",
+	shared.IntervalWeek:  "%G-W%V",
+	shared.IntervalMonth: "%Y-%m",
+}
+
+type events struct {
+	coll *mongo.Collection
+}
+
+func (r events) Get(ctx context.Context, id primitive.ObjectID) (models.Event, error) {
+	var event models.Event
+	err := r.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&event)
+	return event, notFound(err)
+}
+
+func (r events) Find(ctx context.Context, f storage.EventFilter, skip, limit int64) ([]models.Event, int64, error) {
+	filter := singleEventsFilter(ctx, f)
+	total, err := r.coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var cursor *mongo.Cursor
+	if f.Query.Sort == models.SortTrending {
+		cursor, err = r.coll.Aggregate(ctx, bson.A{
+			bson.M{"$match": filter},
+			bson.M{"$addFields": bson.M{"trendingScore": popularity.SortKeyExpression(time.Now())}},
+			bson.M{"$sort": bson.D{{Key: "trendingScore", Value: -1}, {Key: "date", Value: 1}}},
+			bson.M{"$skip": skip},
+			bson.M{"$limit": limit},
+		})
+	} else {
+		findOptions := options.Find()
+		findOptions.SetSort(bson.D{{Key: "date", Value: 1}})
+		findOptions.SetSkip(skip)
+		findOptions.SetLimit(limit)
+		cursor, err = r.coll.Find(ctx, filter, findOptions)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var events []models.Event
+	for cursor.Next(ctx) {
+		var event models.Event
+		cursor.Decode(&event)
+		events = append(events, event)
+	}
+	return events, total, cursor.Err()
+}
+
+func (r events) FindSeries(ctx context.Context, f storage.EventFilter, from, to time.Time) ([]models.Event, error) {
+	filter := bson.M{"$and": append(queryConditions(ctx, f),
+		bson.M{"recurrence": bson.M{"$exists": true}},
+		bson.M{"date": bson.M{"$lte": to}},
+		bson.M{"recurrence.lastDate": bson.M{"$not": bson.M{"$lt": from}}},
+	)}
+	if f.Expr != nil {
+		filter["$and"] = append(filter["$and"].([]bson.M), f.Expr.SeriesFilter())
+	}
+	cursor, err := r.coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var series []models.Event
+	err = cursor.All(ctx, &series)
+	return series, err
+}
+
+func (r events) CountValues(ctx context.Context, f storage.EventFilter, keys []string) (map[string]map[string]int64, error) {
+	facets := bson.M{}
+	for _, key := range keys {
+		facets[key] = countStages(key)
+	}
+	cursor, err := r.coll.Aggregate(ctx, bson.A{
+		bson.M{"$match": singleEventsFilter(ctx, f)},
+		bson.M{"$facet": facets},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var results []map[string][]models.DistinctValue
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	counts := map[string]map[string]int64{}
+	for _, key := range keys {
+		counts[key] = map[string]int64{}
+		if len(results) > 0 {
+			for _, v := range results[0][key] {
+				counts[key][v.Value] += v.Count
+			}
+		}
+	}
+	return counts, nil
+}
+
+// countStages returns the stages that count the events per value of the key.
+func countStages(key string) bson.A {
+	var stages bson.A
+	var value any = "$" + key
+	if format, found := intervalFormats[key]; found {
+		value = bson.M{"$dateToString": bson.M{
+			"format": format,
+			"date":   bson.M{"$add": bson.A{"$date", bson.M{"$multiply": bson.A{bson.M{"$ifNull": bson.A{"$offset", 0}}, 1000}}}},
+		}}
+	} else if key == "genres" {
+		// genres that are listed twice count once, like in shared.EventValues
+		stages = append(stages,
+			bson.M{"$project": bson.M{"genres": bson.M{"$setUnion": bson.A{"$genres", bson.A{}}}}},
+			bson.M{"$unwind": "$genres"},
+		)
+	}
+	return append(stages,
+		bson.M{"$group": bson.M{"_id": value, "count": bson.M{"$sum": 1}}},
+		bson.M{"$project": bson.M{"_id": 0, "value": "$_id", "count": 1}},
+	)
+}
+
+func (r events) FindByKeys(ctx context.Context, keys []models.EventKey) ([]models.Event, error) {
+	cursor, err := r.coll.Find(ctx, bson.M{"sourceKey": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, err
+	}
+	var events []models.Event
+	err = cursor.All(ctx, &events)
+	return events, err
+}
+
+func (r events) FindByURLs(ctx context.Context, urls []string) ([]models.Event, error) {
+	cursor, err := r.coll.Find(ctx, bson.M{"url": bson.M{"$in": urls}})
+	if err != nil {
+		return nil, err
+	}
+	var events []models.Event
+	err = cursor.All(ctx, &events)
+	return events, err
+}
+
+func (r events) Upsert(ctx context.Context, events []models.Event) (map[int]primitive.ObjectID, error) {
+	insertedIDs := map[int]primitive.ObjectID{}
+	if len(events) == 0 {
+		return insertedIDs, nil
+	}
+	var operations []mongo.WriteModel
+	for _, event := range events {
+		key := shared.UpsertKey(event)
+		op := mongo.NewReplaceOneModel()
+		// The filter ignores the comment assuming that the comment might be updated over time.
+		// In future versions we might need to take more factors into account to decide whether
+		// an existing event needs to be updated or a new event needs to be added.
+		// Events stored before the source key was introduced are matched by their fields.
+		filterEvent := bson.M{
+			"$or": []bson.M{
+				{"sourceKey": key},
+				{
+					"title":     key.Title,
+					"date":      key.Date,
+					"location":  key.Location,
+					"url":       key.URL,
+					"sourceUrl": key.SourceURL,
+					"sourceKey": bson.M{"$exists": false},
+				},
+			},
+		}
+		op.SetFilter(filterEvent)
+		op.SetUpsert(true)
+		op.SetReplacement(event)
+		operations = append(operations, op)
+	}
+
+	bulkOption := options.BulkWriteOptions{}
+	bulkOption.SetOrdered(true)
+	result, err := r.coll.BulkWrite(ctx, operations, &bulkOption)
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range result.UpsertedIDs {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			insertedIDs[int(i)] = oid
+		}
+	}
+	return insertedIDs, nil
+}
+
+func (r events) FindBySource(ctx context.Context, sourceURL string, since *time.Time) ([]models.Event, error) {
+	cursor, err := r.coll.Find(ctx, sourceFilter(sourceURL, since))
+	if err != nil {
+		return nil, err
+	}
+	var events []models.Event
+	err = cursor.All(ctx, &events)
+	return events, err
+}
+
+func (r events) DeleteBySource(ctx context.Context, sourceURL string, since *time.Time) (int64, error) {
+	result, err := r.coll.DeleteMany(ctx, sourceFilter(sourceURL, since))
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func sourceFilter(sourceURL string, since *time.Time) bson.M {
+	if since == nil {
+		return bson.M{"sourceUrl": sourceURL}
+	}
+	if sourceURL == "" {
+		return bson.M{"date": bson.M{"$gte": since}}
+	}
+	return bson.M{
+		"$and": []bson.M{
+			{
+				"date": bson.M{
+					"$gte": since,
+				},
+			},
+			{
+				"sourceUrl": sourceURL,
+			},
+		},
+	}
+}
+
+// queryConditions returns the conditions of the filter that don't depend on the date.
+func queryConditions(ctx context.Context, f storage.EventFilter) []bson.M {
+	q := f.Query
+	conditions := []bson.M{shared.VisibleEventsFilter()}
+
+	// Special handling for title to include normalized search
+	if q.Title != "" {
+		normalizedTitle := shared.RemoveDiacritics(q.Title)
+		conditions = append(conditions, bson.M{
+			"$or": []bson.M{
+				{
+					"title": bson.M{
+						"$regex": primitive.Regex{
+							Pattern: regexp.QuoteMeta(q.Title),
+							Options: "i",
+						},
+					},
+				},
+				{
+					"normalizedTitle": bson.M{
+						"$regex": primitive.Regex{
+							Pattern: regexp.QuoteMeta(normalizedTitle),
+							Options: "i",
+						},
+					},
+				},
+			},
+		})
+	}
+
+	for searchKey, searchValue := range map[string]string{"location": q.Location, "country": q.Country, "type": q.Type} {
+		if searchValue != "" {
+			conditions = append(conditions, bson.M{
+				searchKey: bson.M{
+					"$regex": primitive.Regex{
+						Pattern: regexp.QuoteMeta(searchValue),
+						Options: "i",
+					},
+				},
+			})
+		}
+	}
+
+	conditions = append(conditions, genreConditions(q)...)
+
+	if q.City != "" {
+		cityFilter := bson.M{
+			"$or": []bson.M{
+				{
+					"city": bson.M{
+						"$regex": primitive.Regex{
+							Pattern: q.City,
+							Options: "i",
+						},
+					},
+				},
+			},
+		}
+		if q.Radius > 0 {
+			// near in or not supported: https://jira.mongodb.org/browse/SERVER-13974
+			if geolocs, err := geo.AllMatchesCityCoordinates(ctx, q.City, q.Country); err == nil && len(geolocs) > 0 {
+				radiusFilter := bson.D{
+					{Key: "address.geolocation", Value: bson.D{
+						{Key: "$geoWithin", Value: bson.D{ // we need to use geoWithin for CountDocuments to properly work, see https://www.mongodb.com/docs/manual/reference/method/db.collection.countDocuments/#query-restrictions
+							{Key: "$centerSphere", Value: bson.A{geolocs[0].Coordinates, float64(q.Radius) / geo.EarthRadiusKm}},
+						}},
+					}},
+				}
+				cityFilter["$or"] = append(cityFilter["$or"].([]bson.M), radiusFilter.Map())
+			}
+		}
+		conditions = append(conditions, cityFilter)
+	}
+
+	if f.Follows != nil {
+		conditions = append(conditions, followsFilter(f.Follows))
+	}
+	return conditions
+}
+
+// followsFilter returns the filter that matches the events of the follows.
+func followsFilter(f *storage.Follows) bson.M {
+	or := []bson.M{}
+	for _, a := range f.Artists {
+		or = append(or, bson.M{"normalizedTitle": bson.M{
+			"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(a), Options: "i"},
+		}})
+	}
+	for _, v := range f.Venues {
+		or = append(or, bson.M{"location": bson.M{
+			"$regex": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(v) + "$", Options: "i"},
+		}})
+	}
+	for _, c := range f.Cities {
+		or = append(or, bson.M{"city": bson.M{
+			"$regex": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(c) + "$", Options: "i"},
+		}})
+	}
+	if len(or) == 0 {
+		// users that don't follow anything have an empty feed
+		return bson.M{"_id": bson.M{"$exists": false}}
+	}
+	return bson.M{"$or": or}
+}
+
+// genreConditions returns the conditions on the genres and types of the query.
+func genreConditions(q models.Query) []bson.M {
+	var conditions []bson.M
+	if len(q.Genres) > 0 {
+		genres := genreValues(q, q.Genres)
+		if q.GenreMode == models.GenreModeAll {
+			for _, g := range genres {
+				conditions = append(conditions, bson.M{"genres": g})
+			}
+		} else {
+			conditions = append(conditions, bson.M{"genres": bson.M{"$in": genres}})
+		}
+	}
+	if len(q.ExcludeGenres) > 0 {
+		conditions = append(conditions, bson.M{"genres": bson.M{"$nin": genreValues(q, q.ExcludeGenres)}})
+	}
+	if len(q.ExcludeTypes) > 0 {
+		conditions = append(conditions, bson.M{"type": bson.M{"$nin": shared.LowerAll(q.ExcludeTypes)}})
+	}
+	return conditions
+}
+
+// genreValues returns the genres or, if subgenres are included, the regexes of their patterns.
+func genreValues(q models.Query, genres []string) []any {
+	var values []any
+	for _, g := range genres {
+		if q.Subgenres {
+			values = append(values, primitive.Regex{Pattern: shared.SubgenrePattern(g), Options: "i"})
+		} else {
+			values = append(values, g)
+		}
+	}
+	return values
+}
+
+// singleEventsFilter returns the filter that matches the events that don't recur and
+// match the filter within the date window of its query.
+func singleEventsFilter(ctx context.Context, f storage.EventFilter) bson.M {
+	q := f.Query
+	filter := bson.M{"$and": append(queryConditions(ctx, f), bson.M{"recurrence": bson.M{"$exists": false}})}
+	if f.Expr != nil {
+		filter["$and"] = append(filter["$and"].([]bson.M), f.Expr.Filter())
+	}
+	if q.StartDate != nil {
+		if q.EndDate == nil {
+			filter["$and"] = append(filter["$and"].([]bson.M), bson.M{"date": bson.M{"$gt": q.StartDate}})
+		} else {
+			filter["$and"] = append(filter["$and"].([]bson.M),
+				bson.M{"date": bson.M{"$gte": q.StartDate}},
+				bson.M{"date": bson.M{"$lte": q.EndDate}},
+			)
+		}
+	}
+	return filter
+}
+
+func (r events) FindByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.Event, error) {
+	cursor, err := r.coll.Find(ctx, bson.M{"$and": []bson.M{{"_id": bson.M{"$in": ids}}, shared.VisibleEventsFilter()}})
+	if err != nil {
+		return nil, err
+	}
+	var events []models.Event
+	err = cursor.All(ctx, &events)
+	return events, err
+}
+
+func (r events) FindOverridden(ctx context.Context, skip, limit int64) ([]models.Event, int64, error) {
+	return r.findPage(ctx, bson.M{"overrides": bson.M{"$exists": true}}, bson.D{{Key: "overrides.updatedAt", Value: -1}}, skip, limit)
+}
+
+func (r events) FindPending(ctx context.Context, skip, limit int64) ([]models.Event, int64, error) {
+	return r.findPage(ctx, bson.M{"moderationStatus": moderation.StatusPending}, bson.D{{Key: "date", Value: 1}}, skip, limit)
+}
+
+// findPage returns a page of the events that match the filter and the total number of them.
+func (r events) findPage(ctx context.Context, filter bson.M, sort bson.D, skip, limit int64) ([]models.Event, int64, error) {
+	total, err := r.coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	findOptions := options.Find()
+	findOptions.SetSort(sort)
+	findOptions.SetSkip(skip)
+	findOptions.SetLimit(limit)
+	cursor, err := r.coll.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	var events []models.Event
+	err = cursor.All(ctx, &events)
+	return events, total, err
+}
+
+func (r events) SetOverrides(ctx context.Context, e models.Event) error {
+	result, err := r.coll.ReplaceOne(ctx, bson.M{"_id": e.ID}, e)
+	if err != nil {
+		return err
+	}
+	return matched(result)
+}
+
+func (r events) ClearOverrides(ctx context.Context, id primitive.ObjectID) error {
+	return r.update(ctx, id, bson.M{"$unset": bson.M{"overrides": ""}})
+}
+
+func (r events) SetModerationStatus(ctx context.Context, id primitive.ObjectID, status string, key models.EventKey) error {
+	return r.update(ctx, id, bson.M{"$set": bson.M{"moderationStatus": status, "sourceKey": key}})
+}
+
+func (r events) SetCancelled(ctx context.Context, id primitive.ObjectID, cancelled []time.Time, key models.EventKey) error {
+	return r.update(ctx, id, bson.M{"$set": bson.M{"recurrence.cancelled": cancelled, "sourceKey": key}})
+}
+
+func (r events) SetAddress(ctx context.Context, id primitive.ObjectID, address models.Address) error {
+	return r.update(ctx, id, bson.M{"$set": bson.M{"address": address}, "$unset": bson.M{"cityFallback": ""}})
+}
+
+func (r events) SetGenres(ctx context.Context, id primitive.ObjectID, genres []string) error {
+	return r.update(ctx, id, bson.M{"$set": bson.M{"genres": genres}})
+}
+
+// update applies the update to the event with the given id or returns storage.ErrNotFound.
+func (r events) update(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	result, err := r.coll.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	return matched(result)
+}
+
+// matched returns storage.ErrNotFound if the update didn't match a document.
+func matched(result *mongo.UpdateResult) error {
+	if result.MatchedCount == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (r events) RecordInteraction(ctx context.Context, id primitive.ObjectID, interaction string, t time.Time) (models.Event, error) {
+	// the update is computed in the database, so that concurrent interactions are not lost
+	var event models.Event
+	filter := bson.M{"$and": []bson.M{{"_id": id}, shared.VisibleEventsFilter()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.coll.FindOneAndUpdate(ctx, filter, popularity.Update(interaction, t), opts).Decode(&event)
+	return event, notFound(err)
+}
+
+func (r events) FindSimilar(ctx context.Context, f storage.SimilarFilter, limit int64) ([]models.Event, error) {
+	related := []bson.M{}
+	if len(f.Genres) > 0 {
+		related = append(related, bson.M{"genres": bson.M{"$in": f.Genres}})
+	}
+	for _, a := range f.Artists {
+		related = append(related, bson.M{"normalizedTitle": bson.M{
+			"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(a), Options: "i"},
+		}})
+	}
+	if f.Location != "" {
+		related = append(related, bson.M{"location": f.Location, "city": f.City})
+	}
+	if len(f.Coordinates) == 2 {
+		related = append(related, bson.M{"address.geolocation": bson.M{
+			"$geoWithin": bson.M{"$centerSphere": bson.A{f.Coordinates, f.RadiusKm / geo.EarthRadiusKm}},
+		}})
+	}
+	if len(related) == 0 {
+		return nil, nil
+	}
+
+	filter := bson.M{"$and": []bson.M{
+		shared.VisibleEventsFilter(),
+		{"_id": bson.M{"$ne": f.ExcludeID}},
+		{"$or": related},
+		shared.UpcomingEventsFilter(f.Since),
+	}}
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "date", Value: 1}})
+	findOptions.SetLimit(limit)
+	cursor, err := r.coll.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	var events []models.Event
+	err = cursor.All(ctx, &events)
+	return events, err
+}
+
+func (r events) AutocompleteValues(ctx context.Context, f storage.AutocompleteFilter) (storage.AutocompleteValues, error) {
+	var values storage.AutocompleteValues
+	facets := bson.M{}
+	if f.Venues {
+		facets["venues"] = bson.A{
+			bson.M{"$group": bson.M{"_id": bson.M{"value": "$location", "city": "$city"}, "count": bson.M{"$sum": 1}}},
+			bson.M{"$project": bson.M{"_id": 0, "value": "$_id.value", "city": "$_id.city", "count": 1}},
+		}
+	}
+	if f.Cities {
+		facets["cities"] = bson.A{
+			bson.M{"$group": bson.M{"_id": "$city", "count": bson.M{"$sum": 1}}},
+			bson.M{"$project": bson.M{"_id": 0, "value": "$_id", "count": 1}},
+		}
+	}
+	if f.Genres {
+		facets["genres"] = bson.A{
+			bson.M{"$unwind": "$genres"},
+			bson.M{"$group": bson.M{"_id": "$genres", "count": bson.M{"$sum": 1}}},
+			bson.M{"$project": bson.M{"_id": 0, "value": "$_id", "count": 1}},
+		}
+	}
+	if f.TitlePattern != "" {
+		facets["titles"] = bson.A{
+			bson.M{"$match": bson.M{"normalizedTitle": bson.M{"$regex": primitive.Regex{Pattern: f.TitlePattern, Options: "i"}}}},
+			bson.M{"$limit": f.MaxTitles},
+			bson.M{"$project": bson.M{"_id": 0, "value": "$title"}},
+		}
+	}
+	if len(facets) == 0 {
+		return values, nil
+	}
+
+	cursor, err := r.coll.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"$and": []bson.M{shared.UpcomingEventsFilter(f.Since), shared.VisibleEventsFilter()}}},
+		bson.M{"$facet": facets},
+	})
+	if err != nil {
+		return values, err
+	}
+	var results []map[string][]models.Suggestion
+	if err := cursor.All(ctx, &results); err != nil {
+		return values, err
+	}
+	if len(results) == 0 {
+		return values, nil
+	}
+	values.Venues = results[0]["venues"]
+	values.Cities = results[0]["cities"]
+	values.Genres = results[0]["genres"]
+	for _, t := range results[0]["titles"] {
+		values.Titles = append(values.Titles, t.Value)
+	}
+	return values, nil
+}
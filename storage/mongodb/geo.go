@@ -0,0 +1,117 @@
+package mongodb
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/jakopako/event-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type cities struct {
+	coll *mongo.Collection
+}
+
+func (r cities) Get(ctx context.Context, name, state, country string) (models.City, error) {
+	filter := bson.D{{Key: "name", Value: name}, {Key: "state", Value: state}, {Key: "country", Value: country}}
+	var city models.City
+	err := r.coll.FindOne(ctx, filter).Decode(&city)
+	return city, notFound(err)
+}
+
+func (r cities) FindByName(ctx context.Context, name, country string) ([]models.City, error) {
+	filter := bson.D{{Key: "name", Value: name}}
+	if country != "" {
+		filter = append(filter, bson.E{Key: "country", Value: country})
+	}
+	cursor, err := r.coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var cities []models.City
+	err = cursor.All(ctx, &cities)
+	return cities, err
+}
+
+func (r cities) Find(ctx context.Context, country string, limit int64) ([]models.City, error) {
+	filter := bson.M{}
+	if country != "" {
+		filter["country"] = strings.ToLower(country)
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "name", Value: 1}}).SetLimit(limit)
+	cursor, err := r.coll.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	cities := []models.City{}
+	if err := cursor.All(ctx, &cities); err != nil {
+		return nil, err
+	}
+	return cities, nil
+}
+
+func (r cities) Insert(ctx context.Context, c models.City) error {
+	_, err := r.coll.InsertOne(ctx, c)
+	return err
+}
+
+type venues struct {
+	coll *mongo.Collection
+}
+
+func (r venues) Get(ctx context.Context, name, city, state, country string) (models.Venue, error) {
+	filter := bson.D{{Key: "name", Value: name}, {Key: "address.locality", Value: city}}
+	if state != "" {
+		filter = append(filter, bson.E{Key: "address.state", Value: state})
+	}
+	if country != "" {
+		filter = append(filter, bson.E{Key: "address.country", Value: country})
+	}
+	var venue models.Venue
+	err := r.coll.FindOne(ctx, filter).Decode(&venue)
+	return venue, notFound(err)
+}
+
+func (r venues) Find(ctx context.Context, city, name string, limit int64) ([]models.Venue, error) {
+	filter := bson.M{}
+	if city != "" {
+		filter["address.locality"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(city) + "$", Options: "i"}
+	}
+	if name != "" {
+		filter["name"] = primitive.Regex{Pattern: regexp.QuoteMeta(name), Options: "i"}
+	}
+	findOptions := options.Find().SetSort(bson.D{{Key: "name", Value: 1}}).SetLimit(limit)
+	cursor, err := r.coll.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	venues := []models.Venue{}
+	if err := cursor.All(ctx, &venues); err != nil {
+		return nil, err
+	}
+	return venues, nil
+}
+
+func (r venues) Insert(ctx context.Context, v models.Venue) error {
+	_, err := r.coll.InsertOne(ctx, v)
+	return err
+}
+
+type artistGenres struct {
+	coll *mongo.Collection
+}
+
+func (r artistGenres) Get(ctx context.Context, artist string) ([]string, error) {
+	var result models.TitleGenre
+	err := r.coll.FindOne(ctx, bson.D{{Key: "title", Value: artist}}).Decode(&result)
+	return result.Genres, notFound(err)
+}
+
+func (r artistGenres) Insert(ctx context.Context, artist string, genres []string) error {
+	_, err := r.coll.InsertOne(ctx, models.TitleGenre{Title: artist, Genres: genres})
+	return err
+}
@@ -0,0 +1,353 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jakopako/event-api/account"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/shared"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MigrationCollectionName is the collection in which the applied migrations are recorded.
+const MigrationCollectionName = "migrations"
+
+const (
+	// migrationLockID is the id of the document that an instance holds while it applies
+	// migrations.
+	migrationLockID = "lock"
+	// migrationLockTimeout is the time after which the lock is taken over by another
+	// instance, it has to be longer than the migrations take.
+	migrationLockTimeout = 30 * time.Minute
+	// migrationLockRetry is the interval at which a held lock is tried again.
+	migrationLockRetry = time.Second
+	// migrationBatchSize is the number of documents that a migration updates at once.
+	migrationBatchSize = 500
+)
+
+// Migration changes the indexes or the data of the database. Migrations are applied in
+// the order of their versions and recorded, so that each of them runs only once.
+// Migrations have to be idempotent nevertheless since several instances of the api might
+// apply them at the same time.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+}
+
+// appliedMigration is the record of an applied migration.
+type appliedMigration struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedAt"`
+}
+
+// Migrations are all migrations in the order of their versions. Never change a migration
+// that has been released, add a new one instead.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "remove empty geolocations of events, they can't be indexed",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(shared.EventCollectionName).UpdateMany(ctx,
+				bson.M{"address.geolocation": bson.M{"$exists": true}, "address.geolocation.coordinates": bson.M{"$exists": false}},
+				bson.M{"$unset": bson.M{"address.geolocation": ""}},
+			)
+			return err
+		},
+	},
+	{
+		Version:     2,
+		Description: "index the geolocation of events for radius searches",
+		Up: createIndexes(shared.EventCollectionName,
+			mongo.IndexModel{Keys: bson.D{{Key: "address.geolocation", Value: "2dsphere"}}},
+		),
+	},
+	{
+		Version:     3,
+		Description: "set the source key of events stored before source keys were introduced",
+		Up:          backfillSourceKeys,
+	},
+	{
+		Version:     4,
+		Description: "index the keys by which events are upserted, deleted and sorted",
+		Up: createIndexes(shared.EventCollectionName,
+			mongo.IndexModel{
+				Keys:    bson.D{{Key: "sourceKey", Value: 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"sourceKey": bson.M{"$exists": true}}),
+			},
+			mongo.IndexModel{Keys: bson.D{{Key: "url", Value: 1}}},
+			mongo.IndexModel{Keys: bson.D{{Key: "date", Value: 1}}},
+			mongo.IndexModel{Keys: bson.D{{Key: "sourceUrl", Value: 1}, {Key: "date", Value: 1}}},
+		),
+	},
+	{
+		Version:     5,
+		Description: "index notifications, scraper statuses and the geo and genre caches",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for coll, indexes := range map[string][]mongo.IndexModel{
+				shared.NotificationCollectionName: {
+					{Keys: bson.D{{Key: "email", Value: 1}, {Key: "token", Value: 1}}, Options: options.Index().SetUnique(true)},
+					{Keys: bson.D{{Key: "email", Value: 1}, {Key: "query", Value: 1}}, Options: options.Index().SetUnique(true)},
+					{Keys: bson.D{{Key: "active", Value: 1}}},
+				},
+				shared.ScraperStatusCollectionName: {
+					{Keys: bson.D{{Key: "scraperName", Value: 1}}, Options: options.Index().SetUnique(true)},
+				},
+				"genres": {
+					{Keys: bson.D{{Key: "title", Value: 1}}, Options: options.Index().SetUnique(true)},
+				},
+				"cities": {
+					{Keys: bson.D{{Key: "name", Value: 1}, {Key: "state", Value: 1}, {Key: "country", Value: 1}}},
+				},
+				"venues": {
+					{Keys: bson.D{{Key: "name", Value: 1}, {Key: "address.locality", Value: 1}}},
+				},
+			} {
+				if err := createIndexes(coll, indexes...)(ctx, db); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     6,
+		Description: "index users by email and expire sessions and magic links",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			expire := options.Index().SetExpireAfterSeconds(0)
+			for coll, indexes := range map[string][]mongo.IndexModel{
+				account.UserCollectionName: {
+					{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+				},
+				account.SessionCollectionName: {
+					{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+					{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: expire},
+				},
+				account.MagicLinkCollectionName: {
+					{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+					{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: expire},
+				},
+			} {
+				if err := createIndexes(coll, indexes...)(ctx, db); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     7,
+		Description: "index the archive of past events for history queries",
+		Up: createIndexes(shared.ArchiveCollectionName,
+			mongo.IndexModel{Keys: bson.D{{Key: "date", Value: 1}}},
+			mongo.IndexModel{Keys: bson.D{{Key: "sourceUrl", Value: 1}, {Key: "date", Value: 1}}},
+			mongo.IndexModel{Keys: bson.D{{Key: "address.geolocation", Value: "2dsphere"}}},
+		),
+	},
+	{
+		Version:     8,
+		Description: "mark the events that have the coordinates of their city because their venue couldn't be found",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			// the addresses of venues always have a locality
+			_, err := db.Collection(shared.EventCollectionName).UpdateMany(ctx,
+				bson.M{"address.locality": bson.M{"$in": bson.A{"", nil}}, "overrides.address": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"cityFallback": true}},
+			)
+			return err
+		},
+	},
+}
+
+// createIndexes returns a migration function that creates the indexes on the collection.
+// Indexes that exist already are left as they are.
+func createIndexes(coll string, indexes ...mongo.IndexModel) func(ctx context.Context, db *mongo.Database) error {
+	return func(ctx context.Context, db *mongo.Database) error {
+		if _, err := db.Collection(coll).Indexes().CreateMany(ctx, indexes); err != nil {
+			return fmt.Errorf("failed to create indexes on %s: %w", coll, err)
+		}
+		return nil
+	}
+}
+
+// backfillSourceKeys sets the source key of the events that have none. The keys are
+// computed like the keys of upserted events, so that the events are found by the next
+// upsert of their source.
+func backfillSourceKeys(ctx context.Context, db *mongo.Database) error {
+	coll := db.Collection(shared.EventCollectionName)
+	cursor, err := coll.Find(ctx, bson.M{"sourceKey": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	var updates []mongo.WriteModel
+	write := func() error {
+		if len(updates) == 0 {
+			return nil
+		}
+		_, err := coll.BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false))
+		updates = nil
+		return err
+	}
+	for cursor.Next(ctx) {
+		var e models.Event
+		if err := cursor.Decode(&e); err != nil {
+			return err
+		}
+		updates = append(updates, sourceKeyUpdate(e))
+		if len(updates) == migrationBatchSize {
+			if err := write(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return write()
+}
+
+// sourceKeyUpdate returns the update that sets the source key of the stored event unless
+// it has been set in the meantime.
+func sourceKeyUpdate(e models.Event) *mongo.UpdateOneModel {
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"_id": e.ID, "sourceKey": bson.M{"$exists": false}}).
+		SetUpdate(bson.M{"$set": bson.M{"sourceKey": shared.NewEventKey(e)}})
+}
+
+// Migrate applies the migrations that haven't been applied to the database yet and
+// returns them. It stops at the first migration that fails. Only one instance of the api
+// applies migrations at a time, the others wait for it.
+func Migrate(ctx context.Context, db *mongo.Database) ([]Migration, error) {
+	return applyMigrations(ctx, db, newMigrationRecords(db), Migrations)
+}
+
+// PendingMigrations returns the migrations that haven't been applied to the database yet.
+func PendingMigrations(ctx context.Context, db *mongo.Database) ([]Migration, error) {
+	return pendingMigrations(ctx, newMigrationRecords(db), Migrations)
+}
+
+// records keeps track of the applied migrations, see Migrate.
+type records interface {
+	// lock waits until no other instance applies migrations and returns the function that
+	// releases the lock.
+	lock(ctx context.Context) (func(ctx context.Context) error, error)
+	// applied returns the versions of the applied migrations.
+	applied(ctx context.Context) (map[int]bool, error)
+	// record records the migration as applied.
+	record(ctx context.Context, m Migration) error
+}
+
+func applyMigrations(ctx context.Context, db *mongo.Database, r records, migrations []Migration) ([]Migration, error) {
+	unlock, err := r.lock(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock the migrations: %w", err)
+	}
+	defer func() {
+		if err := unlock(context.WithoutCancel(ctx)); err != nil {
+			slog.Error("failed to unlock the migrations", "err", err)
+		}
+	}()
+
+	// the migrations that have been applied by another instance in the meantime are skipped
+	pending, err := pendingMigrations(ctx, r, migrations)
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, m := range pending {
+		slog.Info("applying migration", "version", m.Version, "description", m.Description)
+		if err := m.Up(ctx, db); err != nil {
+			return applied, fmt.Errorf("migration %d failed: %w", m.Version, err)
+		}
+		if err := r.record(ctx, m); err != nil {
+			return applied, fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+func pendingMigrations(ctx context.Context, r records, migrations []Migration) ([]Migration, error) {
+	done, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// migrationRecords records the applied migrations in the migrations collection. The lock
+// is a document in the same collection.
+type migrationRecords struct {
+	coll *mongo.Collection
+	// owner identifies the lock of this instance
+	owner string
+}
+
+func newMigrationRecords(db *mongo.Database) migrationRecords {
+	return migrationRecords{coll: db.Collection(MigrationCollectionName), owner: primitive.NewObjectID().Hex()}
+}
+
+func (r migrationRecords) lock(ctx context.Context) (func(ctx context.Context) error, error) {
+	for {
+		now := time.Now().UTC()
+		// the lock is taken over if it has expired, e.g. because its owner has crashed
+		filter := bson.M{"_id": migrationLockID, "lockedUntil": bson.M{"$lt": now}}
+		update := bson.M{"$set": bson.M{"owner": r.owner, "lockedUntil": now.Add(migrationLockTimeout)}}
+		err := r.coll.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetUpsert(true)).Err()
+		// the lock has been inserted if it didn't exist
+		if err == nil || errors.Is(err, mongo.ErrNoDocuments) {
+			return r.unlock, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+		slog.Info("waiting for another instance to apply the migrations")
+		select {
+		case <-time.After(migrationLockRetry):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (r migrationRecords) unlock(ctx context.Context) error {
+	_, err := r.coll.DeleteOne(ctx, bson.M{"_id": migrationLockID, "owner": r.owner})
+	return err
+}
+
+func (r migrationRecords) applied(ctx context.Context) (map[int]bool, error) {
+	// the lock isn't a record
+	cursor, err := r.coll.Find(ctx, bson.M{"_id": bson.M{"$type": "number"}})
+	if err != nil {
+		return nil, err
+	}
+	var records []appliedMigration
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	done := map[int]bool{}
+	for _, rec := range records {
+		done[rec.Version] = true
+	}
+	return done, nil
+}
+
+func (r migrationRecords) record(ctx context.Context, m Migration) error {
+	rec := appliedMigration{Version: m.Version, Description: m.Description, AppliedAt: time.Now().UTC()}
+	_, err := r.coll.ReplaceOne(ctx, bson.M{"_id": m.Version}, rec, options.Replace().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,164 @@
+package mongodb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/shared"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	for i, m := range Migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d, want %d", i, m.Version, i+1)
+		}
+		if m.Description == "" || m.Up == nil {
+			t.Errorf("migration %d has no description or function", m.Version)
+		}
+	}
+}
+
+// fakeRecords keeps the applied migrations in memory.
+type fakeRecords struct {
+	done   map[int]bool
+	locked bool
+}
+
+func (r *fakeRecords) lock(ctx context.Context) (func(ctx context.Context) error, error) {
+	if r.locked {
+		return nil, errors.New("locked twice")
+	}
+	r.locked = true
+	return func(ctx context.Context) error {
+		r.locked = false
+		return nil
+	}, nil
+}
+
+func (r *fakeRecords) applied(ctx context.Context) (map[int]bool, error) {
+	if !r.locked {
+		return nil, errors.New("not locked")
+	}
+	return r.done, nil
+}
+
+func (r *fakeRecords) record(ctx context.Context, m Migration) error {
+	r.done[m.Version] = true
+	return nil
+}
+
+func TestApplyMigrations(t *testing.T) {
+	ctx := context.Background()
+	runs := map[int]int{}
+	up := func(version int) func(context.Context, *mongo.Database) error {
+		return func(context.Context, *mongo.Database) error {
+			runs[version]++
+			return nil
+		}
+	}
+	migrations := []Migration{
+		{Version: 1, Description: "one", Up: up(1)},
+		{Version: 2, Description: "two", Up: up(2)},
+		{Version: 3, Description: "three", Up: up(3)},
+	}
+	r := &fakeRecords{done: map[int]bool{2: true}}
+
+	applied, err := applyMigrations(ctx, nil, r, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || applied[0].Version != 1 || applied[1].Version != 3 {
+		t.Errorf("got applied migrations %+v, want 1 and 3", applied)
+	}
+	if runs[2] != 0 {
+		t.Errorf("recorded migration 2 has been applied %d times", runs[2])
+	}
+	if r.locked {
+		t.Error("the migrations are still locked")
+	}
+
+	applied, err = applyMigrations(ctx, nil, r, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("got applied migrations %+v on the second run, want none", applied)
+	}
+	if runs[1] != 1 || runs[3] != 1 {
+		t.Errorf("got runs %v, want every pending migration applied once", runs)
+	}
+}
+
+func TestApplyMigrationsStopsAtFailure(t *testing.T) {
+	ctx := context.Background()
+	migrations := []Migration{
+		{Version: 1, Description: "fails", Up: func(context.Context, *mongo.Database) error { return errors.New("failed") }},
+		{Version: 2, Description: "not applied", Up: func(context.Context, *mongo.Database) error {
+			t.Error("migration 2 has been applied after migration 1 failed")
+			return nil
+		}},
+	}
+	r := &fakeRecords{done: map[int]bool{}}
+	if _, err := applyMigrations(ctx, nil, r, migrations); err == nil {
+		t.Fatal("expected an error")
+	}
+	if r.done[1] {
+		t.Error("the failed migration has been recorded")
+	}
+	if r.locked {
+		t.Error("the migrations are still locked")
+	}
+}
+
+// TestSourceKeyUpdate checks that the source keys that migration 3 sets to stored events
+// are the keys that upserts look the events up with, also for fields that aren't stored.
+func TestSourceKeyUpdate(t *testing.T) {
+	events := []models.Event{
+		{
+			ID:        primitive.NewObjectID(),
+			Title:     "Jazz Night",
+			Location:  "Moods",
+			URL:       "https://moods.ch/jazz-night",
+			SourceURL: "https://moods.ch",
+			Date:      time.Date(2026, 10, 31, 20, 0, 0, 0, time.UTC),
+		},
+		// the location, the url and the date are omitted from the stored document
+		{
+			ID:        primitive.NewObjectID(),
+			Title:     "Open Air",
+			SourceURL: "https://openair.ch",
+		},
+	}
+	for _, e := range events {
+		stored, err := bson.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded models.Event
+		if err := bson.Unmarshal(stored, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		update, ok := sourceKeyUpdate(decoded).Update.(bson.M)
+		if !ok {
+			t.Fatalf("unexpected update %v", sourceKeyUpdate(decoded).Update)
+		}
+		got, err := bson.Marshal(update["$set"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := bson.Marshal(bson.M{"sourceKey": shared.NewEventKey(e)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("got source key %v for %q, want %v", bson.Raw(got), e.Title, bson.Raw(want))
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Package mongodb implements the repositories of package storage with MongoDB.
+package mongodb
+
+import (
+	"github.com/jakopako/event-api/account"
+	"github.com/jakopako/event-api/moderation"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+	"github.com/jakopako/event-api/webhook"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// New returns the store that keeps its data in the given database.
+func New(db *mongo.Database) storage.Store {
+	return storage.Store{
+		Events: events{db.Collection(shared.EventCollectionName)},
+		Archive: archive{
+			events: events{db.Collection(shared.ArchiveCollectionName)},
+			from:   db.Collection(shared.EventCollectionName),
+		},
+		Notifications: notifications{db.Collection(shared.NotificationCollectionName)},
+		Statuses:      statuses{db.Collection(shared.ScraperStatusCollectionName)},
+		Cities:        cities{db.Collection("cities")},
+		Venues:        venues{db.Collection("venues")},
+		ArtistGenres:  artistGenres{db.Collection("genres")},
+		Rules:         rules{db.Collection(moderation.RuleCollectionName)},
+		Webhooks: webhooks{
+			coll:       db.Collection(webhook.CollectionName),
+			deliveries: db.Collection(webhook.DeliveryCollectionName),
+		},
+		Users: users{db.Collection(account.UserCollectionName)},
+		Sessions: sessions{
+			coll:       db.Collection(account.SessionCollectionName),
+			magicLinks: db.Collection(account.MagicLinkCollectionName),
+		},
+		Backup: backup{db, map[string]string{
+			storage.KindEvents:        shared.EventCollectionName,
+			storage.KindArchive:       shared.ArchiveCollectionName,
+			storage.KindVenues:        "venues",
+			storage.KindCities:        "cities",
+			storage.KindGenres:        "genres",
+			storage.KindNotifications: shared.NotificationCollectionName,
+			storage.KindStatuses:      shared.ScraperStatusCollectionName,
+		}},
+	}
+}
+
+// notFound translates the error of the driver for missing documents.
+func notFound(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return storage.ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type notifications struct {
+	coll *mongo.Collection
+}
+
+func (r notifications) Add(ctx context.Context, n models.Notification) (bool, error) {
+	update := bson.M{
+		"$setOnInsert": n,
+	}
+	filter := bson.D{{Key: "email", Value: n.Email}, {Key: "query", Value: n.Query}}
+	opts := options.Update().SetUpsert(true)
+	result, err := r.coll.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 0, nil
+}
+
+func (r notifications) Get(ctx context.Context, email, token string) (models.Notification, error) {
+	var n models.Notification
+	err := r.coll.FindOne(ctx, tokenFilter(email, token)).Decode(&n)
+	return n, notFound(err)
+}
+
+func (r notifications) Activate(ctx context.Context, email, token string) error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "active", Value: true}}}}
+	_, err := r.coll.UpdateOne(ctx, tokenFilter(email, token), update)
+	return err
+}
+
+func (r notifications) Delete(ctx context.Context, email, token string) error {
+	_, err := r.coll.DeleteOne(ctx, tokenFilter(email, token))
+	return err
+}
+
+func (r notifications) DeleteInactive(ctx context.Context, before time.Time) (int64, error) {
+	filter := bson.D{{Key: "active", Value: false}, {Key: "setupDate", Value: bson.M{"$lt": before}}}
+	result, err := r.coll.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (r notifications) FindActive(ctx context.Context) ([]models.Notification, error) {
+	cursor, err := r.coll.Find(ctx, bson.D{{Key: "active", Value: true}})
+	if err != nil {
+		return nil, err
+	}
+	var results []models.Notification
+	err = cursor.All(ctx, &results)
+	return results, err
+}
+
+func tokenFilter(email, token string) bson.D {
+	return bson.D{{Key: "email", Value: email}, {Key: "token", Value: token}}
+}
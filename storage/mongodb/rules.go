@@ -0,0 +1,44 @@
+package mongodb
+
+import (
+	"context"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type rules struct {
+	coll *mongo.Collection
+}
+
+func (r rules) Find(ctx context.Context) ([]models.ModerationRule, error) {
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := r.coll.Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	var results []models.ModerationRule
+	err = cursor.All(ctx, &results)
+	return results, err
+}
+
+func (r rules) Insert(ctx context.Context, rule models.ModerationRule) error {
+	_, err := r.coll.InsertOne(ctx, rule)
+	return err
+}
+
+func (r rules) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.coll.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
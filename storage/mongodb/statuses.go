@@ -0,0 +1,66 @@
+package mongodb
+
+import (
+	"context"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type statuses struct {
+	coll *mongo.Collection
+}
+
+func (r statuses) Find(ctx context.Context, name string, skip, limit int64, withLogs bool) ([]models.ScraperStatus, int64, error) {
+	filter := bson.M{}
+	if name != "" {
+		filter = bson.M{
+			"scraperName": name,
+		}
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "scraperName", Value: 1}})
+	findOptions.SetSkip(skip)
+	findOptions.SetLimit(limit)
+	// the logs can be huge, so they are only fetched if they are needed
+	if !withLogs {
+		findOptions.SetProjection(bson.M{"scraperLogs": 0})
+	}
+
+	total, err := r.coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := r.coll.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	var statuses []models.ScraperStatus
+	err = cursor.All(ctx, &statuses)
+	return statuses, total, err
+}
+
+func (r statuses) Upsert(ctx context.Context, s models.ScraperStatus) error {
+	filter := bson.M{"scraperName": s.ScraperName}
+	opts := options.Update().SetUpsert(true)
+	update := bson.M{
+		"$set": s,
+	}
+	_, err := r.coll.UpdateOne(ctx, filter, update, opts)
+	return err
+}
+
+func (r statuses) Delete(ctx context.Context, name string) error {
+	result, err := r.coll.DeleteOne(ctx, bson.M{"scraperName": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
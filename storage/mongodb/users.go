@@ -0,0 +1,135 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// followFields maps the kinds of things users can follow to the fields of the user.
+var followFields = map[string]string{
+	storage.FollowArtists: "followedArtists",
+	storage.FollowVenues:  "followedVenues",
+	storage.FollowCities:  "followedCities",
+}
+
+type users struct {
+	coll *mongo.Collection
+}
+
+func (r users) Get(ctx context.Context, id primitive.ObjectID) (models.User, error) {
+	var u models.User
+	err := r.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&u)
+	return u, notFound(err)
+}
+
+func (r users) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	var u models.User
+	err := r.coll.FindOne(ctx, bson.M{"email": email}).Decode(&u)
+	return u, notFound(err)
+}
+
+func (r users) Insert(ctx context.Context, u models.User) (bool, error) {
+	opts := options.Update().SetUpsert(true)
+	result, err := r.coll.UpdateOne(ctx, bson.M{"email": u.Email}, bson.M{"$setOnInsert": u}, opts)
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedID != nil, nil
+}
+
+func (r users) GetOrInsert(ctx context.Context, u models.User) (models.User, error) {
+	var found models.User
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := r.coll.FindOneAndUpdate(ctx, bson.M{"email": u.Email}, bson.M{"$setOnInsert": u}, opts).Decode(&found)
+	return found, err
+}
+
+func (r users) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.coll.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (r users) VerifyEmail(ctx context.Context, id primitive.ObjectID) (models.User, error) {
+	return r.update(ctx, id, bson.M{"$set": bson.M{"emailVerified": true}, "$unset": bson.M{"passwordHash": ""}})
+}
+
+func (r users) AddFavourite(ctx context.Context, id, eventID primitive.ObjectID) (models.User, error) {
+	return r.update(ctx, id, bson.M{"$addToSet": bson.M{"favouriteEvents": eventID}})
+}
+
+func (r users) RemoveFavourite(ctx context.Context, id, eventID primitive.ObjectID) (models.User, error) {
+	return r.update(ctx, id, bson.M{"$pull": bson.M{"favouriteEvents": eventID}})
+}
+
+func (r users) AddFollow(ctx context.Context, id primitive.ObjectID, kind, name string) (models.User, error) {
+	field, ok := followFields[kind]
+	if !ok {
+		return models.User{}, fmt.Errorf("unknown kind %q", kind)
+	}
+	return r.update(ctx, id, bson.M{"$addToSet": bson.M{field: name}})
+}
+
+func (r users) RemoveFollow(ctx context.Context, id primitive.ObjectID, kind, name string) (models.User, error) {
+	field, ok := followFields[kind]
+	if !ok {
+		return models.User{}, fmt.Errorf("unknown kind %q", kind)
+	}
+	return r.update(ctx, id, bson.M{"$pull": bson.M{field: name}})
+}
+
+// update applies the update to the user and returns the updated user.
+func (r users) update(ctx context.Context, id primitive.ObjectID, update bson.M) (models.User, error) {
+	var u models.User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.coll.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&u)
+	return u, notFound(err)
+}
+
+type sessions struct {
+	coll       *mongo.Collection
+	magicLinks *mongo.Collection
+}
+
+func (r sessions) Add(ctx context.Context, s models.Session) error {
+	_, err := r.coll.InsertOne(ctx, s)
+	return err
+}
+
+func (r sessions) Get(ctx context.Context, tokenHash string, now time.Time) (models.Session, error) {
+	var s models.Session
+	err := r.coll.FindOne(ctx, unexpiredFilter(tokenHash, now)).Decode(&s)
+	return s, notFound(err)
+}
+
+func (r sessions) Delete(ctx context.Context, tokenHash string) error {
+	_, err := r.coll.DeleteOne(ctx, bson.M{"tokenHash": tokenHash})
+	return err
+}
+
+func (r sessions) DeleteByUser(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.coll.DeleteMany(ctx, bson.M{"userId": userID})
+	return err
+}
+
+func (r sessions) AddMagicLink(ctx context.Context, l models.MagicLink) error {
+	_, err := r.magicLinks.InsertOne(ctx, l)
+	return err
+}
+
+func (r sessions) RedeemMagicLink(ctx context.Context, tokenHash string, now time.Time) (models.MagicLink, error) {
+	var l models.MagicLink
+	err := r.magicLinks.FindOneAndDelete(ctx, unexpiredFilter(tokenHash, now)).Decode(&l)
+	return l, notFound(err)
+}
+
+func unexpiredFilter(tokenHash string, now time.Time) bson.M {
+	return bson.M{"tokenHash": tokenHash, "expiresAt": bson.M{"$gt": now}}
+}
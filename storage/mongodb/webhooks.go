@@ -0,0 +1,85 @@
+package mongodb
+
+import (
+	"context"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type webhooks struct {
+	coll       *mongo.Collection
+	deliveries *mongo.Collection
+}
+
+func (r webhooks) Find(ctx context.Context, owner *primitive.ObjectID) ([]models.Webhook, error) {
+	filter := bson.M{}
+	if owner != nil {
+		filter["owner"] = *owner
+	}
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := r.coll.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	var results []models.Webhook
+	err = cursor.All(ctx, &results)
+	return results, err
+}
+
+func (r webhooks) Get(ctx context.Context, id primitive.ObjectID) (models.Webhook, error) {
+	var w models.Webhook
+	err := r.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&w)
+	return w, notFound(err)
+}
+
+func (r webhooks) Count(ctx context.Context) (int64, error) {
+	return r.coll.EstimatedDocumentCount(ctx)
+}
+
+func (r webhooks) Insert(ctx context.Context, w models.Webhook) error {
+	_, err := r.coll.InsertOne(ctx, w)
+	return err
+}
+
+func (r webhooks) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.coll.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return storage.ErrNotFound
+	}
+	_, err = r.deliveries.DeleteMany(ctx, bson.M{"webhookId": id})
+	return err
+}
+
+func (r webhooks) AddDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	_, err := r.deliveries.InsertOne(ctx, d)
+	return err
+}
+
+func (r webhooks) FindDeliveries(ctx context.Context, webhookID primitive.ObjectID, skip, limit int64) ([]models.WebhookDelivery, int64, error) {
+	filter := bson.M{"webhookId": webhookID}
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	findOptions.SetSkip(skip)
+	findOptions.SetLimit(limit)
+
+	total, err := r.deliveries.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := r.deliveries.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	var results []models.WebhookDelivery
+	err = cursor.All(ctx, &results)
+	return results, total, err
+}
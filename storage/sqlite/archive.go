@@ -0,0 +1,34 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+)
+
+// archive is a table of events with the schema of the events table.
+type archive struct {
+	events
+	// from is the table of the events that are archived
+	from string
+}
+
+func (r archive) MoveBefore(ctx context.Context, before time.Time) (int64, error) {
+	const past = " WHERE (recurring = 0 AND date < ?) OR last_date < ?"
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "INSERT OR REPLACE INTO "+r.table+" SELECT * FROM "+r.from+past, before.UnixMilli(), before.UnixMilli()); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM "+r.from+past, before.UnixMilli(), before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return moved, tx.Commit()
+}
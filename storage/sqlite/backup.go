@@ -0,0 +1,201 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type backup struct {
+	db *sql.DB
+}
+
+// backupTables are the tables of the kinds.
+var backupTables = map[string]string{
+	storage.KindEvents:        "events",
+	storage.KindArchive:       "events_archive",
+	storage.KindVenues:        "venues",
+	storage.KindCities:        "cities",
+	storage.KindGenres:        "genres",
+	storage.KindNotifications: "notifications",
+	storage.KindStatuses:      "statuses",
+}
+
+func (r backup) Dump(ctx context.Context, kind string, fn func(doc bson.Raw) error) error {
+	var query string
+	switch kind {
+	case storage.KindEvents, storage.KindArchive, storage.KindVenues, storage.KindCities:
+		query = "SELECT doc FROM " + backupTables[kind] + " ORDER BY rowid"
+	case storage.KindGenres:
+		query = "SELECT artist, genres FROM genres ORDER BY artist"
+	case storage.KindNotifications:
+		query = "SELECT " + notificationColumns + " FROM notifications ORDER BY rowid"
+	case storage.KindStatuses:
+		query = "SELECT doc, logs FROM statuses ORDER BY scraper_name"
+	default:
+		return fmt.Errorf("unknown kind %q", kind)
+	}
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var doc []byte
+		switch kind {
+		case storage.KindGenres:
+			var g models.TitleGenre
+			var genresJSON string
+			if err := rows.Scan(&g.Title, &genresJSON); err != nil {
+				return err
+			}
+			if err := json.Unmarshal([]byte(genresJSON), &g.Genres); err != nil {
+				return err
+			}
+			doc, err = bson.Marshal(g)
+		case storage.KindNotifications:
+			var n models.Notification
+			if n, err = scanNotification(rows); err != nil {
+				return err
+			}
+			doc, err = bson.Marshal(n)
+		case storage.KindStatuses:
+			// the logs are stored apart from the document
+			var s models.ScraperStatus
+			var status []byte
+			var logs string
+			if err := rows.Scan(&status, &logs); err != nil {
+				return err
+			}
+			if err := bson.Unmarshal(status, &s); err != nil {
+				return err
+			}
+			s.ScraperLogs = logs
+			doc, err = bson.Marshal(s)
+		default:
+			err = rows.Scan(&doc)
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r backup) Restore(ctx context.Context, kind string, docs []bson.Raw) error {
+	if _, ok := backupTables[kind]; !ok {
+		return fmt.Errorf("unknown kind %q", kind)
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, doc := range docs {
+		if err := restoreItem(ctx, tx, kind, doc); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// restoreItem stores the item of the kind, replacing the item with the same key.
+func restoreItem(ctx context.Context, tx *sql.Tx, kind string, doc bson.Raw) error {
+	switch kind {
+	case storage.KindEvents, storage.KindArchive:
+		var e models.Event
+		if err := bson.Unmarshal(doc, &e); err != nil {
+			return err
+		}
+		row, err := eventRow(e)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO "+backupTables[kind]+" ("+eventColumns+") VALUES (?"+strings.Repeat(", ?", len(row)-1)+")", row...)
+		return err
+	case storage.KindVenues:
+		var v models.Venue
+		if err := bson.Unmarshal(doc, &v); err != nil {
+			return err
+		}
+		venue, err := bson.Marshal(v)
+		if err != nil {
+			return err
+		}
+		a := v.Address
+		if _, err := tx.ExecContext(ctx, "DELETE FROM venues WHERE name = ? AND locality = ?", v.Name, a.Locality); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO venues (name, locality, state, country, doc) VALUES (?, ?, ?, ?, ?)",
+			v.Name, a.Locality, a.State, a.Country, venue)
+		return err
+	case storage.KindCities:
+		var c models.City
+		if err := bson.Unmarshal(doc, &c); err != nil {
+			return err
+		}
+		city, err := bson.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM cities WHERE name = ? AND state = ? AND country = ?", c.Name, c.State, c.Country); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO cities (name, state, country, doc) VALUES (?, ?, ?, ?)", c.Name, c.State, c.Country, city)
+		return err
+	case storage.KindGenres:
+		var g models.TitleGenre
+		if err := bson.Unmarshal(doc, &g); err != nil {
+			return err
+		}
+		genresJSON, err := json.Marshal(g.Genres)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO genres (artist, genres) VALUES (?, ?)", g.Title, string(genresJSON))
+		return err
+	case storage.KindNotifications:
+		var n models.Notification
+		if err := bson.Unmarshal(doc, &n); err != nil {
+			return err
+		}
+		query, err := bson.Marshal(n.Query)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO notifications ("+notificationColumns+") VALUES (?, ?, ?, ?, ?)",
+			n.Email, n.Token, query, n.SetupDate.UnixMilli(), n.Active)
+		return err
+	default:
+		var s models.ScraperStatus
+		if err := bson.Unmarshal(doc, &s); err != nil {
+			return err
+		}
+		logs := s.ScraperLogs
+		s.ScraperLogs = ""
+		status, err := bson.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO statuses (scraper_name, logs, doc) VALUES (?, ?, ?)", s.ScraperName, logs, status)
+		return err
+	}
+}
+
+func (r backup) Clear(ctx context.Context, kind string) error {
+	table, ok := backupTables[kind]
+	if !ok {
+		return fmt.Errorf("unknown kind %q", kind)
+	}
+	_, err := r.db.ExecContext(ctx, "DELETE FROM "+table)
+	return err
+}
@@ -0,0 +1,630 @@
+package sqlite
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jakopako/event-api/geo"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/moderation"
+	"github.com/jakopako/event-api/popularity"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// events stores the events in a table with the schema of the events table.
+type events struct {
+	db    *sql.DB
+	table string
+}
+
+func (r events) Get(ctx context.Context, id primitive.ObjectID) (models.Event, error) {
+	var doc []byte
+	err := r.db.QueryRowContext(ctx, "SELECT doc FROM "+r.table+" WHERE id = ?", id.Hex()).Scan(&doc)
+	if err != nil {
+		return models.Event{}, notFound(err)
+	}
+	var event models.Event
+	err = bson.Unmarshal(doc, &event)
+	return event, err
+}
+
+func (r events) Find(ctx context.Context, f storage.EventFilter, skip, limit int64) ([]models.Event, int64, error) {
+	w, err := singleEventsConditions(ctx, f)
+	if err != nil {
+		return nil, 0, err
+	}
+	if f.Expr == nil && f.Query.Sort != models.SortTrending {
+		var total int64
+		if err := r.db.QueryRowContext(ctx, "SELECT count(*) FROM "+r.table+w.String(), w.args...).Scan(&total); err != nil {
+			return nil, 0, err
+		}
+		events, err := r.find(ctx, w, " ORDER BY date LIMIT ? OFFSET ?", limit, skip)
+		return events, total, err
+	}
+
+	// the filter expression and the trending score are evaluated in Go
+	matched, err := r.findSingle(ctx, f, w)
+	if err != nil {
+		return nil, 0, err
+	}
+	if f.Query.Sort == models.SortTrending {
+		now := time.Now()
+		slices.SortStableFunc(matched, func(a, b models.Event) int {
+			if c := cmp.Compare(popularity.SortKey(b, now), popularity.SortKey(a, now)); c != 0 {
+				return c
+			}
+			return a.Date.Compare(b.Date)
+		})
+	}
+	total := int64(len(matched))
+	return matched[min(skip, total):min(skip+limit, total)], total, nil
+}
+
+// findSingle returns the events that match the conditions and the filter expression of f,
+// ordered by date.
+func (r events) findSingle(ctx context.Context, f storage.EventFilter, w *where) ([]models.Event, error) {
+	events, err := r.find(ctx, w, " ORDER BY date")
+	if err != nil || f.Expr == nil {
+		return events, err
+	}
+	var matched []models.Event
+	for _, e := range events {
+		if f.Expr.Match(e) {
+			matched = append(matched, e)
+		}
+	}
+	return matched, nil
+}
+
+func (r events) FindSeries(ctx context.Context, f storage.EventFilter, from, to time.Time) ([]models.Event, error) {
+	w, err := queryConditions(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	// the dates and the filter expression are checked per occurrence by the caller
+	w.add("recurring = 1")
+	w.add("date <= ?", to.UnixMilli())
+	w.add("(last_date IS NULL OR last_date >= ?)", from.UnixMilli())
+	return r.find(ctx, w, "")
+}
+
+func (r events) CountValues(ctx context.Context, f storage.EventFilter, keys []string) (map[string]map[string]int64, error) {
+	w, err := singleEventsConditions(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	matched, err := r.findSingle(ctx, f, w)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]map[string]int64{}
+	for _, key := range keys {
+		counts[key] = map[string]int64{}
+		for _, e := range matched {
+			for _, v := range shared.EventValues(e, key) {
+				counts[key][v]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+func (r events) FindByKeys(ctx context.Context, keys []models.EventKey) ([]models.Event, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	var values []string
+	for _, k := range keys {
+		values = append(values, shared.EventKeyString(k))
+	}
+	w := &where{}
+	w.in("source_key", values)
+	return r.find(ctx, w, "")
+}
+
+func (r events) FindByURLs(ctx context.Context, urls []string) ([]models.Event, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	w := &where{}
+	w.in("url", urls)
+	return r.find(ctx, w, "")
+}
+
+func (r events) Upsert(ctx context.Context, events []models.Event) (map[int]primitive.ObjectID, error) {
+	insertedIDs := map[int]primitive.ObjectID{}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for i, event := range events {
+		// events stored before the source key was introduced are matched by their fields
+		key := shared.EventKeyString(shared.UpsertKey(event))
+		var id string
+		err := tx.QueryRowContext(ctx,
+			"SELECT id FROM "+r.table+" WHERE source_key = ? OR (source_key IS NULL AND field_key = ?) LIMIT 1",
+			key, key).Scan(&id)
+		switch {
+		case err == nil:
+			if event.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+				return nil, err
+			}
+		case err == sql.ErrNoRows:
+			event.ID = primitive.NewObjectID()
+			insertedIDs[i] = event.ID
+		default:
+			return nil, err
+		}
+		row, err := eventRow(event)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT OR REPLACE INTO "+r.table+" ("+eventColumns+") VALUES (?"+strings.Repeat(", ?", len(row)-1)+")", row...); err != nil {
+			return nil, err
+		}
+	}
+	return insertedIDs, tx.Commit()
+}
+
+func (r events) FindBySource(ctx context.Context, sourceURL string, since *time.Time) ([]models.Event, error) {
+	return r.find(ctx, sourceConditions(sourceURL, since), "")
+}
+
+func (r events) DeleteBySource(ctx context.Context, sourceURL string, since *time.Time) (int64, error) {
+	w := sourceConditions(sourceURL, since)
+	result, err := r.db.ExecContext(ctx, "DELETE FROM "+r.table+w.String(), w.args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// find returns the events that match the conditions. The suffix, e.g. an ORDER BY clause, is
+// appended to the statement together with its arguments.
+func (r events) find(ctx context.Context, w *where, suffix string, args ...any) ([]models.Event, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT doc FROM "+r.table+w.String()+suffix, append(w.args, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[models.Event](rows)
+}
+
+const eventColumns = "id, date, last_date, recurring, title, normalized_title, location, city, country, type, url, " +
+	"source_url, source_key, field_key, moderation_status, genres, lon, lat, doc"
+
+// eventRow returns the values of the eventColumns of the event.
+func eventRow(e models.Event) ([]any, error) {
+	doc, err := bson.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	genres := e.Genres
+	if genres == nil {
+		genres = []string{}
+	}
+	genresJSON, err := json.Marshal(genres)
+	if err != nil {
+		return nil, err
+	}
+	var lastDate, sourceKey, lon, lat any
+	recurring := e.Recurrence != nil
+	if recurring && e.Recurrence.LastDate != nil {
+		lastDate = e.Recurrence.LastDate.UnixMilli()
+	}
+	if e.SourceKey != nil {
+		sourceKey = shared.EventKeyString(*e.SourceKey)
+	}
+	if c := e.Address.Geolocacation.Coordinates; len(c) >= 2 {
+		lon, lat = c[0], c[1]
+	}
+	return []any{
+		e.ID.Hex(), e.Date.UnixMilli(), lastDate, recurring, e.Title, e.NormalizedTitle, e.Location, e.City, e.Country,
+		e.Type, e.URL, e.SourceURL, sourceKey, shared.EventKeyString(shared.NewEventKey(e)), e.ModerationStatus,
+		string(genresJSON), lon, lat, doc,
+	}, nil
+}
+
+// where collects the conditions of a statement and their arguments.
+type where struct {
+	conditions []string
+	args       []any
+}
+
+func (w *where) add(condition string, args ...any) {
+	w.conditions = append(w.conditions, condition)
+	w.args = append(w.args, args...)
+}
+
+// in adds the condition that the column has one of the values.
+func (w *where) in(column string, values []string) {
+	w.add(column+" IN (?"+strings.Repeat(", ?", len(values)-1)+")", anys(values)...)
+}
+
+// String returns the WHERE clause or an empty string if there are no conditions.
+func (w *where) String() string {
+	if len(w.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conditions, " AND ")
+}
+
+func anys(values []string) []any {
+	var a []any
+	for _, v := range values {
+		a = append(a, v)
+	}
+	return a
+}
+
+// containsPattern returns the pattern that matches values containing s, ignoring case.
+func containsPattern(s string) string {
+	return "(?i)" + regexp.QuoteMeta(s)
+}
+
+// queryConditions returns the conditions of the filter that don't depend on the date. The
+// filter expression is left out since it is evaluated in Go.
+func queryConditions(ctx context.Context, f storage.EventFilter) (*where, error) {
+	q := f.Query
+	w := visibleConditions()
+
+	// Special handling for title to include normalized search
+	if q.Title != "" {
+		w.add("(regexp(?, title) OR regexp(?, normalized_title))", containsPattern(q.Title), containsPattern(shared.RemoveDiacritics(q.Title)))
+	}
+	for column, value := range map[string]string{"location": q.Location, "country": q.Country, "type": q.Type} {
+		if value != "" {
+			w.add("regexp(?, "+column+")", containsPattern(value))
+		}
+	}
+
+	genreConditions(w, q)
+
+	if q.City != "" {
+		cityCondition := "regexp(?, city)"
+		args := []any{"(?i)" + q.City}
+		if q.Radius > 0 {
+			if geolocs, err := geo.AllMatchesCityCoordinates(ctx, q.City, q.Country); err == nil && len(geolocs) > 0 {
+				cityCondition += " OR distance(?, ?, lon, lat) <= ?"
+				args = append(args, geolocs[0].Coordinates[0], geolocs[0].Coordinates[1], float64(q.Radius))
+			}
+		}
+		w.add("("+cityCondition+")", args...)
+	}
+
+	if f.Follows != nil {
+		followsConditions(w, f.Follows)
+	}
+	return w, nil
+}
+
+// followsConditions adds the condition that matches the events of the follows.
+func followsConditions(w *where, f *storage.Follows) {
+	var conditions []string
+	var args []any
+	for _, a := range f.Artists {
+		conditions = append(conditions, "regexp(?, normalized_title)")
+		args = append(args, containsPattern(a))
+	}
+	for column, values := range map[string][]string{"location": f.Venues, "city": f.Cities} {
+		for _, v := range values {
+			conditions = append(conditions, "regexp(?, "+column+")")
+			args = append(args, "(?i)^"+regexp.QuoteMeta(v)+"$")
+		}
+	}
+	if len(conditions) == 0 {
+		// users that don't follow anything have an empty feed
+		w.add("0")
+		return
+	}
+	w.add("("+strings.Join(conditions, " OR ")+")", args...)
+}
+
+// genreConditions adds the conditions on the genres and types of the query.
+func genreConditions(w *where, q models.Query) {
+	if len(q.Genres) > 0 {
+		var conditions []string
+		var args []any
+		for _, g := range q.Genres {
+			condition, arg := genreCondition(q, g)
+			conditions = append(conditions, condition)
+			args = append(args, arg)
+		}
+		if q.GenreMode == models.GenreModeAll {
+			for i := range conditions {
+				w.add(conditions[i], args[i])
+			}
+		} else {
+			w.add("("+strings.Join(conditions, " OR ")+")", args...)
+		}
+	}
+	for _, g := range q.ExcludeGenres {
+		condition, arg := genreCondition(q, g)
+		w.add("NOT "+condition, arg)
+	}
+	if len(q.ExcludeTypes) > 0 {
+		w.add("type NOT IN (?"+strings.Repeat(", ?", len(q.ExcludeTypes)-1)+")", anys(shared.LowerAll(q.ExcludeTypes))...)
+	}
+}
+
+// genreCondition returns the condition that an event has the genre or, if subgenres are
+// included, one of its subgenres.
+func genreCondition(q models.Query, genre string) (string, any) {
+	if q.Subgenres {
+		return "EXISTS (SELECT 1 FROM json_each(genres) WHERE regexp(?, value))", "(?i)" + shared.SubgenrePattern(genre)
+	}
+	return "EXISTS (SELECT 1 FROM json_each(genres) WHERE value = ?)", genre
+}
+
+// singleEventsConditions returns the conditions that match the events that don't recur and
+// match the filter within the date window of its query.
+func singleEventsConditions(ctx context.Context, f storage.EventFilter) (*where, error) {
+	w, err := queryConditions(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	w.add("recurring = 0")
+	q := f.Query
+	if q.StartDate != nil {
+		if q.EndDate == nil {
+			w.add("date > ?", q.StartDate.UnixMilli())
+		} else {
+			w.add("date >= ? AND date <= ?", q.StartDate.UnixMilli(), q.EndDate.UnixMilli())
+		}
+	}
+	return w, nil
+}
+
+func sourceConditions(sourceURL string, since *time.Time) *where {
+	w := &where{}
+	if since != nil {
+		w.add("date >= ?", since.UnixMilli())
+		if sourceURL == "" {
+			return w
+		}
+	}
+	w.add("source_url = ?", sourceURL)
+	return w
+}
+
+func (r events) FindByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.Event, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var hexes []string
+	for _, id := range ids {
+		hexes = append(hexes, id.Hex())
+	}
+	w := visibleConditions()
+	w.in("id", hexes)
+	return r.find(ctx, w, "")
+}
+
+func (r events) FindOverridden(ctx context.Context, skip, limit int64) ([]models.Event, int64, error) {
+	// the overrides aren't indexed since only admins list them
+	events, err := r.find(ctx, &where{}, "")
+	if err != nil {
+		return nil, 0, err
+	}
+	overridden := slices.DeleteFunc(events, func(e models.Event) bool { return e.Overrides == nil })
+	slices.SortStableFunc(overridden, func(a, b models.Event) int { return b.Overrides.UpdatedAt.Compare(a.Overrides.UpdatedAt) })
+	total := int64(len(overridden))
+	return overridden[min(skip, total):min(skip+limit, total)], total, nil
+}
+
+func (r events) FindPending(ctx context.Context, skip, limit int64) ([]models.Event, int64, error) {
+	w := &where{}
+	w.add("moderation_status = ?", moderation.StatusPending)
+	var total int64
+	if err := r.db.QueryRowContext(ctx, "SELECT count(*) FROM "+r.table+w.String(), w.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	events, err := r.find(ctx, w, " ORDER BY date LIMIT ? OFFSET ?", limit, skip)
+	return events, total, err
+}
+
+func (r events) SetOverrides(ctx context.Context, e models.Event) error {
+	_, err := r.update(ctx, e.ID, func(stored *models.Event) bool {
+		*stored = e
+		return true
+	})
+	return err
+}
+
+func (r events) ClearOverrides(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.update(ctx, id, func(e *models.Event) bool {
+		e.Overrides = nil
+		return true
+	})
+	return err
+}
+
+func (r events) SetModerationStatus(ctx context.Context, id primitive.ObjectID, status string, key models.EventKey) error {
+	_, err := r.update(ctx, id, func(e *models.Event) bool {
+		e.ModerationStatus = status
+		e.SourceKey = &key
+		return true
+	})
+	return err
+}
+
+func (r events) SetCancelled(ctx context.Context, id primitive.ObjectID, cancelled []time.Time, key models.EventKey) error {
+	_, err := r.update(ctx, id, func(e *models.Event) bool {
+		if e.Recurrence != nil {
+			e.Recurrence.Cancelled = cancelled
+		}
+		e.SourceKey = &key
+		return true
+	})
+	return err
+}
+
+func (r events) SetAddress(ctx context.Context, id primitive.ObjectID, address models.Address) error {
+	_, err := r.update(ctx, id, func(e *models.Event) bool {
+		e.Address = address
+		e.CityFallback = false
+		return true
+	})
+	return err
+}
+
+func (r events) SetGenres(ctx context.Context, id primitive.ObjectID, genres []string) error {
+	_, err := r.update(ctx, id, func(e *models.Event) bool {
+		e.Genres = genres
+		return true
+	})
+	return err
+}
+
+func (r events) RecordInteraction(ctx context.Context, id primitive.ObjectID, interaction string, t time.Time) (models.Event, error) {
+	return r.update(ctx, id, func(e *models.Event) bool {
+		if !shared.IsVisible(*e) {
+			return false
+		}
+		popularity.Record(e, interaction, t)
+		return true
+	})
+}
+
+// update calls fn with the event with the given id within a transaction and stores the
+// updated event. If fn returns false or there is no such event, ErrNotFound is returned.
+func (r events) update(ctx context.Context, id primitive.ObjectID, fn func(e *models.Event) bool) (models.Event, error) {
+	var event models.Event
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return event, err
+	}
+	defer tx.Rollback()
+	var doc []byte
+	if err := tx.QueryRowContext(ctx, "SELECT doc FROM "+r.table+" WHERE id = ?", id.Hex()).Scan(&doc); err != nil {
+		return event, notFound(err)
+	}
+	if err := bson.Unmarshal(doc, &event); err != nil {
+		return event, err
+	}
+	if !fn(&event) {
+		return models.Event{}, storage.ErrNotFound
+	}
+	row, err := eventRow(event)
+	if err != nil {
+		return event, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT OR REPLACE INTO "+r.table+" ("+eventColumns+") VALUES (?"+strings.Repeat(", ?", len(row)-1)+")", row...); err != nil {
+		return event, err
+	}
+	return event, tx.Commit()
+}
+
+func (r events) FindSimilar(ctx context.Context, f storage.SimilarFilter, limit int64) ([]models.Event, error) {
+	var related []string
+	var args []any
+	if len(f.Genres) > 0 {
+		related = append(related, "EXISTS (SELECT 1 FROM json_each(genres) WHERE value IN (?"+strings.Repeat(", ?", len(f.Genres)-1)+"))")
+		args = append(args, anys(f.Genres)...)
+	}
+	for _, a := range f.Artists {
+		related = append(related, "regexp(?, normalized_title)")
+		args = append(args, containsPattern(a))
+	}
+	if f.Location != "" {
+		related = append(related, "(location = ? AND city = ?)")
+		args = append(args, f.Location, f.City)
+	}
+	if len(f.Coordinates) == 2 {
+		related = append(related, "distance(?, ?, lon, lat) <= ?")
+		args = append(args, f.Coordinates[0], f.Coordinates[1], f.RadiusKm)
+	}
+	if len(related) == 0 {
+		return nil, nil
+	}
+
+	w := upcomingConditions(f.Since)
+	w.add("id != ?", f.ExcludeID.Hex())
+	w.add("("+strings.Join(related, " OR ")+")", args...)
+	return r.find(ctx, w, " ORDER BY date LIMIT ?", limit)
+}
+
+func (r events) AutocompleteValues(ctx context.Context, f storage.AutocompleteFilter) (storage.AutocompleteValues, error) {
+	var values storage.AutocompleteValues
+	w := upcomingConditions(f.Since)
+	var err error
+	if f.Venues {
+		if values.Venues, err = r.countSuggestions(ctx, "SELECT location, city, count(*) FROM "+r.table+w.String()+" GROUP BY location, city", w.args); err != nil {
+			return values, err
+		}
+	}
+	if f.Cities {
+		if values.Cities, err = r.countSuggestions(ctx, "SELECT city, '', count(*) FROM "+r.table+w.String()+" GROUP BY city", w.args); err != nil {
+			return values, err
+		}
+	}
+	if f.Genres {
+		if values.Genres, err = r.countSuggestions(ctx, "SELECT g.value, '', count(*) FROM "+r.table+", json_each(genres) AS g"+w.String()+" GROUP BY g.value", w.args); err != nil {
+			return values, err
+		}
+	}
+	if f.TitlePattern != "" {
+		tw := upcomingConditions(f.Since)
+		tw.add("regexp(?, normalized_title)", "(?i)"+f.TitlePattern)
+		rows, err := r.db.QueryContext(ctx, "SELECT title FROM "+r.table+tw.String()+" LIMIT ?", append(tw.args, f.MaxTitles)...)
+		if err != nil {
+			return values, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var title string
+			if err := rows.Scan(&title); err != nil {
+				return values, err
+			}
+			values.Titles = append(values.Titles, title)
+		}
+		if err := rows.Err(); err != nil {
+			return values, err
+		}
+	}
+	return values, nil
+}
+
+// countSuggestions returns the suggestions of a query that selects the value, the city and
+// the count.
+func (r events) countSuggestions(ctx context.Context, query string, args []any) ([]models.Suggestion, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var suggestions []models.Suggestion
+	for rows.Next() {
+		var s models.Suggestion
+		if err := rows.Scan(&s.Value, &s.City, &s.Count); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, rows.Err()
+}
+
+// visibleConditions returns the condition that matches the visible events, see
+// shared.VisibleEventsFilter.
+func visibleConditions() *where {
+	w := &where{}
+	w.add("moderation_status NOT IN (?"+strings.Repeat(", ?", len(moderation.HiddenStatuses)-1)+")", anys(moderation.HiddenStatuses)...)
+	return w
+}
+
+// upcomingConditions returns the conditions that match the visible events after since and
+// the recurring events with occurrences after it, see shared.UpcomingEventsFilter.
+func upcomingConditions(since time.Time) *where {
+	w := visibleConditions()
+	w.add("(date > ? OR (recurring = 1 AND (last_date IS NULL OR last_date >= ?)))", since.UnixMilli(), since.UnixMilli())
+	return w
+}
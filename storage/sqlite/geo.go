@@ -0,0 +1,137 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/jakopako/event-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type cities struct {
+	db *sql.DB
+}
+
+func (r cities) Get(ctx context.Context, name, state, country string) (models.City, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT doc FROM cities WHERE name = ? AND state = ? AND country = ? LIMIT 1", name, state, country)
+	if err != nil {
+		return models.City{}, err
+	}
+	return first(decodeAll[models.City](rows))
+}
+
+func (r cities) FindByName(ctx context.Context, name, country string) ([]models.City, error) {
+	w := &where{}
+	w.add("name = ?", name)
+	if country != "" {
+		w.add("country = ?", country)
+	}
+	rows, err := r.db.QueryContext(ctx, "SELECT doc FROM cities"+w.String(), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[models.City](rows)
+}
+
+func (r cities) Find(ctx context.Context, country string, limit int64) ([]models.City, error) {
+	w := &where{}
+	if country != "" {
+		w.add("country = ?", strings.ToLower(country))
+	}
+	rows, err := r.db.QueryContext(ctx, "SELECT doc FROM cities"+w.String()+" ORDER BY name LIMIT ?", append(w.args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	cities, err := decodeAll[models.City](rows)
+	if cities == nil {
+		cities = []models.City{}
+	}
+	return cities, err
+}
+
+func (r cities) Insert(ctx context.Context, c models.City) error {
+	doc, err := bson.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, "INSERT INTO cities (name, state, country, doc) VALUES (?, ?, ?, ?)", c.Name, c.State, c.Country, doc)
+	return err
+}
+
+type venues struct {
+	db *sql.DB
+}
+
+func (r venues) Get(ctx context.Context, name, city, state, country string) (models.Venue, error) {
+	w := &where{}
+	w.add("name = ?", name)
+	w.add("locality = ?", city)
+	if state != "" {
+		w.add("state = ?", state)
+	}
+	if country != "" {
+		w.add("country = ?", country)
+	}
+	rows, err := r.db.QueryContext(ctx, "SELECT doc FROM venues"+w.String()+" LIMIT 1", w.args...)
+	if err != nil {
+		return models.Venue{}, err
+	}
+	return first(decodeAll[models.Venue](rows))
+}
+
+func (r venues) Find(ctx context.Context, city, name string, limit int64) ([]models.Venue, error) {
+	w := &where{}
+	if city != "" {
+		w.add("regexp(?, locality)", "(?i)^"+regexp.QuoteMeta(city)+"$")
+	}
+	if name != "" {
+		w.add("regexp(?, name)", containsPattern(name))
+	}
+	rows, err := r.db.QueryContext(ctx, "SELECT doc FROM venues"+w.String()+" ORDER BY name LIMIT ?", append(w.args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	venues, err := decodeAll[models.Venue](rows)
+	if venues == nil {
+		venues = []models.Venue{}
+	}
+	return venues, err
+}
+
+func (r venues) Insert(ctx context.Context, v models.Venue) error {
+	doc, err := bson.Marshal(v)
+	if err != nil {
+		return err
+	}
+	a := v.Address
+	_, err = r.db.ExecContext(ctx, "INSERT INTO venues (name, locality, state, country, doc) VALUES (?, ?, ?, ?, ?)",
+		v.Name, a.Locality, a.State, a.Country, doc)
+	return err
+}
+
+type artistGenres struct {
+	db *sql.DB
+}
+
+func (r artistGenres) Get(ctx context.Context, artist string) ([]string, error) {
+	var genresJSON string
+	err := r.db.QueryRowContext(ctx, "SELECT genres FROM genres WHERE artist = ?", artist).Scan(&genresJSON)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	var genres []string
+	err = json.Unmarshal([]byte(genresJSON), &genres)
+	return genres, err
+}
+
+func (r artistGenres) Insert(ctx context.Context, artist string, genres []string) error {
+	genresJSON, err := json.Marshal(genres)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, "INSERT OR REPLACE INTO genres (artist, genres) VALUES (?, ?)", artist, string(genresJSON))
+	return err
+}
@@ -0,0 +1,84 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type notifications struct {
+	db *sql.DB
+}
+
+func (r notifications) Add(ctx context.Context, n models.Notification) (bool, error) {
+	// like in MongoDB queries are equal if their documents are equal
+	query, err := bson.Marshal(n.Query)
+	if err != nil {
+		return false, err
+	}
+	result, err := r.db.ExecContext(ctx,
+		"INSERT INTO notifications (email, token, query, setup_date, active) VALUES (?, ?, ?, ?, ?) ON CONFLICT (email, query) DO NOTHING",
+		n.Email, n.Token, query, n.SetupDate.UnixMilli(), n.Active)
+	if err != nil {
+		return false, err
+	}
+	added, err := result.RowsAffected()
+	return added > 0, err
+}
+
+func (r notifications) Get(ctx context.Context, email, token string) (models.Notification, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT "+notificationColumns+" FROM notifications WHERE email = ? AND token = ?", email, token)
+	n, err := scanNotification(row)
+	return n, notFound(err)
+}
+
+func (r notifications) Activate(ctx context.Context, email, token string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE notifications SET active = 1 WHERE email = ? AND token = ?", email, token)
+	return err
+}
+
+func (r notifications) Delete(ctx context.Context, email, token string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM notifications WHERE email = ? AND token = ?", email, token)
+	return err
+}
+
+func (r notifications) DeleteInactive(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM notifications WHERE active = 0 AND setup_date < ?", before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r notifications) FindActive(ctx context.Context) ([]models.Notification, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+notificationColumns+" FROM notifications WHERE active = 1")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var active []models.Notification
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		active = append(active, n)
+	}
+	return active, rows.Err()
+}
+
+const notificationColumns = "email, token, query, setup_date, active"
+
+func scanNotification(row interface{ Scan(...any) error }) (models.Notification, error) {
+	var n models.Notification
+	var query []byte
+	var setupDate int64
+	if err := row.Scan(&n.Email, &n.Token, &query, &setupDate, &n.Active); err != nil {
+		return n, err
+	}
+	n.SetupDate = time.UnixMilli(setupDate).UTC()
+	return n, bson.Unmarshal(query, &n.Query)
+}
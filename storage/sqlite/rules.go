@@ -0,0 +1,36 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jakopako/event-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type rules struct {
+	db *sql.DB
+}
+
+func (r rules) Find(ctx context.Context) ([]models.ModerationRule, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT doc FROM moderation_rules ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[models.ModerationRule](rows)
+}
+
+func (r rules) Insert(ctx context.Context, rule models.ModerationRule) error {
+	doc, err := bson.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, "INSERT INTO moderation_rules (id, created_at, doc) VALUES (?, ?, ?)",
+		rule.ID.Hex(), rule.CreatedAt.UnixMilli(), doc)
+	return err
+}
+
+func (r rules) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return affected(r.db.ExecContext(ctx, "DELETE FROM moderation_rules WHERE id = ?", id.Hex()))
+}
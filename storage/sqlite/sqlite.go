@@ -0,0 +1,272 @@
+// Package sqlite implements the repositories of package storage with an embedded SQLite
+// database. It is meant for small deployments, e.g. for the events of a single city, that
+// don't want to operate MongoDB.
+//
+// The items are stored as BSON documents, like in MongoDB, next to the columns that are
+// needed to query them. Regular expressions and distances are computed by the functions
+// regexp and distance, which are implemented in Go.
+package sqlite
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/jakopako/event-api/geo"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	sqlitedriver "modernc.org/sqlite"
+)
+
+// eventsTable is the schema of a table of events, the events and the archive.
+const eventsTable = `
+CREATE TABLE IF NOT EXISTS %[1]s (
+	id TEXT PRIMARY KEY,
+	date INTEGER NOT NULL,
+	last_date INTEGER,
+	recurring INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	normalized_title TEXT NOT NULL,
+	location TEXT NOT NULL,
+	city TEXT NOT NULL,
+	country TEXT NOT NULL,
+	type TEXT NOT NULL,
+	url TEXT NOT NULL,
+	source_url TEXT NOT NULL,
+	source_key TEXT,
+	field_key TEXT NOT NULL,
+	moderation_status TEXT NOT NULL,
+	genres TEXT NOT NULL,
+	lon REAL,
+	lat REAL,
+	doc BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS %[1]s_date ON %[1]s (date);
+CREATE UNIQUE INDEX IF NOT EXISTS %[1]s_source_key ON %[1]s (source_key);
+CREATE INDEX IF NOT EXISTS %[1]s_field_key ON %[1]s (field_key);
+CREATE INDEX IF NOT EXISTS %[1]s_url ON %[1]s (url);
+CREATE INDEX IF NOT EXISTS %[1]s_source_url ON %[1]s (source_url, date);
+`
+
+const schema = `
+CREATE TABLE IF NOT EXISTS notifications (
+	email TEXT NOT NULL,
+	token TEXT NOT NULL,
+	query BLOB NOT NULL,
+	setup_date INTEGER NOT NULL,
+	active INTEGER NOT NULL,
+	PRIMARY KEY (email, token),
+	UNIQUE (email, query)
+);
+
+CREATE TABLE IF NOT EXISTS statuses (
+	scraper_name TEXT PRIMARY KEY,
+	logs TEXT NOT NULL,
+	doc BLOB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS cities (
+	name TEXT NOT NULL,
+	state TEXT NOT NULL,
+	country TEXT NOT NULL,
+	doc BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS cities_name ON cities (name, country);
+
+CREATE TABLE IF NOT EXISTS venues (
+	name TEXT NOT NULL,
+	locality TEXT NOT NULL,
+	state TEXT NOT NULL,
+	country TEXT NOT NULL,
+	doc BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS venues_name ON venues (name, locality);
+
+CREATE TABLE IF NOT EXISTS genres (
+	artist TEXT PRIMARY KEY,
+	genres TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS moderation_rules (
+	id TEXT PRIMARY KEY,
+	created_at INTEGER NOT NULL,
+	doc BLOB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id TEXT PRIMARY KEY,
+	created_at INTEGER NOT NULL,
+	doc BLOB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	webhook_id TEXT NOT NULL,
+	timestamp INTEGER NOT NULL,
+	doc BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, timestamp);
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	email TEXT NOT NULL UNIQUE,
+	doc BLOB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	token_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_user_id ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS magic_links (
+	token_hash TEXT PRIMARY KEY,
+	email TEXT NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+`
+
+func init() {
+	sqlitedriver.MustRegisterDeterministicScalarFunction("regexp", 2, regexpFunc)
+	sqlitedriver.MustRegisterDeterministicScalarFunction("distance", 4, distanceFunc)
+}
+
+// Open opens the database file at the given path. The file and the tables are created if
+// they don't exist yet.
+func Open(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows only one writer at a time
+	db.SetMaxOpenConns(1)
+	for _, table := range []string{"events", "events_archive"} {
+		if _, err := db.Exec(fmt.Sprintf(eventsTable, table)); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+	return db, nil
+}
+
+// New returns the store that keeps its data in the given database, which has to have
+// been opened with Open before.
+func New(db *sql.DB) storage.Store {
+	return storage.Store{
+		Events:        events{db, "events"},
+		Archive:       archive{events{db, "events_archive"}, "events"},
+		Notifications: notifications{db},
+		Statuses:      statuses{db},
+		Cities:        cities{db},
+		Venues:        venues{db},
+		ArtistGenres:  artistGenres{db},
+		Rules:         rules{db},
+		Webhooks:      webhooks{db},
+		Users:         users{db},
+		Sessions:      sessions{db},
+		Backup:        backup{db},
+	}
+}
+
+// regexps caches the compiled patterns of the regexp function.
+var regexps sync.Map
+
+// regexpFunc implements the function regexp(pattern, value) and thereby the REGEXP operator.
+// Values that are NULL never match.
+func regexpFunc(ctx *sqlitedriver.FunctionContext, args []driver.Value) (driver.Value, error) {
+	pattern, ok := args[0].(string)
+	if !ok {
+		return nil, errors.New("regexp: pattern has to be a string")
+	}
+	value, ok := args[1].(string)
+	if !ok {
+		return false, nil
+	}
+	r, found := regexps.Load(pattern)
+	if !found {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		r, _ = regexps.LoadOrStore(pattern, compiled)
+	}
+	return r.(*regexp.Regexp).MatchString(value), nil
+}
+
+// distanceFunc implements the function distance(lon1, lat1, lon2, lat2), the haversine
+// distance in kilometers, see geo.DistanceKm. It returns NULL if a coordinate is missing.
+func distanceFunc(ctx *sqlitedriver.FunctionContext, args []driver.Value) (driver.Value, error) {
+	coordinates := make([]float64, len(args))
+	for i, a := range args {
+		switch v := a.(type) {
+		case float64:
+			coordinates[i] = v
+		case int64:
+			coordinates[i] = float64(v)
+		default:
+			return nil, nil
+		}
+	}
+	return geo.DistanceKm(coordinates[:2], coordinates[2:]), nil
+}
+
+// notFound translates the error of database/sql for missing rows.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.ErrNotFound
+	}
+	return err
+}
+
+// affected returns ErrNotFound if the statement that returned the result didn't affect
+// any row.
+func affected(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// decodeAll decodes the BSON documents in the first column of the rows.
+func decodeAll[T any](rows *sql.Rows) ([]T, error) {
+	defer rows.Close()
+	var items []T
+	for rows.Next() {
+		var doc []byte
+		if err := rows.Scan(&doc); err != nil {
+			return nil, err
+		}
+		var item T
+		if err := bson.Unmarshal(doc, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// first returns the first of the items or ErrNotFound if there are none.
+func first[T any](items []T, err error) (T, error) {
+	var item T
+	if err != nil {
+		return item, err
+	}
+	if len(items) == 0 {
+		return item, storage.ErrNotFound
+	}
+	return items[0], nil
+}
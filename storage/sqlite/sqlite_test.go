@@ -0,0 +1,572 @@
+package sqlite_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jakopako/event-api/geo"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/moderation"
+	"github.com/jakopako/event-api/popularity"
+	"github.com/jakopako/event-api/search"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+	"github.com/jakopako/event-api/storage/sqlite"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var day = time.Date(2026, 11, 2, 0, 0, 0, 0, time.UTC)
+
+// open sets up storage.S with a new database in a temporary directory.
+func open(t *testing.T) context.Context {
+	t.Helper()
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	storage.S = sqlite.New(db)
+	geo.InitGeolocCache()
+	return context.Background()
+}
+
+func event(title, city string, lon, lat float64, date time.Time, genres ...string) models.Event {
+	e := models.Event{
+		Title:           title,
+		NormalizedTitle: shared.RemoveDiacritics(title),
+		Location:        "Venue " + title,
+		City:            city,
+		Country:         "Switzerland",
+		Date:            date,
+		URL:             "http://example.com/" + title,
+		Type:            "concert",
+		SourceURL:       "http://example.com/" + city,
+		Genres:          genres,
+	}
+	key := shared.NewEventKey(e)
+	e.SourceKey = &key
+	e.Address.Geolocacation.GeoJSONType = "Point"
+	e.Address.Geolocacation.Coordinates = []float64{lon, lat}
+	return e
+}
+
+func seed(t *testing.T, ctx context.Context) {
+	t.Helper()
+	zurich := models.City{Name: "zurich", Country: "switzerland"}
+	zurich.Geolocation.Coordinates = []float64{8.5417, 47.3769}
+	if err := storage.S.Cities.Insert(ctx, zurich); err != nil {
+		t.Fatal(err)
+	}
+	hidden := event("Hidden Gig", "Zurich", 8.54, 47.37, day.Add(21*time.Hour), "jazz")
+	hidden.ModerationStatus = moderation.StatusPending
+	weekly := event("Weekly Jam", "Zurich", 8.54, 47.37, day.Add(-7*24*time.Hour), "jazz")
+	weekly.Recurrence = &models.Recurrence{RRule: "FREQ=WEEKLY"}
+	if _, err := storage.S.Events.Upsert(ctx, []models.Event{
+		event("Jazz Night", "Zurich", 8.54, 47.37, day.Add(20*time.Hour), "jazz", "soul"),
+		event("Techno Party", "Winterthur", 8.72, 47.50, day.Add(44*time.Hour), "techno"),
+		event("Café Concert", "Bern", 7.44, 46.95, day.Add(68*time.Hour), "jazz"),
+		event("Hard Techno Rave", "Bern", 7.44, 46.95, day.Add(92*time.Hour), "hard techno"),
+		hidden,
+		weekly,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func titles(events []models.Event) []string {
+	var t []string
+	for _, e := range events {
+		t = append(t, e.Title)
+	}
+	return t
+}
+
+func TestFind(t *testing.T) {
+	ctx := open(t)
+	seed(t, ctx)
+	start := day
+	end := day.Add(24 * time.Hour)
+	tests := []struct {
+		q    models.Query
+		want []string
+	}{
+		{models.Query{StartDate: &start}, []string{"Jazz Night", "Techno Party", "Café Concert", "Hard Techno Rave"}},
+		{models.Query{StartDate: &start, EndDate: &end}, []string{"Jazz Night"}},
+		{models.Query{Title: "TECHNO"}, []string{"Techno Party", "Hard Techno Rave"}},
+		{models.Query{Title: "cafe"}, []string{"Café Concert"}},
+		{models.Query{Title: "café"}, []string{"Café Concert"}},
+		{models.Query{Location: "venue jazz"}, []string{"Jazz Night"}},
+		{models.Query{City: "zurich", Radius: 30}, []string{"Jazz Night", "Techno Party"}},
+		{models.Query{City: "bern|winterthur"}, []string{"Techno Party", "Café Concert", "Hard Techno Rave"}},
+		{models.Query{Genres: []string{"jazz", "techno"}}, []string{"Jazz Night", "Techno Party", "Café Concert"}},
+		{models.Query{Genres: []string{"jazz", "soul"}, GenreMode: models.GenreModeAll}, []string{"Jazz Night"}},
+		{models.Query{Genres: []string{"techno"}, Subgenres: true}, []string{"Techno Party", "Hard Techno Rave"}},
+		{models.Query{ExcludeGenres: []string{"jazz"}}, []string{"Techno Party", "Hard Techno Rave"}},
+		{models.Query{ExcludeGenres: []string{"techno"}, Subgenres: true}, []string{"Jazz Night", "Café Concert"}},
+		{models.Query{ExcludeTypes: []string{"concert"}}, nil},
+		{models.Query{ExcludeTypes: []string{"CONCERT"}}, nil},
+	}
+	for _, tc := range tests {
+		got, total, err := storage.S.Events.Find(ctx, storage.EventFilter{Query: tc.q}, 0, 10)
+		if err != nil {
+			t.Errorf("query %+v: %v", tc.q, err)
+			continue
+		}
+		if !slices.Equal(titles(got), tc.want) || total != int64(len(tc.want)) {
+			t.Errorf("query %+v: got %v (%d), want %v", tc.q, titles(got), total, tc.want)
+		}
+	}
+}
+
+func TestFindWithExpressionAndPaging(t *testing.T) {
+	ctx := open(t)
+	seed(t, ctx)
+	expr, err := search.Parse(`genres in ("jazz", "techno") and not city = "Bern"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, total, err := storage.S.Events.Find(ctx, storage.EventFilter{Expr: expr}, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Techno Party"}; !slices.Equal(titles(got), want) || total != 2 {
+		t.Errorf("got %v (%d), want %v (2)", titles(got), total, want)
+	}
+
+	got, total, err = storage.S.Events.Find(ctx, storage.EventFilter{}, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Café Concert"}; !slices.Equal(titles(got), want) || total != 4 {
+		t.Errorf("got %v (%d), want %v (4)", titles(got), total, want)
+	}
+}
+
+func TestFindSeriesAndCountValues(t *testing.T) {
+	ctx := open(t)
+	seed(t, ctx)
+	series, err := storage.S.Events.FindSeries(ctx, storage.EventFilter{Query: models.Query{Genres: []string{"jazz"}}}, day, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Weekly Jam"}; !slices.Equal(titles(series), want) {
+		t.Errorf("got series %v, want %v", titles(series), want)
+	}
+
+	counts, err := storage.S.Events.CountValues(ctx, storage.EventFilter{}, []string{"city", shared.IntervalDay})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["city"]["Bern"] != 2 || counts["city"]["Zurich"] != 1 || counts[shared.IntervalDay]["2026-11-02"] != 1 {
+		t.Errorf("got counts %v", counts)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	ctx := open(t)
+	seed(t, ctx)
+	// the hidden gig is archived as well but stays hidden
+	moved, err := storage.S.Archive.MoveBefore(ctx, day.Add(30*time.Hour))
+	if err != nil || moved != 2 {
+		t.Fatalf("moved %d events with error %v, want 2", moved, err)
+	}
+	archived, _, err := storage.S.Archive.Find(ctx, storage.EventFilter{}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Jazz Night"}; !slices.Equal(titles(archived), want) {
+		t.Errorf("got archived events %v, want %v", titles(archived), want)
+	}
+	// recurring events without a last date are never archived
+	series, err := storage.S.Events.FindSeries(ctx, storage.EventFilter{}, day, day.Add(24*time.Hour))
+	if err != nil || len(series) != 1 {
+		t.Errorf("got series %v with error %v, want the weekly jam", titles(series), err)
+	}
+	current, _, err := storage.S.Events.Find(ctx, storage.EventFilter{}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Techno Party", "Café Concert", "Hard Techno Rave"}; !slices.Equal(titles(current), want) {
+		t.Errorf("got events %v, want %v", titles(current), want)
+	}
+	if moved, err := storage.S.Archive.MoveBefore(ctx, day.Add(30*time.Hour)); err != nil || moved != 0 {
+		t.Errorf("moved %d events again with error %v, want 0", moved, err)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	ctx := open(t)
+	e := event("Jazz Night", "Zurich", 8.54, 47.37, day.Add(20*time.Hour), "jazz")
+	inserted, err := storage.S.Events.Upsert(ctx, []models.Event{e})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inserted) != 1 {
+		t.Fatalf("got %d inserted events, want 1", len(inserted))
+	}
+
+	// an overridden title doesn't change the source key
+	e.Title = "Corrected Jazz Night"
+	inserted, err = storage.S.Events.Upsert(ctx, []models.Event{e})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inserted) != 0 {
+		t.Errorf("got %d inserted events, want 0", len(inserted))
+	}
+	stored, err := storage.S.Events.FindByKeys(ctx, []models.EventKey{*e.SourceKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].Title != e.Title || stored[0].SourceKey == nil {
+		t.Fatalf("got %+v, want the corrected event", stored)
+	}
+	got, err := storage.S.Events.Get(ctx, stored[0].ID)
+	if err != nil || got.Title != e.Title {
+		t.Errorf("got %q and error %v, want %q", got.Title, err, e.Title)
+	}
+
+	n, err := storage.S.Events.DeleteBySource(ctx, e.SourceURL, nil)
+	if err != nil || n != 1 {
+		t.Errorf("deleted %d events with error %v, want 1", n, err)
+	}
+	if _, err := storage.S.Events.Get(ctx, stored[0].ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("got error %v for deleted event, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	ctx := open(t)
+	now := time.Now().UTC()
+	n := models.Notification{Email: "a@example.com", Token: "a", SetupDate: now, Query: models.Query{City: "Bern", Limit: 10}}
+	for i, want := range []bool{true, false} {
+		added, err := storage.S.Notifications.Add(ctx, n)
+		if err != nil || added != want {
+			t.Errorf("add %d: got %t and error %v, want %t", i, added, err, want)
+		}
+		n.Token = "b"
+	}
+	if err := storage.S.Notifications.Activate(ctx, "a@example.com", "a"); err != nil {
+		t.Fatal(err)
+	}
+	active, err := storage.S.Notifications.FindActive(ctx)
+	if err != nil || len(active) != 1 || active[0].Query.City != "Bern" || !active[0].SetupDate.Equal(now.Truncate(time.Millisecond)) {
+		t.Errorf("got %+v and error %v, want the activated notification", active, err)
+	}
+	if _, err := storage.S.Notifications.Get(ctx, "a@example.com", "b"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func TestStatuses(t *testing.T) {
+	ctx := open(t)
+	for _, name := range []string{"zurich", "bern"} {
+		if err := storage.S.Statuses.Upsert(ctx, models.ScraperStatus{ScraperName: name, ScraperLogs: "logs of " + name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	statuses, total, err := storage.S.Statuses.Find(ctx, "", 0, 1, true)
+	if err != nil || total != 2 || len(statuses) != 1 || statuses[0].ScraperLogs != "logs of bern" {
+		t.Errorf("got %+v (%d) and error %v, want bern with logs", statuses, total, err)
+	}
+	statuses, _, err = storage.S.Statuses.Find(ctx, "zurich", 0, 0, false)
+	if err != nil || len(statuses) != 1 || statuses[0].ScraperLogs != "" {
+		t.Errorf("got %+v and error %v, want zurich without logs", statuses, err)
+	}
+	if err := storage.S.Statuses.Delete(ctx, "basel"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func TestGeoAndGenres(t *testing.T) {
+	ctx := open(t)
+	v := models.Venue{Name: "Dachstock", Address: models.Address{Locality: "Bern", Country: "Switzerland"}}
+	if err := storage.S.Venues.Insert(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.S.Venues.Get(ctx, "Dachstock", "Bern", "", ""); err != nil {
+		t.Errorf("got error %v for stored venue", err)
+	}
+	venues, err := storage.S.Venues.Find(ctx, "bern", "dach", 10)
+	if err != nil || len(venues) != 1 {
+		t.Errorf("got %+v and error %v, want the stored venue", venues, err)
+	}
+
+	if _, err := storage.S.ArtistGenres.Get(ctx, "bonaparte"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+	if err := storage.S.ArtistGenres.Insert(ctx, "bonaparte", []string{"punk", "electro"}); err != nil {
+		t.Fatal(err)
+	}
+	genres, err := storage.S.ArtistGenres.Get(ctx, "bonaparte")
+	if err != nil || !slices.Equal(genres, []string{"punk", "electro"}) {
+		t.Errorf("got %v and error %v, want punk and electro", genres, err)
+	}
+}
+
+func TestEventUpdates(t *testing.T) {
+	ctx := open(t)
+	seed(t, ctx)
+	all, err := storage.S.Events.FindBySource(ctx, "", &time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byTitle := map[string]models.Event{}
+	for _, e := range all {
+		byTitle[e.Title] = e
+	}
+
+	pending, total, err := storage.S.Events.FindPending(ctx, 0, 10)
+	if err != nil || total != 1 || !slices.Equal(titles(pending), []string{"Hidden Gig"}) {
+		t.Fatalf("got pending %v (%d), %v", titles(pending), total, err)
+	}
+	hidden := byTitle["Hidden Gig"]
+	if err := storage.S.Events.SetModerationStatus(ctx, hidden.ID, moderation.StatusApproved, *hidden.SourceKey); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := storage.S.Events.FindByIDs(ctx, []primitive.ObjectID{hidden.ID}); len(found) != 1 {
+		t.Errorf("expected the approved event to be visible")
+	}
+
+	jazz := byTitle["Jazz Night"]
+	title := "Jazz Night (sold out)"
+	jazz.Overrides = &models.EventOverrides{Title: &title, UpdatedAt: day}
+	shared.ApplyOverrides(&jazz)
+	if err := storage.S.Events.SetOverrides(ctx, jazz); err != nil {
+		t.Fatal(err)
+	}
+	overridden, total, err := storage.S.Events.FindOverridden(ctx, 0, 10)
+	if err != nil || total != 1 || !slices.Equal(titles(overridden), []string{title}) {
+		t.Fatalf("got overridden %v (%d), %v", titles(overridden), total, err)
+	}
+	if err := storage.S.Events.ClearOverrides(ctx, jazz.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, total, _ := storage.S.Events.FindOverridden(ctx, 0, 10); total != 0 {
+		t.Errorf("expected the overrides to be cleared")
+	}
+
+	weekly := byTitle["Weekly Jam"]
+	cancelled := []time.Time{day.Add(-7 * 24 * time.Hour)}
+	if err := storage.S.Events.SetCancelled(ctx, weekly.ID, cancelled, *weekly.SourceKey); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := storage.S.Events.Get(ctx, weekly.ID); !slices.EqualFunc(got.Recurrence.Cancelled, cancelled, time.Time.Equal) {
+		t.Errorf("got cancelled %v, want %v", got.Recurrence.Cancelled, cancelled)
+	}
+
+	for range 2 {
+		if _, err := storage.S.Events.RecordInteraction(ctx, jazz.ID, popularity.InteractionView, day); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := storage.S.Events.Get(ctx, jazz.ID); got.Popularity == nil || got.Popularity.Views != 2 {
+		t.Errorf("got popularity %+v, want 2 views", got.Popularity)
+	}
+
+	address := models.Address{Locality: "Zurich", Street: "Seefeldstrasse", Geolocacation: jazz.Address.Geolocacation}
+	if err := storage.S.Events.SetAddress(ctx, jazz.ID, address); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.S.Events.SetGenres(ctx, jazz.ID, []string{"blues"}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := storage.S.Events.Get(ctx, jazz.ID)
+	if got.Address.Street != "Seefeldstrasse" || !slices.Equal(got.Genres, []string{"blues"}) {
+		t.Errorf("got street %q and genres %v, want Seefeldstrasse and blues", got.Address.Street, got.Genres)
+	}
+	if got.Popularity == nil || got.Popularity.Views != 2 {
+		t.Errorf("got popularity %+v after setting the address and genres, want 2 views", got.Popularity)
+	}
+
+	missing := primitive.NewObjectID()
+	if err := storage.S.Events.ClearOverrides(ctx, missing); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := storage.S.Events.RecordInteraction(ctx, missing, popularity.InteractionClick, day); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFindSimilarAndAutocompleteValues(t *testing.T) {
+	ctx := open(t)
+	seed(t, ctx)
+
+	similar, err := storage.S.Events.FindSimilar(ctx, storage.SimilarFilter{
+		Since:       day,
+		Genres:      []string{"techno"},
+		Artists:     []string{"cafe"},
+		Coordinates: []float64{8.54, 47.37},
+		RadiusKm:    5,
+	}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Weekly Jam", "Jazz Night", "Techno Party", "Café Concert"}; !slices.Equal(titles(similar), want) {
+		t.Errorf("got similar %v, want %v", titles(similar), want)
+	}
+
+	values, err := storage.S.Events.AutocompleteValues(ctx, storage.AutocompleteFilter{
+		Since:        day,
+		Cities:       true,
+		Genres:       true,
+		TitlePattern: `(^|\W)techno`,
+		MaxTitles:    10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := func(suggestions []models.Suggestion) map[string]int64 {
+		c := map[string]int64{}
+		for _, s := range suggestions {
+			c[s.Value] = s.Count
+		}
+		return c
+	}
+	if c := counts(values.Cities); c["Bern"] != 2 || c["Zurich"] != 2 || c["Winterthur"] != 1 {
+		t.Errorf("got cities %v", c)
+	}
+	if c := counts(values.Genres); c["jazz"] != 3 || c["techno"] != 1 {
+		t.Errorf("got genres %v", c)
+	}
+	if values.Venues != nil || len(values.Titles) != 2 {
+		t.Errorf("got venues %v and titles %v", values.Venues, values.Titles)
+	}
+}
+
+func TestFindFollows(t *testing.T) {
+	ctx := open(t)
+	seed(t, ctx)
+	tests := []struct {
+		follows storage.Follows
+		want    []string
+	}{
+		{storage.Follows{}, nil},
+		{storage.Follows{Artists: []string{"cafe"}}, []string{"Café Concert"}},
+		{storage.Follows{Venues: []string{"venue techno party"}, Cities: []string{"ZURICH"}}, []string{"Jazz Night", "Techno Party"}},
+		{storage.Follows{Cities: []string{"Zur"}}, nil},
+	}
+	for _, tc := range tests {
+		got, _, err := storage.S.Events.Find(ctx, storage.EventFilter{Follows: &tc.follows}, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(titles(got), tc.want) {
+			t.Errorf("follows %+v: got %v, want %v", tc.follows, titles(got), tc.want)
+		}
+	}
+}
+
+func TestRulesAndWebhooks(t *testing.T) {
+	ctx := open(t)
+	now := time.Now().UTC()
+	for i, pattern := range []string{"b", "a"} {
+		r := models.ModerationRule{ID: primitive.NewObjectID(), Field: "title", Pattern: pattern, Action: moderation.ActionHold, CreatedAt: now.Add(time.Duration(i) * time.Second)}
+		if err := storage.S.Rules.Insert(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules, err := storage.S.Rules.Find(ctx)
+	if err != nil || len(rules) != 2 || rules[0].Pattern != "b" {
+		t.Fatalf("got rules %+v and error %v", rules, err)
+	}
+	if err := storage.S.Rules.Delete(ctx, rules[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.S.Rules.Delete(ctx, rules[0].ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+
+	hook := models.Webhook{ID: primitive.NewObjectID(), URL: "https://example.com", Secret: "s", Query: models.Query{City: "Bern"}, CreatedAt: now}
+	if err := storage.S.Webhooks.Insert(ctx, hook); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		d := models.WebhookDelivery{DeliveryID: "d", WebhookID: hook.ID, Attempt: i + 1, Timestamp: now.Add(time.Duration(i) * time.Second)}
+		if err := storage.S.Webhooks.AddDelivery(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deliveries, total, err := storage.S.Webhooks.FindDeliveries(ctx, hook.ID, 0, 2)
+	if err != nil || total != 3 || len(deliveries) != 2 || deliveries[0].Attempt != 3 {
+		t.Errorf("got deliveries %+v (%d) and error %v, want the latest 2 of 3", deliveries, total, err)
+	}
+	if err := storage.S.Webhooks.Delete(ctx, hook.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := storage.S.Webhooks.Count(ctx); err != nil || n != 0 {
+		t.Errorf("got %d webhooks and error %v", n, err)
+	}
+	if _, total, _ := storage.S.Webhooks.FindDeliveries(ctx, hook.ID, 0, 2); total != 0 {
+		t.Errorf("got %d deliveries of a deleted webhook", total)
+	}
+}
+
+func TestUsersAndSessions(t *testing.T) {
+	ctx := open(t)
+	now := time.Now().UTC()
+	u := models.User{ID: primitive.NewObjectID(), Email: "a@example.com", PasswordHash: []byte("hash"), CreatedAt: now}
+	for i, want := range []bool{true, false} {
+		inserted, err := storage.S.Users.Insert(ctx, u)
+		if err != nil || inserted != want {
+			t.Errorf("insert %d: got %t and error %v, want %t", i, inserted, err, want)
+		}
+		u.ID = primitive.NewObjectID()
+	}
+	found, err := storage.S.Users.GetOrInsert(ctx, u)
+	if err != nil || found.ID == u.ID {
+		t.Errorf("got user %+v and error %v, want the stored user", found, err)
+	}
+
+	eventID := primitive.NewObjectID()
+	for range 2 {
+		if _, err := storage.S.Users.AddFavourite(ctx, found.ID, eventID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	updated, err := storage.S.Users.AddFollow(ctx, found.ID, storage.FollowArtists, "Bonobo")
+	if err != nil || !slices.Equal(updated.FavouriteEvents, []primitive.ObjectID{eventID}) || !slices.Equal(updated.FollowedArtists, []string{"Bonobo"}) {
+		t.Errorf("got user %+v and error %v", updated, err)
+	}
+	if updated, err = storage.S.Users.RemoveFollow(ctx, found.ID, storage.FollowArtists, "Bonobo"); err != nil || len(updated.FollowedArtists) != 0 {
+		t.Errorf("got followed artists %v and error %v", updated.FollowedArtists, err)
+	}
+	if _, err := storage.S.Users.AddFollow(ctx, primitive.NewObjectID(), storage.FollowCities, "Bern"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("got error %v, want %v", err, storage.ErrNotFound)
+	}
+	if updated, err = storage.S.Users.VerifyEmail(ctx, found.ID); err != nil || !updated.EmailVerified || updated.PasswordHash != nil {
+		t.Errorf("got user %+v and error %v, want a verified user without password", updated, err)
+	}
+
+	s := models.Session{TokenHash: "h", UserID: found.ID, ExpiresAt: now.Add(time.Hour)}
+	if err := storage.S.Sessions.Add(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := storage.S.Sessions.Get(ctx, "h", now); err != nil || got.UserID != found.ID {
+		t.Errorf("got session %+v and error %v", got, err)
+	}
+	if _, err := storage.S.Sessions.Get(ctx, "h", now.Add(2*time.Hour)); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("got error %v for an expired session, want %v", err, storage.ErrNotFound)
+	}
+	if err := storage.S.Sessions.DeleteByUser(ctx, found.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.S.Sessions.Get(ctx, "h", now); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("got error %v for a deleted session, want %v", err, storage.ErrNotFound)
+	}
+
+	l := models.MagicLink{TokenHash: "m", Email: "b@example.com", ExpiresAt: now.Add(time.Minute)}
+	if err := storage.S.Sessions.AddMagicLink(ctx, l); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := storage.S.Sessions.RedeemMagicLink(ctx, "m", now); err != nil || got.Email != l.Email {
+		t.Errorf("got magic link %+v and error %v", got, err)
+	}
+	if _, err := storage.S.Sessions.RedeemMagicLink(ctx, "m", now); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("got error %v for a redeemed magic link, want %v", err, storage.ErrNotFound)
+	}
+}
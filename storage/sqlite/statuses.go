@@ -0,0 +1,80 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type statuses struct {
+	db *sql.DB
+}
+
+func (r statuses) Find(ctx context.Context, name string, skip, limit int64, withLogs bool) ([]models.ScraperStatus, int64, error) {
+	w := &where{}
+	if name != "" {
+		w.add("scraper_name = ?", name)
+	}
+	var total int64
+	if err := r.db.QueryRowContext(ctx, "SELECT count(*) FROM statuses"+w.String(), w.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	if limit == 0 {
+		limit = -1
+	}
+	// the logs can be huge, so they are only fetched if they are needed
+	logs := "''"
+	if withLogs {
+		logs = "logs"
+	}
+	rows, err := r.db.QueryContext(ctx, "SELECT doc, "+logs+" FROM statuses"+w.String()+" ORDER BY scraper_name LIMIT ? OFFSET ?",
+		append(w.args, limit, skip)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var statuses []models.ScraperStatus
+	for rows.Next() {
+		var doc []byte
+		var logs string
+		if err := rows.Scan(&doc, &logs); err != nil {
+			return nil, 0, err
+		}
+		var s models.ScraperStatus
+		if err := bson.Unmarshal(doc, &s); err != nil {
+			return nil, 0, err
+		}
+		s.ScraperLogs = logs
+		statuses = append(statuses, s)
+	}
+	return statuses, total, rows.Err()
+}
+
+func (r statuses) Upsert(ctx context.Context, s models.ScraperStatus) error {
+	logs := s.ScraperLogs
+	s.ScraperLogs = ""
+	doc, err := bson.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, "INSERT OR REPLACE INTO statuses (scraper_name, logs, doc) VALUES (?, ?, ?)", s.ScraperName, logs, doc)
+	return err
+}
+
+func (r statuses) Delete(ctx context.Context, name string) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM statuses WHERE scraper_name = ?", name)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
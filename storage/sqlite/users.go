@@ -0,0 +1,181 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type users struct {
+	db *sql.DB
+}
+
+func (r users) Get(ctx context.Context, id primitive.ObjectID) (models.User, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT doc FROM users WHERE id = ?", id.Hex())
+	if err != nil {
+		return models.User{}, err
+	}
+	return first(decodeAll[models.User](rows))
+}
+
+func (r users) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT doc FROM users WHERE email = ?", email)
+	if err != nil {
+		return models.User{}, err
+	}
+	return first(decodeAll[models.User](rows))
+}
+
+func (r users) Insert(ctx context.Context, u models.User) (bool, error) {
+	doc, err := bson.Marshal(u)
+	if err != nil {
+		return false, err
+	}
+	result, err := r.db.ExecContext(ctx, "INSERT INTO users (id, email, doc) VALUES (?, ?, ?) ON CONFLICT (email) DO NOTHING",
+		u.ID.Hex(), u.Email, doc)
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	return inserted > 0, err
+}
+
+func (r users) GetOrInsert(ctx context.Context, u models.User) (models.User, error) {
+	if _, err := r.Insert(ctx, u); err != nil {
+		return models.User{}, err
+	}
+	return r.GetByEmail(ctx, u.Email)
+}
+
+func (r users) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id.Hex())
+	return err
+}
+
+func (r users) VerifyEmail(ctx context.Context, id primitive.ObjectID) (models.User, error) {
+	return r.update(ctx, id, func(u *models.User) {
+		u.EmailVerified = true
+		u.PasswordHash = nil
+	})
+}
+
+func (r users) AddFavourite(ctx context.Context, id, eventID primitive.ObjectID) (models.User, error) {
+	return r.update(ctx, id, func(u *models.User) {
+		if !slices.Contains(u.FavouriteEvents, eventID) {
+			u.FavouriteEvents = append(u.FavouriteEvents, eventID)
+		}
+	})
+}
+
+func (r users) RemoveFavourite(ctx context.Context, id, eventID primitive.ObjectID) (models.User, error) {
+	return r.update(ctx, id, func(u *models.User) {
+		u.FavouriteEvents = slices.DeleteFunc(u.FavouriteEvents, func(e primitive.ObjectID) bool { return e == eventID })
+	})
+}
+
+func (r users) AddFollow(ctx context.Context, id primitive.ObjectID, kind, name string) (models.User, error) {
+	if !slices.Contains(storage.FollowKinds, kind) {
+		return models.User{}, fmt.Errorf("unknown kind %q", kind)
+	}
+	return r.update(ctx, id, func(u *models.User) {
+		if names := storage.Followed(u, kind); !slices.Contains(*names, name) {
+			*names = append(*names, name)
+		}
+	})
+}
+
+func (r users) RemoveFollow(ctx context.Context, id primitive.ObjectID, kind, name string) (models.User, error) {
+	if !slices.Contains(storage.FollowKinds, kind) {
+		return models.User{}, fmt.Errorf("unknown kind %q", kind)
+	}
+	return r.update(ctx, id, func(u *models.User) {
+		names := storage.Followed(u, kind)
+		*names = slices.DeleteFunc(*names, func(n string) bool { return n == name })
+	})
+}
+
+// update applies fn to the user with the given id within a transaction and returns the
+// updated user.
+func (r users) update(ctx context.Context, id primitive.ObjectID, fn func(u *models.User)) (models.User, error) {
+	var u models.User
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return u, err
+	}
+	defer tx.Rollback()
+	var doc []byte
+	if err := tx.QueryRowContext(ctx, "SELECT doc FROM users WHERE id = ?", id.Hex()).Scan(&doc); err != nil {
+		return u, notFound(err)
+	}
+	if err := bson.Unmarshal(doc, &u); err != nil {
+		return u, err
+	}
+	fn(&u)
+	if doc, err = bson.Marshal(u); err != nil {
+		return u, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET doc = ? WHERE id = ?", doc, id.Hex()); err != nil {
+		return u, err
+	}
+	return u, tx.Commit()
+}
+
+type sessions struct {
+	db *sql.DB
+}
+
+func (r sessions) Add(ctx context.Context, s models.Session) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)",
+		s.TokenHash, s.UserID.Hex(), s.ExpiresAt.UnixMilli())
+	return err
+}
+
+func (r sessions) Get(ctx context.Context, tokenHash string, now time.Time) (models.Session, error) {
+	s := models.Session{TokenHash: tokenHash}
+	var userID string
+	var expiresAt int64
+	err := r.db.QueryRowContext(ctx, "SELECT user_id, expires_at FROM sessions WHERE token_hash = ? AND expires_at > ?",
+		tokenHash, now.UnixMilli()).Scan(&userID, &expiresAt)
+	if err != nil {
+		return s, notFound(err)
+	}
+	s.ExpiresAt = time.UnixMilli(expiresAt).UTC()
+	s.UserID, err = primitive.ObjectIDFromHex(userID)
+	return s, err
+}
+
+func (r sessions) Delete(ctx context.Context, tokenHash string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+func (r sessions) DeleteByUser(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ?", userID.Hex())
+	return err
+}
+
+func (r sessions) AddMagicLink(ctx context.Context, l models.MagicLink) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO magic_links (token_hash, email, expires_at) VALUES (?, ?, ?)",
+		l.TokenHash, l.Email, l.ExpiresAt.UnixMilli())
+	return err
+}
+
+func (r sessions) RedeemMagicLink(ctx context.Context, tokenHash string, now time.Time) (models.MagicLink, error) {
+	l := models.MagicLink{TokenHash: tokenHash}
+	var expiresAt int64
+	// deleting and returning the link in one statement redeems it only once
+	err := r.db.QueryRowContext(ctx, "DELETE FROM magic_links WHERE token_hash = ? AND expires_at > ? RETURNING email, expires_at",
+		tokenHash, now.UnixMilli()).Scan(&l.Email, &expiresAt)
+	if err != nil {
+		return l, notFound(err)
+	}
+	l.ExpiresAt = time.UnixMilli(expiresAt).UTC()
+	return l, nil
+}
@@ -0,0 +1,92 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+
+	"github.com/jakopako/event-api/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type webhooks struct {
+	db *sql.DB
+}
+
+func (r webhooks) Find(ctx context.Context, owner *primitive.ObjectID) ([]models.Webhook, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT doc FROM webhooks ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	hooks, err := decodeAll[models.Webhook](rows)
+	if err != nil || owner == nil {
+		return hooks, err
+	}
+	// there are only a few webhooks, so the owner isn't a column
+	return slices.DeleteFunc(hooks, func(w models.Webhook) bool { return w.Owner != *owner }), nil
+}
+
+func (r webhooks) Get(ctx context.Context, id primitive.ObjectID) (models.Webhook, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT doc FROM webhooks WHERE id = ?", id.Hex())
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	return first(decodeAll[models.Webhook](rows))
+}
+
+func (r webhooks) Count(ctx context.Context) (int64, error) {
+	var n int64
+	err := r.db.QueryRowContext(ctx, "SELECT count(*) FROM webhooks").Scan(&n)
+	return n, err
+}
+
+func (r webhooks) Insert(ctx context.Context, w models.Webhook) error {
+	doc, err := bson.Marshal(w)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, "INSERT INTO webhooks (id, created_at, doc) VALUES (?, ?, ?)",
+		w.ID.Hex(), w.CreatedAt.UnixMilli(), doc)
+	return err
+}
+
+func (r webhooks) Delete(ctx context.Context, id primitive.ObjectID) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := affected(tx.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ?", id.Hex())); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE webhook_id = ?", id.Hex()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r webhooks) AddDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	doc, err := bson.Marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, "INSERT INTO webhook_deliveries (webhook_id, timestamp, doc) VALUES (?, ?, ?)",
+		d.WebhookID.Hex(), d.Timestamp.UnixMilli(), doc)
+	return err
+}
+
+func (r webhooks) FindDeliveries(ctx context.Context, webhookID primitive.ObjectID, skip, limit int64) ([]models.WebhookDelivery, int64, error) {
+	var total int64
+	if err := r.db.QueryRowContext(ctx, "SELECT count(*) FROM webhook_deliveries WHERE webhook_id = ?", webhookID.Hex()).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT doc FROM webhook_deliveries WHERE webhook_id = ? ORDER BY timestamp DESC, rowid DESC LIMIT ? OFFSET ?",
+		webhookID.Hex(), limit, skip)
+	if err != nil {
+		return nil, 0, err
+	}
+	deliveries, err := decodeAll[models.WebhookDelivery](rows)
+	return deliveries, total, err
+}
@@ -0,0 +1,386 @@
+// Package storage defines the repositories through which the api reads and writes its
+// data. The backends are implemented in the subpackages, mongodb for production and
+// memory for tests and local development.
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/search"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotFound is returned if the requested item doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// Store bundles the repositories of a backend.
+type Store struct {
+	Events        EventRepository
+	Archive       ArchiveRepository
+	Notifications NotificationRepository
+	Statuses      StatusRepository
+	Cities        CityRepository
+	Venues        VenueRepository
+	ArtistGenres  ArtistGenreRepository
+	Rules         RuleRepository
+	Webhooks      WebhookRepository
+	Users         UserRepository
+	Sessions      SessionRepository
+	Backup        BackupRepository
+}
+
+// S is the store of the api. It has to be set up before the routes are served.
+var S Store
+
+// EventFilter selects the events that match a query, see shared.FetchEvents.
+type EventFilter struct {
+	Query models.Query
+	// Expr is the parsed filter expression of the query, nil if it has none
+	Expr search.Expr
+	// Follows restricts the events to the ones the user follows, nil if it doesn't
+	Follows *Follows
+}
+
+// Follows selects the events of the artists, venues and cities a user follows. Events match
+// if their normalized title contains one of the artists or if their location or city is
+// one of the venues or cities, ignoring case. No event matches if all of them are empty.
+type Follows struct {
+	// Artists are normalized, see shared.RemoveDiacritics
+	Artists []string
+	Venues  []string
+	Cities  []string
+}
+
+// Match checks whether the event is one of the followed events.
+func (f *Follows) Match(e models.Event) bool {
+	title := strings.ToLower(e.NormalizedTitle)
+	return slices.ContainsFunc(f.Artists, func(a string) bool { return strings.Contains(title, strings.ToLower(a)) }) ||
+		slices.ContainsFunc(f.Venues, func(v string) bool { return strings.EqualFold(v, e.Location) }) ||
+		slices.ContainsFunc(f.Cities, func(c string) bool { return strings.EqualFold(c, e.City) })
+}
+
+// EventRepository stores the events. Recurring events are stored once per series, their
+// occurrences are expanded by the callers.
+type EventRepository interface {
+	// Get returns the event with the given id or ErrNotFound.
+	Get(ctx context.Context, id primitive.ObjectID) (models.Event, error)
+	// Find returns the events that don't recur and match the filter within the date window
+	// of its query, in the order of the sort of the query, and the total number of them.
+	Find(ctx context.Context, f EventFilter, skip, limit int64) ([]models.Event, int64, error)
+	// FindSeries returns the recurring events that match the filter apart from the date and
+	// may have occurrences between from and to.
+	FindSeries(ctx context.Context, f EventFilter, from, to time.Time) ([]models.Event, error)
+	// CountValues counts the events that don't recur and match the filter within the date
+	// window of its query per value of each of the given keys. Keys are the fields in
+	// shared.DistinctFields or the intervals of shared.IntervalKey.
+	CountValues(ctx context.Context, f EventFilter, keys []string) (map[string]map[string]int64, error)
+	// FindByKeys returns the events with one of the given source keys.
+	FindByKeys(ctx context.Context, keys []models.EventKey) ([]models.Event, error)
+	// FindByURLs returns the events with one of the given urls.
+	FindByURLs(ctx context.Context, urls []string) ([]models.Event, error)
+	// Upsert replaces the stored events with the same source keys, see shared.UpsertKey, or,
+	// for events stored before source keys were introduced, the same fields, and inserts the
+	// others. It returns the ids of the inserted events by their index.
+	Upsert(ctx context.Context, events []models.Event) (map[int]primitive.ObjectID, error)
+	// FindBySource returns the events of the source or, if since is set, the events of the
+	// source at or after that date. Without a source all events since that date are returned.
+	FindBySource(ctx context.Context, sourceURL string, since *time.Time) ([]models.Event, error)
+	// DeleteBySource deletes the events that FindBySource returns and returns their number.
+	DeleteBySource(ctx context.Context, sourceURL string, since *time.Time) (int64, error)
+	// FindByIDs returns the visible events with one of the given ids.
+	FindByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.Event, error)
+	// FindOverridden returns the events with manual overrides, the most recently overridden
+	// first, and the total number of them.
+	FindOverridden(ctx context.Context, skip, limit int64) ([]models.Event, int64, error)
+	// FindPending returns the events that wait for moderation ordered by date and the total
+	// number of them.
+	FindPending(ctx context.Context, skip, limit int64) ([]models.Event, int64, error)
+	// SetOverrides replaces the stored event with the given one, to which its overrides
+	// have been applied, see shared.ApplyOverrides. It returns ErrNotFound if there is no
+	// event with its id.
+	SetOverrides(ctx context.Context, e models.Event) error
+	// ClearOverrides removes the overrides of the event with the given id or returns
+	// ErrNotFound. The overridden values stay until the event is upserted again.
+	ClearOverrides(ctx context.Context, id primitive.ObjectID) error
+	// SetModerationStatus sets the moderation status and the source key of the event with
+	// the given id or returns ErrNotFound.
+	SetModerationStatus(ctx context.Context, id primitive.ObjectID, status string, key models.EventKey) error
+	// SetCancelled sets the cancelled occurrences and the source key of the recurring event
+	// with the given id or returns ErrNotFound.
+	SetCancelled(ctx context.Context, id primitive.ObjectID, cancelled []time.Time, key models.EventKey) error
+	// SetAddress sets the address of the event with the given id and clears its city
+	// fallback or returns ErrNotFound.
+	SetAddress(ctx context.Context, id primitive.ObjectID, address models.Address) error
+	// SetGenres sets the genres of the event with the given id or returns ErrNotFound.
+	SetGenres(ctx context.Context, id primitive.ObjectID, genres []string) error
+	// RecordInteraction adds an interaction of the given kind at the given time to the
+	// popularity of the visible event with the given id, see popularity.Record, and returns
+	// the updated event or ErrNotFound. Concurrent interactions must not be lost.
+	RecordInteraction(ctx context.Context, id primitive.ObjectID, interaction string, t time.Time) (models.Event, error)
+	// FindSimilar returns at most limit of the visible events that match the filter, ordered
+	// by date.
+	FindSimilar(ctx context.Context, f SimilarFilter, limit int64) ([]models.Event, error)
+	// AutocompleteValues returns the values of the visible upcoming events that are
+	// selected by the filter.
+	AutocompleteValues(ctx context.Context, f AutocompleteFilter) (AutocompleteValues, error)
+}
+
+// SimilarFilter selects the candidates for the events that are similar to an event, see
+// package similar. Events match if they take place after Since, or recur until then, and
+// share a genre, an artist or the venue with the event or are close to it.
+type SimilarFilter struct {
+	// ExcludeID is the id of the event itself
+	ExcludeID primitive.ObjectID
+	Since     time.Time
+	// Genres are compared exactly
+	Genres []string
+	// Artists are matched as substrings of the normalized title, ignoring case
+	Artists []string
+	// Location and City select the events at the same venue if Location is not empty
+	Location string
+	City     string
+	// Coordinates select the events within RadiusKm if they are set
+	Coordinates []float64
+	RadiusKm    float64
+}
+
+// AutocompleteFilter selects the values that AutocompleteValues returns. The events take
+// place after Since or recur until then.
+type AutocompleteFilter struct {
+	Since  time.Time
+	Venues bool
+	Cities bool
+	Genres bool
+	// TitlePattern selects the titles of at most MaxTitles events whose normalized title
+	// matches the pattern, ignoring case. No titles are returned if it is empty.
+	TitlePattern string
+	MaxTitles    int64
+}
+
+// AutocompleteValues are the values of the events with the number of events per value.
+// Venues are told apart by their location and city. The types of the suggestions are
+// left empty.
+type AutocompleteValues struct {
+	Venues []models.Suggestion
+	Cities []models.Suggestion
+	Genres []models.Suggestion
+	Titles []string
+}
+
+// ArchiveRepository stores the past events that have been moved out of the events, so
+// that the events and their indexes stay small. The archived events are queried like the
+// events, with the methods of EventRepository.
+type ArchiveRepository interface {
+	EventRepository
+	// MoveBefore moves the events that don't recur and took place before the given date
+	// and the recurring events whose last occurrence was before it from the events to the
+	// archive. It returns the number of moved events.
+	MoveBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// NotificationRepository stores the email notifications. Notifications are identified by
+// their email and token.
+type NotificationRepository interface {
+	// Add stores the notification unless one with the same email and query exists. It
+	// reports whether the notification has been added.
+	Add(ctx context.Context, n models.Notification) (bool, error)
+	// Get returns the notification or ErrNotFound.
+	Get(ctx context.Context, email, token string) (models.Notification, error)
+	// Activate activates the notification.
+	Activate(ctx context.Context, email, token string) error
+	// Delete deletes the notification. It doesn't fail if there is no such notification.
+	Delete(ctx context.Context, email, token string) error
+	// DeleteInactive deletes the inactive notifications set up before the given date.
+	DeleteInactive(ctx context.Context, before time.Time) (int64, error)
+	// FindActive returns all active notifications.
+	FindActive(ctx context.Context) ([]models.Notification, error)
+}
+
+// StatusRepository stores the statuses of the scrapers, one per scraper name.
+type StatusRepository interface {
+	// Find returns the status of the scraper with the given name or, if the name is empty,
+	// of all scrapers, ordered by name, and the total number of them. A limit of 0 returns
+	// all statuses. The logs are left out unless withLogs is set.
+	Find(ctx context.Context, name string, skip, limit int64, withLogs bool) ([]models.ScraperStatus, int64, error)
+	// Upsert replaces the status of the scraper or inserts it.
+	Upsert(ctx context.Context, s models.ScraperStatus) error
+	// Delete deletes the status of the scraper with the given name or returns ErrNotFound.
+	Delete(ctx context.Context, name string) error
+}
+
+// CityRepository stores the coordinates of the cities that have been looked up. Callers
+// pass names, states and countries in lower case.
+type CityRepository interface {
+	// Get returns the city with the given name, state and country or ErrNotFound.
+	Get(ctx context.Context, name, state, country string) (models.City, error)
+	// FindByName returns all cities with the given name, in the given country if it is not empty.
+	FindByName(ctx context.Context, name, country string) ([]models.City, error)
+	// Find returns at most limit cities ordered by name, in the given country if it is not empty.
+	Find(ctx context.Context, country string, limit int64) ([]models.City, error)
+	// Insert stores the city.
+	Insert(ctx context.Context, c models.City) error
+}
+
+// VenueRepository stores the venues that have been looked up.
+type VenueRepository interface {
+	// Get returns the venue with the given name in the given city or ErrNotFound. The
+	// state and the country are only compared if they are not empty.
+	Get(ctx context.Context, name, city, state, country string) (models.Venue, error)
+	// Find returns at most limit venues ordered by name, in the given city and with
+	// names containing the given string if they are not empty, ignoring case.
+	Find(ctx context.Context, city, name string, limit int64) ([]models.Venue, error)
+	// Insert stores the venue.
+	Insert(ctx context.Context, v models.Venue) error
+}
+
+// ArtistGenreRepository stores the genres of the artists that have been looked up.
+// Callers pass artists in lower case.
+type ArtistGenreRepository interface {
+	// Get returns the genres of the artist or ErrNotFound if they have never been stored.
+	Get(ctx context.Context, artist string) ([]string, error)
+	// Insert stores the genres of the artist.
+	Insert(ctx context.Context, artist string, genres []string) error
+}
+
+// RuleRepository stores the moderation rules.
+type RuleRepository interface {
+	// Find returns all rules in the order in which they have been created.
+	Find(ctx context.Context) ([]models.ModerationRule, error)
+	// Insert stores the rule.
+	Insert(ctx context.Context, r models.ModerationRule) error
+	// Delete deletes the rule with the given id or returns ErrNotFound.
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// WebhookRepository stores the webhooks and the log of their deliveries.
+type WebhookRepository interface {
+	// Find returns the webhooks of the owner or all webhooks if owner is nil, including
+	// their secrets, in the order in which they have been created.
+	Find(ctx context.Context, owner *primitive.ObjectID) ([]models.Webhook, error)
+	// Get returns the webhook with the given id or ErrNotFound.
+	Get(ctx context.Context, id primitive.ObjectID) (models.Webhook, error)
+	// Count returns the number of webhooks.
+	Count(ctx context.Context) (int64, error)
+	// Insert stores the webhook.
+	Insert(ctx context.Context, w models.Webhook) error
+	// Delete deletes the webhook with the given id and its deliveries or returns ErrNotFound.
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// AddDelivery logs a delivery attempt.
+	AddDelivery(ctx context.Context, d models.WebhookDelivery) error
+	// FindDeliveries returns the delivery attempts of the webhook with the given id, the
+	// latest first, and the total number of them.
+	FindDeliveries(ctx context.Context, webhookID primitive.ObjectID, skip, limit int64) ([]models.WebhookDelivery, int64, error)
+}
+
+// The kinds of things users can follow, see UserRepository.AddFollow.
+const (
+	FollowArtists = "artists"
+	FollowVenues  = "venues"
+	FollowCities  = "cities"
+)
+
+// FollowKinds are all kinds of things users can follow.
+var FollowKinds = []string{FollowArtists, FollowVenues, FollowCities}
+
+// Followed returns the names of the things of the given kind that the user follows or nil
+// if the kind is unknown.
+func Followed(u *models.User, kind string) *[]string {
+	switch kind {
+	case FollowArtists:
+		return &u.FollowedArtists
+	case FollowVenues:
+		return &u.FollowedVenues
+	case FollowCities:
+		return &u.FollowedCities
+	}
+	return nil
+}
+
+// UserRepository stores the user accounts. Users are identified by their id and their
+// email address, which callers pass normalized.
+type UserRepository interface {
+	// Get returns the user with the given id or ErrNotFound.
+	Get(ctx context.Context, id primitive.ObjectID) (models.User, error)
+	// GetByEmail returns the user with the given email address or ErrNotFound.
+	GetByEmail(ctx context.Context, email string) (models.User, error)
+	// Insert stores the user, whose id has to be set, unless a user with the same email
+	// address exists. It reports whether the user has been inserted.
+	Insert(ctx context.Context, u models.User) (bool, error)
+	// GetOrInsert returns the user with the email address of the given user and stores
+	// the given user, whose id has to be set, if there is none.
+	GetOrInsert(ctx context.Context, u models.User) (models.User, error)
+	// Delete deletes the user with the given id. It doesn't fail if there is no such user.
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// VerifyEmail marks the email address of the user as verified, removes the password
+	// of the user and returns the updated user or ErrNotFound.
+	VerifyEmail(ctx context.Context, id primitive.ObjectID) (models.User, error)
+	// AddFavourite adds the event to the favourites of the user and returns the updated
+	// user or ErrNotFound. Events that are favourites already are not added twice.
+	AddFavourite(ctx context.Context, id, eventID primitive.ObjectID) (models.User, error)
+	// RemoveFavourite removes the event from the favourites of the user and returns the
+	// updated user or ErrNotFound.
+	RemoveFavourite(ctx context.Context, id, eventID primitive.ObjectID) (models.User, error)
+	// AddFollow adds the name to the things of the given kind, one of FollowKinds, that
+	// the user follows and returns the updated user or ErrNotFound. Names that are
+	// followed already are not added twice.
+	AddFollow(ctx context.Context, id primitive.ObjectID, kind, name string) (models.User, error)
+	// RemoveFollow removes the name from the things of the given kind that the user
+	// follows and returns the updated user or ErrNotFound.
+	RemoveFollow(ctx context.Context, id primitive.ObjectID, kind, name string) (models.User, error)
+}
+
+// SessionRepository stores the sessions of the users and the magic links with which
+// users log in. Both are identified by the hash of their token.
+type SessionRepository interface {
+	// Add stores the session.
+	Add(ctx context.Context, s models.Session) error
+	// Get returns the session with the given token hash that expires after now or
+	// ErrNotFound.
+	Get(ctx context.Context, tokenHash string, now time.Time) (models.Session, error)
+	// Delete deletes the session with the given token hash. It doesn't fail if there is
+	// no such session.
+	Delete(ctx context.Context, tokenHash string) error
+	// DeleteByUser deletes all sessions of the user with the given id.
+	DeleteByUser(ctx context.Context, userID primitive.ObjectID) error
+	// AddMagicLink stores the magic link.
+	AddMagicLink(ctx context.Context, l models.MagicLink) error
+	// RedeemMagicLink deletes the magic link with the given token hash that expires after
+	// now and returns it or ErrNotFound. Concurrent calls redeem a link only once.
+	RedeemMagicLink(ctx context.Context, tokenHash string, now time.Time) (models.MagicLink, error)
+}
+
+// The kinds of items that are dumped and restored by the BackupRepository.
+const (
+	KindEvents        = "events"
+	KindArchive       = "archive"
+	KindVenues        = "venues"
+	KindCities        = "cities"
+	KindGenres        = "genres"
+	KindNotifications = "notifications"
+	KindStatuses      = "statuses"
+)
+
+// Kinds are all kinds of items in the order in which they are dumped.
+var Kinds = []string{KindEvents, KindArchive, KindVenues, KindCities, KindGenres, KindNotifications, KindStatuses}
+
+// BackupRepository reads and writes all items of a kind for backups. Items are passed as
+// the BSON documents of their models, models.Event for KindEvents and KindArchive,
+// models.Venue, models.City, models.TitleGenre, models.Notification and
+// models.ScraperStatus, so that backups can be restored to any backend.
+type BackupRepository interface {
+	// Dump calls fn with the document of every stored item of the kind.
+	Dump(ctx context.Context, kind string, fn func(doc bson.Raw) error) error
+	// Restore stores the items of the kind and replaces the stored items with the same
+	// key: the id of events, the name and locality of venues, the name, state and country
+	// of cities, the title of genres, the email and token of notifications and the name of
+	// the scraper of statuses.
+	Restore(ctx context.Context, kind string, docs []bson.Raw) error
+	// Clear deletes all items of the kind.
+	Clear(ctx context.Context, kind string) error
+}
@@ -0,0 +1,82 @@
+package stream
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/shared"
+)
+
+// subscriberBufferSize is the number of events that are buffered per subscriber.
+// If a subscriber is too slow to keep up, further events are dropped for that subscriber.
+const subscriberBufferSize = 100
+
+// Subscriber receives all published events that match its query.
+type Subscriber struct {
+	Events chan models.Event
+	// Done is closed when the hub is closed, the subscriber won't receive any events anymore.
+	Done    <-chan struct{}
+	matcher *shared.EventMatcher
+}
+
+// Hub distributes newly added events to subscribers.
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscriber]struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+var H = NewHub()
+
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: make(map[*Subscriber]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Subscribe registers a new subscriber for events matching the given matcher.
+// Subscribers have to be removed with Unsubscribe when they are not needed anymore.
+func (h *Hub) Subscribe(matcher *shared.EventMatcher) *Subscriber {
+	s := &Subscriber{
+		Events:  make(chan models.Event, subscriberBufferSize),
+		Done:    h.done,
+		matcher: matcher,
+	}
+	h.mu.Lock()
+	h.subscribers[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// Close ends all subscriptions, including the ones that are made afterwards. Streams never
+// end on their own, so they have to be closed before the server can shut down.
+func (h *Hub) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.mu.Lock()
+	delete(h.subscribers, s)
+	h.mu.Unlock()
+}
+
+// Publish sends the given events to all subscribers whose query matches. It never blocks.
+func (h *Hub) Publish(events []models.Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subscribers {
+		for _, e := range events {
+			if !s.matcher.Match(e) {
+				continue
+			}
+			select {
+			case s.Events <- e:
+			default:
+				slog.Warn("dropping event for slow stream subscriber", "title", e.Title)
+			}
+		}
+	}
+}
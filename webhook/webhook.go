@@ -0,0 +1,332 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jakopako/event-api/config"
+	"github.com/jakopako/event-api/models"
+	"github.com/jakopako/event-api/shared"
+	"github.com/jakopako/event-api/storage"
+)
+
+const (
+	CollectionName         = "webhooks"
+	DeliveryCollectionName = "webhookDeliveries"
+
+	ChangeCreated     = "created"
+	ChangeUpdated     = "updated"
+	ChangeRescheduled = "rescheduled"
+	ChangeDeleted     = "deleted"
+
+	// SignatureHeader contains the hex encoded HMAC-SHA256 of the request body, signed with
+	// the secret of the webhook and prefixed with 'sha256='.
+	SignatureHeader = "X-Event-Api-Signature"
+	DeliveryHeader  = "X-Event-Api-Delivery"
+	ChangeHeader    = "X-Event-Api-Change"
+
+	maxAttempts  = 6
+	queueSize    = 1000
+	numWorkers   = 4
+	requestLimit = 10 * time.Second
+)
+
+// Changes contains all changes that webhooks can subscribe to.
+var Changes = []string{ChangeCreated, ChangeUpdated, ChangeRescheduled, ChangeDeleted}
+
+type delivery struct {
+	id      string
+	webhook models.Webhook
+	change  models.EventChange
+	attempt int
+}
+
+// Dispatcher delivers event changes to webhooks. Failed deliveries are retried
+// with exponential backoff and every attempt is logged.
+type Dispatcher struct {
+	client      *http.Client
+	queue       chan delivery
+	baseBackoff time.Duration
+	// record logs a delivery attempt, by default to the store
+	record func(models.WebhookDelivery)
+	// pending counts the deliveries that are queued, in progress or wait for a retry
+	pending sync.WaitGroup
+	mu      sync.Mutex
+	// retries are the deliveries that wait for a retry by their timers
+	retries  map[*time.Timer]delivery
+	draining bool
+}
+
+var D *Dispatcher
+
+func InitDispatcher() {
+	// this code assumes that the store has already been set up
+	D = newDispatcher(time.Second, config.C.Webhooks.AllowPrivateHosts, recordDelivery)
+	D.start()
+}
+
+func newDispatcher(baseBackoff time.Duration, allowPrivateHosts bool, record func(models.WebhookDelivery)) *Dispatcher {
+	return &Dispatcher{
+		client:      newClient(allowPrivateHosts),
+		queue:       make(chan delivery, queueSize),
+		baseBackoff: baseBackoff,
+		record:      record,
+		retries:     map[*time.Timer]delivery{},
+	}
+}
+
+func (d *Dispatcher) start() {
+	for range numWorkers {
+		go func() {
+			for job := range d.queue {
+				d.deliver(job)
+			}
+		}()
+	}
+}
+
+// Notify sends the given changes to all webhooks that subscribed to them. The
+// deliveries happen asynchronously.
+func (d *Dispatcher) Notify(ctx context.Context, changes []models.EventChange) {
+	if len(changes) == 0 {
+		return
+	}
+	hooks, err := storage.S.Webhooks.Find(ctx, nil)
+	if err != nil {
+		slog.Error("failed to load webhooks", "err", err)
+		return
+	}
+
+	for _, hook := range hooks {
+		matcher, err := shared.NewEventMatcher(ctx, hook.Query)
+		if err != nil {
+			slog.Error("invalid webhook query", "webhook", hook.ID.Hex(), "err", err)
+			continue
+		}
+		for _, change := range changes {
+			if len(hook.Changes) > 0 && !slices.Contains(hook.Changes, change.Change) {
+				continue
+			}
+			if !matcher.Match(change.Event) {
+				continue
+			}
+			d.enqueue(delivery{id: newDeliveryID(), webhook: hook, change: change, attempt: 1})
+		}
+	}
+}
+
+// enqueue queues a new delivery.
+func (d *Dispatcher) enqueue(job delivery) {
+	d.pending.Add(1)
+	d.push(job)
+}
+
+// push passes a pending delivery to the workers or drops it if the queue is full.
+func (d *Dispatcher) push(job delivery) {
+	select {
+	case d.queue <- job:
+	default:
+		slog.Error("webhook queue is full, dropping delivery", "webhook", job.webhook.ID.Hex(), "delivery", job.id)
+		d.record(models.WebhookDelivery{
+			DeliveryID: job.id,
+			WebhookID:  job.webhook.ID,
+			Change:     job.change.Change,
+			EventID:    job.change.Event.ID,
+			Attempt:    job.attempt,
+			Error:      "delivery queue is full",
+			Timestamp:  time.Now().UTC(),
+		})
+		d.pending.Done()
+	}
+}
+
+func (d *Dispatcher) deliver(job delivery) {
+	log := models.WebhookDelivery{
+		DeliveryID: job.id,
+		WebhookID:  job.webhook.ID,
+		Change:     job.change.Change,
+		EventID:    job.change.Event.ID,
+		Attempt:    job.attempt,
+	}
+
+	statusCode, err := d.post(job)
+	log.Timestamp = time.Now().UTC()
+	log.StatusCode = statusCode
+	if err == nil {
+		log.Success = true
+		d.record(log)
+		d.pending.Done()
+		return
+	}
+
+	log.Error = err.Error()
+	d.record(log)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if job.attempt >= maxAttempts || d.draining {
+		slog.Warn("giving up webhook delivery", "webhook", job.webhook.ID.Hex(), "delivery", job.id, "err", err)
+		d.pending.Done()
+		return
+	}
+
+	// retry after 1s, 2s, 4s, ... without blocking the worker
+	backoff := d.baseBackoff * time.Duration(1<<(job.attempt-1))
+	job.attempt++
+	var timer *time.Timer
+	timer = time.AfterFunc(backoff, func() {
+		d.mu.Lock()
+		_, waiting := d.retries[timer]
+		delete(d.retries, timer)
+		d.mu.Unlock()
+		// Drain has taken the retry otherwise
+		if waiting {
+			d.push(job)
+		}
+	})
+	d.retries[timer] = job
+}
+
+// Drain retries the deliveries that wait for a retry right away and waits until all
+// deliveries are done. Deliveries that fail from now on aren't retried anymore. It returns
+// the error of the context if it is done first.
+func (d *Dispatcher) Drain(ctx context.Context) error {
+	d.mu.Lock()
+	d.draining = true
+	retries := d.retries
+	d.retries = map[*time.Timer]delivery{}
+	d.mu.Unlock()
+	for timer, job := range retries {
+		timer.Stop()
+		d.push(job)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) post(job delivery) (int, error) {
+	body, err := json.Marshal(models.WebhookPayload{
+		DeliveryID:  job.id,
+		EventChange: job.change,
+		Timestamp:   time.Now().UTC(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, job.webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "https://github.com/jakopako/event-api (webhook)")
+	req.Header.Set(SignatureHeader, "sha256="+Sign(job.webhook.Secret, body))
+	req.Header.Set(DeliveryHeader, job.id)
+	req.Header.Set(ChangeHeader, job.change.Change)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook returned non-2xx status code: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// newClient returns the client that delivers the webhooks. Since anybody with an account can
+// register webhooks, it only connects to public addresses unless allowPrivateHosts is set,
+// so that webhooks can't reach the internal network of the api. Redirects aren't followed.
+func newClient(allowPrivateHosts bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivateHosts {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			// the address has been resolved already
+			Control: func(network, address string, _ syscall.RawConn) error {
+				addr, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if !isPublic(addr.Addr()) {
+					return fmt.Errorf("webhooks can't be delivered to %s", addr.Addr())
+				}
+				return nil
+			},
+		}
+		transport.DialContext = dialer.DialContext
+		// a proxy would connect to the address instead
+		transport.Proxy = nil
+	}
+	return &http.Client{
+		Timeout:   requestLimit,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// sharedAddressSpace is the range of carrier-grade NAT addresses, which aren't public either.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HasWebhooks checks whether at least one webhook is registered. It can be used to
+// skip expensive change detection if nobody is interested in the changes.
+func HasWebhooks(ctx context.Context) bool {
+	if storage.S.Webhooks == nil {
+		return false
+	}
+	n, err := storage.S.Webhooks.Count(ctx)
+	return err == nil && n > 0
+}
+
+func newDeliveryID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func recordDelivery(log models.WebhookDelivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.C.Storage.Timeout)
+	defer cancel()
+	if err := storage.S.Webhooks.AddDelivery(ctx, log); err != nil {
+		slog.Error("failed to record webhook delivery", "delivery", log.DeliveryID, "err", err)
+	}
+}
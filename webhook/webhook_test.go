@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jakopako/event-api/models"
+)
+
+func TestDeliverWithRetries(t *testing.T) {
+	secret := "superSecret"
+	var mu sync.Mutex
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != "sha256="+Sign(secret, body) {
+			t.Errorf("invalid signature %q", r.Header.Get(SignatureHeader))
+		}
+		var payload models.WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("failed to unmarshal payload: %v", err)
+		}
+		if payload.Change != ChangeCreated || payload.Event.Title != "Jazz Night" {
+			t.Errorf("unexpected payload %+v", payload)
+		}
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		if n < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	records := make(chan models.WebhookDelivery, 10)
+	d := newDispatcher(time.Millisecond, true, func(l models.WebhookDelivery) { records <- l })
+	d.start()
+	d.enqueue(delivery{
+		id:      "d1",
+		webhook: models.Webhook{URL: server.URL, Secret: secret},
+		change:  models.EventChange{Change: ChangeCreated, Event: models.Event{Title: "Jazz Night"}},
+		attempt: 1,
+	})
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		select {
+		case l := <-records:
+			if l.Attempt != attempt {
+				t.Errorf("expected attempt %d, got %d", attempt, l.Attempt)
+			}
+			if l.Success != (attempt == 3) {
+				t.Errorf("unexpected success %v for attempt %d (status %d)", l.Success, attempt, l.StatusCode)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for attempt %d", attempt)
+		}
+	}
+}
+
+func TestDrainRetriesRightAway(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// the retry would only be sent after an hour
+	records := make(chan models.WebhookDelivery, 10)
+	d := newDispatcher(time.Hour, true, func(l models.WebhookDelivery) { records <- l })
+	d.start()
+	d.enqueue(delivery{
+		id:      "d1",
+		webhook: models.Webhook{URL: server.URL},
+		change:  models.EventChange{Change: ChangeCreated, Event: models.Event{Title: "Jazz Night"}},
+		attempt: 1,
+	})
+	select {
+	case l := <-records:
+		if l.Success {
+			t.Fatalf("expected the first attempt to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the first attempt")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case l := <-records:
+		if !l.Success || l.Attempt != 2 {
+			t.Errorf("got attempt %d with success %v, want a successful second attempt", l.Attempt, l.Success)
+		}
+	default:
+		t.Error("the retry hasn't been sent before Drain returned")
+	}
+}
+
+func TestSign(t *testing.T) {
+	// reference value computed with: echo -n '{"a":1}' | openssl dgst -sha256 -hmac secret
+	expected := "aa9e2e3575f5d7098b6caccd790888c36d5fdb63342a73bada2d6a51747a8494"
+	if got := Sign("secret", []byte(`{"a":1}`)); got != expected {
+		t.Errorf("Sign() = %q; want %q", got, expected)
+	}
+}
+
+func TestPrivateHostsAreRefused(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, server.URL, http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	// loopback addresses are refused
+	d := newDispatcher(time.Hour, false, func(models.WebhookDelivery) {})
+	if _, err := d.post(delivery{webhook: models.Webhook{URL: server.URL}}); err == nil {
+		t.Error("expected an error for a loopback address")
+	}
+	for _, addr := range []string{"10.0.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "::1", "fe80::1", "::ffff:127.0.0.1", "0.0.0.0"} {
+		if isPublic(netip.MustParseAddr(addr)) {
+			t.Errorf("%s is public", addr)
+		}
+	}
+	if !isPublic(netip.MustParseAddr("203.0.113.7")) {
+		t.Error("203.0.113.7 isn't public")
+	}
+
+	// redirects aren't followed
+	d = newDispatcher(time.Hour, true, func(models.WebhookDelivery) {})
+	if status, err := d.post(delivery{webhook: models.Webhook{URL: redirect.URL}}); err == nil || status != http.StatusFound {
+		t.Errorf("got status %d and error %v for a redirect", status, err)
+	}
+	if calls != 0 {
+		t.Errorf("the server has been called %d times", calls)
+	}
+}